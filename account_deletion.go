@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// how long a deleted account stays recoverable before it is purged
+const AccountDeletionGracePeriod = 30 * 24 * time.Hour
+
+var (
+	errAccountPendingDeletion = errors.New("account is pending deletion, log in to restore it")
+	errDeletionNotDue         = errors.New("account is no longer due for deletion")
+)
+
+// scheduleUserDeletion puts the account into the pending deletion state.
+// The account is hidden and key, session and sub-token logins are rejected until it is either
+// restored by a password login or purged by purgePendingDeletions.
+func scheduleUserDeletion(user User) int64 {
+	if deleteAt := user.GetDeleteAt(); deleteAt > 0 {
+		return deleteAt
+	}
+
+	now := time.Now()
+	deleteAt := now.Add(AccountDeletionGracePeriod).UnixMilli()
+	user.Set("sys.deletion_requested_at", now.UnixMilli())
+	user.Set("sys.delete_at", deleteAt)
+
+	log.Printf("Scheduled deletion of user %s at %s", user.GetUsername(), time.UnixMilli(deleteAt).Format(time.RFC3339))
+	go saveUsers()
+	return deleteAt
+}
+
+// restoreUserDeletion cancels a pending deletion, returns false if none was scheduled.
+// callers hold usersMutex so a purge that already picked the account sees the restore.
+func restoreUserDeletion(user User) bool {
+	if !user.IsPendingDeletion() {
+		return false
+	}
+
+	user.DelKey("sys.deletion_requested_at")
+	user.DelKey("sys.delete_at")
+
+	log.Printf("Restored user %s from pending deletion", user.GetUsername())
+	go saveUsers()
+	return true
+}
+
+func purgePendingDeletions() {
+	for {
+		time.Sleep(1 * time.Hour)
+
+		now := time.Now().UnixMilli()
+		purged := 0
+		for _, username := range dueDeletions(now) {
+			err := purgeIfDue(username, now)
+			switch {
+			case err == nil:
+				purged++
+			case errors.Is(err, errDeletionNotDue):
+				// restored by a login since it was picked
+			default:
+				log.Printf("Error purging user %s: %v", username, err)
+			}
+		}
+
+		if purged > 0 {
+			log.Printf("Purged %d accounts past their deletion grace period", purged)
+		}
+	}
+}
+
+// dueDeletions lists the accounts whose grace period ran out by now
+func dueDeletions(now int64) []Username {
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+
+	due := make([]Username, 0)
+	for _, user := range users {
+		if deleteAt := user.GetDeleteAt(); deleteAt > 0 && deleteAt <= now {
+			due = append(due, user.GetUsername())
+		}
+	}
+	return due
+}
+
+// purgeIfDue hard deletes the account if it is still pending deletion and due.
+// the check runs under usersMutex, which a login holds while restoring the account.
+func purgeIfDue(username Username, now int64) error {
+	return performUserDeletionIf(username, false, false, func(u User) bool {
+		deleteAt := u.GetDeleteAt()
+		return deleteAt > 0 && deleteAt <= now
+	})
+}
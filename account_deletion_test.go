@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPendingDeletionRejectsTokens(t *testing.T) {
+	withTempPath(t, &USERDATA_PATH, "userdata")
+	user := User{"username": "leaving", "sys.id": "del-leaving", "key": "leaving-key"}
+	withTestUsers(t, user)
+
+	issued, err := issueOAuthSubToken("leaving", OAuthApp{ClientId: "app_test", Name: "Test App"}, []TokenPermission{PermViewProfile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authenticateWithKey("leaving-key") == nil {
+		t.Fatal("Key should authenticate before deletion is scheduled")
+	}
+
+	user.Set("sys.delete_at", time.Now().Add(AccountDeletionGracePeriod).UnixMilli())
+
+	if authenticateWithKey("leaving-key") != nil {
+		t.Error("Key of an account pending deletion should not authenticate")
+	}
+	if findUserByKey("leaving-key") == nil {
+		t.Error("Lookup without checks should still find the account")
+	}
+	if _, _, err := authenticateWithSubTokenFast(issued.Token, ""); !errors.Is(err, errAccountPendingDeletion) {
+		t.Errorf("Sub-token of an account pending deletion should be refused, got %v", err)
+	}
+}
+
+func TestScheduleAndRestoreDeletion(t *testing.T) {
+	user := User{"username": "leaving", "sys.id": "del-leaving"}
+	withTestUsers(t, user)
+
+	deleteAt := scheduleUserDeletion(user)
+	if !user.IsPendingDeletion() || deleteAt < time.Now().Add(AccountDeletionGracePeriod-time.Minute).UnixMilli() {
+		t.Fatalf("Deletion should be scheduled after the grace period, got %d", deleteAt)
+	}
+	if again := scheduleUserDeletion(user); again != deleteAt {
+		t.Errorf("Scheduling twice should keep the first date, got %d", again)
+	}
+
+	if !restoreUserDeletion(user) || user.IsPendingDeletion() || user.Has("sys.deletion_requested_at") {
+		t.Fatal("Restoring should clear the pending deletion")
+	}
+	if restoreUserDeletion(user) {
+		t.Error("Restoring an account that isn't pending deletion should report false")
+	}
+}
+
+func TestPurgeRechecksPendingDeletion(t *testing.T) {
+	now := time.Now().UnixMilli()
+	due := User{"username": "due", "sys.id": "del-due", "sys.delete_at": now - 1000}
+	later := User{"username": "later", "sys.id": "del-later", "sys.delete_at": now + 60000}
+	withTestUsers(t, due, later)
+
+	names := dueDeletions(now)
+	if len(names) != 1 || names[0] != "due" {
+		t.Fatalf("Only accounts past their grace period should be due, got %v", names)
+	}
+
+	// a login restores the account after it was picked for purging
+	restoreUserDeletion(due)
+	if err := purgeIfDue("due", now); !errors.Is(err, errDeletionNotDue) {
+		t.Errorf("Restored account should not be purged, got %v", err)
+	}
+	if err := purgeIfDue("later", now); !errors.Is(err, errDeletionNotDue) {
+		t.Errorf("Account inside its grace period should not be purged, got %v", err)
+	}
+	usersMutex.RLock()
+	remaining := len(users)
+	usersMutex.RUnlock()
+	if remaining != 2 {
+		t.Errorf("No account should have been deleted, %d left", remaining)
+	}
+}
@@ -7,7 +7,23 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// authenticateWithKey resolves a main key or session token to its user. Accounts
+// pending deletion are refused until a password login restores them.
 func authenticateWithKey(key string) *User {
+	user := findUserByKey(key)
+	if user == nil || user.IsPendingDeletion() {
+		return nil
+	}
+	return user
+}
+
+// findUserByKey is authenticateWithKey without the account state check
+func findUserByKey(key string) *User {
+	if isSessionToken(key) {
+		user, _ := authenticateSession(key)
+		return user
+	}
+
 	usersMutex.RLock()
 	defer usersMutex.RUnlock()
 
@@ -19,6 +35,24 @@ func authenticateWithKey(key string) *User {
 	return nil
 }
 
+// authenticateSession resolves a per-device session token to its user
+func authenticateSession(token string) (*User, *Session) {
+	username, session, err := lookupSession(token)
+	if err != nil {
+		return nil, nil
+	}
+
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+
+	for _, user := range users {
+		if strings.ToLower(string(user.GetUsername())) == username {
+			return &user, session
+		}
+	}
+	return nil, nil
+}
+
 func doesUserOwnKey(userId UserId, key string) bool {
 	keyOwnershipCacheMutex.Lock()
 	defer keyOwnershipCacheMutex.Unlock()
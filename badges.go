@@ -5,7 +5,6 @@ import (
 	"log"
 	"os"
 	"sync"
-	"time"
 )
 
 const BADGES_FILE_PATH = "./rotur/badges.json"
@@ -28,40 +27,31 @@ func loadJSONBadges() error {
 		return err
 	}
 
-	var badges []JSONBadge
-	if err := json.Unmarshal(data, &badges); err != nil {
+	badges, err := parseJSONBadges(data)
+	if err != nil {
 		log.Printf("Error parsing badges.json: %v", err)
 		log.Printf("Raw data: %s", string(data))
 		return err
 	}
 
-	jsonBadgesMutex.Lock()
-	jsonBadges = badges
-	jsonBadgesMutex.Unlock()
-
-	log.Printf("Successfully loaded %d badges from JSON", len(badges))
+	setJSONBadges(badges)
 	return nil
 }
 
-func watchBadgesFile() {
-	var lastMtime time.Time
-	if stat, err := os.Stat(BADGES_FILE_PATH); err == nil {
-		lastMtime = stat.ModTime()
+func parseJSONBadges(data []byte) ([]JSONBadge, error) {
+	var badges []JSONBadge
+	if err := json.Unmarshal(data, &badges); err != nil {
+		return nil, err
 	}
+	return badges, nil
+}
 
-	for {
-		time.Sleep(500 * time.Millisecond)
-		if stat, err := os.Stat(BADGES_FILE_PATH); err == nil {
-			if stat.ModTime().After(lastMtime) {
-				time.Sleep(500 * time.Millisecond)
-				log.Println("Detected change in badges.json, reloading...")
-				if err := loadJSONBadges(); err != nil {
-					log.Printf("Error reloading badges: %v", err)
-				}
-				lastMtime = stat.ModTime()
-			}
-		}
-	}
+func setJSONBadges(badges []JSONBadge) {
+	jsonBadgesMutex.Lock()
+	jsonBadges = badges
+	jsonBadgesMutex.Unlock()
+
+	log.Printf("Successfully loaded %d badges from JSON", len(badges))
 }
 
 func calculateUserBadges(user User) []Badge {
@@ -4,11 +4,12 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 )
 
 var DAILY_CLAIMS_FILE_PATH = "./rotur_daily.json"
 
-const USERS_FILE_PATH = "./users.json"
+var USERS_FILE_PATH = "./users.json"
 
 var (
 	LOCAL_POSTS_PATH              string
@@ -21,6 +22,7 @@ var (
 	USERDATA_PATH                 string
 	COSMETICS_FILE_PATH           string
 	COSMETICS_ASSETS_PATH         string
+	OAUTH_APPS_FILE_PATH          string
 	WEBSOCKET_SERVER_URL          string
 	EVENT_SERVER_URL              string
 	SUBSCRIPTION_CHECK_INTERVAL   int
@@ -29,6 +31,28 @@ var (
 	DISCORD_WEBHOOK_URL           string
 	KEY_OWNERSHIP_CACHE_TTL       int
 	ADMIN_TOKEN                   string
+	WEBAUTHN_RP_ID                string
+	WEBAUTHN_RP_NAME              string
+	WEBAUTHN_ORIGINS              []string
+	DEVICE_VERIFICATION_URI       string
+	VALIDATOR_KEYS_FILE_PATH      string
+	LEDGER_FILE_PATH              string
+	STANDING_ORDERS_FILE_PATH     string
+	PAYMENT_REQUESTS_FILE_PATH    string
+	ESCROW_CONTRACTS_FILE_PATH    string
+	ECONOMY_SNAPSHOTS_FILE_PATH   string
+	HELD_TRANSFERS_FILE_PATH      string
+	ECONOMY_POLICY_FILE_PATH      string
+	ECONOMY_POLICY_LEDGER_PATH    string
+	STANDING_ORDER_CHECK_INTERVAL int
+	VALIDATOR_ISSUER              string
+	SMTP_HOST                     string
+	SMTP_PORT                     int
+	SMTP_USERNAME                 string
+	SMTP_PASSWORD                 string
+	MAIL_FROM                     string
+	EMAIL_LINK_BASE               string
+	EMAIL_TOKEN_SECRET            string
 
 	bannedDomains = []string{
 		"pornhub.com", "xvideos.com", "xnxx.com", "redtube.com", "youporn.com",
@@ -45,6 +69,7 @@ var (
 		"search":   {Count: 20, Period: 60},
 		"ai":       {Count: 5, Period: 10},
 		"register": {Count: 5, Period: 10},
+		"email":    {Count: 3, Period: 900},
 		"global":   {Count: 10, Period: 10}, // Global rate limit: 10 requests per 10 seconds
 	}
 )
@@ -84,6 +109,16 @@ func loadConfigFromEnv() {
 	KEYS_FILE_PATH = mustEnv("KEYS_FILE_PATH", "./keys.json")
 	EVENTS_HISTORY_PATH = mustEnv("EVENTS_HISTORY_PATH", "./events_history.json")
 	SYSTEMS_FILE_PATH = mustEnv("SYSTEMS_FILE_PATH", "./systems.json")
+	OAUTH_APPS_FILE_PATH = mustEnv("OAUTH_APPS_FILE_PATH", "./oauth_apps.json")
+	VALIDATOR_KEYS_FILE_PATH = mustEnv("VALIDATOR_KEYS_FILE_PATH", "./validator_keys.json")
+	LEDGER_FILE_PATH = mustEnv("LEDGER_FILE_PATH", "./rotur/ledger.jsonl")
+	STANDING_ORDERS_FILE_PATH = mustEnv("STANDING_ORDERS_FILE_PATH", "./standing_orders.json")
+	PAYMENT_REQUESTS_FILE_PATH = mustEnv("PAYMENT_REQUESTS_FILE_PATH", "./payment_requests.json")
+	ESCROW_CONTRACTS_FILE_PATH = mustEnv("ESCROW_CONTRACTS_FILE_PATH", "./escrow_contracts.json")
+	ECONOMY_SNAPSHOTS_FILE_PATH = mustEnv("ECONOMY_SNAPSHOTS_FILE_PATH", "./economy_snapshots.json")
+	HELD_TRANSFERS_FILE_PATH = mustEnv("HELD_TRANSFERS_FILE_PATH", "./held_transfers.json")
+	ECONOMY_POLICY_FILE_PATH = mustEnv("ECONOMY_POLICY_FILE_PATH", "./economy_policy.json")
+	ECONOMY_POLICY_LEDGER_PATH = mustEnv("ECONOMY_POLICY_LEDGER_PATH", "./rotur/economy_policy_ledger.jsonl")
 
 	// External services
 	WEBSOCKET_SERVER_URL = mustEnv("WEBSOCKET_SERVER_URL", "")
@@ -94,10 +129,48 @@ func loadConfigFromEnv() {
 	// Numeric settings
 	SUBSCRIPTION_CHECK_INTERVAL = intEnv("SUBSCRIPTION_CHECK_INTERVAL", 3600)
 	INACTIVITY_TAX_CHECK_INTERVAL = intEnv("INACTIVITY_TAX_CHECK_INTERVAL", 3600)
+	STANDING_ORDER_CHECK_INTERVAL = intEnv("STANDING_ORDER_CHECK_INTERVAL", 300)
+
 	KEY_OWNERSHIP_CACHE_TTL = intEnv("KEY_OWNERSHIP_CACHE_TTL", 600)
 
+	// Fraud rules, see fraud_rules.go for the defaults
+	applyFraudRuleActions(os.Getenv("FRAUD_RULE_ACTIONS"))
+	fraudConfig.NewAccountDays = intEnv("FRAUD_NEW_ACCOUNT_DAYS", fraudConfig.NewAccountDays)
+	fraudConfig.FunnelSenders = intEnv("FRAUD_FUNNEL_SENDERS", fraudConfig.FunnelSenders)
+	fraudConfig.VelocityTransfers = intEnv("FRAUD_VELOCITY_TRANSFERS", fraudConfig.VelocityTransfers)
+	fraudConfig.VelocityCredits = float64(intEnv("FRAUD_VELOCITY_CREDITS", int(fraudConfig.VelocityCredits)))
+	fraudConfig.SharedIpAccounts = intEnv("FRAUD_SHARED_IP_ACCOUNTS", fraudConfig.SharedIpAccounts)
+
+	// Daily claim rewards, see economy_policy.go for the defaults. ECONOMY_POLICY_FILE_PATH overrides these
+	economyPolicy.Faucets.DailyClaim.BaseCredits = intEnv("DAILY_CLAIM_BASE_CREDITS", economyPolicy.Faucets.DailyClaim.BaseCredits)
+	economyPolicy.Faucets.DailyClaim.StreakBonusPercent = intEnv("DAILY_STREAK_BONUS_PERCENT", economyPolicy.Faucets.DailyClaim.StreakBonusPercent)
+	economyPolicy.Faucets.DailyClaim.MaxStreakBonusPercent = intEnv("DAILY_STREAK_MAX_BONUS_PERCENT", economyPolicy.Faucets.DailyClaim.MaxStreakBonusPercent)
+	economyPolicy.Faucets.DailyClaim.GraceDays = intEnv("DAILY_STREAK_GRACE_DAYS", economyPolicy.Faucets.DailyClaim.GraceDays)
+	economyPolicy.Faucets.DailyClaim.MilestoneEvery = intEnv("DAILY_MILESTONE_EVERY", economyPolicy.Faucets.DailyClaim.MilestoneEvery)
+	economyPolicy.Faucets.DailyClaim.MilestoneBonus = intEnv("DAILY_MILESTONE_BONUS", economyPolicy.Faucets.DailyClaim.MilestoneBonus)
+
 	// Auth / admin tokens
 	ADMIN_TOKEN = mustEnv("ADMIN_TOKEN", "")
+
+	// Passkeys
+	WEBAUTHN_RP_ID = mustEnv("WEBAUTHN_RP_ID", "rotur.dev")
+	WEBAUTHN_RP_NAME = mustEnv("WEBAUTHN_RP_NAME", "rotur")
+	WEBAUTHN_ORIGINS = strings.Split(mustEnv("WEBAUTHN_ORIGINS", "https://rotur.dev"), ",")
+
+	// Issuer claim of signed validators
+	VALIDATOR_ISSUER = mustEnv("VALIDATOR_ISSUER", "https://api.rotur.dev")
+
+	// Device linking, where users go to enter the code shown on a device
+	DEVICE_VERIFICATION_URI = mustEnv("DEVICE_VERIFICATION_URI", "https://rotur.dev/link")
+
+	// Account emails, verification and reset links point at EMAIL_LINK_BASE
+	SMTP_HOST = os.Getenv("SMTP_HOST")
+	SMTP_PORT = intEnv("SMTP_PORT", 587)
+	SMTP_USERNAME = os.Getenv("SMTP_USERNAME")
+	SMTP_PASSWORD = os.Getenv("SMTP_PASSWORD")
+	MAIL_FROM = mustEnv("MAIL_FROM", "rotur <noreply@rotur.dev>")
+	EMAIL_LINK_BASE = mustEnv("EMAIL_LINK_BASE", "https://rotur.dev")
+	EMAIL_TOKEN_SECRET = mustEnv("EMAIL_TOKEN_SECRET", "")
 }
 
 func init() {
@@ -475,14 +475,28 @@ func purchaseCosmetic(c *gin.Context) {
 	}
 
 	creatorShare := roundVal(entry.Price * (entry.CreatorPct / 100.0))
-	platformShare := roundVal(entry.Price - creatorShare)
+	platformShare := fromMinor(toMinor(entry.Price) - toMinor(creatorShare))
 
-	newPurchaserBal := roundVal(userCredits - entry.Price)
-	if newPurchaserBal < 0 {
+	creatorUser := getUserById(entry.CreatorId)
+	mistUser, mistErr := getAccountByUsername(Username("mist"))
+
+	// whichever side is missing is booked to the platform instead
+	postings := []Posting{userPosting(*user, -entry.Price)}
+	if len(creatorUser) > 0 {
+		postings = append(postings, userPosting(creatorUser, creatorShare))
+	} else {
+		postings = append(postings, accountPosting(LedgerSink, creatorShare))
+	}
+	if mistErr == nil && len(mistUser) > 0 {
+		postings = append(postings, userPosting(mistUser, platformShare))
+	} else {
+		postings = append(postings, accountPosting(LedgerTax, platformShare))
+	}
+	if _, err := postLedger("cosmetic_purchase", id, postings...); err != nil {
 		c.JSON(400, gin.H{"error": "Insufficient credits"})
 		return
 	}
-	user.SetBalance(newPurchaserBal)
+	newPurchaserBal := user.GetCredits()
 	now := time.Now().UnixMilli()
 	user.addTransaction(Transaction{
 		Note:      "Cosmetic purchase: " + entry.Name,
@@ -493,11 +507,8 @@ func purchaseCosmetic(c *gin.Context) {
 		NewTotal:  newPurchaserBal,
 	})
 
-	creatorUser := getUserById(entry.CreatorId)
 	if len(creatorUser) > 0 {
-		creatorBal := creatorUser.GetCredits()
-		newCreatorBal := roundVal(creatorBal + creatorShare)
-		creatorUser.SetBalance(newCreatorBal)
+		newCreatorBal := creatorUser.GetCredits()
 		creatorUser.addTransaction(Transaction{
 			Note:      "Cosmetic sale: " + entry.Name,
 			User:      user.GetId(),
@@ -508,11 +519,8 @@ func purchaseCosmetic(c *gin.Context) {
 		})
 	}
 
-	mistUser, mistErr := getAccountByUsername(Username("mist"))
 	if mistErr == nil && len(mistUser) > 0 {
-		mistBal := mistUser.GetCredits()
-		newMistBal := roundVal(mistBal + platformShare)
-		mistUser.SetBalance(newMistBal)
+		newMistBal := mistUser.GetCredits()
 		if platformShare > 0 {
 			mistUser.addTransaction(Transaction{
 				Note:      "Cosmetic platform cut: " + entry.Name,
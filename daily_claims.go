@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dailyClaimPeriod = 86400
+
+// claims are appended to the journal and folded into the snapshot every so often
+const dailyClaimsCompactEvery = 1000
+
+// DailyClaimRewards is the reward curve for daily claims, set by the economy
+// policy. A claim pays BaseCredits x the tier multiplier x the streak bonus, and
+// every MilestoneEvery days of streak pays MilestoneBonus x the multiplier on top.
+type DailyClaimRewards struct {
+	BaseCredits           int `json:"base_credits"`
+	StreakBonusPercent    int `json:"streak_bonus_percent"`     // added per day of streak after the first
+	MaxStreakBonusPercent int `json:"max_streak_bonus_percent"` // cap on the streak bonus
+	GraceDays             int `json:"grace_days"`               // missed days a streak survives, refilled at each milestone
+	MilestoneEvery        int `json:"milestone_every"`
+	MilestoneBonus        int `json:"milestone_bonus"`
+}
+
+type DailyClaimState struct {
+	LastClaim   int64 `json:"last_claim"`
+	Streak      int   `json:"streak"`
+	BestStreak  int   `json:"best_streak"`
+	GraceUsed   int   `json:"grace_used,omitempty"`
+	TotalClaims int   `json:"total_claims"`
+}
+
+type dailyClaimRecord struct {
+	Username Username `json:"username"`
+	DailyClaimState
+}
+
+var (
+	dailyClaims        = make(map[Username]DailyClaimState)
+	dailyClaimsJournal int
+	dailyClaimMutex    sync.Mutex
+)
+
+func dailyClaimsJournalPath() string {
+	return strings.TrimSuffix(DAILY_CLAIMS_FILE_PATH, ".json") + ".jsonl"
+}
+
+// loadDailyClaims reads the snapshot, replays the journal on top of it and
+// compacts the two. Snapshots from before streaks only hold the last claim time.
+func loadDailyClaims() {
+	dailyClaimMutex.Lock()
+	defer dailyClaimMutex.Unlock()
+
+	dailyClaims = make(map[Username]DailyClaimState)
+	dailyClaimsJournal = 0
+
+	if data, err := os.ReadFile(DAILY_CLAIMS_FILE_PATH); err == nil {
+		var raw map[Username]json.RawMessage
+		if err := json.Unmarshal(data, &raw); err != nil {
+			log.Printf("Error unmarshaling daily claims: %v", err)
+		}
+		for username, value := range raw {
+			var state DailyClaimState
+			var last float64
+			if json.Unmarshal(value, &last) == nil {
+				state = DailyClaimState{LastClaim: int64(last), Streak: 1, BestStreak: 1, TotalClaims: 1}
+			} else if err := json.Unmarshal(value, &state); err != nil {
+				continue
+			}
+			dailyClaims[username] = state
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("Error reading daily claims file: %v", err)
+	}
+
+	if f, err := os.Open(dailyClaimsJournalPath()); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var rec dailyClaimRecord
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.Username == "" {
+				continue
+			}
+			dailyClaims[rec.Username] = rec.DailyClaimState
+			dailyClaimsJournal++
+		}
+		f.Close()
+	}
+	if dailyClaimsJournal > 0 {
+		compactDailyClaimsLocked()
+	}
+
+	log.Printf("Loaded %d daily claims", len(dailyClaims))
+}
+
+// compactDailyClaimsLocked writes every claim to the snapshot and empties the journal
+func compactDailyClaimsLocked() {
+	if !saveJsonFile(DAILY_CLAIMS_FILE_PATH, dailyClaims) {
+		return
+	}
+	if err := os.Truncate(dailyClaimsJournalPath(), 0); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error truncating daily claims journal: %v", err)
+		return
+	}
+	dailyClaimsJournal = 0
+}
+
+func appendDailyClaimLocked(rec dailyClaimRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dailyClaimsJournalPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	dailyClaimsJournal++
+	if dailyClaimsJournal >= dailyClaimsCompactEvery {
+		compactDailyClaimsLocked()
+	}
+	return nil
+}
+
+func getDailyClaim(username Username) (DailyClaimState, bool) {
+	dailyClaimMutex.Lock()
+	defer dailyClaimMutex.Unlock()
+	state, ok := dailyClaims[username.ToLower()]
+	return state, ok
+}
+
+// graceLeft is how many more days the streak can miss
+func (s DailyClaimState) graceLeft() int {
+	return max(getEconomyPolicy().Faucets.DailyClaim.GraceDays-s.GraceUsed, 0)
+}
+
+// nextDailyClaim works out the state a claim made now would leave, or how many
+// seconds are left until the next claim
+func nextDailyClaim(prev DailyClaimState, now time.Time) (DailyClaimState, float64) {
+	next := prev
+	next.LastClaim = now.Unix()
+	next.TotalClaims++
+
+	elapsed := now.Unix() - prev.LastClaim
+	switch {
+	case prev.LastClaim == 0 || prev.Streak == 0:
+		next.Streak, next.GraceUsed = 1, 0
+	case elapsed < dailyClaimPeriod:
+		return prev, float64(dailyClaimPeriod - elapsed)
+	default:
+		missed := int((elapsed - dailyClaimPeriod) / dailyClaimPeriod)
+		if missed <= prev.graceLeft() {
+			next.Streak++
+			next.GraceUsed += missed
+		} else {
+			next.Streak, next.GraceUsed = 1, 0
+		}
+	}
+
+	if every := getEconomyPolicy().Faucets.DailyClaim.MilestoneEvery; every > 0 && next.Streak%every == 0 {
+		next.GraceUsed = 0
+	}
+	next.BestStreak = max(next.BestStreak, next.Streak)
+	return next, 0
+}
+
+// streakExpiresIn is how many seconds are left to claim before the streak resets
+func (s DailyClaimState) streakExpiresIn(now time.Time) int64 {
+	if s.Streak == 0 {
+		return 0
+	}
+	deadline := s.LastClaim + int64(2+s.graceLeft())*dailyClaimPeriod
+	return max(deadline-now.Unix(), 0)
+}
+
+// dailyClaimReward prices a claim for a tier multiplier and the streak it reaches
+func dailyClaimReward(multiplier int, streak int) (float64, bool) {
+	return dailyClaimRewardFor(getEconomyPolicy().Faucets.DailyClaim, multiplier, streak)
+}
+
+func dailyClaimRewardFor(r DailyClaimRewards, multiplier int, streak int) (float64, bool) {
+	bonus := min(max(streak-1, 0)*r.StreakBonusPercent, r.MaxStreakBonusPercent)
+	amount := float64(r.BaseCredits*multiplier) * float64(100+bonus) / 100
+
+	milestone := r.MilestoneEvery > 0 && streak > 0 && streak%r.MilestoneEvery == 0
+	if milestone {
+		amount += float64(r.MilestoneBonus * multiplier)
+	}
+	return roundVal(amount), milestone
+}
+
+// recordDailyClaim stores a claim worked out by nextDailyClaim. It fails if
+// another claim was recorded in between.
+func recordDailyClaim(username Username, prev DailyClaimState, next DailyClaimState) bool {
+	username = username.ToLower()
+	dailyClaimMutex.Lock()
+	defer dailyClaimMutex.Unlock()
+
+	if dailyClaims[username].LastClaim != prev.LastClaim {
+		return false
+	}
+	dailyClaims[username] = next
+	if err := appendDailyClaimLocked(dailyClaimRecord{Username: username, DailyClaimState: next}); err != nil {
+		log.Printf("Error saving daily claim for %s: %v", username, err)
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDailyClaimStreakAndGrace(t *testing.T) {
+	day := time.Duration(dailyClaimPeriod) * time.Second
+	start := time.Unix(1_700_000_000, 0)
+
+	s, wait := nextDailyClaim(DailyClaimState{}, start)
+	if wait != 0 || s.Streak != 1 {
+		t.Fatalf("First claim should start a streak, got %+v", s)
+	}
+	if _, wait := nextDailyClaim(s, start.Add(time.Hour)); wait != float64(dailyClaimPeriod-3600) {
+		t.Errorf("Expected 23 hours left, got %v", wait)
+	}
+
+	s, _ = nextDailyClaim(s, start.Add(day+time.Hour))
+	if s.Streak != 2 || s.GraceUsed != 0 {
+		t.Fatalf("Claim the next day should extend the streak, got %+v", s)
+	}
+	// one missed day is covered by the grace day
+	s, _ = nextDailyClaim(s, start.Add(3*day+2*time.Hour))
+	if s.Streak != 3 || s.GraceUsed != 1 || s.graceLeft() != 0 {
+		t.Fatalf("Missed day should use grace, got %+v", s)
+	}
+	s, _ = nextDailyClaim(s, start.Add(5*day+3*time.Hour))
+	if s.Streak != 1 || s.BestStreak != 3 || s.TotalClaims != 4 {
+		t.Errorf("Missing another day should reset the streak, got %+v", s)
+	}
+}
+
+func TestDailyClaimReward(t *testing.T) {
+	if amount, milestone := dailyClaimReward(1, 1); amount != 1 || milestone {
+		t.Errorf("Day one pays the base, got %v", amount)
+	}
+	if amount, _ := dailyClaimReward(2, 3); amount != 2.4 {
+		t.Errorf("Expected base x2 with a 20%% streak bonus, got %v", amount)
+	}
+	if amount, milestone := dailyClaimReward(3, 7); amount != 3*1.6+3*2 || !milestone {
+		t.Errorf("Expected the weekly milestone bonus, got %v", amount)
+	}
+	if amount, _ := dailyClaimReward(1, 50); amount != 2 {
+		t.Errorf("Streak bonus should be capped, got %v", amount)
+	}
+}
+
+func TestDailyClaimsJournal(t *testing.T) {
+	withTestDailyClaims(t)
+
+	// claims saved before streaks only hold the time
+	if err := os.WriteFile(DAILY_CLAIMS_FILE_PATH, []byte(`{"old": 1700000000}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loadDailyClaims()
+	prev, ok := getDailyClaim("Old")
+	if !ok || prev.LastClaim != 1700000000 || prev.Streak != 1 {
+		t.Fatalf("Legacy claim should load as a one day streak, got %+v", prev)
+	}
+
+	next, _ := nextDailyClaim(prev, time.Unix(prev.LastClaim+dailyClaimPeriod, 0))
+	if !recordDailyClaim("old", prev, next) {
+		t.Fatal("Claim should be recorded")
+	}
+	if recordDailyClaim("old", prev, next) {
+		t.Error("Second claim from the same state should be refused")
+	}
+	if dailyClaimsJournal != 1 {
+		t.Errorf("Claim should be appended to the journal, got %d lines", dailyClaimsJournal)
+	}
+
+	loadDailyClaims()
+	if s, _ := getDailyClaim("old"); s.Streak != 2 || dailyClaimsJournal != 0 {
+		t.Errorf("Journal should be replayed and compacted, got %+v", s)
+	}
+	if info, err := os.Stat(dailyClaimsJournalPath()); err != nil || info.Size() != 0 {
+		t.Errorf("Journal should be empty after compaction, %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Device authorization grant (RFC 8628) for signing in on devices without a
+// browser: the device shows a short user code, the user approves it while signed
+// in elsewhere, and the device polls until it receives a sub-token.
+
+const (
+	DeviceCodeLifetime      = 10 * time.Minute
+	DevicePollInterval      = 5 // seconds
+	DeviceSlowDownIncrement = 5 // seconds added to the interval on each slow_down
+	MaxPendingDeviceCodes   = 10000
+	DefaultDeviceScope      = "read_only"
+
+	// linked devices are signed out after this long without use
+	DeviceTokenIdleHrs = 90 * 24
+
+	// no vowels so codes can't spell words, no digits to avoid 0/O and 1/I mixups
+	deviceUserCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	deviceUserCodeLength   = 8
+)
+
+const (
+	DeviceStatusPending  = "pending"
+	DeviceStatusApproved = "approved"
+	DeviceStatusDenied   = "denied"
+)
+
+type deviceAuthorization struct {
+	DeviceCode  string
+	UserCode    string
+	ClientName  string
+	Permissions []TokenPermission
+	CreatedAt   int64
+	ExpiresAt   int64
+	Interval    int
+	LastPollAt  int64
+	Status      string
+	Username    string
+}
+
+var (
+	deviceCodes      = make(map[string]*deviceAuthorization) // device code -> authorization
+	deviceUserCodes  = make(map[string]string)               // user code -> device code
+	deviceCodesMutex sync.Mutex
+)
+
+var (
+	errDevicePending  = fmt.Errorf("authorization_pending")
+	errDeviceSlowDown = fmt.Errorf("slow_down")
+	errDeviceDenied   = fmt.Errorf("access_denied")
+	errDeviceExpired  = fmt.Errorf("expired_token")
+)
+
+func generateDeviceCode() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return "rotur_dc_" + b64url(b)
+}
+
+func generateDeviceUserCode() string {
+	var sb strings.Builder
+	limit := big.NewInt(int64(len(deviceUserCodeAlphabet)))
+	for i := 0; i < deviceUserCodeLength; i++ {
+		if i == deviceUserCodeLength/2 {
+			sb.WriteByte('-')
+		}
+		n, _ := rand.Int(rand.Reader, limit)
+		sb.WriteByte(deviceUserCodeAlphabet[n.Int64()])
+	}
+	return sb.String()
+}
+
+// normalizeDeviceUserCode accepts codes typed in lowercase or without the dash
+func normalizeDeviceUserCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != deviceUserCodeLength {
+		return code
+	}
+	return code[:deviceUserCodeLength/2] + "-" + code[deviceUserCodeLength/2:]
+}
+
+// pruneDeviceCodes drops expired entries, the caller holds deviceCodesMutex
+func pruneDeviceCodes(now int64) {
+	for _, d := range deviceCodes {
+		if d.ExpiresAt < now {
+			forgetDeviceCode(d)
+		}
+	}
+}
+
+// forgetDeviceCode removes d, the caller holds deviceCodesMutex
+func forgetDeviceCode(d *deviceAuthorization) {
+	// a used user code may since have been handed to another device
+	if deviceUserCodes[d.UserCode] == d.DeviceCode {
+		delete(deviceUserCodes, d.UserCode)
+	}
+	delete(deviceCodes, d.DeviceCode)
+}
+
+func startDeviceAuthorization(clientName string, perms []TokenPermission) (*deviceAuthorization, error) {
+	deviceCodesMutex.Lock()
+	defer deviceCodesMutex.Unlock()
+
+	now := time.Now().UnixMilli()
+	pruneDeviceCodes(now)
+	if len(deviceCodes) >= MaxPendingDeviceCodes {
+		return nil, fmt.Errorf("too many pending device authorizations, try again later")
+	}
+
+	userCode := generateDeviceUserCode()
+	for _, taken := deviceUserCodes[userCode]; taken; _, taken = deviceUserCodes[userCode] {
+		userCode = generateDeviceUserCode()
+	}
+
+	d := &deviceAuthorization{
+		DeviceCode:  generateDeviceCode(),
+		UserCode:    userCode,
+		ClientName:  clientName,
+		Permissions: perms,
+		CreatedAt:   now,
+		ExpiresAt:   now + DeviceCodeLifetime.Milliseconds(),
+		Interval:    DevicePollInterval,
+		Status:      DeviceStatusPending,
+	}
+	deviceCodes[d.DeviceCode] = d
+	deviceUserCodes[userCode] = d.DeviceCode
+
+	copied := *d
+	return &copied, nil
+}
+
+// getDeviceAuthorization looks up a pending authorization by the code the user typed
+func getDeviceAuthorization(userCode string) (*deviceAuthorization, bool) {
+	deviceCodesMutex.Lock()
+	defer deviceCodesMutex.Unlock()
+
+	deviceCode, ok := deviceUserCodes[normalizeDeviceUserCode(userCode)]
+	if !ok {
+		return nil, false
+	}
+	d, ok := deviceCodes[deviceCode]
+	if !ok || d.ExpiresAt < time.Now().UnixMilli() || d.Status != DeviceStatusPending {
+		return nil, false
+	}
+	copied := *d
+	return &copied, true
+}
+
+// decideDeviceAuthorization records the user's answer, a code can only be decided once
+func decideDeviceAuthorization(userCode string, username string, approve bool) error {
+	deviceCodesMutex.Lock()
+	defer deviceCodesMutex.Unlock()
+
+	deviceCode, ok := deviceUserCodes[normalizeDeviceUserCode(userCode)]
+	if !ok {
+		return fmt.Errorf("code not found or expired")
+	}
+	d, ok := deviceCodes[deviceCode]
+	if !ok || d.ExpiresAt < time.Now().UnixMilli() {
+		return fmt.Errorf("code not found or expired")
+	}
+	if d.Status != DeviceStatusPending {
+		return fmt.Errorf("code has already been used")
+	}
+
+	// the user code is short, so stop it being guessed again once it has been used
+	delete(deviceUserCodes, d.UserCode)
+	if approve {
+		d.Status = DeviceStatusApproved
+		d.Username = strings.ToLower(username)
+	} else {
+		d.Status = DeviceStatusDenied
+	}
+	return nil
+}
+
+// pollDeviceAuthorization implements the token endpoint checks. An approved
+// authorization is removed so the device code can only be exchanged once.
+func pollDeviceAuthorization(deviceCode string) (*deviceAuthorization, error) {
+	deviceCodesMutex.Lock()
+	defer deviceCodesMutex.Unlock()
+
+	d, ok := deviceCodes[deviceCode]
+	if !ok {
+		return nil, errDeviceExpired
+	}
+
+	now := time.Now().UnixMilli()
+	if d.ExpiresAt < now {
+		forgetDeviceCode(d)
+		return nil, errDeviceExpired
+	}
+
+	if d.LastPollAt != 0 && now-d.LastPollAt < int64(d.Interval)*1000 {
+		d.Interval += DeviceSlowDownIncrement
+		d.LastPollAt = now
+		return nil, errDeviceSlowDown
+	}
+	d.LastPollAt = now
+
+	switch d.Status {
+	case DeviceStatusApproved:
+		forgetDeviceCode(d)
+		copied := *d
+		return &copied, nil
+	case DeviceStatusDenied:
+		forgetDeviceCode(d)
+		return nil, errDeviceDenied
+	}
+	return nil, errDevicePending
+}
+
+// issueDeviceSubToken creates the sub-token handed to a linked device
+func issueDeviceSubToken(username string, d *deviceAuthorization) (*SubToken, error) {
+	store, err := loadTokenStore(username)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	activeCount := 0
+	for _, t := range store.Tokens {
+		if !t.Revoked && (t.ExpiresAt == nil || *t.ExpiresAt > now || t.refreshable(now)) {
+			activeCount++
+		}
+	}
+	if activeCount >= 25 {
+		return nil, fmt.Errorf("maximum of 25 active sub-tokens reached")
+	}
+
+	subToken := SubToken{
+		ID:             generateSubTokenID(),
+		Name:           d.ClientName,
+		Token:          generateSubTokenValue(),
+		Permissions:    d.Permissions,
+		CreatedAt:      now,
+		Description:    "Linked device",
+		Websites:       []string{},
+		IdleTimeoutHrs: DeviceTokenIdleHrs,
+	}
+	store.Tokens = append(store.Tokens, subToken)
+
+	if err := saveTokenStore(username, store); err != nil {
+		return nil, err
+	}
+
+	addToSubTokenIndex(subToken.Token, username, subToken.ID)
+	return &subToken, nil
+}
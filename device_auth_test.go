@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// rewindDevicePoll pretends the last poll was long enough ago
+func rewindDevicePoll(deviceCode string) {
+	deviceCodesMutex.Lock()
+	defer deviceCodesMutex.Unlock()
+	if d, ok := deviceCodes[deviceCode]; ok {
+		d.LastPollAt -= int64(d.Interval) * 1000
+	}
+}
+
+func TestDeviceAuthorizationFlow(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "device_test_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origPath := USERDATA_PATH
+	USERDATA_PATH = tmpDir
+	defer func() { USERDATA_PATH = origPath }()
+
+	perms, _ := parseOAuthScope(DefaultDeviceScope)
+	d, err := startDeviceAuthorization("TV", perms)
+	if err != nil {
+		t.Fatalf("Failed to start device authorization: %v", err)
+	}
+
+	if _, err := pollDeviceAuthorization(d.DeviceCode); err != errDevicePending {
+		t.Fatalf("Expected authorization_pending, got %v", err)
+	}
+	if _, err := pollDeviceAuthorization(d.DeviceCode); err != errDeviceSlowDown {
+		t.Fatalf("Expected slow_down, got %v", err)
+	}
+
+	// typed in lowercase without the dash
+	typed := strings.ToLower(d.UserCode[:4] + d.UserCode[5:])
+	if _, ok := getDeviceAuthorization(typed); !ok {
+		t.Fatal("User code should be found regardless of formatting")
+	}
+	if err := decideDeviceAuthorization(typed, "DeviceUser", true); err != nil {
+		t.Fatalf("Failed to approve: %v", err)
+	}
+	if err := decideDeviceAuthorization(d.UserCode, "other", true); err == nil {
+		t.Error("User code should only be usable once")
+	}
+
+	rewindDevicePoll(d.DeviceCode)
+	approved, err := pollDeviceAuthorization(d.DeviceCode)
+	if err != nil {
+		t.Fatalf("Expected approval, got %v", err)
+	}
+	if approved.Username != "deviceuser" {
+		t.Errorf("Expected deviceuser, got %s", approved.Username)
+	}
+	if _, err := pollDeviceAuthorization(d.DeviceCode); err != errDeviceExpired {
+		t.Error("Device code should only be exchanged once")
+	}
+
+	token, err := issueDeviceSubToken(approved.Username, approved)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	if token.IdleTimeoutHrs != DeviceTokenIdleHrs || token.hasPermission(PermTransferCredits) {
+		t.Errorf("Device token should be idle-limited and scoped, got %+v", token)
+	}
+}
+
+func TestDeviceAuthorizationDenied(t *testing.T) {
+	d, err := startDeviceAuthorization("Console", []TokenPermission{PermViewProfile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := decideDeviceAuthorization(d.UserCode, "deviceuser", false); err != nil {
+		t.Fatalf("Failed to deny: %v", err)
+	}
+	if _, err := pollDeviceAuthorization(d.DeviceCode); err != errDeviceDenied {
+		t.Errorf("Expected access_denied, got %v", err)
+	}
+}
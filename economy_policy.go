@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The economy policy decides how credits enter the economy (faucets) and leave
+// it (sinks). It is read from ECONOMY_POLICY_FILE_PATH, reloaded when the file
+// changes, and every credit it moves is also written to the policy ledger.
+
+const (
+	PolicyDailyClaim        = "daily_claim"
+	PolicySystemOwnerReward = "system_owner_reward"
+	PolicyTransferTax       = "transfer_tax"
+	PolicyGiftTax           = "gift_tax"
+	PolicyInactivityDecay   = "inactivity_decay"
+
+	PolicyFaucet = "faucet"
+	PolicySink   = "sink"
+)
+
+var policyKinds = map[string]string{
+	PolicyDailyClaim:        PolicyFaucet,
+	PolicySystemOwnerReward: PolicyFaucet,
+	PolicyTransferTax:       PolicySink,
+	PolicyGiftTax:           PolicySink,
+	PolicyInactivityDecay:   PolicySink,
+}
+
+type EconomyPolicy struct {
+	Faucets EconomyFaucets `json:"faucets"`
+	Sinks   EconomySinks   `json:"sinks"`
+}
+
+type EconomyFaucets struct {
+	DailyClaim        DailyClaimRewards `json:"daily_claim"`
+	SystemOwnerReward float64           `json:"system_owner_reward"` // minted to a system's owner whenever rotur pays one of its users
+}
+
+type EconomySinks struct {
+	TransferTaxPercent float64               `json:"transfer_tax_percent"` // paid by the sender on top of user to user transfers
+	GiftTaxPercent     float64               `json:"gift_tax_percent"`
+	InactivityDecay    InactivityDecayPolicy `json:"inactivity_decay"`
+}
+
+// InactivityDecayPolicy takes Percent of the balance above MinBalance from
+// accounts that haven't logged in for InactiveDays, once every IntervalHours
+type InactivityDecayPolicy struct {
+	Enabled       bool    `json:"enabled"`
+	InactiveDays  int     `json:"inactive_days"`
+	Percent       float64 `json:"percent"`
+	MinBalance    float64 `json:"min_balance"`
+	IntervalHours int     `json:"interval_hours"`
+}
+
+func defaultEconomyPolicy() EconomyPolicy {
+	return EconomyPolicy{
+		Faucets: EconomyFaucets{
+			DailyClaim: DailyClaimRewards{
+				BaseCredits:           1,
+				StreakBonusPercent:    10,
+				MaxStreakBonusPercent: 100,
+				GraceDays:             1,
+				MilestoneEvery:        7,
+				MilestoneBonus:        2,
+			},
+			SystemOwnerReward: 0.25,
+		},
+		Sinks: EconomySinks{
+			TransferTaxPercent: 0,
+			GiftTaxPercent:     1,
+			InactivityDecay: InactivityDecayPolicy{
+				InactiveDays:  180,
+				Percent:       1,
+				MinBalance:    100,
+				IntervalHours: 24,
+			},
+		},
+	}
+}
+
+var (
+	economyPolicy      = defaultEconomyPolicy()
+	economyPolicyMutex sync.RWMutex
+
+	policyLedgerMutex sync.Mutex
+	lastDecayRun      int64 // ms, guarded by economyPolicyMutex
+)
+
+func getEconomyPolicy() EconomyPolicy {
+	economyPolicyMutex.RLock()
+	defer economyPolicyMutex.RUnlock()
+	return economyPolicy
+}
+
+func setEconomyPolicy(p EconomyPolicy) {
+	economyPolicyMutex.Lock()
+	economyPolicy = p
+	economyPolicyMutex.Unlock()
+}
+
+func validateEconomyPolicy(p EconomyPolicy) error {
+	d := p.Faucets.DailyClaim
+	decay := p.Sinks.InactivityDecay
+	switch {
+	case d.BaseCredits < 0 || d.StreakBonusPercent < 0 || d.MaxStreakBonusPercent < 0 || d.MilestoneBonus < 0:
+		return errors.New("daily claim rewards cannot be negative")
+	case d.GraceDays < 0 || d.MilestoneEvery < 0:
+		return errors.New("grace_days and milestone_every cannot be negative")
+	case p.Faucets.SystemOwnerReward < 0:
+		return errors.New("system_owner_reward cannot be negative")
+	case p.Sinks.TransferTaxPercent < 0 || p.Sinks.TransferTaxPercent > 50:
+		return errors.New("transfer_tax_percent must be between 0 and 50")
+	case p.Sinks.GiftTaxPercent < 0 || p.Sinks.GiftTaxPercent > 50:
+		return errors.New("gift_tax_percent must be between 0 and 50")
+	case decay.Percent < 0 || decay.Percent > 100:
+		return errors.New("inactivity decay percent must be between 0 and 100")
+	case decay.MinBalance < 0:
+		return errors.New("inactivity decay min_balance cannot be negative")
+	case decay.Enabled && (decay.InactiveDays < 1 || decay.IntervalHours < 1):
+		return errors.New("inactivity decay needs inactive_days and interval_hours of at least 1")
+	}
+	return nil
+}
+
+// parseEconomyPolicy reads a policy file on top of the running policy, so a
+// file only needs the settings it changes
+func parseEconomyPolicy(data []byte) (EconomyPolicy, error) {
+	p := getEconomyPolicy()
+	if len(data) == 0 {
+		return p, errors.New("file is empty")
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, err
+	}
+	return p, validateEconomyPolicy(p)
+}
+
+func loadEconomyPolicy() {
+	if data, err := os.ReadFile(ECONOMY_POLICY_FILE_PATH); err == nil {
+		p, err := parseEconomyPolicy(data)
+		if err != nil {
+			log.Printf("Error loading economy policy, keeping defaults: %v", err)
+		} else {
+			setEconomyPolicy(p)
+			log.Printf("Loaded economy policy")
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("Error reading economy policy file: %v", err)
+	}
+
+	if data, err := os.ReadFile(economyPolicyStatePath()); err == nil {
+		var state economyPolicyState
+		if err := json.Unmarshal(data, &state); err != nil {
+			log.Printf("Error unmarshaling economy policy state: %v", err)
+		} else if state.LastDecayRun > 0 {
+			economyPolicyMutex.Lock()
+			lastDecayRun = state.LastDecayRun
+			economyPolicyMutex.Unlock()
+			return
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("Error reading economy policy state: %v", err)
+	}
+
+	// without a state file the last decay run is the newest decay in the policy ledger
+	movements, err := readPolicyMovements(PolicyInactivityDecay, 0, 1)
+	if err != nil {
+		log.Printf("Error reading policy ledger: %v", err)
+		return
+	}
+	if len(movements) > 0 {
+		economyPolicyMutex.Lock()
+		lastDecayRun = movements[0].Timestamp
+		economyPolicyMutex.Unlock()
+	}
+}
+
+// economyPolicyState is kept next to the policy file so a restart knows when the
+// decay last ran, even when it charged nobody
+type economyPolicyState struct {
+	LastDecayRun int64 `json:"last_decay_run"`
+}
+
+func economyPolicyStatePath() string {
+	return strings.TrimSuffix(ECONOMY_POLICY_FILE_PATH, ".json") + "_state.json"
+}
+
+func saveEconomyPolicy(p EconomyPolicy) bool {
+	return saveJsonFile(ECONOMY_POLICY_FILE_PATH, p)
+}
+
+// PolicyMovement is a credit movement made by a policy. LedgerId points at the
+// ledger entry that moved the credits.
+type PolicyMovement struct {
+	Policy    string  `json:"policy"`
+	Kind      string  `json:"kind"`
+	UserId    UserId  `json:"user_id,omitempty"`
+	Amount    float64 `json:"amount"`
+	LedgerId  string  `json:"ledger_id,omitempty"`
+	Timestamp int64   `json:"ts"`
+}
+
+func recordPolicyMovement(policy string, entry *LedgerEntry, userId UserId, amount float64) {
+	m := PolicyMovement{
+		Policy:    policy,
+		Kind:      policyKinds[policy],
+		UserId:    userId,
+		Amount:    amount,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	if entry != nil {
+		m.LedgerId = entry.Id
+		m.Timestamp = entry.Timestamp
+	}
+
+	line, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	policyLedgerMutex.Lock()
+	defer policyLedgerMutex.Unlock()
+	if err := os.MkdirAll(filepath.Dir(ECONOMY_POLICY_LEDGER_PATH), 0755); err != nil {
+		log.Printf("Error saving policy movement: %v", err)
+		return
+	}
+	f, err := os.OpenFile(ECONOMY_POLICY_LEDGER_PATH, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Error saving policy movement: %v", err)
+		return
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("Error saving policy movement: %v", err)
+	}
+	f.Close()
+}
+
+// readPolicyMovements returns up to limit movements newest first, optionally
+// for one policy and after since (ms)
+func readPolicyMovements(policy string, since int64, limit int) ([]PolicyMovement, error) {
+	policyLedgerMutex.Lock()
+	defer policyLedgerMutex.Unlock()
+
+	movements := make([]PolicyMovement, 0)
+	f, err := os.Open(ECONOMY_POLICY_LEDGER_PATH)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return movements, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var m PolicyMovement
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			continue
+		}
+		if (policy != "" && m.Policy != policy) || m.Timestamp <= since {
+			continue
+		}
+		movements = append(movements, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(movements)-1; i < j; i, j = i+1, j-1 {
+		movements[i], movements[j] = movements[j], movements[i]
+	}
+	if limit > 0 && len(movements) > limit {
+		movements = movements[:limit]
+	}
+	return movements, nil
+}
+
+// lastActive is the newest of a user's last login and account creation, in ms
+func lastActive(u User) int64 {
+	return max(int64(u.GetInt("sys.last_login")), u.GetCreated())
+}
+
+// inactivityDecayCharge is what the decay policy would take from a user now
+func inactivityDecayCharge(policy InactivityDecayPolicy, u User, now time.Time) float64 {
+	if u.GetUsername() == "rotur" || u.GetId() == "" {
+		return 0
+	}
+	cutoff := now.AddDate(0, 0, -policy.InactiveDays).UnixMilli()
+	if lastActive(u) > cutoff {
+		return 0
+	}
+	excess := u.GetCredits() - policy.MinBalance
+	if excess <= 0 {
+		return 0
+	}
+	return roundVal(excess * policy.Percent / 100)
+}
+
+func snapshotUsers() []User {
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+	snapshot := make([]User, len(users))
+	copy(snapshot, users)
+	return snapshot
+}
+
+// applyInactivityDecay charges every inactive account and returns how many were
+// charged and how much was taken
+func applyInactivityDecay(policy InactivityDecayPolicy, now time.Time) (int, float64) {
+	accounts := 0
+	var total int64
+	for _, u := range snapshotUsers() {
+		charge := inactivityDecayCharge(policy, u, now)
+		if charge < 0.01 {
+			continue
+		}
+		entry, err := postLedger("inactivity_decay", "Inactivity decay", userPosting(u, -charge), accountPosting(LedgerTax, charge))
+		if err != nil {
+			log.Printf("Inactivity decay failed for %s: %v", u.GetUsername(), err)
+			continue
+		}
+		u.addTransaction(Transaction{
+			Note:     "Inactivity decay",
+			User:     UserId(""),
+			Amount:   charge,
+			Type:     "tax",
+			NewTotal: u.GetCredits(),
+		})
+		recordPolicyMovement(PolicyInactivityDecay, entry, u.GetId(), charge)
+		accounts++
+		total += toMinor(charge)
+	}
+	economyPolicyMutex.Lock()
+	lastDecayRun = now.UnixMilli()
+	economyPolicyMutex.Unlock()
+	saveJsonFile(economyPolicyStatePath(), economyPolicyState{LastDecayRun: now.UnixMilli()})
+	return accounts, fromMinor(total)
+}
+
+// runEconomyPolicy runs the scheduled sinks, the rest of the policy is applied
+// as credits move
+func runEconomyPolicy() {
+	ticker := time.NewTicker(time.Duration(INACTIVITY_TAX_CHECK_INTERVAL) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		economyPolicyMutex.RLock()
+		decay, lastRun := economyPolicy.Sinks.InactivityDecay, lastDecayRun
+		economyPolicyMutex.RUnlock()
+		now := time.Now()
+		if !decay.Enabled || now.Sub(time.UnixMilli(lastRun)) < time.Duration(decay.IntervalHours)*time.Hour {
+			continue
+		}
+		accounts, total := applyInactivityDecay(decay, now)
+		if accounts > 0 {
+			log.Printf("Inactivity decay took %.2f credits from %d accounts", total, accounts)
+			go saveUsers()
+		}
+	}
+}
+
+// PolicySimulation is what a policy would move, worked out against the current
+// users and the last week of the ledger without moving anything
+type PolicySimulation struct {
+	Policy             EconomyPolicy       `json:"policy"`
+	Accounts           int                 `json:"accounts"`
+	WindowDays         int                 `json:"window_days"`
+	InactivityDecay    PolicySimulatedSink `json:"inactivity_decay"`
+	TransferTax        PolicySimulatedSink `json:"transfer_tax"`
+	GiftTax            PolicySimulatedSink `json:"gift_tax"`
+	DailyClaims        PolicySimulatedFlow `json:"daily_claims"`
+	SystemOwnerRewards PolicySimulatedFlow `json:"system_owner_rewards"`
+}
+
+type PolicySimulatedSink struct {
+	Accounts int                     `json:"accounts,omitempty"`
+	Volume   float64                 `json:"volume,omitempty"` // what the tax is charged on over the window
+	Credits  float64                 `json:"credits"`
+	Largest  []PolicySimulatedCharge `json:"largest,omitempty"`
+}
+
+type PolicySimulatedFlow struct {
+	Count   int     `json:"count"`
+	Credits float64 `json:"credits"`
+}
+
+type PolicySimulatedCharge struct {
+	Username Username `json:"username"`
+	Balance  float64  `json:"balance"`
+	Charge   float64  `json:"charge"`
+}
+
+const policySimulationWindowDays = 7
+
+// simulateEconomyPolicy shows what policy would do: one decay run over the
+// current balances, taxes over the last week of transfers and gifts, and one
+// more claim for every daily claim streak that is still alive
+func simulateEconomyPolicy(policy EconomyPolicy, now time.Time) (PolicySimulation, error) {
+	sim := PolicySimulation{Policy: policy, WindowDays: policySimulationWindowDays}
+
+	charges := make([]PolicySimulatedCharge, 0)
+	var decayed, claims int64
+	for _, u := range snapshotUsers() {
+		sim.Accounts++
+		if charge := inactivityDecayCharge(policy.Sinks.InactivityDecay, u, now); charge >= 0.01 {
+			charges = append(charges, PolicySimulatedCharge{Username: u.GetUsername(), Balance: u.GetCredits(), Charge: charge})
+			decayed += toMinor(charge)
+		}
+		if state, ok := getDailyClaim(u.GetUsername()); ok && state.streakExpiresIn(now) > 0 {
+			next, _ := nextDailyClaim(state, time.Unix(max(state.LastClaim+dailyClaimPeriod, now.Unix()), 0))
+			reward, _ := dailyClaimRewardFor(policy.Faucets.DailyClaim, u.GetSubscriptionBenefits().Daily_Credit_Multipler, next.Streak)
+			sim.DailyClaims.Count++
+			claims += toMinor(reward)
+		}
+	}
+	sort.Slice(charges, func(i, j int) bool { return charges[i].Charge > charges[j].Charge })
+	sim.InactivityDecay = PolicySimulatedSink{Accounts: len(charges), Credits: fromMinor(decayed), Largest: charges[:min(len(charges), 20)]}
+	sim.DailyClaims.Credits = fromMinor(claims)
+
+	transfers, gifts, mints, err := ledgerPolicyBases(now.AddDate(0, 0, -policySimulationWindowDays).UnixMilli())
+	if err != nil {
+		return sim, err
+	}
+	sim.TransferTax = PolicySimulatedSink{Volume: transfers, Credits: roundVal(transfers * policy.Sinks.TransferTaxPercent / 100)}
+	sim.GiftTax = PolicySimulatedSink{Volume: gifts, Credits: roundVal(gifts * policy.Sinks.GiftTaxPercent / 100)}
+	sim.SystemOwnerRewards = PolicySimulatedFlow{Count: mints, Credits: roundVal(float64(mints) * policy.Faucets.SystemOwnerReward)}
+	return sim, nil
+}
+
+// ledgerPolicyBases sums user to user transfers and gifts created since (ms) and
+// counts the mints that pay a system owner reward
+func ledgerPolicyBases(since int64) (transfers float64, gifts float64, mints int, err error) {
+	f, err := os.Open(LEDGER_FILE_PATH)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, 0, nil
+		}
+		return 0, 0, 0, err
+	}
+	defer f.Close()
+
+	var transferUnits, giftUnits int64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var e LedgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Timestamp < since {
+			continue
+		}
+		switch e.Kind {
+		case "mint":
+			mints++
+		case "transfer":
+			for _, p := range e.Postings {
+				if p.Account.isUser() && p.Amount > 0 {
+					transferUnits += p.Amount
+				}
+			}
+		case "gift_create":
+			for _, p := range e.Postings {
+				if p.Account == LedgerGiftEscrow {
+					giftUnits += p.Amount
+				}
+			}
+		}
+	}
+	return fromMinor(transferUnits), fromMinor(giftUnits), mints, scanner.Err()
+}
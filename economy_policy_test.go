@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInactivityDecay(t *testing.T) {
+	now := time.Now()
+	longAgo := now.AddDate(0, 0, -200).UnixMilli()
+	dormant := User{"username": "dormant", "sys.id": "ep-dormant", "sys.currency": 300.0, "created": longAgo, "sys.last_login": longAgo}
+	active := User{"username": "active", "sys.id": "ep-active", "sys.currency": 300.0, "created": longAgo, "sys.last_login": now.UnixMilli()}
+	poor := User{"username": "poor", "sys.id": "ep-poor", "sys.currency": 50.0, "created": longAgo}
+	withTestUsers(t, dormant, active, poor)
+	withTestLedger(t)
+
+	policy := defaultEconomyPolicy()
+	policy.Sinks.InactivityDecay.Enabled = true
+	withTestEconomyPolicy(t, policy)
+
+	sim, err := simulateEconomyPolicy(policy, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim.InactivityDecay.Accounts != 1 || sim.InactivityDecay.Credits != 2 || dormant.GetCredits() != 300 {
+		t.Fatalf("Simulation should find 1%% of the dormant balance above 100 without taking it, got %+v", sim.InactivityDecay)
+	}
+
+	accounts, total := applyInactivityDecay(policy.Sinks.InactivityDecay, now)
+	if accounts != 1 || total != 2 || dormant.GetCredits() != 298 || active.GetCredits() != 300 || poor.GetCredits() != 50 {
+		t.Errorf("Only the dormant account should decay, took %v from %d", total, accounts)
+	}
+	if fromMinor(getLedgerBalance(LedgerTax)) != 2 {
+		t.Errorf("Decay should be booked as tax, got %v", fromMinor(getLedgerBalance(LedgerTax)))
+	}
+
+	movements, err := readPolicyMovements(PolicyInactivityDecay, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movements) != 1 || movements[0].UserId != dormant.GetId() || movements[0].Kind != PolicySink || movements[0].LedgerId == "" {
+		t.Errorf("Decay should be in the policy ledger, got %+v", movements)
+	}
+}
+
+func TestInactivityDecayRunPersists(t *testing.T) {
+	active := User{"username": "active", "sys.id": "ep-active", "sys.currency": 300.0, "sys.last_login": time.Now().UnixMilli()}
+	withTestUsers(t, active)
+	withTestLedger(t)
+
+	policy := defaultEconomyPolicy()
+	policy.Sinks.InactivityDecay.Enabled = true
+	withTestEconomyPolicy(t, policy)
+
+	now := time.Now()
+	if accounts, _ := applyInactivityDecay(policy.Sinks.InactivityDecay, now); accounts != 0 {
+		t.Fatalf("No one is inactive, charged %d", accounts)
+	}
+
+	// a restart should not run the decay again straight away
+	economyPolicyMutex.Lock()
+	lastDecayRun = 0
+	economyPolicyMutex.Unlock()
+	loadEconomyPolicy()
+	economyPolicyMutex.RLock()
+	defer economyPolicyMutex.RUnlock()
+	if lastDecayRun != now.UnixMilli() {
+		t.Errorf("A run that charged nobody should still be remembered, got %d", lastDecayRun)
+	}
+}
+
+func TestEconomyPolicySimulatesTaxes(t *testing.T) {
+	a := User{"username": "a", "sys.id": "ep-a", "sys.currency": 50.0}
+	b := User{"username": "b", "sys.id": "ep-b", "sys.currency": 0.0}
+	withTestUsers(t, a, b)
+	withTestLedger(t)
+	withTestEconomyPolicy(t, defaultEconomyPolicy())
+
+	if _, err := postLedger("transfer", "", userPosting(a, -20), userPosting(b, 20)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := postLedger("gift_create", "", userPosting(a, -10.1), accountPosting(LedgerGiftEscrow, 10), accountPosting(LedgerTax, 0.1)); err != nil {
+		t.Fatal(err)
+	}
+
+	proposed, err := parseEconomyPolicy([]byte(`{"sinks": {"transfer_tax_percent": 5}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proposed.Sinks.GiftTaxPercent != 1 {
+		t.Errorf("Settings left out should keep their values, got %v", proposed.Sinks.GiftTaxPercent)
+	}
+	sim, err := simulateEconomyPolicy(proposed, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim.TransferTax.Volume != 20 || sim.TransferTax.Credits != 1 || sim.GiftTax.Volume != 10 || sim.GiftTax.Credits != 0.1 {
+		t.Errorf("Unexpected projections transfer %+v gift %+v", sim.TransferTax, sim.GiftTax)
+	}
+	if getEconomyPolicy().Sinks.TransferTaxPercent != 0 {
+		t.Error("Simulating should not change the running policy")
+	}
+
+	if _, err := parseEconomyPolicy([]byte(`{"sinks": {"gift_tax_percent": 80}}`)); err == nil {
+		t.Error("Gift tax above 50% should be rejected")
+	}
+}
+
+func TestTransferReportsTaxInDebit(t *testing.T) {
+	a := User{"username": "a", "sys.id": "ep-a", "sys.currency": 50.0}
+	b := User{"username": "b", "sys.id": "ep-b", "sys.currency": 0.0}
+	withTestUsers(t, a, b)
+	withTestLedger(t)
+
+	policy := defaultEconomyPolicy()
+	policy.Sinks.TransferTaxPercent = 10
+	withTestEconomyPolicy(t, policy)
+
+	debited, err := performCreditTransfer("a", "b", 20, "rent", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if debited != 22 || a.GetCredits() != 28 || b.GetCredits() != 20 {
+		t.Errorf("Debit should include the tax, reported %v, sender has %v", debited, a.GetCredits())
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const economySnapshotDateFormat = "2006-01-02"
+
+// EconomySnapshot is the state of the economy at the end of a UTC day. Supply
+// figures are measured when the snapshot is taken, flows are read from the
+// ledger entries posted during the day.
+type EconomySnapshot struct {
+	Date                string  `json:"date"`
+	TakenAt             int64   `json:"taken_at"`
+	TotalSupply         float64 `json:"total_supply"`
+	Circulating         float64 `json:"circulating"`
+	TransferVolume      float64 `json:"transfer_volume"`
+	Velocity            float64 `json:"velocity"`
+	Gini                float64 `json:"gini"`
+	Accounts            int     `json:"accounts"`
+	NewAccounts         int     `json:"new_accounts"`
+	DailyClaims         int     `json:"daily_claims"`
+	DailyClaimsIssued   float64 `json:"daily_claims_issued"`
+	TaxCollected        float64 `json:"tax_collected"`
+	LedgerEntriesPosted int     `json:"ledger_entries"`
+}
+
+var (
+	economySnapshots      []EconomySnapshot
+	economySnapshotsMutex sync.RWMutex
+)
+
+// ledger entry kinds that move credits between holders rather than in or out of the economy
+var transferLedgerKinds = map[string]bool{
+	"transfer":       true,
+	"group_tip":      true,
+	"gift_claim":     true,
+	"escrow_release": true,
+}
+
+func loadEconomySnapshots() {
+	economySnapshotsMutex.Lock()
+	defer economySnapshotsMutex.Unlock()
+
+	data, err := os.ReadFile(ECONOMY_SNAPSHOTS_FILE_PATH)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading economy snapshots file: %v", err)
+		}
+		economySnapshots = make([]EconomySnapshot, 0)
+		return
+	}
+
+	if err := json.Unmarshal(data, &economySnapshots); err != nil {
+		log.Printf("Error unmarshaling economy snapshots: %v", err)
+		economySnapshots = make([]EconomySnapshot, 0)
+		return
+	}
+
+	log.Printf("Loaded %d economy snapshots", len(economySnapshots))
+}
+
+func saveEconomySnapshotsLocked() {
+	saveJsonFile(ECONOMY_SNAPSHOTS_FILE_PATH, economySnapshots)
+}
+
+// giniCoefficient is 0 when every balance is equal and approaches 1 when one
+// account holds everything
+func giniCoefficient(balances []float64) float64 {
+	n := len(balances)
+	if n == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), balances...)
+	sort.Float64s(sorted)
+
+	var total, weighted float64
+	for i, b := range sorted {
+		total += b
+		weighted += float64(i+1) * b
+	}
+	if total <= 0 {
+		return 0
+	}
+	return 2*weighted/(float64(n)*total) - float64(n+1)/float64(n)
+}
+
+// addLedgerFlows adds the flows of the entries posted in [start, end) to the snapshot
+func addLedgerFlows(s *EconomySnapshot, start int64, end int64) error {
+	f, err := os.Open(LEDGER_FILE_PATH)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	var volume, claimed, tax int64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var e LedgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if e.Timestamp < start || e.Timestamp >= end {
+			continue
+		}
+		s.LedgerEntriesPosted++
+		isClaim := e.Kind == "mint" && e.Memo == "Daily claim"
+		if isClaim {
+			s.DailyClaims++
+		}
+		for _, p := range e.Postings {
+			switch {
+			case p.Account == LedgerTax && p.Amount > 0:
+				tax += p.Amount
+			case isClaim && p.Account == LedgerMint:
+				claimed -= p.Amount
+			case transferLedgerKinds[e.Kind] && p.Account.isUser() && p.Amount > 0:
+				volume += p.Amount
+			}
+		}
+	}
+	s.TransferVolume = fromMinor(volume)
+	s.DailyClaimsIssued = fromMinor(claimed)
+	s.TaxCollected = fromMinor(tax)
+	return scanner.Err()
+}
+
+// takeEconomySnapshot measures the economy for the UTC day starting at dayStart
+func takeEconomySnapshot(dayStart time.Time) (EconomySnapshot, error) {
+	start := dayStart.UnixMilli()
+	end := dayStart.AddDate(0, 0, 1).UnixMilli()
+	s := EconomySnapshot{
+		Date:    dayStart.Format(economySnapshotDateFormat),
+		TakenAt: time.Now().UnixMilli(),
+	}
+
+	balances := make([]float64, 0)
+	var circulating int64
+	usersMutex.RLock()
+	for _, u := range users {
+		s.Accounts++
+		if created := u.GetCreated(); created >= start && created < end {
+			s.NewAccounts++
+		}
+		if u.IsBanned() || u.GetUsername() == "rotur" {
+			continue
+		}
+		if credits := u.GetCredits(); credits >= 0 {
+			balances = append(balances, credits)
+			circulating += toMinor(credits)
+		}
+	}
+	usersMutex.RUnlock()
+	s.Circulating = fromMinor(circulating)
+	s.Gini = math.Round(giniCoefficient(balances)*10000) / 10000
+
+	// total supply also counts what sits in groups and escrow
+	var supply int64
+	ledgerMutex.Lock()
+	for account, units := range ledgerBalances {
+		name := string(account)
+		if strings.HasPrefix(name, "user:") || strings.HasPrefix(name, "group:") || strings.HasPrefix(name, "escrow:") {
+			supply += units
+		}
+	}
+	ledgerMutex.Unlock()
+	s.TotalSupply = fromMinor(supply)
+
+	if err := addLedgerFlows(&s, start, end); err != nil {
+		return s, err
+	}
+	if s.Circulating > 0 {
+		s.Velocity = math.Round(s.TransferVolume/s.Circulating*10000) / 10000
+	}
+	return s, nil
+}
+
+// recordEconomySnapshot snapshots the last finished UTC day if it hasn't been yet.
+// Days the server was down for are skipped, their balances can't be recovered.
+func recordEconomySnapshot(now time.Time) bool {
+	today := now.UTC().Truncate(24 * time.Hour)
+	yesterday := today.AddDate(0, 0, -1)
+	date := yesterday.Format(economySnapshotDateFormat)
+
+	economySnapshotsMutex.Lock()
+	defer economySnapshotsMutex.Unlock()
+
+	if n := len(economySnapshots); n > 0 && economySnapshots[n-1].Date >= date {
+		return false
+	}
+
+	s, err := takeEconomySnapshot(yesterday)
+	if err != nil {
+		log.Printf("Error taking economy snapshot: %v", err)
+		return false
+	}
+	economySnapshots = append(economySnapshots, s)
+	saveEconomySnapshotsLocked()
+	return true
+}
+
+func runEconomySnapshots() {
+	if recordEconomySnapshot(time.Now()) {
+		log.Println("Recorded economy snapshot")
+	}
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if recordEconomySnapshot(time.Now()) {
+			log.Println("Recorded economy snapshot")
+		}
+	}
+}
+
+// getEconomySnapshotsBetween returns the snapshots for dates in [from, to], oldest first
+func getEconomySnapshotsBetween(from string, to string) []EconomySnapshot {
+	economySnapshotsMutex.RLock()
+	defer economySnapshotsMutex.RUnlock()
+
+	result := make([]EconomySnapshot, 0)
+	for _, s := range economySnapshots {
+		if s.Date >= from && s.Date <= to {
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGiniCoefficient(t *testing.T) {
+	if g := giniCoefficient([]float64{5, 5, 5, 5}); g != 0 {
+		t.Errorf("Equal balances should give 0, got %v", g)
+	}
+	if g := giniCoefficient([]float64{0, 0, 0, 100}); math.Abs(g-0.75) > 1e-9 {
+		t.Errorf("One holder out of four should give 0.75, got %v", g)
+	}
+	if g := giniCoefficient(nil); g != 0 {
+		t.Errorf("No balances should give 0, got %v", g)
+	}
+}
+
+func TestEconomySnapshot(t *testing.T) {
+	withTestLedger(t)
+	now := time.Now()
+	alice := User{"username": "alice", "sys.id": "eco-alice", "sys.currency": 0.0, "created": now.AddDate(-1, 0, 0).UnixMilli()}
+	bob := User{"username": "bob", "sys.id": "eco-bob", "sys.currency": 0.0, "created": now.UnixMilli()}
+	withTestUsers(t, alice, bob)
+
+	if _, err := postLedger("mint", "Daily claim", accountPosting(LedgerMint, -10), userPosting(alice, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := postLedger("transfer", "rent", userPosting(alice, -4.1), userPosting(bob, 4), accountPosting(LedgerTax, 0.1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := postLedger("gift_create", "gift", userPosting(alice, -1), accountPosting(LedgerGiftEscrow, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := takeEconomySnapshot(now.UTC().Truncate(24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.TotalSupply != 9.9 || s.Circulating != 8.9 {
+		t.Errorf("Expected supply 9.9 and 8.9 circulating, got %v %v", s.TotalSupply, s.Circulating)
+	}
+	if s.DailyClaims != 1 || s.DailyClaimsIssued != 10 || s.TaxCollected != 0.1 || s.TransferVolume != 4 {
+		t.Errorf("Unexpected flows %+v", s)
+	}
+	if s.NewAccounts != 1 || s.Accounts != 2 {
+		t.Errorf("Expected 1 new account out of 2, got %d of %d", s.NewAccounts, s.Accounts)
+	}
+
+	yesterday, err := takeEconomySnapshot(now.UTC().Truncate(24*time.Hour).AddDate(0, 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if yesterday.LedgerEntriesPosted != 0 || yesterday.DailyClaims != 0 {
+		t.Errorf("Today's entries should not count towards yesterday, got %+v", yesterday)
+	}
+}
+
+func TestRecordEconomySnapshotOncePerDay(t *testing.T) {
+	withTestLedger(t)
+	withTestUsers(t)
+	origPath := ECONOMY_SNAPSHOTS_FILE_PATH
+	ECONOMY_SNAPSHOTS_FILE_PATH = filepath.Join(t.TempDir(), "economy_snapshots.json")
+	economySnapshotsMutex.Lock()
+	origSnapshots := economySnapshots
+	economySnapshots = make([]EconomySnapshot, 0)
+	economySnapshotsMutex.Unlock()
+	t.Cleanup(func() {
+		ECONOMY_SNAPSHOTS_FILE_PATH = origPath
+		economySnapshotsMutex.Lock()
+		economySnapshots = origSnapshots
+		economySnapshotsMutex.Unlock()
+	})
+
+	now := time.Date(2026, 3, 2, 0, 5, 0, 0, time.UTC)
+	if !recordEconomySnapshot(now) || recordEconomySnapshot(now.Add(time.Hour)) {
+		t.Fatal("Expected exactly one snapshot for the day")
+	}
+	if got := getEconomySnapshotsBetween("2026-03-01", "2026-03-01"); len(got) != 1 {
+		t.Errorf("Expected the snapshot to be for 2026-03-01, got %+v", got)
+	}
+	if !recordEconomySnapshot(now.AddDate(0, 0, 1)) {
+		t.Error("Expected a snapshot on the next day")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Verification and reset links carry a signed, expiring token instead of server
+// side state. Each token is bound to the value it acts on (the email being
+// verified, the password being replaced), so it stops working once that changes
+// and a reset link can only be used once.
+
+const (
+	EmailTokenVerify = "verify_email"
+	EmailTokenReset  = "reset_password"
+
+	EmailVerifyLifetime   = 24 * time.Hour
+	PasswordResetLifetime = time.Hour
+)
+
+type emailTokenClaims struct {
+	Purpose   string `json:"p"`
+	Subject   UserId `json:"sub"`
+	Binding   string `json:"b"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+var (
+	emailTokenKey     []byte
+	emailTokenKeyOnce sync.Once
+)
+
+func getEmailTokenKey() []byte {
+	emailTokenKeyOnce.Do(func() {
+		if EMAIL_TOKEN_SECRET != "" {
+			emailTokenKey = []byte(EMAIL_TOKEN_SECRET)
+			return
+		}
+		// links stop working on restart, but nothing can be forged
+		log.Printf("[config] WARNING: EMAIL_TOKEN_SECRET not set, using a random key")
+		emailTokenKey = make([]byte, 32)
+		rand.Read(emailTokenKey)
+	})
+	return emailTokenKey
+}
+
+// emailTokenBinding is the value a token of this purpose is tied to
+func emailTokenBinding(user User, purpose string) string {
+	var value string
+	switch purpose {
+	case EmailTokenVerify:
+		value = strings.ToLower(strings.TrimSpace(user.GetEmail()))
+	case EmailTokenReset:
+		value = user.GetPassword()
+	}
+	sum := sha256.Sum256([]byte(purpose + ":" + value))
+	return hex.EncodeToString(sum[:12])
+}
+
+func signEmailToken(payload []byte) string {
+	mac := hmac.New(sha256.New, getEmailTokenKey())
+	mac.Write(payload)
+	return b64url(mac.Sum(nil))
+}
+
+func issueEmailToken(user User, purpose string, lifetime time.Duration) (string, error) {
+	payload, err := json.Marshal(emailTokenClaims{
+		Purpose:   purpose,
+		Subject:   user.GetId(),
+		Binding:   emailTokenBinding(user, purpose),
+		ExpiresAt: time.Now().Add(lifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return b64url(payload) + "." + signEmailToken(payload), nil
+}
+
+// verifyEmailToken returns the user the token was issued for if it is still valid
+func verifyEmailToken(token string, purpose string) (User, error) {
+	encoded, sig, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok {
+		return nil, fmt.Errorf("invalid token")
+	}
+	payload, err := decodeB64url(encoded)
+	if err != nil || !hmac.Equal([]byte(signEmailToken(payload)), []byte(sig)) {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	var claims emailTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Purpose != purpose {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("token has expired")
+	}
+
+	user, err := getAccountByUserId(claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if !hmac.Equal([]byte(emailTokenBinding(user, purpose)), []byte(claims.Binding)) {
+		return nil, fmt.Errorf("token is no longer valid")
+	}
+	return user, nil
+}
+
+var passwordResetMutex sync.Mutex
+
+// redeemPasswordReset checks a reset token and sets the new password. Verifying
+// and replacing happen under one lock so a link can't be redeemed twice.
+func redeemPasswordReset(token string, password string) (User, error) {
+	passwordResetMutex.Lock()
+	defer passwordResetMutex.Unlock()
+
+	user, err := verifyEmailToken(token, EmailTokenReset)
+	if err != nil {
+		return nil, err
+	}
+	if user.GetPassword() == password {
+		return nil, fmt.Errorf("new password must be different from the current one")
+	}
+	user.Set("password", password)
+	// whoever had the old password may have the key too
+	user.Set("key", generateAccountToken())
+
+	// the link reached the inbox, so the address is confirmed too
+	if !user.IsEmailVerified() {
+		user.Set("sys.email_verified", true)
+		user.Set("sys.email_verified_at", time.Now().UnixMilli())
+	}
+	return user, nil
+}
+
+func findAccountByEmail(email string) User {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return nil
+	}
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+	for _, user := range users {
+		if strings.EqualFold(user.GetEmail(), email) {
+			return user
+		}
+	}
+	return nil
+}
+
+func sendVerificationEmail(user User) error {
+	token, err := issueEmailToken(user, EmailTokenVerify, EmailVerifyLifetime)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(MailMessage{
+		To:      user.GetEmail(),
+		Subject: "Verify your rotur email",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm this address for your rotur account:\n%s/verify-email?token=%s\n\nThe link expires in 24 hours. If you did not ask for this you can ignore this email.\n",
+			user.GetUsername(), EMAIL_LINK_BASE, token),
+	})
+}
+
+func sendPasswordResetEmail(user User) error {
+	token, err := issueEmailToken(user, EmailTokenReset, PasswordResetLifetime)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(MailMessage{
+		To:      user.GetEmail(),
+		Subject: "Reset your rotur password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your rotur account. Choose a new one here:\n%s/reset-password?token=%s\n\nThe link expires in 1 hour and works once. If this was not you, your password has not been changed.\n",
+			user.GetUsername(), EMAIL_LINK_BASE, token),
+	})
+}
+
+func (u User) IsEmailVerified() bool {
+	return u.Get("sys.email_verified") == true && u.GetEmail() != ""
+}
+
+// clearEmailVerification is called whenever the email changes
+func (u User) clearEmailVerification() {
+	u.DelKey("sys.email_verified")
+	u.DelKey("sys.email_verified_at")
+}
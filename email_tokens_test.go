@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailVerificationToken(t *testing.T) {
+	user := User{"username": "mailuser", "sys.id": "mail-id-1", "email": "mail@example.com"}
+	withTestUsers(t, user)
+
+	token, err := issueEmailToken(user, EmailTokenVerify, EmailVerifyLifetime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyEmailToken(token, EmailTokenReset); err == nil {
+		t.Error("Verification token should not work as a reset token")
+	}
+	if found, err := verifyEmailToken(token, EmailTokenVerify); err != nil || found.GetUsername() != "mailuser" {
+		t.Fatalf("Expected token to verify, got %v", err)
+	}
+
+	user.Set("email", "other@example.com")
+	if _, err := verifyEmailToken(token, EmailTokenVerify); err == nil {
+		t.Error("Token should stop working once the email changes")
+	}
+
+	expired, _ := issueEmailToken(user, EmailTokenVerify, -time.Second)
+	if _, err := verifyEmailToken(expired, EmailTokenVerify); err == nil {
+		t.Error("Expired token should be rejected")
+	}
+
+	parts := strings.SplitN(token, ".", 2)
+	if _, err := verifyEmailToken(parts[0]+".AAAA", EmailTokenVerify); err == nil {
+		t.Error("Token with a bad signature should be rejected")
+	}
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	origMailer := mailer
+	memory := &MemoryMailer{}
+	mailer = memory
+	defer func() { mailer = origMailer }()
+
+	user := User{"username": "resetuser", "sys.id": "reset-id-1", "email": "reset@example.com", "password": strings.Repeat("a", 32), "key": "old-key"}
+	withTestUsers(t, user)
+
+	if err := sendPasswordResetEmail(user); err != nil {
+		t.Fatal(err)
+	}
+	msg, ok := memory.Last("RESET@example.com")
+	if !ok {
+		t.Fatal("Reset email should have been sent")
+	}
+	_, token, _ := strings.Cut(msg.Body, "token=")
+	token, _, _ = strings.Cut(token, "\n")
+
+	if _, err := redeemPasswordReset(token, strings.Repeat("a", 32)); err == nil {
+		t.Error("Reusing the current password should be rejected")
+	}
+	if _, err := redeemPasswordReset(token, strings.Repeat("b", 32)); err != nil {
+		t.Fatalf("Expected reset to succeed, got %v", err)
+	}
+	if user.GetPassword() != strings.Repeat("b", 32) || !user.IsEmailVerified() {
+		t.Error("Reset should set the password and verify the email")
+	}
+	if user.GetKey() == "old-key" || authenticateWithKey("old-key") != nil {
+		t.Error("Reset should rotate the account key")
+	}
+	if _, err := redeemPasswordReset(token, strings.Repeat("c", 32)); err == nil {
+		t.Error("Reset link should only work once")
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EscrowFunded   = "funded"
+	EscrowDisputed = "disputed"
+	EscrowReleased = "released"
+	EscrowRefunded = "refunded"
+
+	EscrowDefaultTimeout  = 7 * 24 * time.Hour
+	EscrowMaxTimeout      = 90 * 24 * time.Hour
+	MaxOpenEscrowsPerUser = 25
+)
+
+// EscrowEvent is one step in a contract's audit trail
+type EscrowEvent struct {
+	Action    string `json:"action"`
+	By        UserId `json:"by,omitempty"`
+	Note      string `json:"note,omitempty"`
+	Timestamp int64  `json:"time"`
+}
+
+// EscrowContract holds a buyer's credits until the trade with the seller is settled
+type EscrowContract struct {
+	Id              string        `json:"id"`
+	BuyerId         UserId        `json:"buyer_id"`
+	SellerId        UserId        `json:"seller_id"`
+	Amount          float64       `json:"amount"`
+	Terms           string        `json:"terms,omitempty"`
+	ItemName        string        `json:"item_name,omitempty"`
+	Status          string        `json:"status"`
+	BuyerConfirmed  bool          `json:"buyer_confirmed"`
+	SellerConfirmed bool          `json:"seller_confirmed"`
+	DisputeReason   string        `json:"dispute_reason,omitempty"`
+	CreatedAt       int64         `json:"created_at"`
+	ExpiresAt       int64         `json:"expires_at"`
+	ClosedAt        *int64        `json:"closed_at,omitempty"`
+	History         []EscrowEvent `json:"history"`
+}
+
+func (e *EscrowContract) IsOpen() bool {
+	return e.Status == EscrowFunded || e.Status == EscrowDisputed
+}
+
+func (e *EscrowContract) IsParty(id UserId) bool {
+	return e.BuyerId == id || e.SellerId == id
+}
+
+func (e *EscrowContract) record(action string, by UserId, note string) {
+	e.History = append(e.History, EscrowEvent{Action: action, By: by, Note: note, Timestamp: time.Now().UnixMilli()})
+}
+
+var (
+	escrowContracts      = make([]EscrowContract, 0)
+	escrowContractsMutex sync.Mutex
+
+	errEscrowClosed       = errors.New("escrow contract is already settled")
+	errEscrowDisputed     = errors.New("escrow contract is disputed and waiting for an admin")
+	errEscrowNotSeller    = errors.New("only the seller can do this")
+	errEscrowNotDelivered = errors.New("the item has not been transferred to the buyer yet")
+)
+
+func loadEscrowContracts() {
+	escrowContractsMutex.Lock()
+	defer escrowContractsMutex.Unlock()
+
+	data, err := os.ReadFile(ESCROW_CONTRACTS_FILE_PATH)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading escrow contracts file: %v", err)
+		}
+		escrowContracts = make([]EscrowContract, 0)
+		return
+	}
+
+	if err := json.Unmarshal(data, &escrowContracts); err != nil {
+		log.Printf("Error unmarshaling escrow contracts: %v", err)
+		escrowContracts = make([]EscrowContract, 0)
+		return
+	}
+
+	log.Printf("Loaded %d escrow contracts", len(escrowContracts))
+}
+
+// saveEscrowContractsLocked expects escrowContractsMutex to be held
+func saveEscrowContractsLocked() {
+	saveJsonFile(ESCROW_CONTRACTS_FILE_PATH, escrowContracts)
+}
+
+func findEscrowLocked(id string) *EscrowContract {
+	for i := range escrowContracts {
+		if escrowContracts[i].Id == id {
+			return &escrowContracts[i]
+		}
+	}
+	return nil
+}
+
+func countOpenEscrows(buyerId UserId) int {
+	count := 0
+	for i := range escrowContracts {
+		if escrowContracts[i].BuyerId == buyerId && escrowContracts[i].IsOpen() {
+			count++
+		}
+	}
+	return count
+}
+
+func outstandingContractUnits() int64 {
+	escrowContractsMutex.Lock()
+	defer escrowContractsMutex.Unlock()
+	var total int64
+	for i := range escrowContracts {
+		if escrowContracts[i].IsOpen() {
+			total += toMinor(escrowContracts[i].Amount)
+		}
+	}
+	return total
+}
+
+// itemDelivered reports whether the contract's item now belongs to the buyer
+func itemDelivered(e *EscrowContract) bool {
+	if e.ItemName == "" {
+		return true
+	}
+	itemsMutex.Lock()
+	defer itemsMutex.Unlock()
+	for _, item := range items {
+		if strings.EqualFold(item.Name, e.ItemName) {
+			return item.Owner == e.BuyerId
+		}
+	}
+	return false
+}
+
+// openEscrowLocked moves the buyer's credits into escrow and stores the contract
+func openEscrowLocked(buyer User, e EscrowContract) (EscrowContract, error) {
+	if _, err := postLedger("escrow_lock", e.Id, userPosting(buyer, -e.Amount), accountPosting(LedgerTradeEscrow, e.Amount)); err != nil {
+		return e, err
+	}
+	buyer.addTransaction(Transaction{
+		Note:      "escrow for " + string(e.SellerId.User().GetUsername()),
+		User:      e.SellerId,
+		Amount:    e.Amount,
+		Type:      "escrow_lock",
+		Timestamp: time.Now().UnixMilli(),
+		NewTotal:  buyer.GetCredits(),
+		EscrowId:  e.Id,
+	})
+	e.record("funded", buyer.GetId(), e.Terms)
+	escrowContracts = append(escrowContracts, e)
+	saveEscrowContractsLocked()
+	return e, nil
+}
+
+// settleEscrowLocked pays the held credits to the seller (release) or back to
+// the buyer (refund) and closes the contract
+func settleEscrowLocked(e *EscrowContract, release bool, by UserId, note string) error {
+	if !e.IsOpen() {
+		return errEscrowClosed
+	}
+
+	kind, status, txType := "escrow_refund", EscrowRefunded, "escrow_refund"
+	recipientId, counterparty := e.BuyerId, e.SellerId
+	if release {
+		kind, status, txType = "escrow_release", EscrowReleased, "escrow_in"
+		recipientId, counterparty = e.SellerId, e.BuyerId
+	}
+
+	// credits owed to a deleted account leave the economy
+	recipient := getUserById(recipientId)
+	recipientPosting := accountPosting(LedgerSink, e.Amount)
+	if len(recipient) > 0 {
+		recipientPosting = userPosting(recipient, e.Amount)
+	}
+	if _, err := postLedger(kind, e.Id, accountPosting(LedgerTradeEscrow, -e.Amount), recipientPosting); err != nil {
+		return err
+	}
+
+	now := time.Now().UnixMilli()
+	if len(recipient) > 0 {
+		recipient.addTransaction(Transaction{
+			Note:      note,
+			User:      counterparty,
+			Amount:    e.Amount,
+			Type:      txType,
+			Timestamp: now,
+			NewTotal:  recipient.GetCredits(),
+			EscrowId:  e.Id,
+		})
+	}
+
+	e.Status = status
+	e.ClosedAt = &now
+	e.record(status, by, note)
+	return nil
+}
+
+// escrowBuyerAmount is what the buyer's confirmation would release, 0 for anyone else
+func escrowBuyerAmount(id string, userId UserId) float64 {
+	escrowContractsMutex.Lock()
+	defer escrowContractsMutex.Unlock()
+	if e := findEscrowLocked(id); e != nil && e.BuyerId == userId {
+		return e.Amount
+	}
+	return 0
+}
+
+// confirmEscrowLocked records a party's confirmation and releases the credits
+// to the seller once both sides have confirmed. It reports whether it released.
+func confirmEscrowLocked(e *EscrowContract, by UserId) (bool, error) {
+	switch {
+	case e.Status == EscrowDisputed:
+		return false, errEscrowDisputed
+	case !e.IsOpen():
+		return false, errEscrowClosed
+	}
+
+	if by == e.SellerId {
+		if !itemDelivered(e) {
+			return false, errEscrowNotDelivered
+		}
+		e.SellerConfirmed = true
+		e.record("seller_confirmed", by, "")
+	} else {
+		e.BuyerConfirmed = true
+		e.record("buyer_confirmed", by, "")
+	}
+
+	if !e.BuyerConfirmed || !e.SellerConfirmed {
+		return false, nil
+	}
+	return true, settleEscrowLocked(e, true, by, "escrow released")
+}
+
+func disputeEscrowLocked(e *EscrowContract, by UserId, reason string) error {
+	switch {
+	case e.Status == EscrowDisputed:
+		return errEscrowDisputed
+	case !e.IsOpen():
+		return errEscrowClosed
+	}
+	e.Status = EscrowDisputed
+	e.DisputeReason = reason
+	e.record("disputed", by, reason)
+	return nil
+}
+
+// expireEscrowsLocked settles contracts whose timeout has passed. Delivered
+// trades go to the seller, anything else goes back to the buyer. Disputed
+// contracts wait for an admin.
+func expireEscrowsLocked(now int64) int {
+	settled := 0
+	for i := range escrowContracts {
+		e := &escrowContracts[i]
+		if e.Status != EscrowFunded || now < e.ExpiresAt {
+			continue
+		}
+		release := e.SellerConfirmed && itemDelivered(e)
+		if err := settleEscrowLocked(e, release, "", "escrow timed out"); err != nil {
+			log.Printf("Failed to settle expired escrow %s: %v", e.Id, err)
+			continue
+		}
+		settled++
+		for _, party := range []UserId{e.BuyerId, e.SellerId} {
+			addUserEvent(party, "escrow_settled", map[string]any{
+				"id":     e.Id,
+				"status": e.Status,
+				"amount": e.Amount,
+			})
+		}
+	}
+	return settled
+}
+
+func cleanExpiredEscrows() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		escrowContractsMutex.Lock()
+		settled := expireEscrowsLocked(time.Now().UnixMilli())
+		if settled > 0 {
+			saveEscrowContractsLocked()
+			go saveUsers()
+			log.Printf("Settled %d expired escrow contracts", settled)
+		}
+		escrowContractsMutex.Unlock()
+	}
+}
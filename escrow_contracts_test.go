@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// openTestEscrow returns the new contract's id, pointers into escrowContracts
+// don't survive the next append
+func openTestEscrow(t *testing.T, buyer User, seller User, amount float64, expiresAt int64) string {
+	escrowContractsMutex.Lock()
+	defer escrowContractsMutex.Unlock()
+	e, err := openEscrowLocked(buyer, EscrowContract{
+		Id:        uuid.New().String(),
+		BuyerId:   buyer.GetId(),
+		SellerId:  seller.GetId(),
+		Amount:    amount,
+		Status:    EscrowFunded,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e.Id
+}
+
+func TestEscrowConfirmAndRelease(t *testing.T) {
+	buyer := User{"username": "buyer", "sys.id": "esc-buyer", "sys.currency": 20.0}
+	seller := User{"username": "seller", "sys.id": "esc-seller", "sys.currency": 0.0}
+	withTestUsers(t, buyer, seller)
+	withTestLedger(t)
+	withTestEscrows(t)
+
+	id := openTestEscrow(t, buyer, seller, 15, time.Now().Add(time.Hour).UnixMilli())
+	if buyer.GetCredits() != 5 || fromMinor(getLedgerBalance(LedgerTradeEscrow)) != 15 {
+		t.Fatalf("Credits should be held in escrow, buyer has %v", buyer.GetCredits())
+	}
+
+	escrowContractsMutex.Lock()
+	defer escrowContractsMutex.Unlock()
+	e := findEscrowLocked(id)
+
+	if released, err := confirmEscrowLocked(e, seller.GetId()); err != nil || released {
+		t.Fatalf("One confirmation should not release, got %v %v", released, err)
+	}
+	if released, err := confirmEscrowLocked(e, buyer.GetId()); err != nil || !released {
+		t.Fatalf("Both confirmations should release, got %v %v", released, err)
+	}
+	if e.Status != EscrowReleased || seller.GetCredits() != 15 || fromMinor(getLedgerBalance(LedgerTradeEscrow)) != 0 {
+		t.Errorf("Seller should be paid, status %s seller %v", e.Status, seller.GetCredits())
+	}
+	if err := settleEscrowLocked(e, false, "", "again"); err != errEscrowClosed {
+		t.Errorf("Settled contract should not settle twice, got %v", err)
+	}
+
+	txs := seller.GetTransactions()
+	if len(txs) == 0 || txs[0].EscrowId != e.Id {
+		t.Error("Release should be recorded against the contract")
+	}
+}
+
+func TestEscrowDisputeAndTimeout(t *testing.T) {
+	buyer := User{"username": "buyer", "sys.id": "esc-buyer", "sys.currency": 20.0}
+	seller := User{"username": "seller", "sys.id": "esc-seller", "sys.currency": 0.0}
+	withTestUsers(t, buyer, seller)
+	withTestLedger(t)
+	withTestEscrows(t)
+
+	past := time.Now().Add(-time.Minute).UnixMilli()
+	ids := []string{
+		openTestEscrow(t, buyer, seller, 5, past),
+		openTestEscrow(t, buyer, seller, 5, past),
+		openTestEscrow(t, buyer, seller, 5, past),
+	}
+
+	escrowContractsMutex.Lock()
+	defer escrowContractsMutex.Unlock()
+	disputed, undelivered, delivered := findEscrowLocked(ids[0]), findEscrowLocked(ids[1]), findEscrowLocked(ids[2])
+
+	if err := disputeEscrowLocked(disputed, buyer.GetId(), "never arrived"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := confirmEscrowLocked(disputed, seller.GetId()); err != errEscrowDisputed {
+		t.Errorf("Disputed contract should not accept confirmations, got %v", err)
+	}
+	if _, err := confirmEscrowLocked(delivered, seller.GetId()); err != nil {
+		t.Fatal(err)
+	}
+
+	if settled := expireEscrowsLocked(time.Now().UnixMilli()); settled != 2 {
+		t.Fatalf("Expected 2 contracts to time out, got %d", settled)
+	}
+	if disputed.Status != EscrowDisputed || undelivered.Status != EscrowRefunded || delivered.Status != EscrowReleased {
+		t.Errorf("Unexpected statuses %s %s %s", disputed.Status, undelivered.Status, delivered.Status)
+	}
+	if buyer.GetCredits() != 10 || seller.GetCredits() != 5 || fromMinor(getLedgerBalance(LedgerTradeEscrow)) != 5 {
+		t.Errorf("Unexpected balances buyer %v seller %v", buyer.GetCredits(), seller.GetCredits())
+	}
+
+	if err := settleEscrowLocked(disputed, false, "", "arbitrated"); err != nil || disputed.Status != EscrowRefunded {
+		t.Errorf("Admin should be able to refund a disputed contract, got %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// how long a file has to stay quiet before it is reloaded
+const fileWatchDebounce = 500 * time.Millisecond
+
+type watchedFile struct {
+	name    string
+	path    string
+	reload  func(data []byte) error
+	lastSum [sha256.Size]byte
+	timer   *time.Timer
+}
+
+var (
+	watchedFiles      = make(map[string]*watchedFile)
+	watchedFilesMutex sync.Mutex
+)
+
+// registerWatchedFile hot-reloads path whenever it changes on disk.
+// parse must not touch any in-memory state, swap is only called once
+// parse has accepted the new contents.
+func registerWatchedFile[T any](name string, path string, parse func(data []byte) (T, error), swap func(T)) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		log.Printf("[watcher] Cannot watch %s (%s): %v", name, path, err)
+		return
+	}
+
+	wf := &watchedFile{
+		name: name,
+		path: abs,
+		reload: func(data []byte) error {
+			v, err := parse(data)
+			if err != nil {
+				return err
+			}
+			swap(v)
+			return nil
+		},
+	}
+	if data, err := os.ReadFile(abs); err == nil {
+		wf.lastSum = sha256.Sum256(data)
+	}
+
+	watchedFilesMutex.Lock()
+	watchedFiles[abs] = wf
+	watchedFilesMutex.Unlock()
+}
+
+// noteFileWritten records contents we wrote ourselves so they don't trigger a reload
+func noteFileWritten(path string, data []byte) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+
+	watchedFilesMutex.Lock()
+	defer watchedFilesMutex.Unlock()
+	if wf, ok := watchedFiles[abs]; ok {
+		wf.lastSum = sha256.Sum256(data)
+	}
+}
+
+func registerDataFileWatchers() {
+	registerWatchedFile("users", USERS_FILE_PATH, parseUsersData, setUsers)
+	registerWatchedFile("badges", BADGES_FILE_PATH, parseJSONBadges, setJSONBadges)
+
+	registerWatchedFile("items", ITEMS_FILE_PATH, parseJSONFile[[]Item], func(loaded []Item) {
+		itemsMutex.Lock()
+		items = loaded
+		itemsMutex.Unlock()
+	})
+	registerWatchedFile("keys", KEYS_FILE_PATH, parseJSONFile[[]Key], func(loaded []Key) {
+		keysMutex.Lock()
+		keys = loaded
+		keysMutex.Unlock()
+	})
+	registerWatchedFile("systems", SYSTEMS_FILE_PATH, parseJSONFile[map[string]System], func(loaded map[string]System) {
+		systemsMutex.Lock()
+		systems = loaded
+		systemsMutex.Unlock()
+	})
+	registerWatchedFile("cosmetics catalog", COSMETICS_FILE_PATH, parseJSONFile[[]CosmeticCatalogEntry], func(loaded []CosmeticCatalogEntry) {
+		cosmeticsCatalogMu.Lock()
+		cosmeticsCatalog = loaded
+		cosmeticsCatalogMu.Unlock()
+	})
+	registerWatchedFile("economy policy", ECONOMY_POLICY_FILE_PATH, parseEconomyPolicy, setEconomyPolicy)
+}
+
+// parseJSONFile decodes a data file, refusing empty files so a truncated write never wipes state
+func parseJSONFile[T any](data []byte) (T, error) {
+	var v T
+	if len(data) == 0 {
+		return v, fmt.Errorf("file is empty")
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+func startFileWatcher() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("[watcher] Failed to start file watcher, hot reloading disabled: %v", err)
+		return
+	}
+	defer watcher.Close()
+
+	// watch directories rather than files, atomicWrite replaces files by renaming over them
+	watchedFilesMutex.Lock()
+	dirs := make(map[string]bool)
+	for path := range watchedFiles {
+		dirs[filepath.Dir(path)] = true
+	}
+	watchedFilesMutex.Unlock()
+
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			log.Printf("[watcher] Failed to watch %s: %v", dir, err)
+		}
+	}
+
+	runFileWatcher(watcher)
+}
+
+// runFileWatcher schedules reloads for the watcher's events until it is closed
+func runFileWatcher(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) {
+				scheduleFileReload(event.Name)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("[watcher] Error: %v", err)
+		}
+	}
+}
+
+func scheduleFileReload(path string) {
+	watchedFilesMutex.Lock()
+	defer watchedFilesMutex.Unlock()
+
+	wf, ok := watchedFiles[path]
+	if !ok {
+		return
+	}
+
+	if wf.timer != nil {
+		wf.timer.Reset(fileWatchDebounce)
+		return
+	}
+	wf.timer = time.AfterFunc(fileWatchDebounce, func() {
+		reloadWatchedFile(wf)
+	})
+}
+
+func reloadWatchedFile(wf *watchedFile) {
+	data, err := os.ReadFile(wf.path)
+	if err != nil {
+		log.Printf("[watcher] Failed to read %s: %v", wf.name, err)
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	watchedFilesMutex.Lock()
+	unchanged := sum == wf.lastSum
+	watchedFilesMutex.Unlock()
+	if unchanged {
+		return
+	}
+
+	start := time.Now()
+	if err := wf.reload(data); err != nil {
+		log.Printf("[watcher] Rejected reload of %s, keeping current data: %v", wf.name, err)
+		return
+	}
+
+	watchedFilesMutex.Lock()
+	wf.lastSum = sum
+	watchedFilesMutex.Unlock()
+
+	log.Printf("[watcher] Reloaded %s from %s in %s", wf.name, wf.path, time.Since(start).Round(time.Millisecond))
+}
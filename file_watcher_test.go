@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchTestFile registers path with a watcher of its own and counts parses and swaps
+func watchTestFile(t *testing.T, path string) (parses *atomic.Int32, swaps *atomic.Int32) {
+	swapGlobal(t, &watchedFilesMutex, &watchedFiles, make(map[string]*watchedFile))
+	parses, swaps = new(atomic.Int32), new(atomic.Int32)
+	registerWatchedFile("test", path, func(data []byte) (string, error) {
+		parses.Add(1)
+		return string(data), nil
+	}, func(string) {
+		swaps.Add(1)
+	})
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		t.Fatal(err)
+	}
+	go runFileWatcher(watcher)
+	t.Cleanup(func() { watcher.Close() })
+	return parses, swaps
+}
+
+func TestFileWatcherReloadsExternalWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	if err := os.WriteFile(path, []byte("one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parses, swaps := watchTestFile(t, path)
+
+	if err := os.WriteFile(path, []byte("two"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for swaps.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(2 * fileWatchDebounce)
+	if parses.Load() != 1 || swaps.Load() != 1 {
+		t.Errorf("External write should reload once, got %d parses and %d swaps", parses.Load(), swaps.Load())
+	}
+}
+
+func TestFileWatcherIgnoresOwnWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	if err := os.WriteFile(path, []byte("one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parses, swaps := watchTestFile(t, path)
+
+	if err := atomicWrite(path, []byte("two"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * fileWatchDebounce)
+	if parses.Load() != 0 || swaps.Load() != 0 {
+		t.Errorf("Our own write should not reload, got %d parses and %d swaps", parses.Load(), swaps.Load())
+	}
+}
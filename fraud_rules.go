@@ -0,0 +1,369 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FraudAction is what happens to a request when a rule fires
+type FraudAction string
+
+const (
+	FraudAllow FraudAction = "allow" // let it through, the hit is only logged
+	FraudHold  FraudAction = "hold"  // park it in the admin review queue
+	FraudBlock FraudAction = "block"
+)
+
+const (
+	FraudRuleNewAccountFunnel = "new_account_funnel"
+	FraudRuleCircularTransfer = "circular_transfer"
+	FraudRuleVelocityBurst    = "velocity_burst"
+	FraudRuleSharedIp         = "shared_ip"
+)
+
+// the paths the rules are evaluated on
+const (
+	FraudTransfer     = "transfer"
+	FraudDailyClaim   = "daily_claim"
+	FraudGiftClaim    = "gift_claim"
+	FraudItemPurchase = "item_purchase"
+)
+
+type FraudConfig struct {
+	Actions           map[string]FraudAction `json:"actions"`
+	NewAccountDays    int                    `json:"new_account_days"`   // accounts younger than this count as new
+	FunnelSenders     int                    `json:"funnel_senders"`     // new accounts paying one recipient within a day
+	VelocityTransfers int                    `json:"velocity_transfers"` // transfers or claims per hour
+	VelocityCredits   float64                `json:"velocity_credits"`   // credits moved per hour
+	SharedIpAccounts  int                    `json:"shared_ip_accounts"` // accounts behind one login IP acting together
+	CircularDepth     int                    `json:"circular_depth"`     // hops followed looking for a loop back to the sender
+}
+
+var fraudConfig = FraudConfig{
+	Actions: map[string]FraudAction{
+		FraudRuleNewAccountFunnel: FraudHold,
+		FraudRuleCircularTransfer: FraudHold,
+		FraudRuleVelocityBurst:    FraudHold,
+		FraudRuleSharedIp:         FraudHold,
+	},
+	NewAccountDays:    7,
+	FunnelSenders:     3,
+	VelocityTransfers: 20,
+	VelocityCredits:   1000,
+	SharedIpAccounts:  3,
+	CircularDepth:     3,
+}
+
+const fraudLookback = 24 * time.Hour
+
+func isValidFraudAction(a FraudAction) bool {
+	return a == FraudAllow || a == FraudHold || a == FraudBlock
+}
+
+func (a FraudAction) severity() int {
+	switch a {
+	case FraudBlock:
+		return 2
+	case FraudHold:
+		return 1
+	}
+	return 0
+}
+
+// applyFraudRuleActions reads "rule=action" pairs, e.g. "shared_ip=hold,velocity_burst=block"
+func applyFraudRuleActions(spec string) {
+	for _, pair := range strings.Split(spec, ",") {
+		rule, action, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		rule, action = strings.TrimSpace(rule), strings.TrimSpace(action)
+		if _, known := fraudConfig.Actions[rule]; !known || !isValidFraudAction(FraudAction(action)) {
+			log.Printf("[config] ignoring fraud rule action %q", pair)
+			continue
+		}
+		fraudConfig.Actions[rule] = FraudAction(action)
+	}
+}
+
+// FraudCheck describes the request being checked. From pays and To receives,
+// From is nil for daily claims since those credits are minted.
+type FraudCheck struct {
+	Kind          string
+	From          User
+	To            User
+	Amount        float64
+	PriorClaimers []UserId // gift claims only
+}
+
+// actor is the account that made the request
+func (fc FraudCheck) actor() User {
+	if fc.Kind == FraudDailyClaim || fc.Kind == FraudGiftClaim {
+		return fc.To
+	}
+	return fc.From
+}
+
+type FraudHit struct {
+	Rule   string      `json:"rule"`
+	Action FraudAction `json:"action"`
+	Reason string      `json:"reason"`
+}
+
+type fraudRule struct {
+	name  string
+	kinds []string
+	check func(fc FraudCheck, now time.Time) string // returns why it fired, or ""
+}
+
+var fraudRules = []fraudRule{
+	{FraudRuleNewAccountFunnel, []string{FraudTransfer}, checkNewAccountFunnel},
+	{FraudRuleCircularTransfer, []string{FraudTransfer}, checkCircularTransfer},
+	{FraudRuleVelocityBurst, []string{FraudTransfer, FraudGiftClaim}, checkVelocityBurst},
+	{FraudRuleSharedIp, []string{FraudTransfer, FraudDailyClaim, FraudGiftClaim, FraudItemPurchase}, checkSharedIp},
+}
+
+// evaluateFraudRules runs every rule for the request and returns the strictest
+// action among the ones that fired
+func evaluateFraudRules(fc FraudCheck) (FraudAction, []FraudHit) {
+	now := time.Now()
+	action := FraudAllow
+	hits := make([]FraudHit, 0)
+	for _, rule := range fraudRules {
+		applies := false
+		for _, k := range rule.kinds {
+			if k == fc.Kind {
+				applies = true
+				break
+			}
+		}
+		if !applies {
+			continue
+		}
+		reason := rule.check(fc, now)
+		if reason == "" {
+			continue
+		}
+		ruleAction := fraudConfig.Actions[rule.name]
+		hits = append(hits, FraudHit{Rule: rule.name, Action: ruleAction, Reason: reason})
+		if ruleAction.severity() > action.severity() {
+			action = ruleAction
+		}
+	}
+
+	if len(hits) > 0 {
+		log.Printf("[fraud] %s by %s (%.2f credits): %s, %v", fc.Kind, fc.actor().GetUsername(), fc.Amount, action, hits)
+	}
+	return action, hits
+}
+
+func isNewAccount(u User, now time.Time) bool {
+	created := u.GetCreated()
+	return created > 0 && now.Sub(time.UnixMilli(created)) < time.Duration(fraudConfig.NewAccountDays)*24*time.Hour
+}
+
+// checkNewAccountFunnel fires when several new accounts pay the same recipient
+func checkNewAccountFunnel(fc FraudCheck, now time.Time) string {
+	if !isNewAccount(fc.From, now) {
+		return ""
+	}
+	since := now.Add(-fraudLookback).UnixMilli()
+	senders := map[UserId]bool{fc.From.GetId(): true}
+	for _, tx := range fc.To.GetTransactions() {
+		if tx.Timestamp < since {
+			break
+		}
+		if tx.Type != "in" || senders[tx.User] {
+			continue
+		}
+		if sender := getUserById(tx.User); len(sender) > 0 && isNewAccount(sender, now) {
+			senders[tx.User] = true
+		}
+	}
+	if len(senders) >= fraudConfig.FunnelSenders {
+		return fmt.Sprintf("%d new accounts paid %s within a day", len(senders), fc.To.GetUsername())
+	}
+	return ""
+}
+
+// checkCircularTransfer fires when credits sent to the recipient recently made
+// their way back from them to the sender
+func checkCircularTransfer(fc FraudCheck, now time.Time) string {
+	since := now.Add(-fraudLookback).UnixMilli()
+	senderId := fc.From.GetId()
+	visited := map[UserId]bool{fc.To.GetId(): true}
+	frontier := []User{fc.To}
+	for depth := 1; depth <= fraudConfig.CircularDepth && len(frontier) > 0; depth++ {
+		next := make([]User, 0)
+		for _, u := range frontier {
+			for _, tx := range u.GetTransactions() {
+				if tx.Timestamp < since {
+					break
+				}
+				if tx.Type != "out" || visited[tx.User] {
+					continue
+				}
+				if tx.User == senderId {
+					return fmt.Sprintf("%s sent credits back to %s within %d hops", fc.To.GetUsername(), fc.From.GetUsername(), depth)
+				}
+				visited[tx.User] = true
+				if hop := getUserById(tx.User); len(hop) > 0 {
+					next = append(next, hop)
+				}
+			}
+		}
+		frontier = next
+	}
+	return ""
+}
+
+// checkVelocityBurst fires when the actor moves too much, too often in the last hour
+func checkVelocityBurst(fc FraudCheck, now time.Time) string {
+	txType := "out"
+	if fc.Kind == FraudGiftClaim {
+		txType = "gift_claim"
+	}
+	since := now.Add(-time.Hour).UnixMilli()
+	count, total := 1, fc.Amount
+	for _, tx := range fc.actor().GetTransactions() {
+		if tx.Timestamp < since {
+			break
+		}
+		if tx.Type == txType {
+			count++
+			total += tx.Amount
+		}
+	}
+	if count > fraudConfig.VelocityTransfers {
+		return fmt.Sprintf("%d %s in the last hour", count, fc.Kind)
+	}
+	if total > fraudConfig.VelocityCredits {
+		return fmt.Sprintf("%.2f credits moved in the last hour", total)
+	}
+	return ""
+}
+
+// loginIpIndex maps a login IP hmac to the accounts that have logged in from
+// it, so the shared IP rule doesn't scan every user. It is rebuilt when users
+// load and added to on login, IPs that drop out of a login history are
+// filtered out when it is read.
+var (
+	loginIpIndex      = make(map[string]map[UserId]bool)
+	loginIpIndexMutex sync.RWMutex
+)
+
+func buildLoginIpIndex(loaded []User) map[string]map[UserId]bool {
+	index := make(map[string]map[UserId]bool)
+	for _, u := range loaded {
+		id := u.GetId()
+		for _, login := range u.GetLogins() {
+			addLoginIpLocked(index, login.IP_hmac, id)
+		}
+	}
+	return index
+}
+
+func addLoginIpLocked(index map[string]map[UserId]bool, ipHmac string, id UserId) {
+	if ipHmac == "" || id == "" {
+		return
+	}
+	if index[ipHmac] == nil {
+		index[ipHmac] = make(map[UserId]bool)
+	}
+	index[ipHmac][id] = true
+}
+
+func indexLoginIps(u User, logins []Login) {
+	id := u.GetId()
+	loginIpIndexMutex.Lock()
+	defer loginIpIndexMutex.Unlock()
+	for _, login := range logins {
+		addLoginIpLocked(loginIpIndex, login.IP_hmac, id)
+	}
+}
+
+// accountsSharingIp returns the other accounts that have logged in from any of
+// the IPs u has logged in from
+func accountsSharingIp(u User) []User {
+	ips := make(map[string]bool)
+	for _, login := range u.GetLogins() {
+		if login.IP_hmac != "" {
+			ips[login.IP_hmac] = true
+		}
+	}
+	if len(ips) == 0 {
+		return nil
+	}
+
+	id := u.GetId()
+	candidates := make(map[UserId]bool)
+	loginIpIndexMutex.RLock()
+	for ip := range ips {
+		for other := range loginIpIndex[ip] {
+			if other != id {
+				candidates[other] = true
+			}
+		}
+	}
+	loginIpIndexMutex.RUnlock()
+
+	peers := make([]User, 0, len(candidates))
+	for candidate := range candidates {
+		other := getUserById(candidate)
+		if len(other) == 0 {
+			continue
+		}
+		for _, login := range other.GetLogins() {
+			if ips[login.IP_hmac] {
+				peers = append(peers, other)
+				break
+			}
+		}
+	}
+	return peers
+}
+
+// checkSharedIp fires when a cluster of accounts behind the same login IP acts together
+func checkSharedIp(fc FraudCheck, now time.Time) string {
+	actor := fc.actor()
+	peers := accountsSharingIp(actor)
+	if len(peers) == 0 {
+		return ""
+	}
+
+	involved := 1
+	switch fc.Kind {
+	case FraudTransfer, FraudItemPurchase:
+		// paying an account on the same IP, counted against the whole cluster
+		for _, p := range peers {
+			if p.GetId() == fc.To.GetId() {
+				involved += len(peers)
+				break
+			}
+		}
+	case FraudDailyClaim:
+		cutoff := now.Add(-24 * time.Hour).Unix()
+		for _, p := range peers {
+			if claim, ok := getDailyClaim(p.GetUsername()); ok && claim.LastClaim > cutoff {
+				involved++
+			}
+		}
+	case FraudGiftClaim:
+		for _, p := range peers {
+			for _, id := range fc.PriorClaimers {
+				if p.GetId() == id {
+					involved++
+					break
+				}
+			}
+		}
+	}
+
+	if involved >= fraudConfig.SharedIpAccounts {
+		return fmt.Sprintf("%d accounts sharing a login IP with %s", involved, actor.GetUsername())
+	}
+	return ""
+}
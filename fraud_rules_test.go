@@ -0,0 +1,184 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFraudNewAccountFunnel(t *testing.T) {
+	now := time.Now()
+	fresh := now.Add(-time.Hour).UnixMilli()
+	alt1 := User{"username": "alt1", "sys.id": "fr-alt1", "created": fresh}
+	alt2 := User{"username": "alt2", "sys.id": "fr-alt2", "created": fresh}
+	alt3 := User{"username": "alt3", "sys.id": "fr-alt3", "created": fresh}
+	target := User{"username": "target", "sys.id": "fr-target", "created": now.AddDate(-1, 0, 0).UnixMilli()}
+	withTestUsers(t, alt1, alt2, alt3, target)
+
+	target.addTransaction(Transaction{Type: "in", User: alt1.GetId(), Amount: 5})
+	if reason := checkNewAccountFunnel(FraudCheck{Kind: FraudTransfer, From: alt2, To: target, Amount: 5}, now); reason != "" {
+		t.Errorf("Two new senders should be under the threshold, got %q", reason)
+	}
+	target.addTransaction(Transaction{Type: "in", User: alt2.GetId(), Amount: 5})
+	if reason := checkNewAccountFunnel(FraudCheck{Kind: FraudTransfer, From: alt3, To: target, Amount: 5}, now); reason == "" {
+		t.Error("Third new account paying the same recipient should fire")
+	}
+	if reason := checkNewAccountFunnel(FraudCheck{Kind: FraudTransfer, From: target, To: alt3, Amount: 5}, now); reason != "" {
+		t.Error("Old accounts should not trigger the funnel rule")
+	}
+}
+
+func TestFraudCircularTransfer(t *testing.T) {
+	a := User{"username": "a", "sys.id": "fr-a"}
+	b := User{"username": "b", "sys.id": "fr-b"}
+	c := User{"username": "c", "sys.id": "fr-c"}
+	withTestUsers(t, a, b, c)
+
+	// b -> c -> a already happened, a -> b closes the loop
+	b.addTransaction(Transaction{Type: "out", User: c.GetId(), Amount: 10})
+	if reason := checkCircularTransfer(FraudCheck{Kind: FraudTransfer, From: a, To: b, Amount: 10}, time.Now()); reason != "" {
+		t.Errorf("No loop yet, got %q", reason)
+	}
+	c.addTransaction(Transaction{Type: "out", User: a.GetId(), Amount: 10})
+	if reason := checkCircularTransfer(FraudCheck{Kind: FraudTransfer, From: a, To: b, Amount: 10}, time.Now()); reason == "" {
+		t.Error("Expected the loop through c to be caught")
+	}
+}
+
+func TestFraudVelocityAndSharedIp(t *testing.T) {
+	sender := User{"username": "sender", "sys.id": "fr-sender"}
+	alt := User{"username": "alt", "sys.id": "fr-alt"}
+	other := User{"username": "other", "sys.id": "fr-other"}
+	withTestUsers(t, sender, alt, other)
+
+	for i := 0; i < fraudConfig.VelocityTransfers; i++ {
+		sender.addTransaction(Transaction{Type: "out", User: other.GetId(), Amount: 1})
+	}
+	action, hits := evaluateFraudRules(FraudCheck{Kind: FraudTransfer, From: sender, To: other, Amount: 1})
+	if action != fraudConfig.Actions[FraudRuleVelocityBurst] || len(hits) != 1 || hits[0].Rule != FraudRuleVelocityBurst {
+		t.Errorf("Expected a velocity hit, got %s %+v", action, hits)
+	}
+
+	for _, u := range []User{sender, alt, other} {
+		u.SetLogins([]Login{{IP_hmac: "shared"}})
+	}
+	if reason := checkSharedIp(FraudCheck{Kind: FraudTransfer, From: sender, To: alt, Amount: 1}, time.Now()); reason == "" {
+		t.Error("Paying an account in a cluster of 3 on one IP should fire")
+	}
+	gift := FraudCheck{Kind: FraudGiftClaim, To: sender, Amount: 1, PriorClaimers: []UserId{alt.GetId()}}
+	if reason := checkSharedIp(gift, time.Now()); reason != "" {
+		t.Errorf("One earlier claim from the IP is under the threshold, got %q", reason)
+	}
+	gift.PriorClaimers = append(gift.PriorClaimers, other.GetId())
+	if reason := checkSharedIp(gift, time.Now()); reason == "" {
+		t.Error("Third claim of one gift from the same IP should fire")
+	}
+}
+
+func TestHeldTransferApproveAndReject(t *testing.T) {
+	sender := User{"username": "sender", "sys.id": "held-sender", "sys.currency": 20.0}
+	recipient := User{"username": "recipient", "sys.id": "held-recipient", "sys.currency": 0.0}
+	withTestUsers(t, sender, recipient)
+	withTestLedger(t)
+	withTestHeldTransfers(t)
+
+	approved, err := holdTransfer(FraudTransfer, sender, recipient, 8, "first", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected, err := holdTransfer(FraudTransfer, sender, recipient, 5, "second", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sender.GetCredits() != 7 || fromMinor(getLedgerBalance(LedgerHeldTransfers)) != 13 || outstandingHeldUnits() != 1300 {
+		t.Fatalf("Held credits should leave the sender, sender has %v", sender.GetCredits())
+	}
+
+	heldTransfersMutex.Lock()
+	defer heldTransfersMutex.Unlock()
+	if err := resolveHeldTransferLocked(findHeldTransferLocked(approved.Id), true, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := resolveHeldTransferLocked(findHeldTransferLocked(rejected.Id), false, "looks like an alt"); err != nil {
+		t.Fatal(err)
+	}
+	if recipient.GetCredits() != 8 || sender.GetCredits() != 12 || getLedgerBalance(LedgerHeldTransfers) != 0 {
+		t.Errorf("Unexpected balances sender %v recipient %v", sender.GetCredits(), recipient.GetCredits())
+	}
+	if err := resolveHeldTransferLocked(findHeldTransferLocked(approved.Id), false, ""); err != errHeldTransferDone {
+		t.Errorf("Resolved transfer should not resolve twice, got %v", err)
+	}
+}
+
+func TestHeldPaymentsSettleOnResolve(t *testing.T) {
+	payer := User{"username": "payer", "sys.id": "held-payer", "sys.currency": 50.0}
+	payee := User{"username": "payee", "sys.id": "held-payee", "sys.currency": 0.0}
+	withTestUsers(t, payer, payee)
+	withTestLedger(t)
+	withTestHeldTransfers(t)
+
+	now := time.Now()
+	withTestPaymentRequests(t, PaymentRequest{Id: "req", RequesterId: "held-payee", PayerId: "held-payer", Amount: 10, Status: PaymentRequestPending, CreatedAt: now.UnixMilli(), ExpiresAt: now.Add(time.Hour).UnixMilli()})
+	withTestStandingOrders(t, StandingOrder{Id: "order", FromUserId: "held-payer", ToUserId: "held-payee", Amount: 5, Frequency: StandingOrderDaily, Status: StandingOrderActive, NextRunAt: now.UnixMilli()})
+
+	// enough sent in the last hour to hold the next transfer
+	payer.addTransaction(Transaction{Type: "out", User: payee.GetId(), Amount: fraudConfig.VelocityCredits})
+
+	r, err := payPaymentRequest("req", payer)
+	if err != nil || r.Status != PaymentRequestHeld || r.HeldTransferId == "" {
+		t.Fatalf("Held payment should leave the request held, got %v %+v", err, r)
+	}
+	if paid := processStandingOrders(now); paid != 0 {
+		t.Fatalf("Held standing order payment should not count as paid, got %d", paid)
+	}
+	standingOrdersMutex.Lock()
+	order := standingOrders[0]
+	standingOrdersMutex.Unlock()
+	if order.Status != StandingOrderHeld || order.HeldTransferId == "" || order.Runs != 0 {
+		t.Fatalf("Standing order should wait for review, got %+v", order)
+	}
+
+	if _, err := resolveHeldTransfer(r.HeldTransferId, true, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveHeldTransfer(order.HeldTransferId, false, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	paymentRequestsMutex.Lock()
+	r = paymentRequests[0]
+	paymentRequestsMutex.Unlock()
+	if r.Status != PaymentRequestPaid {
+		t.Errorf("Approved hold should pay the request, got %s", r.Status)
+	}
+	standingOrdersMutex.Lock()
+	order = standingOrders[0]
+	standingOrdersMutex.Unlock()
+	if order.Status != StandingOrderActive || order.Runs != 0 || order.Failures != 1 || order.HeldTransferId != "" {
+		t.Errorf("Rejected hold should count as a failed run, got %+v", order)
+	}
+	if payer.GetCredits() != 40 || payee.GetCredits() != 10 {
+		t.Errorf("Unexpected balances payer %v payee %v", payer.GetCredits(), payee.GetCredits())
+	}
+}
+
+func TestLoginIpIndex(t *testing.T) {
+	a := User{"username": "a", "sys.id": "ip-a", "sys.logins": []Login{{IP_hmac: "home"}}}
+	b := User{"username": "b", "sys.id": "ip-b"}
+	c := User{"username": "c", "sys.id": "ip-c"}
+	withTestUsers(t, a, b, c)
+
+	if peers := accountsSharingIp(a); len(peers) != 0 {
+		t.Fatalf("No one else has logged in from a's IP, got %d peers", len(peers))
+	}
+	recordLogin(b, Login{IP_hmac: "home"})
+	recordLogin(c, Login{IP_hmac: "work"})
+	if peers := accountsSharingIp(a); len(peers) != 1 || peers[0].GetId() != b.GetId() {
+		t.Fatalf("A login from the same IP should be indexed, got %d peers", len(peers))
+	}
+
+	// the index keeps old IPs, the login history decides
+	b.SetLogins([]Login{{IP_hmac: "elsewhere"}})
+	if peers := accountsSharingIp(a); len(peers) != 0 {
+		t.Errorf("IPs no longer in the login history should not count, got %d peers", len(peers))
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGiftCampaignClaims(t *testing.T) {
+	campaign := Gift{Id: "campaign", Amount: 2.5, MaxClaims: 3}
+	if campaign.RemainingClaims() != 3 || campaign.Outstanding() != 7.5 {
+		t.Fatalf("Expected 3 claims holding 7.5, got %d %v", campaign.RemainingClaims(), campaign.Outstanding())
+	}
+
+	campaign.Claims = append(campaign.Claims, GiftClaim{UserId: "claimer-1", ClaimedAt: 1})
+	if !campaign.HasClaimed("claimer-1") || campaign.HasClaimed("claimer-2") {
+		t.Error("Claimers should be tracked per user")
+	}
+	if campaign.Outstanding() != 5 || !campaign.CanBeClaimed() {
+		t.Errorf("Expected 5 left and still claimable, got %v", campaign.Outstanding())
+	}
+
+	single := Gift{Id: "single", Amount: 4}
+	if single.IsCampaign() || single.Outstanding() != 4 {
+		t.Errorf("Single gift should hold its amount, got %v", single.Outstanding())
+	}
+	now := time.Now().UnixMilli()
+	single.ClaimedAt = &now
+	if single.Outstanding() != 0 {
+		t.Error("Claimed gift should hold nothing")
+	}
+}
+
+func TestGiftRestrictions(t *testing.T) {
+	day := int64(24 * time.Hour / time.Millisecond)
+	fresh := User{"username": "fresh", "sys.id": "gift-fresh", "created": time.Now().UnixMilli() - day}
+	old := User{"username": "old", "sys.id": "gift-old", "created": time.Now().UnixMilli() - 30*day}
+	withTestUsers(t, fresh, old)
+
+	gift := &Gift{Amount: 1, MaxClaims: 10, Restrictions: &GiftRestrictions{MinAccountAgeDays: 7}}
+	if err := checkGiftRestrictions(gift, fresh); err == nil {
+		t.Error("New accounts should be turned away")
+	}
+	if err := checkGiftRestrictions(gift, old); err != nil {
+		t.Errorf("Old account should be able to claim, got %v", err)
+	}
+
+	old.Set("sys.standing", string(StandingWarning))
+	gift.Restrictions.MinStanding = StandingGood
+	if err := checkGiftRestrictions(gift, old); err == nil {
+		t.Error("Accounts below the required standing should be turned away")
+	}
+
+	if err := validateGiftRestrictions(&GiftRestrictions{MinStanding: StandingBanned}); err == nil {
+		t.Error("Banned is not a valid minimum standing")
+	}
+}
+
+func TestRefundGiftCampaignRemainder(t *testing.T) {
+	withTestLedger(t)
+	creator := User{"username": "creator", "sys.id": "gift-creator", "sys.currency": 0.0}
+	withTestUsers(t, creator)
+
+	if _, err := postLedger("gift_create", "campaign", accountPosting(LedgerMint, -10), accountPosting(LedgerGiftEscrow, 10)); err != nil {
+		t.Fatal(err)
+	}
+	gift := Gift{Id: "campaign", Code: "CODE", Amount: 2, MaxClaims: 5, CreatorId: "gift-creator",
+		Claims: []GiftClaim{{UserId: "a"}, {UserId: "b"}}}
+	if _, err := postLedger("gift_claim", "campaign", accountPosting(LedgerGiftEscrow, -4), accountPosting(LedgerSink, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	giftsMutex.Lock()
+	refunded, err := refundGiftLocked(&gift, "Gift expired: CODE", time.Now().UnixMilli())
+	giftsMutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded != 6 || creator.GetCredits() != 6 {
+		t.Errorf("Expected the 3 unclaimed shares back, got %v", refunded)
+	}
+	if gift.IsActive() || getLedgerBalance(LedgerGiftEscrow) != 0 {
+		t.Error("Refunded campaign should be closed with an empty escrow")
+	}
+}
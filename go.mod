@@ -7,6 +7,7 @@ toolchain go1.24.1
 require (
 	github.com/SherClockHolmes/webpush-go v1.4.0
 	github.com/esimov/colorquant v1.0.0
+	github.com/fsnotify/fsnotify v1.9.0
 	github.com/gin-contrib/cors v1.7.6
 	github.com/gin-gonic/gin v1.10.1
 	github.com/google/uuid v1.6.0
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGroupProductPurchaseAndRenewal(t *testing.T) {
+	withTestEvents(t)
+	withTestLedger(t)
+	buyer := User{"username": "buyer", "sys.id": "gp-buyer", "sys.currency": 25.0}
+	withTestUsers(t, buyer)
+
+	group := &GroupData{
+		Group:   Group{Tag: "shop"},
+		Roles:   []GroupRole{{Id: "owner", Name: "Owner"}, {Id: "vip", Name: "VIP"}},
+		Members: []GroupMember{{UserId: "gp-buyer"}},
+		BenefitProducts: map[string]GroupBenefitProduct{
+			"vip-monthly": {Id: "vip-monthly", Name: "VIP", PriceCredits: 10, RoleGrantedId: "vip", BenefitGranted: "badge", BillingPeriodDays: 30},
+		},
+	}
+	withTestGroups(t, group)
+
+	groupsDataMutex.Lock()
+	defer groupsDataMutex.Unlock()
+
+	if err := validateBenefitProductLocked(group, GroupBenefitProduct{Name: "Take over", RoleGrantedId: "owner"}); err == nil {
+		t.Error("The owner role should not be for sale")
+	}
+
+	now := time.Now()
+	p, err := purchaseBenefitLocked(group, buyer, "vip-monthly", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buyer.GetCredits() != 15 || group.Group.CreditsBalance != 10 || fromMinor(getLedgerBalance(groupLedgerAccount("shop"))) != 10 {
+		t.Fatalf("Unexpected balances buyer %v group %v", buyer.GetCredits(), group.Group.CreditsBalance)
+	}
+	if !p.RoleAdded || !containsString(group.Members[0].RoleIds, "vip") {
+		t.Errorf("Purchase should grant the role, got %+v", group.Members[0].RoleIds)
+	}
+	if benefits := activeBenefitsLocked(group, "gp-buyer"); len(benefits) != 1 || benefits[0] != "badge" {
+		t.Errorf("Expected the purchased benefit, got %v", benefits)
+	}
+	if _, err := purchaseBenefitLocked(group, buyer, "vip-monthly", now); err != errProductOwned {
+		t.Errorf("Buying an active product twice should be refused, got %v", err)
+	}
+
+	// first renewal is paid, the second can't be and takes the role back
+	period := now.AddDate(0, 0, 30)
+	if changed, charged := renewBenefitPurchasesLocked(group, period); !changed || !charged {
+		t.Fatal("Expected the purchase to renew")
+	}
+	if buyer.GetCredits() != 5 || group.Group.CreditsBalance != 20 || group.BenefitPurchases[0].Status != BenefitPurchaseActive {
+		t.Fatalf("Unexpected state after renewal, buyer %v purchase %+v", buyer.GetCredits(), group.BenefitPurchases[0])
+	}
+	renewBenefitPurchasesLocked(group, period.AddDate(0, 0, 30))
+	if group.BenefitPurchases[0].Status != BenefitPurchaseLapsed || containsString(group.Members[0].RoleIds, "vip") {
+		t.Errorf("Unpaid renewal should lapse and revoke the role, got %+v", group.BenefitPurchases[0])
+	}
+	if buyer.GetCredits() != 5 || len(activeBenefitsLocked(group, "gp-buyer")) != 0 {
+		t.Errorf("Lapsed purchase should not charge or grant anything, buyer has %v", buyer.GetCredits())
+	}
+}
+
+func TestGroupProductCancelKeepsExistingRole(t *testing.T) {
+	withTestEvents(t)
+	withTestLedger(t)
+	buyer := User{"username": "buyer", "sys.id": "gp-member", "sys.currency": 5.0}
+	withTestUsers(t, buyer)
+
+	group := &GroupData{
+		Group:   Group{Tag: "club"},
+		Roles:   []GroupRole{{Id: "helper", Name: "Helper"}},
+		Members: []GroupMember{{UserId: "gp-member", RoleIds: []string{"helper"}}},
+		BenefitProducts: map[string]GroupBenefitProduct{
+			"helper": {Id: "helper", Name: "Helper", RoleGrantedId: "helper", BillingPeriodDays: 7},
+		},
+	}
+	withTestGroups(t, group)
+
+	groupsDataMutex.Lock()
+	defer groupsDataMutex.Unlock()
+
+	now := time.Now()
+	p, err := purchaseBenefitLocked(group, buyer, "helper", now)
+	if err != nil || p.RoleAdded {
+		t.Fatalf("Role the member already had should not be marked as added, got %+v %v", p, err)
+	}
+	group.BenefitPurchases[0].CancelAtPeriodEnd = true
+	renewBenefitPurchasesLocked(group, now.AddDate(0, 0, 7))
+	if group.BenefitPurchases[0].Status != BenefitPurchaseCancelled || !containsString(group.Members[0].RoleIds, "helper") {
+		t.Errorf("Cancelled purchase should end without removing the role, got %+v %v", group.BenefitPurchases[0], group.Members[0].RoleIds)
+	}
+}
+
+func TestGroupProductStepUp(t *testing.T) {
+	withTestGroups(t, &GroupData{
+		Group: Group{Tag: "shop"},
+		BenefitProducts: map[string]GroupBenefitProduct{
+			"sticker": {Id: "sticker", PriceCredits: 5},
+			"vip":     {Id: "vip", PriceCredits: StepUpTransferThreshold + 1},
+		},
+	})
+
+	stepUp := func(productId string) bool {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Params = gin.Params{{Key: "grouptag", Value: "shop"}, {Key: "productid", Value: productId}}
+		return groupProductAboveStepUpThreshold(c)
+	}
+	if stepUp("sticker") || stepUp("missing") {
+		t.Error("Cheap or unknown products should not ask for 2fa")
+	}
+	if !stepUp("vip") {
+		t.Error("Products above the step-up threshold should ask for 2fa")
+	}
+}
+
+func TestGroupProductRoleGrantNeedsPermission(t *testing.T) {
+	group := &GroupData{
+		Group: Group{Tag: "guild"},
+		Roles: []GroupRole{
+			{Id: "shopkeeper", Name: "Shopkeeper", Permissions: []string{"groups.products.manage"}},
+			{Id: "seller", Name: "Seller", Permissions: []string{"groups.products.manage", "groups.roles.assign"}},
+			{Id: "vip", Name: "VIP", Permissions: []string{"groups.roles.assign"}},
+			{Id: "admin", Name: "Admin", Permissions: []string{"groups.treasury.manage"}},
+		},
+		Members: []GroupMember{
+			{UserId: "gp-shopkeeper", RoleIds: []string{"shopkeeper"}},
+			{UserId: "gp-seller", RoleIds: []string{"seller"}},
+		},
+	}
+
+	if err := checkProductRoleGrantLocked(group, "gp-shopkeeper", ""); err != nil {
+		t.Errorf("Benefit-only products need no role permissions, got %v", err)
+	}
+	if err := checkProductRoleGrantLocked(group, "gp-shopkeeper", "vip"); err != errProductRoleAssign {
+		t.Errorf("Selling a role should need groups.roles.assign, got %v", err)
+	}
+	if err := checkProductRoleGrantLocked(group, "gp-seller", "admin"); err != errProductRoleTooStrong {
+		t.Errorf("Selling a role with permissions the seller lacks should be refused, got %v", err)
+	}
+	if err := checkProductRoleGrantLocked(group, "gp-seller", "vip"); err != nil {
+		t.Errorf("Selling a role within the seller's permissions should be allowed, got %v", err)
+	}
+	if productErrorStatus(errProductRoleTooStrong) != 403 {
+		t.Error("Role grant refusals should be 403")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTreasuryProposalApprovals(t *testing.T) {
+	withTestEvents(t)
+	withTestLedger(t)
+	recipient := User{"username": "recipient", "sys.id": "tr-recipient", "sys.currency": 0.0}
+	withTestUsers(t, recipient)
+
+	group := &GroupData{
+		Group: Group{Tag: "club", OwnerUserId: "tr-owner", CreditsBalance: 50},
+		Roles: []GroupRole{{Id: "treasurer"}, {Id: "council"}},
+		Members: []GroupMember{
+			{UserId: "tr-owner"},
+			{UserId: "tr-a", RoleIds: []string{"treasurer"}},
+			{UserId: "tr-b", RoleIds: []string{"treasurer"}},
+			{UserId: "tr-c", RoleIds: []string{"council", "treasurer"}},
+		},
+		TreasuryPolicy: &GroupTreasuryPolicy{ApproverRoleIds: []string{"treasurer", "council"}, Threshold: 2},
+	}
+	withTestGroups(t, group)
+	if _, err := postLedger("group_tip", "club", accountPosting(LedgerMint, -50), accountPosting(groupLedgerAccount("club"), 50)); err != nil {
+		t.Fatal(err)
+	}
+
+	groupsDataMutex.Lock()
+	defer groupsDataMutex.Unlock()
+
+	if _, err := proposeTreasuryPayoutLocked(group, GroupTreasuryProposal{Id: "too-much", ProposerId: "tr-a", ToUserId: recipient.GetId(), AmountCredits: 60}); err != errTreasuryInsufficient {
+		t.Errorf("Proposals above the balance should be refused, got %v", err)
+	}
+	p, err := proposeTreasuryPayoutLocked(group, GroupTreasuryProposal{Id: "payout", ProposerId: "tr-a", ToUserId: recipient.GetId(), AmountCredits: 20})
+	if err != nil || p.Status != TreasuryProposalPending {
+		t.Fatalf("Expected a pending proposal, got %v %v", p, err)
+	}
+
+	if err := approveTreasuryProposalLocked(group, p, "tr-a"); err != errOwnProposal {
+		t.Errorf("Proposer should not approve their own proposal, got %v", err)
+	}
+	if err := approveTreasuryProposalLocked(group, p, "tr-b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := approveTreasuryProposalLocked(group, p, "tr-owner"); err != errNotTreasuryApprover {
+		t.Errorf("Members without an approver role should be refused, got %v", err)
+	}
+	if err := executeTreasuryProposalLocked(group, p, "tr-a"); err != errProposalNotApproved {
+		t.Errorf("One of two roles should not be enough, got %v", err)
+	}
+
+	// tr-c holds both roles, the approval goes to the role that hasn't approved yet
+	if err := approveTreasuryProposalLocked(group, p, "tr-c"); err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != TreasuryProposalApproved || p.Approvals[1].RoleId != "council" {
+		t.Fatalf("Expected approval through the council role, got %+v", p.Approvals)
+	}
+
+	if err := executeTreasuryProposalLocked(group, p, "tr-a"); err != nil {
+		t.Fatal(err)
+	}
+	if recipient.GetCredits() != 20 || group.Group.CreditsBalance != 30 || fromMinor(getLedgerBalance(groupLedgerAccount("club"))) != 30 {
+		t.Errorf("Unexpected balances recipient %v group %v", recipient.GetCredits(), group.Group.CreditsBalance)
+	}
+	if n := len(group.TreasuryHistory); n != 1 || group.TreasuryHistory[0].Type != "payout_out" || group.TreasuryHistory[0].BalanceAfter != 30 {
+		t.Errorf("Payout should be in the treasury history, got %+v", group.TreasuryHistory)
+	}
+	if err := executeTreasuryProposalLocked(group, p, "tr-a"); err != errProposalClosed {
+		t.Errorf("Executed proposal should not pay twice, got %v", err)
+	}
+}
+
+func TestTreasuryPayoutToGroup(t *testing.T) {
+	withTestEvents(t)
+	withTestLedger(t)
+	from := &GroupData{Group: Group{Tag: "from", CreditsBalance: 10}}
+	to := &GroupData{Group: Group{Tag: "to", CreditsBalance: 1}}
+	withTestGroups(t, from, to)
+
+	groupsDataMutex.Lock()
+	defer groupsDataMutex.Unlock()
+
+	// without a policy proposals can be executed straight away
+	p, err := proposeTreasuryPayoutLocked(from, GroupTreasuryProposal{Id: "gift", ProposerId: "owner", ToGroupTag: "to", AmountCredits: 4})
+	if err != nil || p.Status != TreasuryProposalApproved {
+		t.Fatalf("Expected an approved proposal, got %v %v", p, err)
+	}
+	if err := executeTreasuryProposalLocked(from, p, "owner"); err != nil {
+		t.Fatal(err)
+	}
+	if from.Group.CreditsBalance != 6 || to.Group.CreditsBalance != 5 {
+		t.Errorf("Unexpected balances %v %v", from.Group.CreditsBalance, to.Group.CreditsBalance)
+	}
+	if len(to.TreasuryHistory) != 1 || to.TreasuryHistory[0].Counterparty != "from" {
+		t.Errorf("Receiving group should record the payout, got %+v", to.TreasuryHistory)
+	}
+
+	if err := validateTreasuryPolicy(from, GroupTreasuryPolicy{ApproverRoleIds: []string{"missing"}, Threshold: 1}); err == nil {
+		t.Error("Policies naming unknown roles should be rejected")
+	}
+}
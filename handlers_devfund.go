@@ -55,15 +55,6 @@ func escrowTransfer(c *gin.Context) {
 		return
 	}
 
-	// Deduct from sender (no tax for escrow)
-	newBal := roundVal(fromCurrency - nAmount)
-	if newBal < 0 { // guard against tiny floating error
-		newBal = 0
-	}
-
-	user.SetBalance(newBal)
-
-	// Add escrow transaction to sender
 	now := time.Now().UnixMilli()
 	note := strings.TrimSpace(req.Note)
 	if note == "" {
@@ -73,6 +64,13 @@ func escrowTransfer(c *gin.Context) {
 		note = note[:50]
 	}
 
+	// Deduct from sender (no tax for escrow)
+	if _, err := postLedger("devfund_escrow", req.PetitionID, userPosting(*user, -nAmount), accountPosting(LedgerDevfund, nAmount)); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	newBal := user.GetCredits()
+
 	user.addTransaction(Transaction{
 		Note:       note,
 		User:       Username("rotur").Id(),
@@ -140,17 +138,6 @@ func escrowRelease(c *gin.Context) {
 		return
 	}
 
-	// Get recipient balance
-	toCurrency := toUser.GetCredits()
-	if toCurrency == 0 {
-		toCurrency = float64(0)
-	}
-
-	// Add credits to recipient
-	newBal := roundVal(toCurrency + nAmount)
-	toUser.SetBalance(newBal)
-
-	// Add transaction to recipient
 	now := time.Now().UnixMilli()
 	note := strings.TrimSpace(req.Note)
 	if note == "" {
@@ -160,6 +147,13 @@ func escrowRelease(c *gin.Context) {
 		note = note[:50]
 	}
 
+	// Add credits to recipient
+	if _, err := postLedger("devfund_release", req.PetitionID, accountPosting(LedgerDevfund, -nAmount), userPosting(toUser, nAmount)); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	newBal := toUser.GetCredits()
+
 	// Helper to add transaction
 	toUser.addTransaction(Transaction{
 		Note:       note,
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getEconomyPolicyAdmin returns the running economy policy, or replaces it when
+// the body has a policy. Settings left out of the policy keep their values.
+func getEconomyPolicyAdmin(c *gin.Context) {
+	if !authenticateAdmin(c) {
+		return
+	}
+
+	var req struct {
+		Policy json.RawMessage `json:"policy"`
+	}
+	_ = c.ShouldBindJSON(&req)
+
+	if len(req.Policy) > 0 {
+		policy, err := parseEconomyPolicy(req.Policy)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if !saveEconomyPolicy(policy) {
+			c.JSON(500, gin.H{"error": "Failed to save economy policy"})
+			return
+		}
+		setEconomyPolicy(policy)
+	}
+
+	economyPolicyMutex.RLock()
+	policy, lastRun := economyPolicy, lastDecayRun
+	economyPolicyMutex.RUnlock()
+	c.JSON(200, gin.H{"policy": policy, "last_decay_run": lastRun})
+}
+
+// simulateEconomyPolicyAdmin dry runs the running policy, or the changes to it in the body
+func simulateEconomyPolicyAdmin(c *gin.Context) {
+	if !authenticateAdmin(c) {
+		return
+	}
+
+	var req struct {
+		Policy json.RawMessage `json:"policy"`
+	}
+	_ = c.ShouldBindJSON(&req)
+
+	policy := getEconomyPolicy()
+	if len(req.Policy) > 0 {
+		var err error
+		if policy, err = parseEconomyPolicy(req.Policy); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	sim, err := simulateEconomyPolicy(policy, time.Now())
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to read ledger"})
+		return
+	}
+	c.JSON(200, sim)
+}
+
+// runEconomyPolicyAdmin runs the inactivity decay now instead of waiting for its schedule
+func runEconomyPolicyAdmin(c *gin.Context) {
+	if !authenticateAdmin(c) {
+		return
+	}
+
+	decay := getEconomyPolicy().Sinks.InactivityDecay
+	if !decay.Enabled {
+		c.JSON(400, gin.H{"error": "Inactivity decay is not enabled"})
+		return
+	}
+
+	accounts, total := applyInactivityDecay(decay, time.Now())
+	if accounts > 0 {
+		go saveUsers()
+	}
+	c.JSON(200, gin.H{"accounts": accounts, "credits": total})
+}
+
+// getPolicyMovementsAdmin lists credits moved by the policy, newest first
+func getPolicyMovementsAdmin(c *gin.Context) {
+	if !authenticateAdmin(c) {
+		return
+	}
+
+	var req struct {
+		Policy string `json:"policy"`
+		Since  int64  `json:"since"`
+		Limit  int    `json:"limit"`
+	}
+	_ = c.ShouldBindJSON(&req)
+	if req.Policy != "" {
+		if _, ok := policyKinds[req.Policy]; !ok {
+			c.JSON(400, gin.H{"error": "Unknown policy"})
+			return
+		}
+	}
+	if req.Limit <= 0 || req.Limit > 1000 {
+		req.Limit = 100
+	}
+
+	movements, err := readPolicyMovements(req.Policy, req.Since, req.Limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to read policy ledger"})
+		return
+	}
+	c.JSON(200, gin.H{"movements": movements, "count": len(movements)})
+}
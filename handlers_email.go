@@ -0,0 +1,151 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// emailRateLimited applies the "email" limit per account on top of the per-ip route limit
+func emailRateLimited(c *gin.Context, user User) bool {
+	isAllowed, remaining, resetTime := applyRateLimit("email:"+string(user.GetId()), "email")
+	if isAllowed {
+		return false
+	}
+	c.Header("X-RateLimit-Limit", strconv.Itoa(rateLimits["email"].Count))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	c.Header("X-RateLimit-Reset", strconv.FormatFloat(resetTime, 'f', 0, 64))
+	c.JSON(429, gin.H{"error": "Too many emails requested, try again later", "reset_time": resetTime})
+	return true
+}
+
+func getEmailStatus(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	c.JSON(200, gin.H{
+		"email":       user.GetEmail(),
+		"verified":    user.IsEmailVerified(),
+		"verified_at": user.Get("sys.email_verified_at"),
+	})
+}
+
+func requestEmailVerification(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	if user.GetEmail() == "" {
+		c.JSON(400, gin.H{"error": "No email address on this account"})
+		return
+	}
+	if user.IsEmailVerified() {
+		c.JSON(400, gin.H{"error": "Email is already verified"})
+		return
+	}
+	if emailRateLimited(c, *user) {
+		return
+	}
+
+	if err := sendVerificationEmail(*user); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", user.GetUsername(), err)
+		c.JSON(500, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Verification email sent"})
+}
+
+func confirmEmailVerification(c *gin.Context) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(400, gin.H{"error": "token is required"})
+		return
+	}
+
+	user, err := verifyEmailToken(req.Token, EmailTokenVerify)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !user.IsEmailVerified() {
+		user.Set("sys.email_verified", true)
+		user.Set("sys.email_verified_at", time.Now().UnixMilli())
+		go saveUsers()
+	}
+	c.JSON(200, gin.H{"message": "Email verified", "username": user.GetUsername()})
+}
+
+// forgotPassword always answers the same way so it can't be used to find accounts
+func forgotPassword(c *gin.Context) {
+	var req struct {
+		Email    string `json:"email"`
+		Username string `json:"username"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if strings.TrimSpace(req.Email) == "" && strings.TrimSpace(req.Username) == "" {
+		c.JSON(400, gin.H{"error": "email or username is required"})
+		return
+	}
+
+	var user User
+	if req.Email != "" {
+		user = findAccountByEmail(req.Email)
+	} else if u, err := getAccountByUsername(strings.TrimSpace(req.Username)); err == nil {
+		user = u
+	}
+
+	if user != nil && user.GetEmail() != "" && !user.IsBanned() {
+		isAllowed, _, _ := applyRateLimit("email:"+string(user.GetId()), "email")
+		if isAllowed {
+			go func(u User) {
+				if err := sendPasswordResetEmail(u); err != nil {
+					log.Printf("Failed to send password reset email to %s: %v", u.GetUsername(), err)
+				}
+			}(user)
+		}
+	}
+
+	c.JSON(200, gin.H{"message": "If an account with that address exists, a reset link has been sent"})
+}
+
+func resetPassword(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(400, gin.H{"error": "token and password are required"})
+		return
+	}
+	if ok, msg := ValidatePasswordHash(req.Password); !ok {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+
+	user, err := redeemPasswordReset(req.Token, req.Password)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	go saveUsers()
+
+	// the key was rotated with the password, sessions and sub-tokens go with it
+	username := string(user.GetUsername())
+	revoked, err := revokeSessions(username, func(s *Session) bool { return true })
+	if err != nil {
+		log.Printf("Failed to revoke sessions after password reset for %s: %v", username, err)
+	}
+	tokensRevoked, err := revokeAllSubTokens(strings.ToLower(username))
+	if err != nil {
+		log.Printf("Failed to revoke sub-tokens after password reset for %s: %v", username, err)
+	}
+	logSecurityEvent(c, user, SecPasswordChanged, map[string]any{"by": "reset", "sessions_revoked": revoked, "tokens_revoked": tokensRevoked})
+
+	c.JSON(200, gin.H{"message": "Password reset, sign in with your new password"})
+}
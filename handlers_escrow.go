@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func escrowErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errEscrowNotSeller):
+		return 403
+	case errors.Is(err, errEscrowClosed), errors.Is(err, errEscrowDisputed):
+		return 409
+	}
+	return 400
+}
+
+// notifyEscrowParties sends an escrow event to both sides except the one acting
+func notifyEscrowParties(e EscrowContract, eventType string, actor UserId) {
+	for _, party := range []UserId{e.BuyerId, e.SellerId} {
+		if party == actor {
+			continue
+		}
+		addUserEvent(party, eventType, map[string]any{
+			"id":     e.Id,
+			"status": e.Status,
+			"amount": e.Amount,
+		})
+	}
+}
+
+func createEscrow(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	var req struct {
+		Seller  string `json:"seller"`
+		Amount  any    `json:"amount"`
+		Terms   string `json:"terms"`
+		Item    string `json:"item"`
+		Timeout int64  `json:"timeout"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request payload"})
+		return
+	}
+
+	nAmount, err := parseTransferAmount(req.Amount)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if nAmount < 0.01 {
+		c.JSON(400, gin.H{"error": "Minimum amount is 0.01"})
+		return
+	}
+
+	if req.Seller == "" {
+		c.JSON(400, gin.H{"error": "Seller username must be provided"})
+		return
+	}
+	seller, err := getAccountByUsername(Username(req.Seller))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Seller not found"})
+		return
+	}
+	if seller.GetId() == user.GetId() {
+		c.JSON(400, gin.H{"error": "Cannot open an escrow with yourself"})
+		return
+	}
+
+	timeout := EscrowDefaultTimeout
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Second
+	}
+	if timeout > EscrowMaxTimeout {
+		c.JSON(400, gin.H{"error": "Escrow timeout can be at most 90 days"})
+		return
+	}
+
+	itemName := strings.ToLower(strings.TrimSpace(req.Item))
+	if itemName != "" {
+		itemsMutex.Lock()
+		owned := false
+		for _, item := range items {
+			if strings.ToLower(item.Name) == itemName {
+				owned = item.Owner == seller.GetId()
+				break
+			}
+		}
+		itemsMutex.Unlock()
+		if !owned {
+			c.JSON(400, gin.H{"error": "The seller does not own that item"})
+			return
+		}
+	}
+
+	now := time.Now()
+	contract := EscrowContract{
+		Id:        uuid.New().String(),
+		BuyerId:   user.GetId(),
+		SellerId:  seller.GetId(),
+		Amount:    nAmount,
+		Terms:     trimAndCapNote(req.Terms, 200),
+		ItemName:  itemName,
+		Status:    EscrowFunded,
+		CreatedAt: now.UnixMilli(),
+		ExpiresAt: now.Add(timeout).UnixMilli(),
+		History:   []EscrowEvent{},
+	}
+
+	escrowContractsMutex.Lock()
+	if countOpenEscrows(user.GetId()) >= MaxOpenEscrowsPerUser {
+		escrowContractsMutex.Unlock()
+		c.JSON(400, gin.H{"error": "Too many open escrow contracts"})
+		return
+	}
+	contract, err = openEscrowLocked(*user, contract)
+	escrowContractsMutex.Unlock()
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	go saveUsers()
+
+	notifyEscrowParties(contract, "escrow_created", user.GetId())
+	c.JSON(201, contract)
+}
+
+func getMyEscrows(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	userId := user.GetId()
+	status := c.Query("status")
+
+	escrowContractsMutex.Lock()
+	contracts := make([]EscrowContract, 0)
+	for _, e := range escrowContracts {
+		if e.IsParty(userId) && (status == "" || e.Status == status) {
+			contracts = append(contracts, e)
+		}
+	}
+	escrowContractsMutex.Unlock()
+
+	sort.Slice(contracts, func(i, j int) bool {
+		return contracts[i].CreatedAt > contracts[j].CreatedAt
+	})
+
+	c.JSON(200, gin.H{
+		"escrows": contracts,
+		"count":   len(contracts),
+	})
+}
+
+func getEscrow(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	escrowContractsMutex.Lock()
+	defer escrowContractsMutex.Unlock()
+	e := findEscrowLocked(c.Param("id"))
+	if e == nil || !e.IsParty(user.GetId()) {
+		c.JSON(404, gin.H{"error": "Escrow contract not found"})
+		return
+	}
+	c.JSON(200, e)
+}
+
+// updateEscrow runs fn on a contract the user is a party to, then saves it and
+// tells the other side about the new state
+func updateEscrow(c *gin.Context, eventType string, fn func(e *EscrowContract, userId UserId) error) {
+	user := c.MustGet("user").(*User)
+	userId := user.GetId()
+
+	escrowContractsMutex.Lock()
+	e := findEscrowLocked(c.Param("id"))
+	if e == nil || !e.IsParty(userId) {
+		escrowContractsMutex.Unlock()
+		c.JSON(404, gin.H{"error": "Escrow contract not found"})
+		return
+	}
+	if err := fn(e, userId); err != nil {
+		escrowContractsMutex.Unlock()
+		c.JSON(escrowErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	contract := *e
+	saveEscrowContractsLocked()
+	escrowContractsMutex.Unlock()
+
+	if !contract.IsOpen() {
+		go saveUsers()
+		eventType = "escrow_settled"
+	}
+	notifyEscrowParties(contract, eventType, userId)
+	c.JSON(200, contract)
+}
+
+func confirmEscrow(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	// the buyer's confirmation releases the stored amount to the seller
+	release, err := reserveTokenTransfer(c, escrowBuyerAmount(c.Param("id"), user.GetId()))
+	if err != nil {
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	}
+	updateEscrow(c, "escrow_confirmed", func(e *EscrowContract, userId UserId) error {
+		_, err := confirmEscrowLocked(e, userId)
+		return err
+	})
+	release(c.Writer.Status() < 300)
+}
+
+// refundEscrow lets the seller hand the credits back, which also ends a dispute
+func refundEscrow(c *gin.Context) {
+	updateEscrow(c, "escrow_settled", func(e *EscrowContract, userId UserId) error {
+		if userId != e.SellerId {
+			return errEscrowNotSeller
+		}
+		return settleEscrowLocked(e, false, userId, "escrow refunded by seller")
+	})
+}
+
+func disputeEscrow(c *gin.Context) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		c.JSON(400, gin.H{"error": "A reason for the dispute is required"})
+		return
+	}
+	updateEscrow(c, "escrow_disputed", func(e *EscrowContract, userId UserId) error {
+		return disputeEscrowLocked(e, userId, trimAndCapNote(req.Reason, 500))
+	})
+}
+
+func getEscrowDisputesAdmin(c *gin.Context) {
+	if !authenticateAdmin(c) {
+		return
+	}
+
+	escrowContractsMutex.Lock()
+	disputed := make([]EscrowContract, 0)
+	for _, e := range escrowContracts {
+		if e.Status == EscrowDisputed {
+			disputed = append(disputed, e)
+		}
+	}
+	escrowContractsMutex.Unlock()
+
+	c.JSON(200, gin.H{"escrows": disputed, "count": len(disputed)})
+}
+
+// resolveEscrowAdmin settles an open contract as the arbitrator
+func resolveEscrowAdmin(c *gin.Context) {
+	if !authenticateAdmin(c) {
+		return
+	}
+
+	var req struct {
+		Id      string `json:"id"`
+		Outcome string `json:"outcome"`
+		Note    string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Id == "" {
+		c.JSON(400, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	if req.Outcome != "release" && req.Outcome != "refund" {
+		c.JSON(400, gin.H{"error": "outcome must be release or refund"})
+		return
+	}
+	note := strings.TrimSpace(req.Note)
+	if note == "" {
+		note = "escrow " + req.Outcome + " by arbitrator"
+	}
+
+	escrowContractsMutex.Lock()
+	e := findEscrowLocked(req.Id)
+	if e == nil {
+		escrowContractsMutex.Unlock()
+		c.JSON(404, gin.H{"error": "Escrow contract not found"})
+		return
+	}
+	if err := settleEscrowLocked(e, req.Outcome == "release", "", trimAndCapNote(note, 200)); err != nil {
+		escrowContractsMutex.Unlock()
+		c.JSON(escrowErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	contract := *e
+	saveEscrowContractsLocked()
+	escrowContractsMutex.Unlock()
+	go saveUsers()
+
+	notifyEscrowParties(contract, "escrow_settled", "")
+	c.JSON(200, contract)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getHeldTransfersAdmin lists the transfers waiting for review along with the rule config
+func getHeldTransfersAdmin(c *gin.Context) {
+	if !authenticateAdmin(c) {
+		return
+	}
+
+	heldTransfersMutex.Lock()
+	pending := make([]HeldTransfer, 0)
+	for _, h := range heldTransfers {
+		if h.Status == HeldTransferPending {
+			pending = append(pending, h)
+		}
+	}
+	heldTransfersMutex.Unlock()
+
+	c.JSON(200, gin.H{"held": pending, "count": len(pending), "rules": fraudConfig})
+}
+
+// resolveHeldTransferAdmin approves or rejects a held transfer
+func resolveHeldTransferAdmin(c *gin.Context) {
+	if !authenticateAdmin(c) {
+		return
+	}
+
+	var req struct {
+		Id      string `json:"id"`
+		Outcome string `json:"outcome"`
+		Note    string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Id == "" {
+		c.JSON(400, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	if req.Outcome != "approve" && req.Outcome != "reject" {
+		c.JSON(400, gin.H{"error": "outcome must be approve or reject"})
+		return
+	}
+
+	held, err := resolveHeldTransfer(req.Id, req.Outcome == "approve", trimAndCapNote(strings.TrimSpace(req.Note), 200))
+	switch {
+	case errors.Is(err, errHeldTransferNotFound):
+		c.JSON(404, gin.H{"error": "Held transfer not found"})
+		return
+	case errors.Is(err, errHeldTransferDone):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	go saveUsers()
+
+	c.JSON(200, held)
+}
@@ -1,25 +1,74 @@
 package main
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 const (
-	GiftTaxPercent      = 0.01
 	GiftMaxExpiryDays   = 90
 	GiftMaxExpiryHours  = GiftMaxExpiryDays * 24
 	GiftMaxExpiryMillis = int64(GiftMaxExpiryHours) * 60 * 60 * 1000
+	GiftMaxClaims       = 1000
 )
 
+// validateGiftRestrictions checks the restrictions a creator asked for
+func validateGiftRestrictions(r *GiftRestrictions) error {
+	if r.MinAccountAgeDays < 0 || r.MinAccountAgeDays > 3650 {
+		return fmt.Errorf("min_account_age_days must be between 0 and 3650")
+	}
+	if r.MinStanding != "" && r.MinStanding != StandingGood && r.MinStanding != StandingWarning {
+		return fmt.Errorf("min_standing must be good or warning")
+	}
+	if r.GroupTag != "" {
+		if _, ok := getGroupByTag(r.GroupTag); !ok {
+			return fmt.Errorf("group not found")
+		}
+	}
+	return nil
+}
+
+// checkGiftRestrictions reports why the user can't claim the gift, if they can't
+func checkGiftRestrictions(g *Gift, user User) error {
+	r := g.Restrictions
+	if r == nil {
+		return nil
+	}
+	if r.MinAccountAgeDays > 0 {
+		minAge := int64(r.MinAccountAgeDays) * 24 * 60 * 60 * 1000
+		if time.Now().UnixMilli()-user.GetCreated() < minAge {
+			return fmt.Errorf("Your account must be at least %d days old to claim this gift", r.MinAccountAgeDays)
+		}
+	}
+	if r.MinStanding != "" && !user.HasStandingOrHigher(r.MinStanding) {
+		return fmt.Errorf("Your account standing does not allow claiming this gift")
+	}
+	if r.GroupTag != "" {
+		member := false
+		for _, m := range getGroupMembers(r.GroupTag) {
+			if m.UserId == user.GetId() {
+				member = true
+				break
+			}
+		}
+		if !member {
+			return fmt.Errorf("Only members of %s can claim this gift", r.GroupTag)
+		}
+	}
+	return nil
+}
+
 func createGift(c *gin.Context) {
 	user := c.MustGet("user").(*User)
 
 	var req struct {
-		Amount       float64 `json:"amount"`
-		Note         string  `json:"note"`
-		ExpiresInHrs int     `json:"expires_in_hrs"`
+		Amount       float64           `json:"amount"`
+		Note         string            `json:"note"`
+		ExpiresInHrs int               `json:"expires_in_hrs"`
+		MaxClaims    int               `json:"max_claims"`
+		Restrictions *GiftRestrictions `json:"restrictions"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": "Invalid request payload"})
@@ -37,8 +86,24 @@ func createGift(c *gin.Context) {
 		return
 	}
 
-	taxAmount := roundVal(nAmount * GiftTaxPercent)
-	totalDeduction := roundVal(nAmount + taxAmount)
+	if req.MaxClaims < 0 || req.MaxClaims > GiftMaxClaims {
+		c.JSON(400, gin.H{"error": "max_claims must be between 1 and 1000"})
+		return
+	}
+	if req.Restrictions != nil {
+		if err := validateGiftRestrictions(req.Restrictions); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// campaigns hold enough for every claim up front
+	escrowAmount := nAmount
+	if req.MaxClaims > 1 {
+		escrowAmount = roundVal(nAmount * float64(req.MaxClaims))
+	}
+	taxAmount := roundVal(escrowAmount * getEconomyPolicy().Sinks.GiftTaxPercent / 100)
+	totalDeduction := roundVal(escrowAmount + taxAmount)
 
 	userCredits := user.GetCredits()
 	if userCredits < totalDeduction {
@@ -63,17 +128,32 @@ func createGift(c *gin.Context) {
 	now := time.Now().UnixMilli()
 
 	gift := Gift{
-		Id:        giftId,
-		Code:      giftCode,
-		Amount:    nAmount,
-		Note:      note,
-		CreatorId: user.GetId(),
-		CreatedAt: now,
-		ExpiresAt: expiresAt,
+		Id:           giftId,
+		Code:         giftCode,
+		Amount:       nAmount,
+		Note:         note,
+		CreatorId:    user.GetId(),
+		CreatedAt:    now,
+		ExpiresAt:    expiresAt,
+		Restrictions: req.Restrictions,
+	}
+	if req.MaxClaims > 1 {
+		gift.MaxClaims = req.MaxClaims
 	}
 
-	newBal := roundVal(userCredits - totalDeduction)
-	user.SetBalance(newBal)
+	entry, err := postLedger("gift_create", giftId,
+		userPosting(*user, -totalDeduction),
+		accountPosting(LedgerGiftEscrow, escrowAmount),
+		accountPosting(LedgerTax, taxAmount),
+	)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if taxAmount > 0 {
+		recordPolicyMovement(PolicyGiftTax, entry, user.GetId(), taxAmount)
+	}
+	newBal := user.GetCredits()
 
 	user.addTransaction(Transaction{
 		Note:      note,
@@ -98,6 +178,7 @@ func createGift(c *gin.Context) {
 		"id":         giftId,
 		"code":       giftCode,
 		"amount":     nAmount,
+		"max_claims": gift.MaxClaims,
 		"tax":        taxAmount,
 		"total_paid": totalDeduction,
 		"expires_at": expiresAt,
@@ -168,6 +249,11 @@ func claimGift(c *gin.Context) {
 		return
 	}
 
+	if gift.HasClaimed(user.GetId()) {
+		c.JSON(400, gin.H{"error": "You have already claimed this gift"})
+		return
+	}
+
 	if !gift.CanBeClaimed() {
 		if gift.ClaimedAt != nil {
 			c.JSON(400, gin.H{"error": "This gift has already been claimed"})
@@ -183,9 +269,26 @@ func claimGift(c *gin.Context) {
 		}
 	}
 
-	userCredits := user.GetCredits()
-	newBal := roundVal(userCredits + gift.Amount)
-	user.SetBalance(newBal)
+	if err := checkGiftRestrictions(gift, *user); err != nil {
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	}
+
+	// gift claims can't wait in the review queue, so a hold turns them away as well
+	claimers := make([]UserId, len(gift.Claims))
+	for i, claim := range gift.Claims {
+		claimers[i] = claim.UserId
+	}
+	if action, _ := evaluateFraudRules(FraudCheck{Kind: FraudGiftClaim, From: getUserById(gift.CreatorId), To: *user, Amount: gift.Amount, PriorClaimers: claimers}); action != FraudAllow {
+		c.JSON(403, gin.H{"error": "Gift claim blocked by fraud checks"})
+		return
+	}
+
+	if _, err := postLedger("gift_claim", gift.Id, accountPosting(LedgerGiftEscrow, -gift.Amount), userPosting(*user, gift.Amount)); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	newBal := user.GetCredits()
 
 	now := time.Now().UnixMilli()
 	claimedBy := user.GetId()
@@ -215,8 +318,15 @@ func claimGift(c *gin.Context) {
 		})
 	}
 
-	gift.ClaimedAt = &now
-	gift.ClaimedBy = &claimedBy
+	if gift.IsCampaign() {
+		gift.Claims = append(gift.Claims, GiftClaim{UserId: claimedBy, ClaimedAt: now})
+		if len(gift.Claims) >= gift.MaxClaims {
+			gift.ClaimedAt = &now
+		}
+	} else {
+		gift.ClaimedAt = &now
+		gift.ClaimedBy = &claimedBy
+	}
 
 	go saveGifts()
 	go saveUsers()
@@ -275,31 +385,19 @@ func cancelGift(c *gin.Context) {
 		}
 	}
 
-	userCredits := user.GetCredits()
-	newBal := roundVal(userCredits + gift.Amount)
-	user.SetBalance(newBal)
-
-	now := time.Now().UnixMilli()
-
-	user.addTransaction(Transaction{
-		Note:      "Gift cancelled: " + gift.Code,
-		User:      UserId(""),
-		Amount:    gift.Amount,
-		Type:      "gift_refund",
-		Timestamp: now,
-		NewTotal:  newBal,
-		GiftId:    gift.Id,
-		GiftCode:  gift.Code,
-	})
-
-	gift.CancelledAt = &now
+	refunded, err := refundGiftLocked(gift, "Gift cancelled: "+gift.Code, time.Now().UnixMilli())
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	newBal := user.GetCredits()
 
 	go saveGifts()
 	go saveUsers()
 
 	c.JSON(200, gin.H{
 		"message":     "Gift cancelled successfully",
-		"refunded":    gift.Amount,
+		"refunded":    refunded,
 		"new_balance": newBal,
 	})
 }
@@ -131,6 +131,8 @@ func createGroup(c *gin.Context) {
 		"groups.events.manage",
 		"groups.events.publish",
 		"groups.tips.manage",
+		"groups.treasury.spend",
+		"groups.products.manage",
 		"groups.group.edit",
 	}
 
@@ -280,6 +280,45 @@ func getEvents(c *gin.Context) {
 	c.JSON(200, results)
 }
 
+// canTipGroup reports whether the user may send credits to the group
+func canTipGroup(user User, groupTag string, group *Group) bool {
+	if group.Public {
+		return true
+	}
+	for _, member := range getGroupMembers(groupTag) {
+		if member.UserId == user.GetId() {
+			return true
+		}
+	}
+	return false
+}
+
+// PerformGroupTip moves credits from the user into the group's balance
+func PerformGroupTip(user User, groupTag string, amount float64) (GroupTip, error) {
+	nAmount := roundVal(amount)
+	if _, err := postLedger("group_tip", groupTag, userPosting(user, -nAmount), accountPosting(groupLedgerAccount(groupTag), nAmount)); err != nil {
+		return GroupTip{}, err
+	}
+	user.addTransaction(Transaction{
+		Note:      "Tip to group " + groupTag,
+		User:      UserId(""),
+		Amount:    nAmount,
+		Type:      "group_tip",
+		Timestamp: time.Now().UnixMilli(),
+		NewTotal:  user.GetCredits(),
+	})
+
+	tip := GroupTip{
+		Id:            uuid.New().String(),
+		GroupTag:      groupTag,
+		FromUserId:    user.GetId(),
+		AmountCredits: nAmount,
+		CreatedAt:     time.Now().Unix(),
+	}
+	addGroupTip(groupTag, tip)
+	return tip, nil
+}
+
 func sendTip(c *gin.Context) {
 	user := c.MustGet("user").(*User)
 
@@ -302,16 +341,7 @@ func sendTip(c *gin.Context) {
 		return
 	}
 
-	members := getGroupMembers(groupTag)
-	isMember := false
-	for _, member := range members {
-		if member.UserId == user.GetId() {
-			isMember = true
-			break
-		}
-	}
-
-	if !isMember && !group.Public {
+	if !canTipGroup(*user, groupTag, group) {
 		c.JSON(403, gin.H{"error": "You can only tip groups you're a member of"})
 		return
 	}
@@ -323,24 +353,11 @@ func sendTip(c *gin.Context) {
 		return
 	}
 
-	user.SetBalance(roundVal(userCredits - nAmount))
-	user.addTransaction(Transaction{
-		Note:      "Tip to group " + groupTag,
-		User:      UserId(""),
-		Amount:    nAmount,
-		Type:      "group_tip",
-		Timestamp: time.Now().UnixMilli(),
-		NewTotal:  roundVal(userCredits - nAmount),
-	})
-
-	tip := GroupTip{
-		Id:            uuid.New().String(),
-		GroupTag:      groupTag,
-		FromUserId:    user.GetId(),
-		AmountCredits: nAmount,
-		CreatedAt:     time.Now().Unix(),
+	tip, err := PerformGroupTip(*user, groupTag, nAmount)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
 	}
-	addGroupTip(groupTag, tip)
 	go saveUsers()
 
 	c.JSON(201, tip)
@@ -716,6 +733,14 @@ func getUserBenefits(c *gin.Context) {
 		}
 	}
 
+	groupsDataMutex.RLock()
+	if data := groupsData[groupTag]; data != nil {
+		for _, benefit := range activeBenefitsLocked(data, member.UserId) {
+			benefitsMap[benefit] = true
+		}
+	}
+	groupsDataMutex.RUnlock()
+
 	benefits := make([]string, 0, len(benefitsMap))
 	for benefit := range benefitsMap {
 		benefits = append(benefits, benefit)
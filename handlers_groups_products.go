@@ -0,0 +1,556 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	BenefitPurchaseActive    = "active"
+	BenefitPurchaseCancelled = "cancelled" // ran out after the member cancelled
+	BenefitPurchaseLapsed    = "lapsed"    // a renewal couldn't be paid
+	BenefitPurchaseEnded     = "ended"     // the product was removed
+
+	MaxBenefitBillingPeriodDays = 365
+)
+
+var (
+	errProductNotFound      = errors.New("product not found")
+	errProductOwned         = errors.New("you already have this product")
+	errProductRoleMissing   = errors.New("the role this product grants no longer exists")
+	errNotGroupMember       = errors.New("you must be a member of this group")
+	errPurchaseNotFound     = errors.New("purchase not found")
+	errPurchaseNotRecurring = errors.New("only recurring purchases can be cancelled")
+	errProductRoleAssign    = errors.New("you don't have permission to assign roles")
+	errProductRoleTooStrong = errors.New("you can't sell a role with permissions you don't have")
+)
+
+func productErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errProductNotFound), errors.Is(err, errPurchaseNotFound):
+		return 404
+	case errors.Is(err, errProductOwned):
+		return 409
+	case errors.Is(err, errNotGroupMember), errors.Is(err, errProductRoleAssign), errors.Is(err, errProductRoleTooStrong):
+		return 403
+	}
+	return 400
+}
+
+func (p GroupBenefitPurchase) isActive() bool {
+	return p.Status == BenefitPurchaseActive
+}
+
+// validateBenefitProductLocked checks a product against the group's roles. The
+// owner role can't be sold since it carries every permission.
+func validateBenefitProductLocked(data *GroupData, p GroupBenefitProduct) error {
+	switch {
+	case p.Name == "" || len(p.Name) > 50:
+		return errors.New("name must be between 1 and 50 characters")
+	case len(p.Description) > 200:
+		return errors.New("description must be at most 200 characters")
+	case p.PriceCredits < 0:
+		return errors.New("price cannot be negative")
+	case p.BillingPeriodDays < 0 || p.BillingPeriodDays > MaxBenefitBillingPeriodDays:
+		return errors.New("billing_period_days must be between 0 and 365")
+	case p.RoleGrantedId == "" && p.BenefitGranted == "":
+		return errors.New("a product must grant a role or a benefit")
+	case len(p.BenefitGranted) > 50:
+		return errors.New("benefit must be at most 50 characters")
+	}
+	if p.RoleGrantedId != "" {
+		for _, role := range data.Roles {
+			if role.Id == p.RoleGrantedId {
+				if role.Name == "Owner" {
+					return errors.New("the owner role cannot be sold")
+				}
+				return nil
+			}
+		}
+		return errors.New("role not found")
+	}
+	return nil
+}
+
+// memberHasPermissionLocked is hasPermission for callers already holding groupsDataMutex
+func memberHasPermissionLocked(data *GroupData, userId UserId, permission string) bool {
+	roleIds := memberRoleIdsLocked(data, userId)
+	for _, role := range data.Roles {
+		if !slices.Contains(roleIds, role.Id) {
+			continue
+		}
+		if role.Name == "Owner" || slices.Contains(role.Permissions, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkProductRoleGrantLocked stops a product manager from selling a role
+// they couldn't assign themselves, or one stronger than their own
+func checkProductRoleGrantLocked(data *GroupData, managerId UserId, roleId string) error {
+	if roleId == "" {
+		return nil
+	}
+	if !memberHasPermissionLocked(data, managerId, "groups.roles.assign") {
+		return errProductRoleAssign
+	}
+	for _, role := range data.Roles {
+		if role.Id != roleId {
+			continue
+		}
+		for _, perm := range role.Permissions {
+			if !memberHasPermissionLocked(data, managerId, perm) {
+				return errProductRoleTooStrong
+			}
+		}
+	}
+	return nil
+}
+
+func findMemberLocked(data *GroupData, userId UserId) *GroupMember {
+	for i := range data.Members {
+		if data.Members[i].UserId == userId {
+			return &data.Members[i]
+		}
+	}
+	return nil
+}
+
+// chargeBenefitLocked moves a product's price from the buyer into the group treasury
+func chargeBenefitLocked(data *GroupData, buyer User, name string, price float64, purchaseId string) error {
+	if price <= 0 {
+		return nil
+	}
+	tag := data.Group.Tag
+	if _, err := postLedger("group_benefit_purchase", purchaseId, userPosting(buyer, -price), accountPosting(groupLedgerAccount(tag), price)); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	data.Group.CreditsBalance = roundVal(data.Group.CreditsBalance + price)
+	data.TreasuryHistory = append(data.TreasuryHistory, GroupTreasuryEntry{
+		Id:            uuid.New().String(),
+		Type:          "benefit_sale",
+		Counterparty:  string(buyer.GetId()),
+		AmountCredits: price,
+		BalanceAfter:  data.Group.CreditsBalance,
+		CreatedAt:     now,
+	})
+	buyer.addTransaction(Transaction{
+		Note:     name + " from group " + tag,
+		User:     UserId(""),
+		Amount:   price,
+		Type:     "group_purchase",
+		NewTotal: buyer.GetCredits(),
+	})
+	return nil
+}
+
+// purchaseBenefitLocked charges the buyer and grants the product's role and benefit
+func purchaseBenefitLocked(data *GroupData, buyer User, productId string, now time.Time) (GroupBenefitPurchase, error) {
+	product, ok := data.BenefitProducts[productId]
+	if !ok {
+		return GroupBenefitPurchase{}, errProductNotFound
+	}
+	buyerId := buyer.GetId()
+	member := findMemberLocked(data, buyerId)
+	if member == nil {
+		return GroupBenefitPurchase{}, errNotGroupMember
+	}
+	for _, p := range data.BenefitPurchases {
+		if p.UserId == buyerId && p.ProductId == productId && p.isActive() {
+			return GroupBenefitPurchase{}, errProductOwned
+		}
+	}
+	if product.RoleGrantedId != "" && validateBenefitProductLocked(data, product) != nil {
+		return GroupBenefitPurchase{}, errProductRoleMissing
+	}
+
+	purchase := GroupBenefitPurchase{
+		Id:             uuid.New().String(),
+		ProductId:      productId,
+		UserId:         buyerId,
+		PriceCredits:   product.PriceCredits,
+		RoleGrantedId:  product.RoleGrantedId,
+		BenefitGranted: product.BenefitGranted,
+		Status:         BenefitPurchaseActive,
+		PurchasedAt:    now.Unix(),
+	}
+	if err := chargeBenefitLocked(data, buyer, product.Name, product.PriceCredits, purchase.Id); err != nil {
+		return GroupBenefitPurchase{}, err
+	}
+
+	if product.RoleGrantedId != "" && !containsString(member.RoleIds, product.RoleGrantedId) {
+		member.RoleIds = append(member.RoleIds, product.RoleGrantedId)
+		purchase.RoleAdded = true
+	}
+	if product.BillingPeriodDays > 0 {
+		next := now.AddDate(0, 0, product.BillingPeriodDays).Unix()
+		purchase.NextBillingAt = &next
+	}
+	data.BenefitPurchases = append(data.BenefitPurchases, purchase)
+	return purchase, nil
+}
+
+// endBenefitPurchaseLocked stops a purchase and takes back a role it gave, unless
+// another active purchase still grants it
+func endBenefitPurchaseLocked(data *GroupData, p *GroupBenefitPurchase, status string, now time.Time) {
+	ended := now.Unix()
+	p.Status = status
+	p.EndedAt = &ended
+	p.NextBillingAt = nil
+	if !p.RoleAdded {
+		return
+	}
+	for _, other := range data.BenefitPurchases {
+		if other.Id != p.Id && other.UserId == p.UserId && other.RoleGrantedId == p.RoleGrantedId && other.isActive() && other.RoleAdded {
+			return
+		}
+	}
+	if member := findMemberLocked(data, p.UserId); member != nil {
+		roles := make([]string, 0, len(member.RoleIds))
+		for _, id := range member.RoleIds {
+			if id != p.RoleGrantedId {
+				roles = append(roles, id)
+			}
+		}
+		member.RoleIds = roles
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// renewBenefitPurchasesLocked charges every recurring purchase that is due and
+// ends the ones that were cancelled, removed or can't be paid. Reports whether
+// anything changed and whether any user was charged.
+func renewBenefitPurchasesLocked(data *GroupData, now time.Time) (changed bool, charged bool) {
+	for i := range data.BenefitPurchases {
+		p := &data.BenefitPurchases[i]
+		if !p.isActive() || p.NextBillingAt == nil || *p.NextBillingAt > now.Unix() {
+			continue
+		}
+		changed = true
+
+		product, ok := data.BenefitProducts[p.ProductId]
+		if !ok {
+			endBenefitPurchaseLocked(data, p, BenefitPurchaseEnded, now)
+			continue
+		}
+		if p.CancelAtPeriodEnd {
+			endBenefitPurchaseLocked(data, p, BenefitPurchaseCancelled, now)
+			continue
+		}
+		buyer := getUserById(p.UserId)
+		if len(buyer) == 0 || findMemberLocked(data, p.UserId) == nil {
+			endBenefitPurchaseLocked(data, p, BenefitPurchaseEnded, now)
+			continue
+		}
+		if err := chargeBenefitLocked(data, buyer, product.Name, p.PriceCredits, p.Id); err != nil {
+			endBenefitPurchaseLocked(data, p, BenefitPurchaseLapsed, now)
+			addUserEvent(p.UserId, "group_benefit_lapsed", map[string]any{
+				"group":   data.Group.Tag,
+				"product": product.Name,
+				"error":   err.Error(),
+			})
+			continue
+		}
+		charged = charged || p.PriceCredits > 0
+		next := time.Unix(*p.NextBillingAt, 0).AddDate(0, 0, product.BillingPeriodDays).Unix()
+		p.NextBillingAt = &next
+	}
+	return changed, charged
+}
+
+func runGroupBenefitBilling() {
+	ticker := time.NewTicker(time.Duration(SUBSCRIPTION_CHECK_INTERVAL) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		dirty := make([]string, 0)
+		usersCharged := false
+		groupsDataMutex.Lock()
+		for tag, data := range groupsData {
+			changed, charged := renewBenefitPurchasesLocked(data, time.Now())
+			if changed {
+				dirty = append(dirty, tag)
+			}
+			usersCharged = usersCharged || charged
+		}
+		groupsDataMutex.Unlock()
+
+		for _, tag := range dirty {
+			saveGroupData(tag)
+		}
+		if usersCharged {
+			go saveUsers()
+		}
+		if len(dirty) > 0 {
+			log.Printf("Renewed group benefits in %d groups", len(dirty))
+		}
+	}
+}
+
+// activeBenefitsLocked lists the benefits a member holds through purchases
+func activeBenefitsLocked(data *GroupData, userId UserId) []string {
+	benefits := make([]string, 0)
+	for _, p := range data.BenefitPurchases {
+		if p.UserId == userId && p.isActive() && p.BenefitGranted != "" {
+			benefits = append(benefits, p.BenefitGranted)
+		}
+	}
+	return benefits
+}
+
+func getGroupProducts(c *gin.Context) {
+	groupTag := c.Param("grouptag")
+	if groupTag == "" {
+		c.JSON(400, gin.H{"error": "Group tag is required"})
+		return
+	}
+
+	groupsDataMutex.RLock()
+	defer groupsDataMutex.RUnlock()
+	data := groupsData[groupTag]
+	if data == nil {
+		c.JSON(404, gin.H{"error": "Group not found"})
+		return
+	}
+
+	products := make([]GroupBenefitProduct, 0, len(data.BenefitProducts))
+	for _, p := range data.BenefitProducts {
+		products = append(products, p)
+	}
+	c.JSON(200, products)
+}
+
+type benefitProductRequest struct {
+	Name              *string  `json:"name"`
+	Description       *string  `json:"description"`
+	PriceCredits      *float64 `json:"price_credits"`
+	RoleGrantedId     *string  `json:"role_granted_id"`
+	BenefitGranted    *string  `json:"benefit_granted"`
+	BillingPeriodDays *int     `json:"billing_period_days"`
+}
+
+func (r benefitProductRequest) apply(p *GroupBenefitProduct) {
+	if r.Name != nil {
+		p.Name = strings.TrimSpace(*r.Name)
+	}
+	if r.Description != nil {
+		p.Description = strings.TrimSpace(*r.Description)
+	}
+	if r.PriceCredits != nil {
+		p.PriceCredits = roundVal(*r.PriceCredits)
+	}
+	if r.RoleGrantedId != nil {
+		p.RoleGrantedId = *r.RoleGrantedId
+	}
+	if r.BenefitGranted != nil {
+		p.BenefitGranted = strings.TrimSpace(*r.BenefitGranted)
+	}
+	if r.BillingPeriodDays != nil {
+		p.BillingPeriodDays = *r.BillingPeriodDays
+	}
+}
+
+// saveGroupProduct creates a product, or updates one when productid is in the path
+func saveGroupProduct(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	groupTag := c.Param("grouptag")
+	productId := c.Param("productid")
+	if groupTag == "" {
+		c.JSON(400, gin.H{"error": "Group tag is required"})
+		return
+	}
+	if !hasPermission(user.GetId(), groupTag, "groups.products.manage") {
+		c.JSON(403, gin.H{"error": "You don't have permission to manage products"})
+		return
+	}
+
+	var req benefitProductRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	groupsDataMutex.Lock()
+	data := groupsData[groupTag]
+	if data == nil {
+		groupsDataMutex.Unlock()
+		c.JSON(404, gin.H{"error": "Group not found"})
+		return
+	}
+
+	product := GroupBenefitProduct{Id: uuid.New().String(), GroupTag: groupTag, CreatedAt: time.Now().Unix()}
+	status := 201
+	if productId != "" {
+		existing, ok := data.BenefitProducts[productId]
+		if !ok {
+			groupsDataMutex.Unlock()
+			c.JSON(404, gin.H{"error": errProductNotFound.Error()})
+			return
+		}
+		product, status = existing, 200
+	}
+	req.apply(&product)
+	if err := validateBenefitProductLocked(data, product); err != nil {
+		groupsDataMutex.Unlock()
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkProductRoleGrantLocked(data, user.GetId(), product.RoleGrantedId); err != nil {
+		groupsDataMutex.Unlock()
+		c.JSON(productErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if data.BenefitProducts == nil {
+		data.BenefitProducts = make(map[string]GroupBenefitProduct)
+	}
+	data.BenefitProducts[product.Id] = product
+	groupsDataMutex.Unlock()
+	go saveGroupData(groupTag)
+
+	c.JSON(status, product)
+}
+
+// deleteGroupProduct removes a product, recurring purchases of it end at their next renewal
+func deleteGroupProduct(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	groupTag := c.Param("grouptag")
+	productId := c.Param("productid")
+	if !hasPermission(user.GetId(), groupTag, "groups.products.manage") {
+		c.JSON(403, gin.H{"error": "You don't have permission to manage products"})
+		return
+	}
+
+	groupsDataMutex.Lock()
+	data := groupsData[groupTag]
+	if data == nil {
+		groupsDataMutex.Unlock()
+		c.JSON(404, gin.H{"error": "Group not found"})
+		return
+	}
+	if _, ok := data.BenefitProducts[productId]; !ok {
+		groupsDataMutex.Unlock()
+		c.JSON(404, gin.H{"error": errProductNotFound.Error()})
+		return
+	}
+	delete(data.BenefitProducts, productId)
+	groupsDataMutex.Unlock()
+	go saveGroupData(groupTag)
+
+	c.JSON(200, gin.H{"message": "Product deleted"})
+}
+
+// groupProductPrice is what buying the product costs, 0 if there is no such product
+func groupProductPrice(groupTag string, productId string) float64 {
+	groupsDataMutex.RLock()
+	defer groupsDataMutex.RUnlock()
+	if data := groupsData[groupTag]; data != nil {
+		return data.BenefitProducts[productId].PriceCredits
+	}
+	return 0
+}
+
+// groupProductAboveStepUpThreshold asks for 2fa when buying an expensive product
+func groupProductAboveStepUpThreshold(c *gin.Context) bool {
+	return groupProductPrice(c.Param("grouptag"), c.Param("productid")) > StepUpTransferThreshold
+}
+
+func purchaseGroupProduct(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	groupTag := c.Param("grouptag")
+
+	groupsDataMutex.Lock()
+	data := groupsData[groupTag]
+	if data == nil {
+		groupsDataMutex.Unlock()
+		c.JSON(404, gin.H{"error": "Group not found"})
+		return
+	}
+	purchase, err := purchaseBenefitLocked(data, *user, c.Param("productid"), time.Now())
+	groupsDataMutex.Unlock()
+	if err != nil {
+		c.JSON(productErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	go saveGroupData(groupTag)
+	go saveUsers()
+
+	c.JSON(201, purchase)
+}
+
+// cancelGroupPurchase stops a recurring purchase from renewing, it stays active
+// until the period that was paid for runs out
+func cancelGroupPurchase(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	groupTag := c.Param("grouptag")
+	purchaseId := c.Param("purchaseid")
+
+	groupsDataMutex.Lock()
+	data := groupsData[groupTag]
+	if data == nil {
+		groupsDataMutex.Unlock()
+		c.JSON(404, gin.H{"error": "Group not found"})
+		return
+	}
+	var purchase *GroupBenefitPurchase
+	for i := range data.BenefitPurchases {
+		if data.BenefitPurchases[i].Id == purchaseId && data.BenefitPurchases[i].UserId == user.GetId() {
+			purchase = &data.BenefitPurchases[i]
+			break
+		}
+	}
+	switch {
+	case purchase == nil || !purchase.isActive():
+		err := errPurchaseNotFound
+		groupsDataMutex.Unlock()
+		c.JSON(productErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	case purchase.NextBillingAt == nil:
+		groupsDataMutex.Unlock()
+		c.JSON(400, gin.H{"error": errPurchaseNotRecurring.Error()})
+		return
+	}
+	purchase.CancelAtPeriodEnd = true
+	updated := *purchase
+	groupsDataMutex.Unlock()
+	go saveGroupData(groupTag)
+
+	c.JSON(200, updated)
+}
+
+func getMyGroupPurchases(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	groupTag := c.Param("grouptag")
+
+	groupsDataMutex.RLock()
+	defer groupsDataMutex.RUnlock()
+	data := groupsData[groupTag]
+	if data == nil {
+		c.JSON(404, gin.H{"error": "Group not found"})
+		return
+	}
+
+	purchases := make([]GroupBenefitPurchase, 0)
+	for _, p := range data.BenefitPurchases {
+		if p.UserId == user.GetId() {
+			purchases = append(purchases, p)
+		}
+	}
+	c.JSON(200, purchases)
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	TreasuryProposalPending   = "pending"
+	TreasuryProposalApproved  = "approved"
+	TreasuryProposalExecuted  = "executed"
+	TreasuryProposalCancelled = "cancelled"
+
+	MaxPendingTreasuryProposals = 50
+)
+
+var (
+	errProposalNotFound      = errors.New("proposal not found")
+	errProposalClosed        = errors.New("proposal is no longer open")
+	errProposalNotApproved   = errors.New("proposal does not have enough approvals yet")
+	errOwnProposal           = errors.New("you cannot approve your own proposal")
+	errAlreadyApproved       = errors.New("you have already approved this proposal")
+	errNotTreasuryApprover   = errors.New("none of your roles can add an approval to this proposal")
+	errTreasuryInsufficient  = errors.New("the group does not have enough credits")
+	errTreasuryRecipientGone = errors.New("recipient no longer exists")
+)
+
+func treasuryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errProposalNotFound):
+		return 404
+	case errors.Is(err, errProposalClosed), errors.Is(err, errAlreadyApproved):
+		return 409
+	case errors.Is(err, errOwnProposal), errors.Is(err, errNotTreasuryApprover):
+		return 403
+	}
+	return 400
+}
+
+func findTreasuryProposalLocked(data *GroupData, id string) *GroupTreasuryProposal {
+	for i := range data.TreasuryProposals {
+		if data.TreasuryProposals[i].Id == id {
+			return &data.TreasuryProposals[i]
+		}
+	}
+	return nil
+}
+
+func memberRoleIdsLocked(data *GroupData, userId UserId) []string {
+	for _, m := range data.Members {
+		if m.UserId == userId {
+			return m.RoleIds
+		}
+	}
+	return nil
+}
+
+func validateTreasuryPolicy(data *GroupData, policy GroupTreasuryPolicy) error {
+	if policy.Threshold < 0 || policy.Threshold > len(policy.ApproverRoleIds) {
+		return errors.New("threshold must be between 0 and the number of approver roles")
+	}
+	seen := make(map[string]bool)
+	for _, id := range policy.ApproverRoleIds {
+		if seen[id] {
+			return errors.New("approver roles must be unique")
+		}
+		seen[id] = true
+		if !slices.ContainsFunc(data.Roles, func(r GroupRole) bool { return r.Id == id }) {
+			return errors.New("unknown role " + id)
+		}
+	}
+	return nil
+}
+
+// proposeTreasuryPayoutLocked adds a payout proposal, it starts out approved
+// when the group has no approval threshold
+func proposeTreasuryPayoutLocked(data *GroupData, p GroupTreasuryProposal) (*GroupTreasuryProposal, error) {
+	pending := 0
+	for _, existing := range data.TreasuryProposals {
+		if existing.Status == TreasuryProposalPending || existing.Status == TreasuryProposalApproved {
+			pending++
+		}
+	}
+	if pending >= MaxPendingTreasuryProposals {
+		return nil, errors.New("too many open proposals")
+	}
+	if p.AmountCredits > data.Group.CreditsBalance {
+		return nil, errTreasuryInsufficient
+	}
+
+	p.Status = TreasuryProposalApproved
+	if data.TreasuryPolicy != nil && data.TreasuryPolicy.Threshold > 0 {
+		p.Threshold = data.TreasuryPolicy.Threshold
+		p.Status = TreasuryProposalPending
+	}
+	p.Approvals = []GroupTreasuryApproval{}
+	data.TreasuryProposals = append(data.TreasuryProposals, p)
+	return &data.TreasuryProposals[len(data.TreasuryProposals)-1], nil
+}
+
+// approveTreasuryProposalLocked counts the approval against one of the
+// approver's roles that hasn't approved yet
+func approveTreasuryProposalLocked(data *GroupData, p *GroupTreasuryProposal, userId UserId) error {
+	if p.Status != TreasuryProposalPending {
+		return errProposalClosed
+	}
+	if p.ProposerId == userId {
+		return errOwnProposal
+	}
+	approvedRoles := make(map[string]bool)
+	for _, a := range p.Approvals {
+		if a.UserId == userId {
+			return errAlreadyApproved
+		}
+		approvedRoles[a.RoleId] = true
+	}
+
+	var approverRoles []string
+	if data.TreasuryPolicy != nil {
+		approverRoles = data.TreasuryPolicy.ApproverRoleIds
+	}
+	roleId := ""
+	for _, id := range memberRoleIdsLocked(data, userId) {
+		if slices.Contains(approverRoles, id) && !approvedRoles[id] {
+			roleId = id
+			break
+		}
+	}
+	if roleId == "" {
+		return errNotTreasuryApprover
+	}
+
+	p.Approvals = append(p.Approvals, GroupTreasuryApproval{UserId: userId, RoleId: roleId, CreatedAt: time.Now().Unix()})
+	if len(p.Approvals) >= p.Threshold {
+		p.Status = TreasuryProposalApproved
+	}
+	return nil
+}
+
+// executeTreasuryProposalLocked pays out an approved proposal from the group balance
+func executeTreasuryProposalLocked(data *GroupData, p *GroupTreasuryProposal, by UserId) error {
+	if p.Status == TreasuryProposalPending {
+		return errProposalNotApproved
+	}
+	if p.Status != TreasuryProposalApproved {
+		return errProposalClosed
+	}
+	if p.AmountCredits > data.Group.CreditsBalance {
+		return errTreasuryInsufficient
+	}
+
+	tag := data.Group.Tag
+	var recipient User
+	var targetData *GroupData
+	var recipientPosting Posting
+	if p.ToGroupTag != "" {
+		targetData = groupsData[p.ToGroupTag]
+		if targetData == nil {
+			return errTreasuryRecipientGone
+		}
+		recipientPosting = accountPosting(groupLedgerAccount(p.ToGroupTag), p.AmountCredits)
+	} else {
+		recipient = getUserById(p.ToUserId)
+		if len(recipient) == 0 {
+			return errTreasuryRecipientGone
+		}
+		recipientPosting = userPosting(recipient, p.AmountCredits)
+	}
+
+	if _, err := postLedger("group_payout", p.Id, accountPosting(groupLedgerAccount(tag), -p.AmountCredits), recipientPosting); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	data.Group.CreditsBalance = roundVal(data.Group.CreditsBalance - p.AmountCredits)
+	counterparty := string(p.ToUserId)
+	if targetData != nil {
+		counterparty = p.ToGroupTag
+		targetData.Group.CreditsBalance = roundVal(targetData.Group.CreditsBalance + p.AmountCredits)
+		targetData.TreasuryHistory = append(targetData.TreasuryHistory, GroupTreasuryEntry{
+			Id:            uuid.New().String(),
+			Type:          "payout_in",
+			Counterparty:  tag,
+			AmountCredits: p.AmountCredits,
+			BalanceAfter:  targetData.Group.CreditsBalance,
+			ProposalId:    p.Id,
+			CreatedAt:     now,
+		})
+	}
+	data.TreasuryHistory = append(data.TreasuryHistory, GroupTreasuryEntry{
+		Id:            uuid.New().String(),
+		Type:          "payout_out",
+		Counterparty:  counterparty,
+		AmountCredits: p.AmountCredits,
+		BalanceAfter:  data.Group.CreditsBalance,
+		ProposalId:    p.Id,
+		CreatedAt:     now,
+	})
+
+	if recipient != nil {
+		note := "Payout from group " + tag
+		if p.Note != "" {
+			note += ": " + p.Note
+		}
+		recipient.addTransaction(Transaction{
+			Note:     note,
+			User:     UserId(""),
+			Amount:   p.AmountCredits,
+			Type:     "group_payout",
+			NewTotal: recipient.GetCredits(),
+		})
+		addUserEvent(recipient.GetId(), "group_payout", map[string]any{
+			"group":  tag,
+			"amount": p.AmountCredits,
+			"note":   p.Note,
+		})
+	}
+
+	p.Status = TreasuryProposalExecuted
+	p.ResolvedAt = &now
+	p.ResolvedBy = by
+	return nil
+}
+
+// treasuryRequest loads what every treasury handler needs, the caller must be a member
+func treasuryRequest(c *gin.Context) (*User, string, bool) {
+	user := c.MustGet("user").(*User)
+	groupTag := c.Param("grouptag")
+	if groupTag == "" {
+		c.JSON(400, gin.H{"error": "Group tag is required"})
+		return nil, "", false
+	}
+	if _, ok := getGroupByTag(groupTag); !ok {
+		c.JSON(404, gin.H{"error": "Group not found"})
+		return nil, "", false
+	}
+	for _, m := range getGroupMembers(groupTag) {
+		if m.UserId == user.GetId() {
+			return user, groupTag, true
+		}
+	}
+	c.JSON(403, gin.H{"error": "You must be a member of this group"})
+	return nil, "", false
+}
+
+func getTreasury(c *gin.Context) {
+	_, groupTag, ok := treasuryRequest(c)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+
+	groupsDataMutex.RLock()
+	defer groupsDataMutex.RUnlock()
+	data := groupsData[groupTag]
+	if data == nil {
+		c.JSON(404, gin.H{"error": "Group not found"})
+		return
+	}
+
+	open := make([]GroupTreasuryProposal, 0)
+	for _, p := range data.TreasuryProposals {
+		if p.Status == TreasuryProposalPending || p.Status == TreasuryProposalApproved {
+			open = append(open, p)
+		}
+	}
+	history := make([]GroupTreasuryEntry, 0, limit)
+	for i := len(data.TreasuryHistory) - 1; i >= 0 && len(history) < limit; i-- {
+		history = append(history, data.TreasuryHistory[i])
+	}
+
+	c.JSON(200, gin.H{
+		"balance":   data.Group.CreditsBalance,
+		"policy":    data.TreasuryPolicy,
+		"proposals": open,
+		"history":   history,
+	})
+}
+
+// setTreasuryPolicy is owner only, anyone else could use it to skip approvals
+func setTreasuryPolicy(c *gin.Context) {
+	user, groupTag, ok := treasuryRequest(c)
+	if !ok {
+		return
+	}
+
+	var policy GroupTreasuryPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	groupsDataMutex.Lock()
+	data := groupsData[groupTag]
+	if data == nil {
+		groupsDataMutex.Unlock()
+		c.JSON(404, gin.H{"error": "Group not found"})
+		return
+	}
+	if data.Group.OwnerUserId != user.GetId() {
+		groupsDataMutex.Unlock()
+		c.JSON(403, gin.H{"error": "Only the group owner can change the treasury policy"})
+		return
+	}
+	if err := validateTreasuryPolicy(data, policy); err != nil {
+		groupsDataMutex.Unlock()
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if policy.Threshold == 0 {
+		data.TreasuryPolicy = nil
+	} else {
+		data.TreasuryPolicy = &policy
+	}
+	groupsDataMutex.Unlock()
+	go saveGroupData(groupTag)
+
+	c.JSON(200, gin.H{"policy": policy})
+}
+
+func createTreasuryProposal(c *gin.Context) {
+	user, groupTag, ok := treasuryRequest(c)
+	if !ok {
+		return
+	}
+	if !hasPermission(user.GetId(), groupTag, "groups.treasury.spend") {
+		c.JSON(403, gin.H{"error": "You don't have permission to spend from the treasury"})
+		return
+	}
+
+	var req struct {
+		ToUser  string  `json:"to_user"`
+		ToGroup string  `json:"to_group"`
+		Amount  float64 `json:"amount"`
+		Note    string  `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	amount := roundVal(req.Amount)
+	if amount < 0.01 {
+		c.JSON(400, gin.H{"error": "Minimum amount is 0.01"})
+		return
+	}
+	if (req.ToUser == "") == (req.ToGroup == "") {
+		c.JSON(400, gin.H{"error": "Provide either to_user or to_group"})
+		return
+	}
+
+	proposal := GroupTreasuryProposal{
+		Id:            uuid.New().String(),
+		GroupTag:      groupTag,
+		ProposerId:    user.GetId(),
+		AmountCredits: amount,
+		Note:          trimAndCapNote(req.Note, 200),
+		CreatedAt:     time.Now().Unix(),
+	}
+	if req.ToUser != "" {
+		recipient, err := getAccountByUsername(req.ToUser)
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Recipient not found"})
+			return
+		}
+		proposal.ToUserId = recipient.GetId()
+	} else {
+		if strings.EqualFold(req.ToGroup, groupTag) {
+			c.JSON(400, gin.H{"error": "A group cannot pay itself"})
+			return
+		}
+		if _, ok := getGroupByTag(req.ToGroup); !ok {
+			c.JSON(404, gin.H{"error": "Recipient group not found"})
+			return
+		}
+		proposal.ToGroupTag = req.ToGroup
+	}
+
+	groupsDataMutex.Lock()
+	data := groupsData[groupTag]
+	if data == nil {
+		groupsDataMutex.Unlock()
+		c.JSON(404, gin.H{"error": "Group not found"})
+		return
+	}
+	p, err := proposeTreasuryPayoutLocked(data, proposal)
+	if err != nil {
+		groupsDataMutex.Unlock()
+		c.JSON(treasuryErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	created := *p
+	groupsDataMutex.Unlock()
+	go saveGroupData(groupTag)
+
+	c.JSON(201, created)
+}
+
+// updateTreasuryProposal runs an approve, execute or cancel action on a proposal
+func updateTreasuryProposal(c *gin.Context, action string) {
+	user, groupTag, ok := treasuryRequest(c)
+	if !ok {
+		return
+	}
+
+	userId := user.GetId()
+	canSpend := hasPermission(userId, groupTag, "groups.treasury.spend")
+	if action == "execute" && !canSpend {
+		c.JSON(403, gin.H{"error": "You don't have permission to spend from the treasury"})
+		return
+	}
+
+	groupsDataMutex.Lock()
+	data := groupsData[groupTag]
+	if data == nil {
+		groupsDataMutex.Unlock()
+		c.JSON(404, gin.H{"error": "Group not found"})
+		return
+	}
+	p := findTreasuryProposalLocked(data, c.Param("proposalid"))
+	if p == nil {
+		groupsDataMutex.Unlock()
+		c.JSON(404, gin.H{"error": errProposalNotFound.Error()})
+		return
+	}
+
+	var err error
+	switch action {
+	case "approve":
+		err = approveTreasuryProposalLocked(data, p, userId)
+	case "execute":
+		err = executeTreasuryProposalLocked(data, p, userId)
+	case "cancel":
+		switch {
+		case p.ProposerId != userId && !canSpend:
+			err = errors.New("only the proposer or a treasury spender can cancel this proposal")
+		case p.Status != TreasuryProposalPending && p.Status != TreasuryProposalApproved:
+			err = errProposalClosed
+		default:
+			now := time.Now().Unix()
+			p.Status = TreasuryProposalCancelled
+			p.ResolvedAt = &now
+			p.ResolvedBy = userId
+		}
+	}
+	if err != nil {
+		groupsDataMutex.Unlock()
+		c.JSON(treasuryErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	updated := *p
+	groupsDataMutex.Unlock()
+
+	go saveGroupData(groupTag)
+	if action == "execute" {
+		if updated.ToGroupTag != "" {
+			go saveGroupData(updated.ToGroupTag)
+		} else {
+			go saveUsers()
+		}
+	}
+	c.JSON(200, updated)
+}
+
+func approveTreasuryProposal(c *gin.Context) {
+	updateTreasuryProposal(c, "approve")
+}
+
+func executeTreasuryProposal(c *gin.Context) {
+	updateTreasuryProposal(c, "execute")
+}
+
+func cancelTreasuryProposal(c *gin.Context) {
+	updateTreasuryProposal(c, "cancel")
+}
@@ -125,6 +125,18 @@ func buyItem(c *gin.Context) {
 		return
 	}
 
+	// purchases can't wait in the review queue, so a hold turns them away as well
+	if action, _ := evaluateFraudRules(FraudCheck{Kind: FraudItemPurchase, From: *user, To: getUserById(targetItem.Owner), Amount: float64(targetItem.Price)}); action != FraudAllow {
+		c.JSON(403, gin.H{"error": "Purchase blocked by fraud checks"})
+		return
+	}
+
+	// items are bought from the platform, the seller is not paid
+	if _, err := postLedger("item_purchase", targetItem.Name, userPosting(*user, -float64(targetItem.Price)), accountPosting(LedgerSink, float64(targetItem.Price))); err != nil {
+		c.JSON(403, gin.H{"error": "Insufficient currency"})
+		return
+	}
+
 	// Process the purchase
 	oldOwner := targetItem.Owner
 	targetItem.Owner = user.GetId()
@@ -144,8 +156,6 @@ func buyItem(c *gin.Context) {
 	targetItem.TotalIncome += targetItem.Price
 
 	go saveItems()
-
-	user.SetBalance(userCurrency - float64(targetItem.Price))
 	go saveUsers()
 
 	// Notify both users
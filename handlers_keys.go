@@ -432,6 +432,11 @@ func buyKey(c *gin.Context) {
 				return
 			}
 
+			if _, err := postLedger("key_purchase", keys[i].Key, keyPaymentPostings(*user, getUserById(keys[i].Creator), float64(keys[i].Price))...); err != nil {
+				c.JSON(400, ErrorResponse{Error: "Insufficient balance to buy this key"})
+				return
+			}
+
 			// Add user to key
 			userData := KeyUserData{
 				Time:  time.Now().Unix(),
@@ -475,7 +480,7 @@ func buyKey(c *gin.Context) {
 
 			go saveKeys()
 
-			// Deduct the price from user's balance
+			// Record the purchase on both accounts
 			usersMutex.Lock()
 			userIndex := -1
 			for j, u := range users {
@@ -495,9 +500,7 @@ func buyKey(c *gin.Context) {
 			usersMutex.Unlock()
 
 			if userIndex != -1 {
-				// Flexible extraction for sys.currency
-				newBal := user.GetCredits() - float64(keys[i].Price)
-				user.SetBalance(newBal)
+				newBal := user.GetCredits()
 				user.addTransaction(Transaction{
 					Note:      "key purchase",
 					User:      user.GetId(),
@@ -512,11 +515,7 @@ func buyKey(c *gin.Context) {
 				// Pay the creator
 				if ownerIndex != -1 && ownerIndex != userIndex {
 					owner, _ := getUserByIdx(ownerIndex)
-					var ownerCurrency float64 = owner.GetCredits()
-					// 10% tax on purchase
-					value := float64(keys[i].Price) * 0.9
-					newBal := ownerCurrency + value
-					owner.SetBalance(newBal)
+					newBal := owner.GetCredits()
 					owner.addTransaction(Transaction{
 						Note:      "key purchase",
 						User:      user.GetId(),
@@ -773,8 +772,12 @@ func checkSubscriptions() {
 							usersToRemove = append(usersToRemove, userId)
 							continue
 						}
-						currencyFloat -= price
-						purchaser.SetBalance(currencyFloat)
+						if _, err := postLedger("key_subscription", key.Key, keyPaymentPostings(purchaser, owner, price)...); err != nil {
+							log.Printf("Failed to charge %s for key %s: %v", username, key.Key, err)
+							usersToRemove = append(usersToRemove, userId)
+							continue
+						}
+						currencyFloat = purchaser.GetCredits()
 						purchaser.addTransaction(Transaction{
 							Note:      "key purchase",
 							User:      key.Creator,
@@ -788,8 +791,7 @@ func checkSubscriptions() {
 
 						// 10% tax on purchase
 						value := price * 0.9
-						newBal := owner.GetCredits() + value
-						owner.SetBalance(newBal)
+						newBal := owner.GetCredits()
 						owner.addTransaction(Transaction{
 							Note:      "key purchase",
 							User:      username.Id(),
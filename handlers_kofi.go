@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"time"
 
@@ -128,8 +129,11 @@ func handleKofiTransaction(c *gin.Context) {
 
 			addCredits := func(credits int) {
 				now := time.Now().UnixMilli()
-				balance := float64(account.GetCredits()) + float64(credits)
-				account.SetBalance(balance)
+				if _, err := postLedger("credit_purchase", fmt.Sprintf("kofi %d", credits), accountPosting(LedgerMint, -float64(credits)), userPosting(account, float64(credits))); err != nil {
+					log.Printf("Failed to add %d purchased credits to %s: %v", credits, account.GetUsername(), err)
+					return
+				}
+				balance := account.GetCredits()
 				account.addTransaction(Transaction{
 					Note:      fmt.Sprintf("%d credit purchase", credits),
 					User:      Username("rotur").Id(),
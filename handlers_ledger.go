@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// reconcileLedgerAdmin reports where stored balances and the ledger disagree.
+// With adjust the differences are booked so the ledger matches again.
+func reconcileLedgerAdmin(c *gin.Context) {
+	if !authenticateAdmin(c) {
+		return
+	}
+
+	var req struct {
+		Adjust bool `json:"adjust"`
+	}
+	_ = c.ShouldBindJSON(&req)
+
+	report, err := reconcileLedger()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to read ledger"})
+		return
+	}
+
+	if !req.Adjust {
+		c.JSON(200, report)
+		return
+	}
+
+	entry, err := adjustLedgerDrift(report)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"report": report, "adjustment": entry})
+}
@@ -1,75 +1,151 @@
 package main
 
 import (
-	"crypto/md5"
-	"fmt"
+	"net/url"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-var usedCodesMutex sync.RWMutex
-var usedCodes = make(map[string]string)
-var counter int64
+// requestDeviceCode starts a device link, the device shows user_code and polls /link/token
+func requestDeviceCode(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
 
-func generateUniqueLinkCode() string {
-	for {
-		counter++
-		timestamp := time.Now().UnixNano()
-		hash := md5.Sum([]byte(fmt.Sprintf("%d-%d", timestamp, counter)))
-		code := strings.ToUpper(fmt.Sprintf("%x", hash)[:6])
+	clientName := strings.TrimSpace(c.PostForm("client_name"))
+	if clientName == "" {
+		clientName = "Linked device"
+	}
+	if len(clientName) > 50 {
+		oauthError(c, 400, "invalid_request", "client_name must be 50 characters or less")
+		return
+	}
 
-		usedCodesMutex.Lock()
-		defer usedCodesMutex.Unlock()
-		if _, exists := usedCodes[code]; !exists {
-			usedCodes[code] = ""
-			return code
-		}
+	scope := c.PostForm("scope")
+	if strings.TrimSpace(scope) == "" {
+		scope = DefaultDeviceScope
+	}
+	perms, err := parseOAuthScope(scope)
+	if err != nil {
+		oauthError(c, 400, "invalid_scope", err.Error())
+		return
+	}
 
-		time.Sleep(time.Nanosecond)
+	d, err := startDeviceAuthorization(clientName, perms)
+	if err != nil {
+		oauthError(c, 503, "temporarily_unavailable", err.Error())
+		return
 	}
+
+	c.JSON(200, gin.H{
+		"device_code":               d.DeviceCode,
+		"user_code":                 d.UserCode,
+		"verification_uri":          DEVICE_VERIFICATION_URI,
+		"verification_uri_complete": DEVICE_VERIFICATION_URI + "?user_code=" + url.QueryEscape(d.UserCode),
+		"expires_in":                int64(DeviceCodeLifetime.Seconds()),
+		"interval":                  d.Interval,
+	})
 }
 
-func getLinkCode(c *gin.Context) {
-	code := generateUniqueLinkCode()
-	c.JSON(200, gin.H{"code": code})
+// getDeviceVerification is the approval screen payload for a code the user typed in
+func getDeviceVerification(c *gin.Context) {
+	d, ok := getDeviceAuthorization(c.Query("user_code"))
+	if !ok {
+		c.JSON(404, gin.H{"error": "Code not found or expired"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"user_code":   d.UserCode,
+		"client_name": d.ClientName,
+		"scope":       formatOAuthScope(d.Permissions),
+		"permissions": d.Permissions,
+		"groups":      summarizePermissionGroups(d.Permissions),
+		"created_at":  d.CreatedAt,
+		"expires_at":  d.ExpiresAt,
+	})
 }
 
-func linkCodeToAccount(c *gin.Context) {
-	code := c.Query("code")
+func approveDeviceVerification(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	var req struct {
+		UserCode string `json:"user_code"`
+		Approve  bool   `json:"approve"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	d, _ := getDeviceAuthorization(req.UserCode)
+	if err := decideDeviceAuthorization(req.UserCode, string(user.GetUsername()), req.Approve); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
 
-	usedCodesMutex.Lock()
-	defer usedCodesMutex.Unlock()
-	if _, exists := usedCodes[code]; exists {
-		user := c.MustGet("user").(*User)
-		usedCodes[code] = user.GetKey()
-		c.JSON(200, "Linked Successfully")
+	if !req.Approve {
+		c.JSON(200, gin.H{"message": "Device link denied"})
 		return
 	}
-	c.JSON(404, gin.H{"error": "No auth code found"})
+	if d != nil {
+		logSecurityEvent(c, *user, SecDeviceLinked, map[string]any{"client_name": d.ClientName, "scope": formatOAuthScope(d.Permissions)})
+	}
+	c.JSON(200, gin.H{"message": "Device linked, it will sign in shortly"})
 }
 
-func getLinkStatus(c *gin.Context) {
-	code := c.Query("code")
-	usedCodesMutex.RLock()
-	defer usedCodesMutex.RUnlock()
-	if val, exists := usedCodes[code]; exists && val != "" {
-		c.JSON(200, gin.H{"status": "linked"})
-	} else {
-		c.JSON(404, gin.H{"status": "not found"})
+// deviceApprovalRequested limits the 2fa check to approvals, denying needs no code
+func deviceApprovalRequested(c *gin.Context) bool {
+	var req struct {
+		Approve bool `json:"approve"`
 	}
+	return peekJSONBody(c, &req) == nil && req.Approve
 }
 
-func getLinkedUser(c *gin.Context) {
-	code := c.Query("code")
-	usedCodesMutex.RLock()
-	defer usedCodesMutex.RUnlock()
-	if val, exists := usedCodes[code]; exists && val != "" {
-		c.JSON(200, gin.H{"linked": true, "token": val})
-		delete(usedCodes, code)
-	} else {
-		c.JSON(404, gin.H{"linked": false, "token": ""})
+// pollDeviceToken is the RFC 8628 token endpoint the device polls
+func pollDeviceToken(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	grantType := c.PostForm("grant_type")
+	if grantType != "" && grantType != "urn:ietf:params:oauth:grant-type:device_code" {
+		oauthError(c, 400, "unsupported_grant_type", "grant_type must be urn:ietf:params:oauth:grant-type:device_code")
+		return
 	}
+
+	d, err := pollDeviceAuthorization(c.PostForm("device_code"))
+	switch err {
+	case nil:
+	case errDevicePending:
+		oauthError(c, 400, err.Error(), "The user has not approved the device yet")
+		return
+	case errDeviceSlowDown:
+		oauthError(c, 400, err.Error(), "Polling too fast, wait longer between requests")
+		return
+	case errDeviceDenied:
+		oauthError(c, 400, err.Error(), "The user denied the device")
+		return
+	default:
+		oauthError(c, 400, errDeviceExpired.Error(), "The device code is invalid or expired")
+		return
+	}
+
+	user, err := getAccountByUsername(d.Username)
+	if err != nil || user.IsBanned() {
+		oauthError(c, 400, "access_denied", "The account can no longer be linked")
+		return
+	}
+
+	token, err := issueDeviceSubToken(d.Username, d)
+	if err != nil {
+		oauthError(c, 400, "access_denied", err.Error())
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"access_token": token.Token,
+		"token_type":   "Bearer",
+		"scope":        formatOAuthScope(token.Permissions),
+		"username":     user.GetUsername(),
+		"linked_at":    time.Now().UnixMilli(),
+	})
 }
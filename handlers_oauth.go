@@ -0,0 +1,485 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func validateOAuthRedirectURIs(uris []string) error {
+	if len(uris) == 0 {
+		return fmt.Errorf("at least one redirect uri is required")
+	}
+	if len(uris) > OAuthMaxRedirectURIs {
+		return fmt.Errorf("maximum of %d redirect uris allowed", OAuthMaxRedirectURIs)
+	}
+	for _, uri := range uris {
+		if err := validateRedirectURI(uri); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createOAuthApp(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	var req struct {
+		Name         string   `json:"name"`
+		Description  string   `json:"description,omitempty"`
+		Website      string   `json:"website,omitempty"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Public       bool     `json:"public,omitempty"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if req.Name == "" || len(req.Name) > 50 {
+		c.JSON(400, gin.H{"error": "App name must be between 1 and 50 characters"})
+		return
+	}
+	if len(req.Description) > 500 {
+		c.JSON(400, gin.H{"error": "App description must be 500 characters or less"})
+		return
+	}
+	if err := validateOAuthRedirectURIs(req.RedirectURIs); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	userId := user.GetId()
+	app := OAuthApp{
+		ClientId:     generateOAuthClientId(),
+		Name:         req.Name,
+		Description:  req.Description,
+		Website:      req.Website,
+		RedirectURIs: req.RedirectURIs,
+		Owner:        userId,
+		CreatedAt:    time.Now().UnixMilli(),
+	}
+
+	var secret string
+	if !req.Public {
+		secret = generateOAuthClientSecret()
+		app.SecretHash = hashOAuthSecret(secret)
+	}
+
+	oauthAppsMutex.Lock()
+	owned := 0
+	for _, a := range oauthApps {
+		if a.Owner == userId {
+			owned++
+		}
+	}
+	if owned >= OAuthMaxAppsPerUser {
+		oauthAppsMutex.Unlock()
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Maximum of %d apps reached", OAuthMaxAppsPerUser)})
+		return
+	}
+	oauthApps = append(oauthApps, app)
+	oauthAppsMutex.Unlock()
+
+	go saveOAuthApps()
+
+	resp := gin.H{"app": app.ToPublic()}
+	if secret != "" {
+		resp["client_secret"] = secret
+	}
+	c.JSON(201, resp)
+}
+
+func listOAuthApps(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	userId := user.GetId()
+
+	oauthAppsMutex.RLock()
+	apps := make([]OAuthAppPublic, 0)
+	for i := range oauthApps {
+		if oauthApps[i].Owner == userId {
+			apps = append(apps, oauthApps[i].ToPublic())
+		}
+	}
+	oauthAppsMutex.RUnlock()
+
+	c.JSON(200, gin.H{
+		"apps":  apps,
+		"total": len(apps),
+	})
+}
+
+func getOAuthAppInfo(c *gin.Context) {
+	app, ok := getOAuthApp(c.Param("client_id"))
+	if !ok {
+		c.JSON(404, gin.H{"error": "App not found"})
+		return
+	}
+	c.JSON(200, app.ToPublic())
+}
+
+func updateOAuthApp(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	clientId := c.Param("client_id")
+
+	var req struct {
+		Name         *string  `json:"name,omitempty"`
+		Description  *string  `json:"description,omitempty"`
+		Website      *string  `json:"website,omitempty"`
+		RedirectURIs []string `json:"redirect_uris,omitempty"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if req.Name != nil && (*req.Name == "" || len(*req.Name) > 50) {
+		c.JSON(400, gin.H{"error": "App name must be between 1 and 50 characters"})
+		return
+	}
+	if req.Description != nil && len(*req.Description) > 500 {
+		c.JSON(400, gin.H{"error": "App description must be 500 characters or less"})
+		return
+	}
+	if req.RedirectURIs != nil {
+		if err := validateOAuthRedirectURIs(req.RedirectURIs); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	oauthAppsMutex.Lock()
+	for i := range oauthApps {
+		app := &oauthApps[i]
+		if app.ClientId != clientId {
+			continue
+		}
+		if app.Owner != user.GetId() {
+			break
+		}
+
+		if req.Name != nil {
+			app.Name = *req.Name
+		}
+		if req.Description != nil {
+			app.Description = *req.Description
+		}
+		if req.Website != nil {
+			app.Website = *req.Website
+		}
+		if req.RedirectURIs != nil {
+			app.RedirectURIs = req.RedirectURIs
+		}
+		public := app.ToPublic()
+		oauthAppsMutex.Unlock()
+
+		go saveOAuthApps()
+		c.JSON(200, public)
+		return
+	}
+	oauthAppsMutex.Unlock()
+
+	c.JSON(404, gin.H{"error": "App not found"})
+}
+
+func rotateOAuthAppSecret(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	clientId := c.Param("client_id")
+
+	oauthAppsMutex.Lock()
+	for i := range oauthApps {
+		app := &oauthApps[i]
+		if app.ClientId != clientId {
+			continue
+		}
+		if app.Owner != user.GetId() {
+			break
+		}
+		if app.SecretHash == "" {
+			oauthAppsMutex.Unlock()
+			c.JSON(400, gin.H{"error": "Public apps do not have a client secret"})
+			return
+		}
+
+		secret := generateOAuthClientSecret()
+		app.SecretHash = hashOAuthSecret(secret)
+		oauthAppsMutex.Unlock()
+
+		go saveOAuthApps()
+		c.JSON(200, gin.H{"client_id": clientId, "client_secret": secret})
+		return
+	}
+	oauthAppsMutex.Unlock()
+
+	c.JSON(404, gin.H{"error": "App not found"})
+}
+
+func deleteOAuthApp(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	clientId := c.Param("client_id")
+
+	oauthAppsMutex.Lock()
+	found := false
+	for i := range oauthApps {
+		if oauthApps[i].ClientId == clientId && oauthApps[i].Owner == user.GetId() {
+			oauthApps = append(oauthApps[:i], oauthApps[i+1:]...)
+			found = true
+			break
+		}
+	}
+	oauthAppsMutex.Unlock()
+
+	if !found {
+		c.JSON(404, gin.H{"error": "App not found"})
+		return
+	}
+
+	go saveOAuthApps()
+	revoked := revokeOAuthAppTokens(clientId)
+
+	c.JSON(200, gin.H{"message": "App deleted successfully", "client_id": clientId, "revoked_tokens": revoked})
+}
+
+type oauthAuthorizeRequest struct {
+	ResponseType        string `json:"response_type" form:"response_type"`
+	ClientId            string `json:"client_id" form:"client_id"`
+	RedirectURI         string `json:"redirect_uri" form:"redirect_uri"`
+	Scope               string `json:"scope" form:"scope"`
+	State               string `json:"state" form:"state"`
+	CodeChallenge       string `json:"code_challenge" form:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method" form:"code_challenge_method"`
+}
+
+func (r *oauthAuthorizeRequest) validate() (OAuthApp, []TokenPermission, error) {
+	app, ok := getOAuthApp(r.ClientId)
+	if !ok {
+		return app, nil, fmt.Errorf("unknown client_id")
+	}
+	if !app.hasRedirectURI(r.RedirectURI) {
+		return app, nil, fmt.Errorf("redirect_uri is not registered for this app")
+	}
+	if r.ResponseType != "code" {
+		return app, nil, fmt.Errorf("response_type must be code")
+	}
+	if r.CodeChallenge == "" || r.CodeChallengeMethod != "S256" {
+		return app, nil, fmt.Errorf("PKCE with code_challenge_method S256 is required")
+	}
+	perms, err := parseOAuthScope(r.Scope)
+	if err != nil {
+		return app, nil, err
+	}
+	return app, perms, nil
+}
+
+func oauthRedirect(redirectURI string, params map[string]string) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	q := u.Query()
+	for k, v := range params {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// getOAuthConsent describes what the app is asking for so the consent screen can render it
+func getOAuthConsent(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	var req oauthAuthorizeRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	app, perms, err := req.validate()
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	previouslyAuthorized := false
+	if store, err := loadTokenStore(strings.ToLower(string(user.GetUsername()))); err == nil {
+		now := time.Now().UnixMilli()
+		for _, t := range store.Tokens {
+			if !t.Revoked && t.AppId == app.ClientId && (t.refreshable(now) || (t.ExpiresAt != nil && *t.ExpiresAt > now)) {
+				previouslyAuthorized = true
+				break
+			}
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"app":                   app.ToPublic(),
+		"scope":                 formatOAuthScope(perms),
+		"permissions":           perms,
+		"groups":                summarizePermissionGroups(perms),
+		"redirect_uri":          req.RedirectURI,
+		"state":                 req.State,
+		"previously_authorized": previouslyAuthorized,
+	})
+}
+
+// approveOAuthConsent records the user's decision and returns where to send them next
+func approveOAuthConsent(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	var req struct {
+		oauthAuthorizeRequest
+		Approve bool `json:"approve"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	app, perms, err := req.validate()
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !req.Approve {
+		c.JSON(200, gin.H{"redirect": oauthRedirect(req.RedirectURI, map[string]string{
+			"error": "access_denied",
+			"state": req.State,
+		})})
+		return
+	}
+
+	code := issueOAuthCode(&oauthAuthCode{
+		ClientId:      app.ClientId,
+		Username:      strings.ToLower(string(user.GetUsername())),
+		RedirectURI:   req.RedirectURI,
+		Permissions:   perms,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().Add(OAuthCodeLifetime).UnixMilli(),
+	})
+	logSecurityEvent(c, *user, SecOAuthAuthorized, map[string]any{"client_id": app.ClientId, "app": app.Name, "scope": req.Scope})
+
+	c.JSON(200, gin.H{"redirect": oauthRedirect(req.RedirectURI, map[string]string{
+		"code":  code,
+		"state": req.State,
+	})})
+}
+
+// summarizePermissionGroups groups requested permissions for consent screens
+func summarizePermissionGroups(perms []TokenPermission) []gin.H {
+	requested := make(map[TokenPermission]bool)
+	for _, p := range perms {
+		requested[p] = true
+	}
+
+	groups := make([]gin.H, 0)
+	for _, g := range PermissionGroups() {
+		granted := make([]TokenPermission, 0)
+		for _, p := range g.Permissions {
+			if requested[p] {
+				granted = append(granted, p)
+			}
+		}
+		if len(granted) == 0 {
+			continue
+		}
+		groups = append(groups, gin.H{
+			"name":        g.Name,
+			"description": g.Description,
+			"permissions": granted,
+			"complete":    len(granted) == len(g.Permissions),
+		})
+	}
+	return groups
+}
+
+func oauthError(c *gin.Context, status int, code string, description string) {
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+}
+
+// oauthClient authenticates the client from basic auth or the form body
+func oauthClient(c *gin.Context) (OAuthApp, bool) {
+	clientId, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientId = c.PostForm("client_id")
+		secret = c.PostForm("client_secret")
+	}
+
+	app, found := getOAuthApp(clientId)
+	if !found || !app.checkSecret(secret) {
+		return app, false
+	}
+	return app, true
+}
+
+func oauthToken(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	app, ok := oauthClient(c)
+	if !ok {
+		oauthError(c, 401, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	var token *SubToken
+	switch c.PostForm("grant_type") {
+	case "authorization_code":
+		code, ok := consumeOAuthCode(c.PostForm("code"))
+		if !ok || code.ClientId != app.ClientId {
+			oauthError(c, 400, "invalid_grant", "Authorization code is invalid or expired")
+			return
+		}
+		if code.RedirectURI != c.PostForm("redirect_uri") {
+			oauthError(c, 400, "invalid_grant", "redirect_uri does not match the authorization request")
+			return
+		}
+		if !verifyPKCE(code.CodeChallenge, c.PostForm("code_verifier")) {
+			oauthError(c, 400, "invalid_grant", "code_verifier does not match the code challenge")
+			return
+		}
+
+		var err error
+		token, err = issueOAuthSubToken(code.Username, app, code.Permissions)
+		if err != nil {
+			oauthError(c, 400, "invalid_grant", err.Error())
+			return
+		}
+	case "refresh_token":
+		var err error
+		token, err = refreshOAuthSubToken(c.PostForm("refresh_token"), app.ClientId)
+		if err != nil {
+			oauthError(c, 400, "invalid_grant", err.Error())
+			return
+		}
+	default:
+		oauthError(c, 400, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"access_token":  token.Token,
+		"token_type":    "Bearer",
+		"expires_in":    int64(OAuthAccessTokenLifetime.Seconds()),
+		"refresh_token": token.RefreshToken,
+		"scope":         formatOAuthScope(token.Permissions),
+	})
+}
+
+// oauthRevoke follows RFC 7009, unknown tokens still get a 200
+func oauthRevoke(c *gin.Context) {
+	app, ok := oauthClient(c)
+	if !ok {
+		oauthError(c, 401, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	revokeOAuthToken(c.PostForm("token"), app.ClientId)
+	c.JSON(200, gin.H{})
+}
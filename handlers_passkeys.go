@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func listPasskeys(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	store, err := loadPasskeyStore(string(user.GetUsername()))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load passkeys"})
+		return
+	}
+
+	passkeys := make([]PasskeyPublic, 0, len(store.Passkeys))
+	for i := range store.Passkeys {
+		passkeys = append(passkeys, store.Passkeys[i].ToPublic())
+	}
+	c.JSON(200, gin.H{"passkeys": passkeys})
+}
+
+func beginPasskeyRegistration(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	options, err := passkeyCreationOptions(*user)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"publicKey": options})
+}
+
+func finishPasskeyRegistration(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	var req struct {
+		Name       string            `json:"name"`
+		Credential PasskeyCredential `json:"credential"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if len(req.Name) > 50 {
+		c.JSON(400, gin.H{"error": "Passkey name must be 50 characters or less"})
+		return
+	}
+
+	passkey, err := registerPasskey(*user, req.Credential, req.Name)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	logSecurityEvent(c, *user, SecPasskeyAdded, map[string]any{"id": passkey.ID, "name": passkey.Name})
+
+	c.JSON(200, gin.H{
+		"message": "Passkey registered",
+		"passkey": passkey.ToPublic(),
+	})
+}
+
+func renamePasskeyHandler(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 50 {
+		c.JSON(400, gin.H{"error": "Passkey name must be between 1 and 50 characters"})
+		return
+	}
+
+	passkey, err := renamePasskey(string(user.GetUsername()), c.Param("id"), req.Name)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save passkeys"})
+		return
+	}
+	if passkey == nil {
+		c.JSON(404, gin.H{"error": "Passkey not found"})
+		return
+	}
+	c.JSON(200, gin.H{"passkey": passkey.ToPublic()})
+}
+
+func deletePasskeyHandler(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	removed, err := removePasskey(string(user.GetUsername()), c.Param("id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save passkeys"})
+		return
+	}
+	if !removed {
+		c.JSON(404, gin.H{"error": "Passkey not found"})
+		return
+	}
+	logSecurityEvent(c, *user, SecPasskeyRemoved, map[string]any{"id": c.Param("id")})
+	c.JSON(200, gin.H{"message": "Passkey removed"})
+}
+
+// beginPasskeyLogin takes an optional username, without one any discoverable passkey can sign in
+func beginPasskeyLogin(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+	}
+	c.ShouldBindJSON(&req)
+
+	options, err := passkeyRequestOptions(strings.TrimSpace(req.Username))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load passkeys"})
+		return
+	}
+	c.JSON(200, gin.H{"publicKey": options})
+}
+
+func finishPasskeyLogin(c *gin.Context) {
+	var req struct {
+		Credential PasskeyCredential `json:"credential"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	username, err := verifyPasskeyLogin(req.Credential)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Passkey login failed: " + err.Error()})
+		return
+	}
+
+	user, err := getAccountByUsername(username)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+
+	// a passkey is possession plus user verification, checked in parseAuthenticatorData, so no totp is asked for
+	completeLogin(c, user, true, "Successful passkey login", "")
+}
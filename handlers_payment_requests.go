@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func createPaymentRequest(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	var req struct {
+		From      string `json:"from"`
+		Amount    any    `json:"amount"`
+		Note      string `json:"note"`
+		ExpiresIn int64  `json:"expires_in"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request payload"})
+		return
+	}
+
+	nAmount, err := parseTransferAmount(req.Amount)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if nAmount < 0.01 {
+		c.JSON(400, gin.H{"error": "Minimum amount is 0.01"})
+		return
+	}
+
+	if req.From == "" {
+		c.JSON(400, gin.H{"error": "Payer username must be provided"})
+		return
+	}
+	payer, err := getAccountByUsername(Username(req.From))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Payer not found"})
+		return
+	}
+	if payer.GetId() == user.GetId() {
+		c.JSON(400, gin.H{"error": "Cannot request credits from yourself"})
+		return
+	}
+	if payer.HasBlocked(user.GetId()) {
+		c.JSON(403, gin.H{"error": "cannot send a payment request to this user"})
+		return
+	}
+
+	lifetime := PaymentRequestDefaultLifetime
+	if req.ExpiresIn > 0 {
+		lifetime = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if lifetime > PaymentRequestMaxLifetime {
+		c.JSON(400, gin.H{"error": "Payment requests can last at most 30 days"})
+		return
+	}
+
+	now := time.Now()
+	request := PaymentRequest{
+		Id:          uuid.New().String(),
+		RequesterId: user.GetId(),
+		PayerId:     payer.GetId(),
+		Amount:      nAmount,
+		Note:        trimAndCapNote(req.Note, 50),
+		Status:      PaymentRequestPending,
+		CreatedAt:   now.UnixMilli(),
+		ExpiresAt:   now.Add(lifetime).UnixMilli(),
+	}
+
+	paymentRequestsMutex.Lock()
+	expirePaymentRequestsLocked(now.UnixMilli())
+	if countPendingPaymentRequests(user.GetId()) >= MaxPendingPaymentRequests {
+		paymentRequestsMutex.Unlock()
+		c.JSON(400, gin.H{"error": "Too many pending payment requests"})
+		return
+	}
+	paymentRequests = append(paymentRequests, request)
+	savePaymentRequestsLocked()
+	paymentRequestsMutex.Unlock()
+
+	addUserEvent(payer.GetId(), "payment_request", map[string]any{
+		"id":     request.Id,
+		"from":   user.GetUsername(),
+		"amount": request.Amount,
+		"note":   request.Note,
+	})
+
+	c.JSON(201, request.ToNet())
+}
+
+// getPaymentRequests lists requests the user sent (role=outgoing) or was sent (role=incoming)
+func getPaymentRequests(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	userId := user.GetId()
+
+	role := c.DefaultQuery("role", "incoming")
+	if role != "incoming" && role != "outgoing" {
+		c.JSON(400, gin.H{"error": "role must be incoming or outgoing"})
+		return
+	}
+	status := c.Query("status")
+
+	paymentRequestsMutex.Lock()
+	if expirePaymentRequestsLocked(time.Now().UnixMilli()) {
+		savePaymentRequestsLocked()
+	}
+	matched := make([]PaymentRequest, 0)
+	for _, r := range paymentRequests {
+		if (role == "incoming" && r.PayerId != userId) || (role == "outgoing" && r.RequesterId != userId) {
+			continue
+		}
+		if status != "" && r.Status != status {
+			continue
+		}
+		matched = append(matched, r)
+	}
+	paymentRequestsMutex.Unlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].CreatedAt > matched[j].CreatedAt
+	})
+
+	netRequests := make([]PaymentRequestNet, 0, len(matched))
+	for _, r := range matched {
+		netRequests = append(netRequests, r.ToNet())
+	}
+
+	c.JSON(200, gin.H{
+		"requests": netRequests,
+		"count":    len(netRequests),
+	})
+}
+
+func acceptPaymentRequest(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	// the amount comes from the stored request, not the body
+	release, err := reserveTokenTransfer(c, paymentRequestAmount(c.Param("id")))
+	if err != nil {
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	}
+	request, err := payPaymentRequest(c.Param("id"), *user)
+	release(err == nil)
+	switch {
+	case errors.Is(err, errPaymentRequestNotFound):
+		c.JSON(404, gin.H{"error": "Payment request not found"})
+		return
+	case errors.Is(err, errPaymentRequestClosed):
+		c.JSON(400, gin.H{"error": "Payment request is " + request.Status})
+		return
+	case err != nil:
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.Amount > SecurityLogTransferThreshold {
+		logSecurityEvent(c, *user, SecTransfer, map[string]any{"to": request.RequesterId.User().GetUsername(), "amount": request.Amount})
+	}
+	if request.Status == PaymentRequestHeld {
+		c.JSON(202, request.ToNet())
+		return
+	}
+	addUserEvent(request.RequesterId, "payment_request_paid", map[string]any{
+		"id":     request.Id,
+		"from":   user.GetUsername(),
+		"amount": request.Amount,
+	})
+
+	c.JSON(200, request.ToNet())
+}
+
+// closePaymentRequest moves a pending request to status. the payer declines, the requester cancels.
+func closePaymentRequest(c *gin.Context, status string) {
+	user := c.MustGet("user").(*User)
+	now := time.Now().UnixMilli()
+
+	paymentRequestsMutex.Lock()
+	expirePaymentRequestsLocked(now)
+	r := findPaymentRequestLocked(c.Param("id"))
+	if r == nil || (status == PaymentRequestDeclined && r.PayerId != user.GetId()) || (status == PaymentRequestCancelled && r.RequesterId != user.GetId()) {
+		paymentRequestsMutex.Unlock()
+		c.JSON(404, gin.H{"error": "Payment request not found"})
+		return
+	}
+	if r.Status != PaymentRequestPending {
+		current := r.Status
+		paymentRequestsMutex.Unlock()
+		c.JSON(400, gin.H{"error": "Payment request is " + current})
+		return
+	}
+	r.Status = status
+	r.RespondedAt = &now
+	request := *r
+	savePaymentRequestsLocked()
+	paymentRequestsMutex.Unlock()
+
+	if status == PaymentRequestDeclined {
+		addUserEvent(request.RequesterId, "payment_request_declined", map[string]any{
+			"id":     request.Id,
+			"from":   user.GetUsername(),
+			"amount": request.Amount,
+		})
+	}
+
+	c.JSON(200, request.ToNet())
+}
+
+func declinePaymentRequest(c *gin.Context) {
+	closePaymentRequest(c, PaymentRequestDeclined)
+}
+
+func cancelPaymentRequest(c *gin.Context) {
+	closePaymentRequest(c, PaymentRequestCancelled)
+}
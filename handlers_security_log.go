@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSecurityLogLimit = 50
+	maxSecurityLogLimit     = 200
+)
+
+func respondSecurityLog(c *gin.Context, username Username, types string, before int64, limit int) {
+	if limit <= 0 {
+		limit = defaultSecurityLogLimit
+	}
+	limit = min(limit, maxSecurityLogLimit)
+
+	var typeList []string
+	for _, t := range strings.Split(types, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			typeList = append(typeList, t)
+		}
+	}
+
+	events, more, err := querySecurityLog(string(username), typeList, before, limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to read security log"})
+		return
+	}
+
+	var next *int64
+	if more && len(events) > 0 {
+		next = &events[len(events)-1].Timestamp
+	}
+	c.JSON(200, gin.H{
+		"username": username,
+		"events":   events,
+		"next":     next,
+	})
+}
+
+// getSecurityLog pages back through the caller's log, pass next as before for older events
+func getSecurityLog(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	before, _ := strconv.ParseInt(c.Query("before"), 10, 64)
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	respondSecurityLog(c, user.GetUsername(), c.Query("type"), before, limit)
+}
+
+func getSecurityLogAdmin(c *gin.Context) {
+	if !authenticateAdmin(c) {
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Type     string `json:"type"`
+		Before   int64  `json:"before"`
+		Limit    int    `json:"limit"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Username == "" {
+		c.JSON(400, gin.H{"error": "username is required"})
+		return
+	}
+
+	user, err := getAccountByUsername(req.Username)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "user not found"})
+		return
+	}
+	respondSecurityLog(c, user.GetUsername(), req.Type, req.Before, req.Limit)
+}
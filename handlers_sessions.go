@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func getSessions(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	sessions, err := listSessions(string(user.GetUsername()))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load sessions"})
+		return
+	}
+
+	currentID := c.GetString("session_id")
+	out := make([]SessionPublic, 0, len(sessions))
+	for i := range sessions {
+		out = append(out, sessions[i].ToPublic(currentID))
+	}
+	c.JSON(200, gin.H{"sessions": out, "current": currentID})
+}
+
+func revokeSession(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	id := c.Param("id")
+
+	n, err := revokeSessions(string(user.GetUsername()), func(s *Session) bool {
+		return s.ID == id
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	if n == 0 {
+		c.JSON(404, gin.H{"error": "Session not found"})
+		return
+	}
+	logSecurityEvent(c, *user, SecSessionRevoked, map[string]any{"session_id": id})
+	c.JSON(200, gin.H{"message": "Session revoked"})
+}
+
+// revokeOtherSessions signs out every device except the one making the request
+func revokeOtherSessions(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	currentID := c.GetString("session_id")
+
+	n, err := revokeSessions(string(user.GetUsername()), func(s *Session) bool {
+		return s.ID != currentID
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+	if n > 0 {
+		logSecurityEvent(c, *user, SecSessionRevoked, map[string]any{"others": true, "count": n})
+	}
+	c.JSON(200, gin.H{"message": "Sessions revoked", "revoked": n})
+}
+
+func logoutSession(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	currentID := c.GetString("session_id")
+	if currentID == "" {
+		c.JSON(400, gin.H{"error": "Request was not made with a session token"})
+		return
+	}
+
+	if _, err := revokeSessions(string(user.GetUsername()), func(s *Session) bool {
+		return s.ID == currentID
+	}); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Logged out"})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func listStandingOrders(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	userId := user.GetId()
+
+	standingOrdersMutex.Lock()
+	orders := make([]StandingOrder, 0)
+	for _, o := range standingOrders {
+		if o.FromUserId == userId {
+			orders = append(orders, o)
+		}
+	}
+	standingOrdersMutex.Unlock()
+
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].CreatedAt > orders[j].CreatedAt
+	})
+
+	c.JSON(200, gin.H{
+		"standing_orders": orders,
+		"count":           len(orders),
+	})
+}
+
+func createStandingOrder(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	var req struct {
+		To        string `json:"to"`
+		Group     string `json:"group"`
+		Amount    any    `json:"amount"`
+		Frequency string `json:"frequency"`
+		Note      string `json:"note"`
+		StartAt   int64  `json:"start_at"`
+		EndAt     *int64 `json:"end_at"`
+		MaxRuns   int    `json:"max_runs"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request payload"})
+		return
+	}
+
+	nAmount, err := parseTransferAmount(req.Amount)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if nAmount < 0.01 {
+		c.JSON(400, gin.H{"error": "Minimum amount is 0.01"})
+		return
+	}
+
+	frequency := strings.ToLower(req.Frequency)
+	if !isValidFrequency(frequency) {
+		c.JSON(400, gin.H{"error": "Frequency must be daily, weekly or monthly"})
+		return
+	}
+	if req.MaxRuns < 0 {
+		c.JSON(400, gin.H{"error": "max_runs cannot be negative"})
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	startAt := req.StartAt
+	if startAt < now {
+		startAt = now
+	}
+	if req.EndAt != nil && *req.EndAt < startAt {
+		c.JSON(400, gin.H{"error": "end_at must be after the first payment"})
+		return
+	}
+
+	order := StandingOrder{
+		Id:         uuid.New().String(),
+		FromUserId: user.GetId(),
+		Amount:     nAmount,
+		Note:       trimAndCapNote(req.Note, 50),
+		Frequency:  frequency,
+		Status:     StandingOrderActive,
+		NextRunAt:  startAt,
+		EndAt:      req.EndAt,
+		MaxRuns:    req.MaxRuns,
+		CreatedAt:  now,
+	}
+	if v, ok := c.Get("sub_token"); ok {
+		token := v.(*SubToken)
+		if tc := token.Constraints; tc != nil && tc.MaxTransfer > 0 && nAmount > tc.MaxTransfer {
+			c.JSON(403, gin.H{"error": fmt.Sprintf("Token is limited to %.2f credits per transfer", tc.MaxTransfer)})
+			return
+		}
+		order.TokenId = token.ID
+	}
+
+	switch {
+	case req.To != "" && req.Group != "":
+		c.JSON(400, gin.H{"error": "Provide either a recipient or a group, not both"})
+		return
+	case req.To != "":
+		toUser, err := getAccountByUsername(Username(req.To))
+		if err != nil {
+			c.JSON(404, gin.H{"error": "Recipient user not found"})
+			return
+		}
+		if toUser.GetId() == user.GetId() {
+			c.JSON(400, gin.H{"error": "Cannot send credits to yourself"})
+			return
+		}
+		order.ToUserId = toUser.GetId()
+	case req.Group != "":
+		group, ok := getGroupByTag(req.Group)
+		if !ok {
+			c.JSON(404, gin.H{"error": "Group not found"})
+			return
+		}
+		if !canTipGroup(*user, req.Group, group) {
+			c.JSON(403, gin.H{"error": "You can only tip groups you're a member of"})
+			return
+		}
+		order.ToGroup = req.Group
+	default:
+		c.JSON(400, gin.H{"error": "Recipient username or group tag must be provided"})
+		return
+	}
+
+	standingOrdersMutex.Lock()
+	if countActiveStandingOrders(user.GetId()) >= MaxStandingOrdersPerUser {
+		standingOrdersMutex.Unlock()
+		c.JSON(400, gin.H{"error": "Standing order limit reached"})
+		return
+	}
+	standingOrders = append(standingOrders, order)
+	saveStandingOrdersLocked()
+	standingOrdersMutex.Unlock()
+
+	c.JSON(201, order)
+}
+
+// updateStandingOrder applies fn to one of the user's orders and saves it
+func updateStandingOrder(c *gin.Context, fn func(o *StandingOrder) (int, string)) {
+	user := c.MustGet("user").(*User)
+	id := c.Param("id")
+
+	standingOrdersMutex.Lock()
+	defer standingOrdersMutex.Unlock()
+
+	for i := range standingOrders {
+		o := &standingOrders[i]
+		if o.Id != id || o.FromUserId != user.GetId() {
+			continue
+		}
+		if code, msg := fn(o); code != 0 {
+			c.JSON(code, gin.H{"error": msg})
+			return
+		}
+		saveStandingOrdersLocked()
+		c.JSON(200, o)
+		return
+	}
+	c.JSON(404, gin.H{"error": "Standing order not found"})
+}
+
+func pauseStandingOrder(c *gin.Context) {
+	updateStandingOrder(c, func(o *StandingOrder) (int, string) {
+		if o.Status != StandingOrderActive {
+			return 400, "Only active standing orders can be paused"
+		}
+		o.Status = StandingOrderPaused
+		return 0, ""
+	})
+}
+
+func resumeStandingOrder(c *gin.Context) {
+	updateStandingOrder(c, func(o *StandingOrder) (int, string) {
+		if o.Status != StandingOrderPaused {
+			return 400, "Only paused standing orders can be resumed"
+		}
+		// payments missed while paused are skipped rather than paid at once
+		o.Status = StandingOrderActive
+		o.Failures = 0
+		o.advance(time.Now().UnixMilli())
+		return 0, ""
+	})
+}
+
+func cancelStandingOrder(c *gin.Context) {
+	updateStandingOrder(c, func(o *StandingOrder) (int, string) {
+		if o.Status == StandingOrderCancelled || o.Status == StandingOrderCompleted {
+			return 400, "Standing order has already ended"
+		}
+		o.Status = StandingOrderCancelled
+		return 0, ""
+	})
+}
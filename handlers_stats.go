@@ -41,6 +41,48 @@ func getEconomyStats(c *gin.Context) {
 	})
 }
 
+// getEconomyHistory returns daily economy snapshots between from and to
+// (YYYY-MM-DD, inclusive), the last 30 days by default
+func getEconomyHistory(c *gin.Context) {
+	toDate := time.Now().UTC().Truncate(24 * time.Hour)
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(economySnapshotDateFormat, v)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+			return
+		}
+		toDate = t
+	}
+
+	fromDate := toDate.AddDate(0, 0, -30)
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(economySnapshotDateFormat, v)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+			return
+		}
+		fromDate = t
+	}
+
+	if fromDate.After(toDate) {
+		c.JSON(400, gin.H{"error": "from must not be after to"})
+		return
+	}
+	if toDate.Sub(fromDate) > 366*24*time.Hour {
+		c.JSON(400, gin.H{"error": "Range cannot be longer than 366 days"})
+		return
+	}
+
+	from, to := fromDate.Format(economySnapshotDateFormat), toDate.Format(economySnapshotDateFormat)
+	snapshots := getEconomySnapshotsBetween(from, to)
+	c.JSON(200, gin.H{
+		"from":      from,
+		"to":        to,
+		"count":     len(snapshots),
+		"snapshots": snapshots,
+	})
+}
+
 func getUserCreditData() []float64 {
 	usersMutex.RLock()
 	defer usersMutex.RUnlock()
@@ -146,7 +188,7 @@ func getMostGained(c *gin.Context) {
 	leaderboard := make([]result, 0, len(users))
 
 	for _, user := range users {
-		if user.IsBanned() || user.IsPrivate() {
+		if user.IsBanned() || user.IsPrivate() || user.IsPendingDeletion() {
 			continue
 		}
 
@@ -203,7 +245,7 @@ func getSystemStats(c *gin.Context) {
 
 	systems := make(map[string]int)
 	for _, user := range users {
-		if user.IsBanned() || user.IsPrivate() {
+		if user.IsBanned() || user.IsPrivate() || user.IsPendingDeletion() {
 			continue
 		}
 		systems[user.GetSystem()]++
@@ -240,7 +282,7 @@ func getFollowersStats(c *gin.Context) {
 	userStatusMap := make(map[Username]bool)
 	usersMutex.RLock()
 	for _, user := range users {
-		if user.IsBanned() || user.IsPrivate() {
+		if user.IsBanned() || user.IsPrivate() || user.IsPendingDeletion() {
 			continue
 		}
 		username := user.GetUsername()
@@ -7,16 +7,14 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
-	"time"
 )
 
 // File operations
 func loadUsers() {
-	usersMutex.Lock()
-	defer usersMutex.Unlock()
-
 	if _, err := os.Stat(USERS_FILE_PATH); os.IsNotExist(err) {
+		usersMutex.Lock()
 		users = make([]User, 0)
+		usersMutex.Unlock()
 		return
 	}
 
@@ -25,16 +23,32 @@ func loadUsers() {
 		log.Printf("Error reading users file (keeping in-memory users): %v", err)
 		return
 	}
-	if len(data) == 0 {
-		log.Printf("users.json read returned 0 bytes; preserving existing in-memory users (%d)", len(users))
+
+	loaded, err := parseUsersData(data)
+	if err != nil {
+		log.Printf("Error loading users (keeping existing users): %v", err)
 		return
 	}
+	setUsers(loaded)
+}
+
+// parseUsersData decodes users.json without touching the in-memory users
+func parseUsersData(data []byte) ([]User, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("users file is empty")
+	}
 
 	var loaded []User
 	if err := json.Unmarshal(data, &loaded); err != nil {
-		log.Printf("Error unmarshaling users (keeping existing %d users): %v", len(users), err)
-		return
+		return nil, err
 	}
+	return loaded, nil
+}
+
+// setUsers swaps in a freshly loaded user list and rebuilds the lookup maps
+func setUsers(loaded []User) {
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
 
 	migratedCount := 0
 	for i := range loaded {
@@ -59,6 +73,9 @@ func loadUsers() {
 	usernameToId = usernameToIdInner
 	idToUser = idToUserInner
 	idToUserMutex.Unlock()
+	loginIpIndexMutex.Lock()
+	loginIpIndex = buildLoginIpIndex(loaded)
+	loginIpIndexMutex.Unlock()
 	users = loaded
 }
 
@@ -167,7 +184,11 @@ func atomicWrite(path string, data []byte, perm os.FileMode) error {
 	if err = f.Close(); err != nil {
 		return err
 	}
-	return os.Rename(tmp, path)
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+	noteFileWritten(path, data)
+	return nil
 }
 
 var usersSaveMutex sync.Mutex
@@ -488,21 +509,3 @@ func saveJsonFile(path string, v any) bool {
 	}
 	return true
 }
-
-func watchUsersFile() {
-	var lastMtime time.Time
-	if stat, err := os.Stat(USERS_FILE_PATH); err == nil {
-		lastMtime = stat.ModTime()
-	}
-
-	for {
-		time.Sleep(500 * time.Millisecond)
-		if stat, err := os.Stat(USERS_FILE_PATH); err == nil {
-			if stat.ModTime().After(lastMtime) {
-				time.Sleep(500 * time.Millisecond)
-				loadUsers()
-				lastMtime = stat.ModTime()
-			}
-		}
-	}
-}
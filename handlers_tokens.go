@@ -18,12 +18,16 @@ func createSubToken(c *gin.Context) {
 	}
 
 	var req struct {
-		Name         string   `json:"name"`
-		Permissions  []string `json:"permissions"`
-		ExpiresInHrs *int     `json:"expires_in_hrs,omitempty"`
-		Origin       string   `json:"origin,omitempty"`
-		Description  string   `json:"description,omitempty"`
-		Websites     []string `json:"websites,omitempty"`
+		Name         string            `json:"name"`
+		Permissions  []string          `json:"permissions"`
+		ExpiresInHrs *int              `json:"expires_in_hrs,omitempty"`
+		Origin       string            `json:"origin,omitempty"`
+		Description  string            `json:"description,omitempty"`
+		Websites     []string          `json:"websites,omitempty"`
+		Constraints  *TokenConstraints `json:"constraints,omitempty"`
+		AllowedIPs   []string          `json:"allowed_ips,omitempty"`
+		IdleHrs      int               `json:"idle_timeout_hrs,omitempty"`
+		RateLimit    *TokenRateLimit   `json:"rate_limit,omitempty"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -65,6 +69,27 @@ func createSubToken(c *gin.Context) {
 		permissions = append(permissions, tp)
 	}
 
+	constraints, err := validateTokenConstraints(req.Constraints)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	allowedIPs, err := validateAllowedIPs(req.AllowedIPs)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.IdleHrs < 0 || req.IdleHrs > MaxTokenIdleHrs {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("idle_timeout_hrs must be between 0 and %d", MaxTokenIdleHrs)})
+		return
+	}
+	rateLimit, err := validateTokenRateLimit(req.RateLimit)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
 	username := strings.ToLower(string(user.GetUsername()))
 	store, err := loadTokenStore(username)
 	if err != nil {
@@ -103,15 +128,19 @@ func createSubToken(c *gin.Context) {
 	}
 
 	subToken := SubToken{
-		ID:          tokenID,
-		Name:        req.Name,
-		Token:       tokenValue,
-		Permissions: permissions,
-		CreatedAt:   now,
-		ExpiresAt:   expiresAt,
-		Origin:      req.Origin,
-		Description: req.Description,
-		Websites:    websites,
+		ID:             tokenID,
+		Name:           req.Name,
+		Token:          tokenValue,
+		Permissions:    permissions,
+		CreatedAt:      now,
+		ExpiresAt:      expiresAt,
+		Origin:         req.Origin,
+		Description:    req.Description,
+		Websites:       websites,
+		Constraints:    constraints,
+		AllowedIPs:     allowedIPs,
+		IdleTimeoutHrs: req.IdleHrs,
+		RateLimit:      rateLimit,
 	}
 
 	store.Tokens = append(store.Tokens, subToken)
@@ -122,17 +151,22 @@ func createSubToken(c *gin.Context) {
 	}
 
 	addToSubTokenIndex(tokenValue, username, tokenID)
+	logSecurityEvent(c, *user, SecTokenCreated, map[string]any{"id": tokenID, "name": req.Name, "permissions": permissions})
 
 	c.JSON(201, SubTokenCreate{
-		ID:          tokenID,
-		Name:        req.Name,
-		Token:       tokenValue,
-		Permissions: permissions,
-		CreatedAt:   now,
-		ExpiresAt:   expiresAt,
-		Origin:      req.Origin,
-		Description: req.Description,
-		Websites:    websites,
+		ID:             tokenID,
+		Name:           req.Name,
+		Token:          tokenValue,
+		Permissions:    permissions,
+		CreatedAt:      now,
+		ExpiresAt:      expiresAt,
+		Origin:         req.Origin,
+		Description:    req.Description,
+		Websites:       websites,
+		Constraints:    constraints,
+		AllowedIPs:     allowedIPs,
+		IdleTimeoutHrs: req.IdleHrs,
+		RateLimit:      rateLimit,
 	})
 }
 
@@ -190,10 +224,14 @@ func updateSubToken(c *gin.Context) {
 	tokenID := c.Param("id")
 
 	var req struct {
-		Name        *string  `json:"name,omitempty"`
-		Permissions []string `json:"permissions,omitempty"`
-		Description *string  `json:"description,omitempty"`
-		Websites    []string `json:"websites,omitempty"`
+		Name        *string           `json:"name,omitempty"`
+		Permissions []string          `json:"permissions,omitempty"`
+		Description *string           `json:"description,omitempty"`
+		Websites    []string          `json:"websites,omitempty"`
+		Constraints *TokenConstraints `json:"constraints,omitempty"`
+		AllowedIPs  []string          `json:"allowed_ips,omitempty"`
+		IdleHrs     *int              `json:"idle_timeout_hrs,omitempty"`
+		RateLimit   *TokenRateLimit   `json:"rate_limit,omitempty"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -254,6 +292,41 @@ func updateSubToken(c *gin.Context) {
 				t.Websites = req.Websites
 			}
 
+			// an empty constraints object clears them
+			if req.Constraints != nil {
+				constraints, err := validateTokenConstraints(req.Constraints)
+				if err != nil {
+					c.JSON(400, gin.H{"error": err.Error()})
+					return
+				}
+				t.Constraints = constraints
+			}
+
+			// an empty list lifts the allowlist, 0 turns off idle expiry and the budget
+			if req.AllowedIPs != nil {
+				allowedIPs, err := validateAllowedIPs(req.AllowedIPs)
+				if err != nil {
+					c.JSON(400, gin.H{"error": err.Error()})
+					return
+				}
+				t.AllowedIPs = allowedIPs
+			}
+			if req.IdleHrs != nil {
+				if *req.IdleHrs < 0 || *req.IdleHrs > MaxTokenIdleHrs {
+					c.JSON(400, gin.H{"error": fmt.Sprintf("idle_timeout_hrs must be between 0 and %d", MaxTokenIdleHrs)})
+					return
+				}
+				t.IdleTimeoutHrs = *req.IdleHrs
+			}
+			if req.RateLimit != nil {
+				rateLimit, err := validateTokenRateLimit(req.RateLimit)
+				if err != nil {
+					c.JSON(400, gin.H{"error": err.Error()})
+					return
+				}
+				t.RateLimit = rateLimit
+			}
+
 			if err := saveTokenStore(username, store); err != nil {
 				c.JSON(500, gin.H{"error": "Failed to save token store"})
 				return
@@ -303,6 +376,8 @@ func revokeSubToken(c *gin.Context) {
 			}
 
 			removeFromSubTokenIndex(t.Token)
+			removeFromOAuthRefreshIndex(t.RefreshToken)
+			logSecurityEvent(c, *user, SecTokenRevoked, map[string]any{"id": tokenID, "name": t.Name})
 
 			c.JSON(200, gin.H{"message": "Token revoked successfully", "id": tokenID})
 			return
@@ -330,13 +405,14 @@ func deleteSubToken(c *gin.Context) {
 		return
 	}
 
-	var tokenValue string
+	var tokenValue, refreshToken string
 	newTokens := make([]SubToken, 0, len(store.Tokens))
 	found := false
 
 	for _, t := range store.Tokens {
 		if t.ID == tokenID {
 			tokenValue = t.Token
+			refreshToken = t.RefreshToken
 			found = true
 			continue
 		}
@@ -355,6 +431,8 @@ func deleteSubToken(c *gin.Context) {
 	}
 
 	removeFromSubTokenIndex(tokenValue)
+	removeFromOAuthRefreshIndex(refreshToken)
+	logSecurityEvent(c, *user, SecTokenRevoked, map[string]any{"id": tokenID, "deleted": true})
 
 	c.JSON(200, gin.H{"message": "Token deleted successfully", "id": tokenID})
 }
@@ -462,6 +540,13 @@ func getSubTokenActivity(c *gin.Context) {
 				status = "revoked"
 			} else if t.ExpiresAt != nil && *t.ExpiresAt < time.Now().UnixMilli() {
 				status = "expired"
+			} else if t.idleExpired(time.Now().UnixMilli()) {
+				status = "idle_expired"
+			}
+
+			violations := t.Violations
+			if violations == nil {
+				violations = []TokenViolation{}
 			}
 
 			c.JSON(200, gin.H{
@@ -476,6 +561,15 @@ func getSubTokenActivity(c *gin.Context) {
 				"origin":       t.Origin,
 				"description":  t.Description,
 				"websites":     t.Websites,
+				"constraints":  t.Constraints,
+				"transfer_usage": gin.H{
+					"day":    t.TransferDay,
+					"amount": t.TransferredToday,
+				},
+				"allowed_ips":      t.AllowedIPs,
+				"idle_timeout_hrs": t.IdleTimeoutHrs,
+				"rate_limit":       t.RateLimit,
+				"violations":       violations,
 			})
 			return
 		}
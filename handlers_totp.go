@@ -0,0 +1,141 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getTwoFactorStatus(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	c.JSON(200, gin.H{
+		"enabled":                user.HasTOTP(),
+		"pending_setup":          user.GetString("sys.totp_pending_secret") != "",
+		"backup_codes_remaining": user.GetBackupCodeCount(),
+	})
+}
+
+// setupTwoFactor starts enrollment, 2fa stays off until enableTwoFactor confirms a code
+func setupTwoFactor(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	if user.HasTOTP() {
+		c.JSON(400, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret := generateTOTPSecret()
+	user.Set("sys.totp_pending_secret", secret)
+	go saveUsers()
+
+	c.JSON(200, gin.H{
+		"secret":      secret,
+		"otpauth_uri": totpURI(user.GetUsername(), secret),
+		"issuer":      totpIssuer,
+		"digits":      totpDigits,
+		"period":      totpPeriod,
+	})
+}
+
+func enableTwoFactor(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if user.HasTOTP() {
+		c.JSON(400, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret := user.GetString("sys.totp_pending_secret")
+	if secret == "" {
+		c.JSON(400, gin.H{"error": "Start setup with /me/2fa/setup first"})
+		return
+	}
+
+	step, ok := verifyTOTP(secret, req.Code, 0, time.Now())
+	if !ok {
+		c.JSON(403, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	codes, hashes := generateBackupCodes()
+	user.Set("sys.totp_secret", secret)
+	user.Set("sys.totp_last_step", step)
+	user.Set("sys.totp_backup_codes", hashes)
+	user.Set("sys.totp_enabled", true)
+	user.DelKey("sys.totp_pending_secret")
+	go saveUsers()
+	logSecurityEvent(c, *user, SecTwoFactorEnabled, nil)
+
+	c.JSON(200, gin.H{
+		"message":      "Two-factor authentication enabled",
+		"backup_codes": codes,
+	})
+}
+
+func disableTwoFactor(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if !user.HasTOTP() {
+		c.JSON(400, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if !checkSecondFactor(*user, req.Code) {
+		c.JSON(403, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	user.DelKey("sys.totp_enabled")
+	user.DelKey("sys.totp_secret")
+	user.DelKey("sys.totp_last_step")
+	user.DelKey("sys.totp_backup_codes")
+	user.DelKey("sys.totp_pending_secret")
+	go saveUsers()
+	logSecurityEvent(c, *user, SecTwoFactorDisabled, nil)
+
+	c.JSON(200, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// regenerateBackupCodes replaces every existing backup code
+func regenerateBackupCodes(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if !user.HasTOTP() {
+		c.JSON(400, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if !checkSecondFactor(*user, req.Code) {
+		c.JSON(403, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	codes, hashes := generateBackupCodes()
+	user.Set("sys.totp_backup_codes", hashes)
+	go saveUsers()
+
+	c.JSON(200, gin.H{"backup_codes": codes})
+}
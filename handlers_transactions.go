@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseTransactionFilter reads the filter query parameters shared by listing and export
+func parseTransactionFilter(c *gin.Context) (TransactionFilter, string) {
+	var f TransactionFilter
+	if types := c.Query("type"); types != "" {
+		for _, t := range strings.Split(types, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				f.Types = append(f.Types, t)
+			}
+		}
+	}
+
+	if name := c.Query("counterparty"); name != "" {
+		counterparty, err := getAccountByUsername(Username(name))
+		if err != nil {
+			return f, "Counterparty not found"
+		}
+		f.Counterparty = counterparty.GetId()
+	}
+
+	for param, dst := range map[string]**float64{"min_amount": &f.MinAmount, "max_amount": &f.MaxAmount} {
+		if v := c.Query(param); v != "" {
+			amount, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return f, "Invalid " + param
+			}
+			*dst = &amount
+		}
+	}
+
+	for param, dst := range map[string]*int64{"since": &f.Since, "until": &f.Until} {
+		if v := c.Query(param); v != "" {
+			ts, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || ts < 0 {
+				return f, "Invalid " + param + ", expected a timestamp in milliseconds"
+			}
+			*dst = ts
+		}
+	}
+
+	f.KeyId = c.Query("key_id")
+	f.GiftId = c.Query("gift_id")
+	f.EscrowId = c.Query("escrow_id")
+	return f, ""
+}
+
+// getMyTransactions lists the user's transactions newest first, including
+// archived ones. new_total on each transaction is the balance right after it.
+func getMyTransactions(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	filter, errMsg := parseTransactionFilter(c)
+	if errMsg != "" {
+		c.JSON(400, gin.H{"error": errMsg})
+		return
+	}
+
+	export := c.Query("export")
+	if export != "" && export != "csv" && export != "json" {
+		c.JSON(400, gin.H{"error": "export must be csv or json"})
+		return
+	}
+
+	matched := make([]Transaction, 0)
+	for _, tx := range user.getTransactionHistory() {
+		if filter.Match(tx) {
+			matched = append(matched, tx)
+		}
+	}
+
+	if export != "" {
+		netTransactions := make([]TransactionNet, len(matched))
+		for i, tx := range matched {
+			netTransactions[i] = tx.ToNet()
+		}
+		exportTransactions(c, user.GetUsername(), export, netTransactions)
+		return
+	}
+
+	limit := TransactionPageDefault
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(400, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = min(n, TransactionPageMax)
+	}
+
+	var after *transactionCursor
+	if v := c.Query("cursor"); v != "" {
+		cursor, err := parseTransactionCursor(v)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		after = &cursor
+	}
+
+	page, next := pageTransactions(matched, after, limit)
+	netTransactions := make([]TransactionNet, len(page))
+	for i, tx := range page {
+		netTransactions[i] = tx.ToNet()
+	}
+
+	resp := gin.H{
+		"transactions": netTransactions,
+		"count":        len(netTransactions),
+		"balance":      user.GetCredits(),
+	}
+	if next != nil {
+		resp["next_cursor"] = next.String()
+	}
+	c.JSON(200, resp)
+}
+
+func exportTransactions(c *gin.Context, username Username, format string, txs []TransactionNet) {
+	filename := "transactions-" + strings.ToLower(string(username)) + "-" + time.Now().UTC().Format("20060102") + "." + format
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	if format == "json" {
+		data, err := json.MarshalIndent(txs, "", "  ")
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to export transactions"})
+			return
+		}
+		c.Data(200, "application/json", data)
+		return
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"time", "type", "counterparty", "amount", "balance", "note", "key_id", "gift_id", "escrow_id", "petition_id"})
+	for _, tx := range txs {
+		w.Write([]string{
+			time.UnixMilli(tx.Timestamp).UTC().Format(time.RFC3339),
+			tx.Type,
+			string(tx.User),
+			strconv.FormatFloat(tx.Amount, 'f', 2, 64),
+			strconv.FormatFloat(tx.NewTotal, 'f', 2, 64),
+			csvSafe(tx.Note),
+			tx.KeyId,
+			tx.GiftId,
+			tx.EscrowId,
+			tx.PetitionId,
+		})
+	}
+	w.Flush()
+	c.Data(200, "text/csv", buf.Bytes())
+}
+
+// csvSafe stops notes written by other users from being run as spreadsheet formulas
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -3,7 +3,7 @@ package main
 import (
 	crypto_rand "crypto/rand"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -12,7 +12,6 @@ import (
 	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -172,7 +171,13 @@ func getUser(c *gin.Context) {
 
 	var foundUser User
 
-	if authKey != "" {
+	sessionKey := ""
+	if isSessionToken(authKey) {
+		if sessionUser, _ := authenticateSession(authKey); sessionUser != nil {
+			foundUser = *sessionUser
+			sessionKey = authKey
+		}
+	} else if authKey != "" {
 		foundUsers, _ := getAccountsBy("key", authKey, 1)
 		if foundUsers != nil {
 			foundUser = foundUsers[0]
@@ -181,7 +186,7 @@ func getUser(c *gin.Context) {
 				return
 			}
 		} else {
-			subUser, _, err := authenticateWithSubTokenFast(authKey)
+			subUser, _, err := authenticateWithSubTokenFast(authKey, c.ClientIP())
 			if err == nil && subUser != nil {
 				foundUser = *subUser
 			}
@@ -191,68 +196,128 @@ func getUser(c *gin.Context) {
 	username := c.Query("username")
 	password := c.Query("password")
 
+	passwordLogin := false
 	if username != "" && password != "" && foundUser == nil {
 		var err error = nil
 		foundUser, err = findAccountByLogin(username, password)
 		if err != nil || foundUser == nil {
 			addLogin(c, foundUser, "Failed login")
+			if target, err := getAccountByUsername(username); err == nil {
+				logSecurityEvent(c, target, SecLoginFailed, map[string]any{"reason": "password"})
+			}
 			c.JSON(403, gin.H{"error": "Invalid authentication credentials"})
 			return
 		}
+		if foundUser.HasTOTP() {
+			code := c.Query("totp")
+			if code == "" {
+				c.JSON(401, gin.H{"error": "Two-factor code required", "totp_required": true})
+				return
+			}
+			if !checkSecondFactor(foundUser, code) {
+				addLogin(c, foundUser, "Failed two-factor login")
+				logSecurityEvent(c, foundUser, SecLoginFailed, map[string]any{"reason": "totp"})
+				c.JSON(403, gin.H{"error": "Invalid two-factor code", "totp_required": true})
+				return
+			}
+		}
+		passwordLogin = true
 	}
 
 	if foundUser != nil {
-		usersMutex.Lock()
-		defer usersMutex.Unlock()
+		completeLogin(c, foundUser, passwordLogin, "Successful Login", sessionKey)
+		return
+	}
 
-		if foundUser.IsBanned() {
-			c.JSON(403, gin.H{
-				"error":    "User is banned",
-				"username": foundUser.GetUsername(),
-			})
-			return
-		}
-		if foundUser.Get("sys.tos_accepted") != true {
-			// early return - TOS not accepted
-			c.JSON(403, gin.H{
-				"error":            "Terms-Of-Service are not accepted or outdated",
-				"username":         foundUser.GetUsername(),
-				"token":            foundUser.GetKey(),
-				"sys.tos_accepted": false,
-			})
-			return
-		}
+	c.JSON(403, gin.H{"error": "Invalid authentication credentials"})
+}
 
-		ip := c.ClientIP()
-		blocked_ips := foundUser.GetBlockedIps()
-		if slices.Contains(blocked_ips, ip) {
-			addLogin(c, foundUser, "Blocked ip attempted login")
-			c.JSON(403, gin.H{"error": "Unable to login to this account"})
-			return
-		}
+// completeLogin runs the checks shared by every login method and responds with the user.
+// interactive logins (password, passkey) also restore accounts pending deletion and open
+// a new device session, whose token is returned as the key. sessionKey is the session
+// token a non-interactive request authenticated with, if any.
+func completeLogin(c *gin.Context, foundUser User, interactive bool, message string, sessionKey string) {
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
 
-		now := time.Now().UnixMilli()
-		foundUser.Set("sys.last_login", now)
-		foundUser.Set("sys.total_logins", foundUser.GetInt("sys.total_logins")+1)
-		foundUser.Set("sys.badges", calculateUserBadges(foundUser))
+	if foundUser.IsBanned() {
+		c.JSON(403, gin.H{
+			"error":    "User is banned",
+			"username": foundUser.GetUsername(),
+		})
+		return
+	}
+	if foundUser.IsPendingDeletion() && !interactive {
+		c.JSON(403, gin.H{
+			"error":     "Account is pending deletion, log in to restore it",
+			"username":  foundUser.GetUsername(),
+			"delete_at": foundUser.GetDeleteAt(),
+		})
+		return
+	}
+	if foundUser.Get("sys.tos_accepted") != true {
+		// early return - TOS not accepted
+		c.JSON(403, gin.H{
+			"error":            "Terms-Of-Service are not accepted or outdated",
+			"username":         foundUser.GetUsername(),
+			"token":            foundUser.GetKey(),
+			"sys.tos_accepted": false,
+		})
+		return
+	}
 
-		header := c.GetHeader("CF-IPCountry")
-		if header == "T1" {
-			// block tor
-			addLogin(c, foundUser, "Tor login attempted")
-			c.JSON(403, gin.H{"error": "Tor is not allowed"})
-			return
-		}
+	ip := c.ClientIP()
+	blocked_ips := foundUser.GetBlockedIps()
+	if slices.Contains(blocked_ips, ip) {
+		addLogin(c, foundUser, "Blocked ip attempted login")
+		logSecurityEvent(c, foundUser, SecLoginBlocked, map[string]any{"reason": "blocked_ip"})
+		c.JSON(403, gin.H{"error": "Unable to login to this account"})
+		return
+	}
 
-		addLogin(c, foundUser, "Successful Login")
-		foundUser.SetSubscription(foundUser.GetSubscription())
+	now := time.Now().UnixMilli()
+	foundUser.Set("sys.last_login", now)
+	foundUser.Set("sys.total_logins", foundUser.GetInt("sys.total_logins")+1)
+	foundUser.Set("sys.badges", calculateUserBadges(foundUser))
 
-		go saveUsers()
-		c.JSON(200, userToNet(foundUser))
+	header := c.GetHeader("CF-IPCountry")
+	if header == "T1" {
+		// block tor
+		addLogin(c, foundUser, "Tor login attempted")
+		logSecurityEvent(c, foundUser, SecLoginBlocked, map[string]any{"reason": "tor"})
+		c.JSON(403, gin.H{"error": "Tor is not allowed"})
 		return
 	}
 
-	c.JSON(403, gin.H{"error": "Invalid authentication credentials"})
+	if restoreUserDeletion(foundUser) {
+		addLogin(c, foundUser, "Account restored from pending deletion")
+	}
+
+	var sessionID string
+	if interactive {
+		session, token, err := startSessionLogin(c, foundUser, message)
+		if err != nil {
+			log.Printf("Failed to create session for %s: %v", foundUser.GetUsername(), err)
+			c.JSON(500, gin.H{"error": "Failed to create session"})
+			return
+		}
+		sessionKey, sessionID = token, session.ID
+		logSecurityEvent(c, foundUser, SecLogin, map[string]any{"method": message, "session_id": sessionID})
+	} else {
+		addLogin(c, foundUser, message)
+		if sessionKey != "" {
+			sessionID = getSessionID(sessionKey)
+		}
+	}
+	foundUser.SetSubscription(foundUser.GetSubscription())
+
+	go saveUsers()
+	netUser := userToNet(foundUser)
+	if sessionKey != "" {
+		netUser["key"] = sessionKey
+		netUser["session_id"] = sessionID
+	}
+	c.JSON(200, netUser)
 }
 
 func userToNet(user User) User {
@@ -267,7 +332,9 @@ func userToNet(user User) User {
 	}
 	userCopy["sys.transactions"] = netTransactions
 
-	delete(userCopy, "password")
+	for _, key := range privateUserKeys {
+		delete(userCopy, key)
+	}
 	return userCopy
 }
 
@@ -278,9 +345,18 @@ func checkAuth(c *gin.Context) {
 		return
 	}
 
+	if isSessionToken(auth) {
+		if user, session := authenticateSession(auth); user != nil && !user.IsPendingDeletion() {
+			c.JSON(200, gin.H{"auth": true, "username": user.GetUsername(), "token_type": "main", "session_id": session.ID})
+			return
+		}
+		c.JSON(200, gin.H{"auth": false, "username": ""})
+		return
+	}
+
 	usersMutex.RLock()
 	for _, user := range users {
-		if user.GetKey() == auth {
+		if user.GetKey() == auth && !user.IsPendingDeletion() {
 			usersMutex.RUnlock()
 			c.JSON(200, gin.H{"auth": true, "username": user.GetUsername(), "token_type": "main"})
 			return
@@ -288,7 +364,7 @@ func checkAuth(c *gin.Context) {
 	}
 	usersMutex.RUnlock()
 
-	subUser, subToken, err := authenticateWithSubTokenFast(auth)
+	subUser, subToken, err := authenticateWithSubTokenFast(auth, "")
 	if err == nil && subUser != nil {
 		c.JSON(200, gin.H{
 			"auth":        true,
@@ -306,7 +382,10 @@ func addLogin(c *gin.Context, user User, message string) {
 	if user == nil {
 		return
 	}
-	logins := user.GetLogins()
+	recordLogin(user, newLoginRecord(c, message))
+}
+
+func newLoginRecord(c *gin.Context, message string) Login {
 	ip := c.ClientIP()
 	hostname := c.GetHeader("Origin")
 	userAgent := c.Request.UserAgent()
@@ -317,7 +396,7 @@ func addLogin(c *gin.Context, user User, message string) {
 		device_type = "Desktop"
 	}
 
-	logins = append(logins, Login{
+	return Login{
 		Origin:      hostname,
 		UserAgent:   userAgent,
 		IP_hmac:     hmacIp(ip),
@@ -325,12 +404,28 @@ func addLogin(c *gin.Context, user User, message string) {
 		Timestamp:   time.Now().UnixMilli(),
 		Device_type: device_type,
 		Message:     message,
-	})
+	}
+}
+
+func recordLogin(user User, login Login) {
+	logins := append(user.GetLogins(), login)
 	maxLogins := user.GetSubscriptionBenefits().Max_Login_History
 	if n := len(logins); n > maxLogins {
 		logins = logins[n-maxLogins:]
 	}
-	user.Set("sys.logins", logins)
+	user.SetLogins(logins)
+}
+
+// startSessionLogin records an interactive login and opens a new device session for it
+func startSessionLogin(c *gin.Context, user User, message string) (*Session, string, error) {
+	login := newLoginRecord(c, message)
+	session, token, err := createSession(user, login)
+	if err != nil {
+		recordLogin(user, login)
+		return nil, "", err
+	}
+	recordLogin(user, session.Login)
+	return session, token, nil
 }
 
 func generateAccountToken() string {
@@ -346,9 +441,21 @@ func generateAccountToken() string {
 	return token
 }
 
+// refreshToken rotates the token the request was made with. a session only rotates
+// itself, the account key rotates the key shared by legacy clients.
 func refreshToken(c *gin.Context) {
 	user := c.MustGet("user").(*User)
 
+	if sessionID := c.GetString("session_id"); sessionID != "" {
+		newToken, err := rotateSession(string(user.GetUsername()), sessionID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to refresh session"})
+			return
+		}
+		c.JSON(200, gin.H{"token": newToken, "session_id": sessionID})
+		return
+	}
+
 	newToken := generateAccountToken()
 
 	usersMutex.Lock()
@@ -613,7 +720,7 @@ func updateUser(c *gin.Context) {
 	var subToken *SubToken
 
 	if user == nil {
-		subUser, st, err := authenticateWithSubTokenFast(authKey)
+		subUser, st, err := authenticateWithSubTokenFast(authKey, c.ClientIP())
 		if err != nil || subUser == nil {
 			c.JSON(403, gin.H{"error": "Invalid authentication key"})
 			return
@@ -670,7 +777,9 @@ func updateUser(c *gin.Context) {
 			return
 		}
 		if !freeAndGifUploads {
-			user.SetBalance(currencyFloat - 10)
+			if _, err := postLedger("banner_upload", "", userPosting(*user, -10), accountPosting(LedgerSink, 10)); err != nil {
+				log.Printf("Failed to charge %s for banner upload: %v", user.GetUsername(), err)
+			}
 		}
 		go doAfter(func(data any) {
 			user.Set("sys.banner", "https://avatars.rotur.dev/.banners/"+user.GetUsername())
@@ -807,6 +916,14 @@ func updateUser(c *gin.Context) {
 		return
 	}
 
+	switch key {
+	case "password":
+		logSecurityEvent(c, *user, SecPasswordChanged, nil)
+	case "email":
+		user.clearEmailVerification()
+		logSecurityEvent(c, *user, SecEmailChanged, nil)
+	}
+
 	go saveUsers()
 
 	c.JSON(200, gin.H{
@@ -879,11 +996,27 @@ func updateUserAdmin(c *gin.Context) {
 				updateUsername(oldUsername, username)
 				user.Set("username", username)
 			case "sys.currency":
-				user.SetBalance(value)
+				balance, err := strconv.ParseFloat(fmt.Sprintf("%v", value), 64)
+				if err != nil || balance < 0 {
+					c.JSON(400, gin.H{"error": "Invalid balance"})
+					return
+				}
+				if err := setBalanceByAdjustment(user, balance, "admin update"); err != nil {
+					c.JSON(500, gin.H{"error": err.Error()})
+					return
+				}
 			default:
 				user.Set(key, value)
 			}
 
+			switch key {
+			case "password":
+				logSecurityEvent(c, user, SecPasswordChanged, map[string]any{"by": "admin"})
+			case "email":
+				user.clearEmailVerification()
+				logSecurityEvent(c, user, SecEmailChanged, map[string]any{"by": "admin"})
+			}
+
 			go saveUsers()
 
 			c.JSON(200, gin.H{
@@ -962,7 +1095,7 @@ func deleteUserKey(c *gin.Context) {
 
 	user := authenticateWithKey(authKey)
 	if user == nil {
-		subUser, subToken, err := authenticateWithSubTokenFast(authKey)
+		subUser, subToken, err := authenticateWithSubTokenFast(authKey, c.ClientIP())
 		if err != nil || subUser == nil {
 			c.JSON(403, gin.H{"error": "Invalid authentication key"})
 			return
@@ -1009,40 +1142,53 @@ func deleteUserKey(c *gin.Context) {
 
 // PerformCreditTransfer performs a credit transfer between two users.
 // Handles tax, transaction logging, and safety rules.
-// Returns an error if the transfer cannot be completed.
+// Returns an error if the transfer cannot be completed, or errTransferHeld if
+// the fraud rules parked it for review.
 func PerformCreditTransfer(fromUsername, toUsername Username, amount float64, note string) error {
-	const totalTax = 0.0
-	const taxRecipientShare = 0.25
+	_, err := performCreditTransfer(fromUsername, toUsername, amount, note, true)
+	return err
+}
+
+// performCreditTransfer skips the fraud rules when checkFraud is false, for
+// admin transfers and approved held transfers. It returns what the sender was
+// charged, the amount plus any transfer tax.
+func performCreditTransfer(fromUsername, toUsername Username, amount float64, note string, checkFraud bool) (float64, error) {
+	policy := getEconomyPolicy()
+	taxRecipientShare := policy.Faucets.SystemOwnerReward
 
 	// normalize + validate amount
 	nAmount, ok := normalizeEscrowAmount(amount)
 	if !ok {
-		return fmt.Errorf("minimum amount is 0.01")
+		return 0, fmt.Errorf("minimum amount is 0.01")
 	}
 
 	fromUser, err := getAccountByUsername(fromUsername)
 	if err != nil {
-		return fmt.Errorf("sender user not found")
+		return 0, fmt.Errorf("sender user not found")
 	}
 
 	toUser, err := getAccountByUsername(toUsername)
 	if err != nil {
-		return fmt.Errorf("recipient user not found")
+		return 0, fmt.Errorf("recipient user not found")
 	}
 
 	if fromUser.GetUsername().ToLower() == toUser.GetUsername().ToLower() {
-		return fmt.Errorf("cannot send credits to yourself")
+		return 0, fmt.Errorf("cannot send credits to yourself")
+	}
+
+	// the transfer tax only applies between users, not to minting or burning
+	totalTax := 0.0
+	if fromUser.GetUsername() != "rotur" && toUser.GetUsername() != "rotur" {
+		totalTax = roundVal(nAmount * policy.Sinks.TransferTaxPercent / 100)
 	}
 
 	fromCurrency := roundVal(fromUser.GetCredits())
 	if fromUsername != "rotur" {
 		if fromCurrency < (nAmount + totalTax) {
-			return fmt.Errorf("insufficient funds (required: %.2f, available: %.2f)", nAmount+totalTax, fromCurrency)
+			return 0, fmt.Errorf("insufficient funds (required: %.2f, available: %.2f)", nAmount+totalTax, fromCurrency)
 		}
 	}
 
-	toCurrency := roundVal(toUser.GetCredits())
-
 	now := time.Now().UnixMilli()
 
 	// Helper: clean note
@@ -1058,7 +1204,42 @@ func PerformCreditTransfer(fromUsername, toUsername Username, amount float64, no
 	}
 	note = mkNote(note)
 
+	// minting and burning through rotur isn't user to user, daily claims are checked in claimDaily
+	if checkFraud && fromUser.GetUsername() != "rotur" && toUser.GetUsername() != "rotur" {
+		action, hits := evaluateFraudRules(FraudCheck{Kind: FraudTransfer, From: fromUser, To: toUser, Amount: nAmount})
+		switch action {
+		case FraudBlock:
+			return 0, errTransferBlocked
+		case FraudHold:
+			h, err := holdTransfer(FraudTransfer, fromUser, toUser, nAmount, note, hits)
+			if err != nil {
+				return 0, err
+			}
+			go saveUsers()
+			return 0, heldTransferError{id: h.Id}
+		}
+	}
+
+	// rotur is the mint, credits it sends are created and credits sent to it leave the economy
+	kind := "transfer"
+	var postings []Posting
+	if fromUser.GetUsername() == "rotur" {
+		kind = "mint"
+		postings = append(postings, accountPosting(LedgerMint, -nAmount))
+	} else {
+		postings = append(postings, userPosting(fromUser, -(nAmount+totalTax)))
+		if totalTax > 0 {
+			postings = append(postings, accountPosting(LedgerTax, totalTax))
+		}
+	}
+	if toUser.GetUsername() == "rotur" {
+		postings = append(postings, accountPosting(LedgerSink, nAmount))
+	} else {
+		postings = append(postings, userPosting(toUser, nAmount))
+	}
+
 	// Send credits when rotur is the sender
+	var taxUser User
 	if fromUsername == "rotur" {
 		taxRecipient := Username("mist")
 		fromSystem := toUser.GetSystem()
@@ -1069,26 +1250,32 @@ func PerformCreditTransfer(fromUsername, toUsername Username, amount float64, no
 		systemsMutex.RUnlock()
 
 		// Apply tax to taxRecipient if exists
-		if idx := getIdxOfAccountBy("username", string(taxRecipient)); taxRecipient != toUser.GetUsername() && idx != -1 {
-			taxUser, _ := getUserByIdx(idx)
-			newBalance := roundVal(taxUser.GetCredits() + taxRecipientShare)
-			taxUser.SetBalance(newBalance)
-			taxUser.addTransaction(Transaction{
-				Note:      "Daily credit",
-				User:      toUser.GetId(),
-				Timestamp: now,
-				Amount:    taxRecipientShare,
-				Type:      "tax",
-				NewTotal:  newBalance,
-			})
+		if idx := getIdxOfAccountBy("username", string(taxRecipient)); taxRecipientShare > 0 && taxRecipient != toUser.GetUsername() && idx != -1 {
+			if u, err := getUserByIdx(idx); err == nil {
+				taxUser = *u
+				postings = append(postings, accountPosting(LedgerMint, -taxRecipientShare), userPosting(taxUser, taxRecipientShare))
+			}
 		}
 	}
-	// Update balances
-	if fromUser.GetUsername() != "rotur" {
-		fromUser.SetBalance(roundVal(fromCurrency - (nAmount + totalTax)))
+
+	entry, err := postLedger(kind, note, postings...)
+	if err != nil {
+		return 0, err
 	}
-	if toUser.GetUsername() != "rotur" {
-		toUser.SetBalance(roundVal(toCurrency + nAmount))
+	if totalTax > 0 {
+		recordPolicyMovement(PolicyTransferTax, entry, fromUser.GetId(), totalTax)
+	}
+
+	if taxUser != nil {
+		recordPolicyMovement(PolicySystemOwnerReward, entry, taxUser.GetId(), taxRecipientShare)
+		taxUser.addTransaction(Transaction{
+			Note:      "Daily credit",
+			User:      toUser.GetId(),
+			Timestamp: now,
+			Amount:    taxRecipientShare,
+			Type:      "tax",
+			NewTotal:  taxUser.GetCredits(),
+		})
 	}
 
 	// Log transactions
@@ -1097,58 +1284,64 @@ func PerformCreditTransfer(fromUsername, toUsername Username, amount float64, no
 		User:     toUser.GetId(),
 		Amount:   nAmount + totalTax,
 		Type:     "out",
-		NewTotal: fromCurrency - nAmount - totalTax,
+		NewTotal: fromUser.GetCredits(),
 	})
 	toUser.addTransaction(Transaction{
 		Note:     note,
 		User:     fromUser.GetId(),
 		Amount:   nAmount,
 		Type:     "in",
-		NewTotal: toCurrency + nAmount,
+		NewTotal: toUser.GetCredits(),
 	})
 
 	go saveUsers()
 
-	return nil
+	return nAmount + totalTax, nil
 }
 
-func transferCredits(c *gin.Context) {
-	user := c.MustGet("user").(*User)
-
-	var req struct {
-		To     string `json:"to"`
-		Amount any    `json:"amount"`
-		Note   string `json:"note"`
-	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid request payload"})
-		return
+// parseTransferAmount accepts a credit amount or a "£" prefixed amount in pounds, rounded to 2 decimal places
+func parseTransferAmount(v any) (float64, error) {
+	amt := fmt.Sprintf("%v", v)
+	if amt == "" || v == nil {
+		return 0, fmt.Errorf("Amount must be provided")
 	}
-	amt := fmt.Sprintf("%v", req.Amount)
 
-	if amt == "" {
-		c.JSON(400, gin.H{"error": "Amount must be provided"})
-		return
-	}
 	var nAmount float64
 	var err error
 	if after, ok := strings.CutPrefix(amt, "£"); ok {
 		// convert to GBP
 		nAmount, err = strconv.ParseFloat(after, 64)
 		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid amount"})
-			return
+			return 0, fmt.Errorf("Invalid amount")
 		}
 		creditsPerPound := creditsToPence(1) * 100
 		nAmount = nAmount / creditsPerPound
 	} else {
 		nAmount, err = strconv.ParseFloat(amt, 64)
 		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid amount"})
-			return
+			return 0, fmt.Errorf("Invalid amount")
 		}
 	}
-	nAmount = math.Round(nAmount*100) / 100 // round to 2 decimal places
+	return math.Round(nAmount*100) / 100, nil
+}
+
+func transferCredits(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	var req struct {
+		To     string `json:"to"`
+		Amount any    `json:"amount"`
+		Note   string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request payload"})
+		return
+	}
+	nAmount, err := parseTransferAmount(req.Amount)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
 	if nAmount < 0.01 {
 		c.JSON(400, gin.H{"error": "Minimum amount is 0.01"})
 		return
@@ -1164,13 +1357,34 @@ func transferCredits(c *gin.Context) {
 		return
 	}
 
-	err = PerformCreditTransfer(user.GetUsername(), toUsername, nAmount, req.Note)
+	debited, err := performCreditTransfer(user.GetUsername(), toUsername, nAmount, req.Note, true)
+	if errors.Is(err, errTransferHeld) {
+		c.JSON(202, gin.H{"message": "Transfer held for review", "held": true, "to": toUsername, "amount": nAmount})
+		return
+	}
+	if errors.Is(err, errTransferBlocked) {
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(200, gin.H{"message": "Transfer successful", "from": user.GetUsername(), "to": toUsername, "amount": nAmount, "debited": nAmount})
+	if nAmount > SecurityLogTransferThreshold {
+		logSecurityEvent(c, *user, SecTransfer, map[string]any{"to": toUsername, "amount": nAmount})
+	}
+	c.JSON(200, gin.H{"message": "Transfer successful", "from": user.GetUsername(), "to": toUsername, "amount": nAmount, "debited": debited})
+}
+
+// deleteMe schedules the caller's account for deletion
+func deleteMe(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	deleteAt := scheduleUserDeletion(*user)
+	c.JSON(200, gin.H{
+		"message":   "Account scheduled for deletion, log in before the deadline to restore it",
+		"delete_at": deleteAt,
+	})
 }
 
 func deleteUser(c *gin.Context) {
@@ -1189,6 +1403,11 @@ func deleteUser(c *gin.Context) {
 		return
 	}
 
+	if requester == usernameLower {
+		deleteMe(c)
+		return
+	}
+
 	if err := performUserDeletion(username, false, false); err != nil {
 		c.JSON(404, gin.H{"error": err.Error()})
 		return
@@ -1266,12 +1485,12 @@ func transferCreditsAdmin(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	err = PerformCreditTransfer(fromUsername, toUsername, amountNum, note)
+	debited, err := performCreditTransfer(fromUsername, toUsername, amountNum, note, false)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(200, gin.H{"message": "Transfer successful", "from": fromUsername, "to": toUsername, "amount": amountNum, "debited": amountNum})
+	c.JSON(200, gin.H{"message": "Transfer successful", "from": fromUsername, "to": toUsername, "amount": amountNum, "debited": debited})
 }
 
 func removeUserDirectory(path string) error {
@@ -1328,33 +1547,58 @@ func reconnectFriends(_ any) {
 }
 
 func performUserDeletion(username Username, isAdmin bool, ban bool) error {
+	return performUserDeletionIf(username, isAdmin, ban, nil)
+}
+
+// performUserDeletionIf deletes the account only if due, when set, still holds
+// for it. due is checked under usersMutex together with removing the account.
+func performUserDeletionIf(username Username, isAdmin bool, ban bool, due func(User) bool) error {
 	usernameLower := username.ToLower()
 
-	idx := getIdxOfAccountBy("username", string(usernameLower))
+	usersMutex.Lock()
+	idx := -1
+	for i, user := range users {
+		if user.GetUsername().ToLower() == usernameLower {
+			idx = i
+			break
+		}
+	}
 	if idx == -1 {
+		usersMutex.Unlock()
 		return fmt.Errorf("user not found")
 	}
-
-	logPrefix := "Deleting user"
-	if isAdmin {
-		logPrefix = "Admin deleting user"
+	if due != nil && !due(users[idx]) {
+		usersMutex.Unlock()
+		return errDeletionNotDue
 	}
-	log.Printf("%s %s", logPrefix, usernameLower)
-
+	u := users[idx]
 	if ban {
-		usersMutex.Lock()
 		// set as banned
 		users[idx] = User{
 			"username":   username,
-			"email":      users[idx].GetEmail(), // so that the same email cant be used by a banned user
+			"email":      u.GetEmail(), // so that the same email cant be used by a banned user
 			"private":    true,
 			"sys.banned": true,
 		}
-		usersMutex.Unlock()
 	} else {
-		deleteAccountAtIndexFast(idx)
+		users[idx] = users[len(users)-1]
+		users = users[:len(users)-1]
+	}
+	usersMutex.Unlock()
+
+	// whatever is left in the account leaves the economy with it
+	if credits := u.GetCredits(); credits > 0 && u.GetId() != "" {
+		if _, err := postLedger("account_closed", string(usernameLower), userPosting(u, -credits), accountPosting(LedgerSink, credits)); err != nil {
+			log.Printf("Failed to close ledger account for %s: %v", usernameLower, err)
+		}
 	}
 
+	logPrefix := "Deleting user"
+	if isAdmin {
+		logPrefix = "Admin deleting user"
+	}
+	log.Printf("%s %s", logPrefix, usernameLower)
+
 	go broadcastUserUpdate(usernameLower, "sys._deleted", true)
 
 	uId := usernameLower.Id()
@@ -1480,51 +1724,32 @@ func renameUserAvatar(oldUsername, newUsername Username) {
 	}
 }
 
-var dailyClaimMutex sync.Mutex
-
-func canClaimDaily(user *User) float64 {
-	username := user.GetUsername().ToLower()
-
-	claimsData := loadDailyClaims()
-
-	nextClaimTime, ok := claimsData[username]
-	if !ok || nextClaimTime == 0 {
-		return 0
-	}
-
-	currentTime := float64(time.Now().Unix())
-
-	elapsed := currentTime - nextClaimTime
-	if elapsed < 86400 {
-		return 86400 - elapsed
-	}
-
-	return 0
-}
-
 func timeUntilNextClaim(c *gin.Context) {
 	user := c.MustGet("user").(*User)
 
-	username := user.GetUsername().ToLower()
-
-	claimsData := loadDailyClaims()
-
-	nextClaimTime, ok := claimsData[username]
+	state, ok := getDailyClaim(user.GetUsername())
 	if !ok {
 		c.JSON(400, gin.H{"error": "No daily claim found"})
 		return
 	}
 
-	currentTime := float64(time.Now().Unix())
-
-	elapsed := currentTime - nextClaimTime
-	if elapsed < 86400 {
-		waitTime := 86400 - elapsed
-		c.JSON(200, gin.H{"wait_time": waitTime})
-		return
+	now := time.Now()
+	next, wait := nextDailyClaim(state, now)
+	if wait > 0 {
+		// the reward for claiming as soon as the wait is over
+		next, _ = nextDailyClaim(state, time.Unix(state.LastClaim+dailyClaimPeriod, 0))
 	}
+	reward, milestone := dailyClaimReward(user.GetSubscriptionBenefits().Daily_Credit_Multipler, next.Streak)
 
-	c.JSON(200, gin.H{"wait_time": 0})
+	c.JSON(200, gin.H{
+		"wait_time":         wait,
+		"streak":            state.Streak,
+		"best_streak":       state.BestStreak,
+		"grace_days_left":   state.graceLeft(),
+		"streak_expires_in": state.streakExpiresIn(now),
+		"next_reward":       reward,
+		"next_milestone":    milestone,
+	})
 }
 
 func claimDaily(c *gin.Context) {
@@ -1532,7 +1757,8 @@ func claimDaily(c *gin.Context) {
 
 	username := user.GetUsername().ToLower()
 
-	waitTime := canClaimDaily(user)
+	prev, _ := getDailyClaim(username)
+	next, waitTime := nextDailyClaim(prev, time.Now())
 	if waitTime > 0 {
 		c.JSON(429, gin.H{
 			"error":      "Daily claim already made",
@@ -1542,49 +1768,42 @@ func claimDaily(c *gin.Context) {
 		return
 	}
 
-	claimsData := loadDailyClaims()
-	currentTime := float64(time.Now().Unix())
-	claimsData[username] = currentTime
-	saveDailyClaims(claimsData)
-
 	benefits := user.GetSubscriptionBenefits()
+	amount, milestone := dailyClaimReward(benefits.Daily_Credit_Multipler, next.Streak)
 
-	PerformCreditTransfer("rotur", username, float64(benefits.Daily_Credit_Multipler), "Daily claim")
-
-	saveUsers()
-
-	c.JSON(200, gin.H{"message": "Daily claim successful"})
-}
-
-// loadDailyClaims loads daily claims data from rotur_daily.json
-func loadDailyClaims() map[Username]float64 {
-	dailyClaimMutex.Lock()
-	defer dailyClaimMutex.Unlock()
-	data, err := os.ReadFile(DAILY_CLAIMS_FILE_PATH)
-	if err != nil {
-		// If file doesn't exist, return empty map
-		return make(map[Username]float64)
+	// alt accounts farming claims from the same IP
+	action, hits := evaluateFraudRules(FraudCheck{Kind: FraudDailyClaim, To: *user, Amount: amount})
+	if action == FraudBlock {
+		c.JSON(403, gin.H{"error": "Daily claim blocked by fraud checks"})
+		return
 	}
 
-	var claimsData map[Username]float64
-	if err := json.Unmarshal(data, &claimsData); err != nil {
-		// If unmarshal fails, return empty map
-		return make(map[Username]float64)
+	if !recordDailyClaim(username, prev, next) {
+		c.JSON(429, gin.H{"error": "Daily claim already made"})
+		return
 	}
 
-	return claimsData
-}
-
-// saveDailyClaims saves daily claims data to rotur_daily.json
-func saveDailyClaims(claimsData map[Username]float64) {
-	dailyClaimMutex.Lock()
-	defer dailyClaimMutex.Unlock()
-	data, err := json.MarshalIndent(claimsData, "", "  ")
-	if err != nil {
+	if action == FraudHold {
+		if _, err := holdTransfer(FraudDailyClaim, nil, *user, amount, "Daily claim", hits); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(202, gin.H{"message": "Daily claim held for review", "held": true, "amount": amount, "streak": next.Streak})
 		return
 	}
 
-	atomicWrite(DAILY_CLAIMS_FILE_PATH, data, 0644)
+	if err := PerformCreditTransfer("rotur", username, amount, "Daily claim"); err == nil {
+		recordPolicyMovement(PolicyDailyClaim, nil, user.GetId(), amount)
+	}
+
+	saveUsers()
+
+	c.JSON(200, gin.H{
+		"message":   "Daily claim successful",
+		"amount":    amount,
+		"streak":    next.Streak,
+		"milestone": milestone,
+	})
 }
 
 func acceptTos(c *gin.Context) {
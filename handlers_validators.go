@@ -66,8 +66,9 @@ func StartValidatorCleanup() {
 }
 
 func generateValidator(c *gin.Context) {
-	authKey := c.Query("auth")
 	user := c.MustGet("user").(*User)
+	// validateToken checks against the account key, whichever token made this request
+	authKey := user.GetKey()
 	key := c.Query("key")
 	if key == "" {
 		c.JSON(400, gin.H{"error": "key is required"})
@@ -75,7 +76,8 @@ func generateValidator(c *gin.Context) {
 	}
 
 	id := user.GetId()
-	timestamp := time.Now().Unix()
+	now := time.Now()
+	timestamp := now.Unix()
 	hashedKey := hashValidator(key, authKey, windowStart(timestamp))
 
 	validatorMutex.Lock()
@@ -86,11 +88,24 @@ func generateValidator(c *gin.Context) {
 	})
 	validatorMutex.Unlock()
 
+	signed, claims, err := signValidator(*user, key, now)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to sign validator"})
+		return
+	}
+
 	c.JSON(200, gin.H{
-		"validator": string(id) + "," + hashedKey,
+		"validator":        string(id) + "," + hashedKey,
+		"signed_validator": signed,
+		"expires_at":       claims.ExpiresAt,
 	})
 }
 
+func getValidatorJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(200, validatorJWKS())
+}
+
 func validateToken(c *gin.Context) {
 	validator := strings.TrimSpace(c.Query("v"))
 	if validator == "" {
@@ -104,6 +119,29 @@ func validateToken(c *gin.Context) {
 		return
 	}
 
+	if isSignedValidator(validator) {
+		claims, err := verifySignedValidator(validator, key, time.Now())
+		if err != nil {
+			c.JSON(200, gin.H{"valid": false, "error": err.Error()})
+			return
+		}
+
+		idToUserMutex.RLock()
+		foundUser, ok := idToUser[claims.Subject]
+		idToUserMutex.RUnlock()
+		if !ok {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"valid":    true,
+			"username": foundUser.GetUsername(),
+			"id":       foundUser.GetId(),
+		})
+		return
+	}
+
 	parts := strings.SplitN(validator, ",", 2)
 	if len(parts) != 2 {
 		c.JSON(400, gin.H{"error": "Invalid validator format"})
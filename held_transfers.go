@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	HeldTransferPending  = "pending"
+	HeldTransferApproved = "approved"
+	HeldTransferRejected = "rejected"
+)
+
+// HeldTransfer is a transfer or daily claim stopped by the fraud rules until an
+// admin looks at it. A held transfer's credits wait in escrow:held, a held
+// daily claim is only minted when approved.
+type HeldTransfer struct {
+	Id         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	FromUserId UserId     `json:"from_user_id,omitempty"`
+	ToUserId   UserId     `json:"to_user_id"`
+	Amount     float64    `json:"amount"`
+	Note       string     `json:"note,omitempty"`
+	Hits       []FraudHit `json:"hits"`
+	Status     string     `json:"status"`
+	CreatedAt  int64      `json:"created_at"`
+	ResolvedAt *int64     `json:"resolved_at,omitempty"`
+	Resolution string     `json:"resolution,omitempty"`
+}
+
+var (
+	heldTransfers      = make([]HeldTransfer, 0)
+	heldTransfersMutex sync.Mutex
+
+	errTransferHeld         = errors.New("transfer held for review")
+	errTransferBlocked      = errors.New("transfer blocked by fraud checks")
+	errHeldTransferDone     = errors.New("held transfer is already resolved")
+	errHeldTransferNotFound = errors.New("held transfer not found")
+	errHeldRecipientGone    = errors.New("recipient no longer exists")
+)
+
+// heldTransferError is errTransferHeld with the id of the hold, so payments made
+// for a payment request or standing order can be settled when it is resolved
+type heldTransferError struct {
+	id string
+}
+
+func (e heldTransferError) Error() string {
+	return errTransferHeld.Error()
+}
+
+func (e heldTransferError) Is(target error) bool {
+	return target == errTransferHeld
+}
+
+// heldTransferId returns the hold behind a held transfer error
+func heldTransferId(err error) (string, bool) {
+	var held heldTransferError
+	if errors.As(err, &held) {
+		return held.id, true
+	}
+	return "", false
+}
+
+func loadHeldTransfers() {
+	heldTransfersMutex.Lock()
+	defer heldTransfersMutex.Unlock()
+
+	data, err := os.ReadFile(HELD_TRANSFERS_FILE_PATH)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading held transfers file: %v", err)
+		}
+		heldTransfers = make([]HeldTransfer, 0)
+		return
+	}
+
+	if err := json.Unmarshal(data, &heldTransfers); err != nil {
+		log.Printf("Error unmarshaling held transfers: %v", err)
+		heldTransfers = make([]HeldTransfer, 0)
+		return
+	}
+
+	log.Printf("Loaded %d held transfers", len(heldTransfers))
+}
+
+// saveHeldTransfersLocked expects heldTransfersMutex to be held
+func saveHeldTransfersLocked() {
+	saveJsonFile(HELD_TRANSFERS_FILE_PATH, heldTransfers)
+}
+
+func findHeldTransferLocked(id string) *HeldTransfer {
+	for i := range heldTransfers {
+		if heldTransfers[i].Id == id {
+			return &heldTransfers[i]
+		}
+	}
+	return nil
+}
+
+func outstandingHeldUnits() int64 {
+	heldTransfersMutex.Lock()
+	defer heldTransfersMutex.Unlock()
+	var total int64
+	for _, h := range heldTransfers {
+		if h.Status == HeldTransferPending && h.FromUserId != "" {
+			total += toMinor(h.Amount)
+		}
+	}
+	return total
+}
+
+// holdTransfer parks a transfer in the review queue, taking the sender's
+// credits now so they can't be spent twice. from is nil for daily claims.
+func holdTransfer(kind string, from User, to User, amount float64, note string, hits []FraudHit) (HeldTransfer, error) {
+	h := HeldTransfer{
+		Id:        uuid.New().String(),
+		Kind:      kind,
+		ToUserId:  to.GetId(),
+		Amount:    amount,
+		Note:      note,
+		Hits:      hits,
+		Status:    HeldTransferPending,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+
+	if len(from) > 0 {
+		h.FromUserId = from.GetId()
+		if _, err := postLedger("transfer_hold", h.Id, userPosting(from, -amount), accountPosting(LedgerHeldTransfers, amount)); err != nil {
+			return h, err
+		}
+		from.addTransaction(Transaction{
+			Note:     note,
+			User:     to.GetId(),
+			Amount:   amount,
+			Type:     "out_held",
+			NewTotal: from.GetCredits(),
+		})
+		addUserEvent(from.GetId(), "transfer_held", map[string]any{
+			"id":     h.Id,
+			"to":     to.GetUsername(),
+			"amount": amount,
+		})
+	}
+
+	heldTransfersMutex.Lock()
+	heldTransfers = append(heldTransfers, h)
+	saveHeldTransfersLocked()
+	heldTransfersMutex.Unlock()
+	return h, nil
+}
+
+// resolveHeldTransfer resolves a held transfer and settles the payment request or
+// standing order that made it. Settling takes their locks, which are held while
+// holding a transfer, so it runs after heldTransfersMutex is released.
+func resolveHeldTransfer(id string, approve bool, note string) (HeldTransfer, error) {
+	heldTransfersMutex.Lock()
+	h := findHeldTransferLocked(id)
+	if h == nil {
+		heldTransfersMutex.Unlock()
+		return HeldTransfer{}, errHeldTransferNotFound
+	}
+	if err := resolveHeldTransferLocked(h, approve, note); err != nil {
+		heldTransfersMutex.Unlock()
+		return *h, err
+	}
+	held := *h
+	saveHeldTransfersLocked()
+	heldTransfersMutex.Unlock()
+
+	settleHeldPaymentRequest(held)
+	settleHeldStandingOrder(held)
+	return held, nil
+}
+
+// resolveHeldTransferLocked pays out an approved transfer or refunds a rejected one
+func resolveHeldTransferLocked(h *HeldTransfer, approve bool, note string) error {
+	if h.Status != HeldTransferPending {
+		return errHeldTransferDone
+	}
+
+	to := getUserById(h.ToUserId)
+	from := getUserById(h.FromUserId)
+	switch {
+	case h.FromUserId == "" && approve:
+		// a held daily claim, minted the same way as an unflagged one
+		if len(to) == 0 {
+			return errHeldRecipientGone
+		}
+		if _, err := performCreditTransfer("rotur", to.GetUsername(), h.Amount, "Daily claim", false); err != nil {
+			return err
+		}
+		recordPolicyMovement(PolicyDailyClaim, nil, to.GetId(), h.Amount)
+	case h.FromUserId != "":
+		recipient, counterparty, kind, txType := to, h.FromUserId, "transfer_hold_release", "in"
+		if !approve {
+			recipient, counterparty, kind, txType = from, h.ToUserId, "transfer_hold_refund", "transfer_refund"
+		}
+		// credits owed to a deleted account leave the economy
+		posting := accountPosting(LedgerSink, h.Amount)
+		if len(recipient) > 0 {
+			posting = userPosting(recipient, h.Amount)
+		}
+		if _, err := postLedger(kind, h.Id, accountPosting(LedgerHeldTransfers, -h.Amount), posting); err != nil {
+			return err
+		}
+		if len(recipient) > 0 {
+			recipient.addTransaction(Transaction{
+				Note:     h.Note,
+				User:     counterparty,
+				Amount:   h.Amount,
+				Type:     txType,
+				NewTotal: recipient.GetCredits(),
+			})
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	h.Status = HeldTransferRejected
+	if approve {
+		h.Status = HeldTransferApproved
+	}
+	h.ResolvedAt = &now
+	h.Resolution = note
+
+	notify := h.ToUserId
+	if h.FromUserId != "" {
+		notify = h.FromUserId
+	}
+	addUserEvent(notify, "transfer_"+h.Status, map[string]any{
+		"id":     h.Id,
+		"kind":   h.Kind,
+		"amount": h.Amount,
+	})
+	return nil
+}
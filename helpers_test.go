@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// swapGlobal replaces *ptr with v under mu and puts the old value back when the
+// test ends
+func swapGlobal[T any](t *testing.T, mu sync.Locker, ptr *T, v T) {
+	mu.Lock()
+	orig := *ptr
+	*ptr = v
+	mu.Unlock()
+
+	t.Cleanup(func() {
+		mu.Lock()
+		*ptr = orig
+		mu.Unlock()
+	})
+}
+
+// MemoryMailer keeps messages instead of sending them
+type MemoryMailer struct {
+	mu   sync.Mutex
+	Sent []MailMessage
+}
+
+func (m *MemoryMailer) Send(msg MailMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Sent = append(m.Sent, msg)
+	return nil
+}
+
+// Last returns the most recent message sent to the address
+func (m *MemoryMailer) Last(to string) (MailMessage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.Sent) - 1; i >= 0; i-- {
+		if strings.EqualFold(m.Sent[i].To, to) {
+			return m.Sent[i], true
+		}
+	}
+	return MailMessage{}, false
+}
+
+// withTestUsers makes the given users the only accounts for the duration of a test
+func withTestUsers(t *testing.T, testUsers ...User) {
+	index := make(map[UserId]User, len(testUsers))
+	for _, u := range testUsers {
+		index[u.GetId()] = u
+	}
+	swapGlobal(t, &usersMutex, &users, testUsers)
+	swapGlobal(t, &idToUserMutex, &idToUser, index)
+	swapGlobal(t, &loginIpIndexMutex, &loginIpIndex, buildLoginIpIndex(testUsers))
+}
+
+// withTempPath points a file path setting into the test's temp dir
+func withTempPath(t *testing.T, path *string, name string) {
+	orig := *path
+	*path = filepath.Join(t.TempDir(), name)
+	t.Cleanup(func() { *path = orig })
+}
+
+// withTestLedger points the ledger at an empty file for the duration of a test.
+// Gifts are emptied too since their escrow is part of reconciliation.
+func withTestLedger(t *testing.T) {
+	withTempPath(t, &LEDGER_FILE_PATH, "ledger.jsonl")
+	swapGlobal(t, &ledgerMutex, &ledgerBalances, make(map[LedgerAccount]int64))
+	swapGlobal(t, &ledgerMutex, &ledgerEntries, 0)
+	swapGlobal(t, &giftsMutex, &gifts, []Gift{})
+}
+
+// TestMain keeps background saves that outlive a test out of the working copy
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "claw-test-*")
+	if err != nil {
+		panic(err)
+	}
+	EVENTS_HISTORY_PATH = filepath.Join(dir, "events_history.json")
+	USERS_FILE_PATH = filepath.Join(dir, "users.json")
+	USERDATA_PATH = filepath.Join(dir, "userdata")
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// withTestEvents gives the test an empty events history
+func withTestEvents(t *testing.T) {
+	dir := t.TempDir()
+	origPath := EVENTS_HISTORY_PATH
+
+	eventsHistoryMutex.Lock()
+	origEvents := eventsHistory
+	EVENTS_HISTORY_PATH = filepath.Join(dir, "events_history.json")
+	eventsHistory = make(map[UserId][]Event)
+	eventsHistoryMutex.Unlock()
+
+	t.Cleanup(func() {
+		// addUserEvent saves in the background, taking the lock waits for saves
+		// in flight before the temp dir goes
+		eventsHistoryMutex.Lock()
+		EVENTS_HISTORY_PATH = origPath
+		eventsHistory = origEvents
+		eventsHistoryMutex.Unlock()
+	})
+}
+
+func withTestStandingOrders(t *testing.T, orders ...StandingOrder) {
+	withTestEvents(t)
+	withTempPath(t, &STANDING_ORDERS_FILE_PATH, "standing_orders.json")
+	swapGlobal(t, &standingOrdersMutex, &standingOrders, orders)
+}
+
+func withTestPaymentRequests(t *testing.T, requests ...PaymentRequest) {
+	withTempPath(t, &PAYMENT_REQUESTS_FILE_PATH, "payment_requests.json")
+	swapGlobal(t, &paymentRequestsMutex, &paymentRequests, requests)
+}
+
+func withTestEscrows(t *testing.T) {
+	withTestEvents(t)
+	withTempPath(t, &ESCROW_CONTRACTS_FILE_PATH, "escrow_contracts.json")
+	swapGlobal(t, &escrowContractsMutex, &escrowContracts, make([]EscrowContract, 0))
+}
+
+func withTestHeldTransfers(t *testing.T) {
+	withTestEvents(t)
+	withTempPath(t, &HELD_TRANSFERS_FILE_PATH, "held_transfers.json")
+	swapGlobal(t, &heldTransfersMutex, &heldTransfers, make([]HeldTransfer, 0))
+}
+
+func withTestGroups(t *testing.T, groups ...*GroupData) {
+	index := make(map[string]*GroupData, len(groups))
+	for _, g := range groups {
+		index[g.Group.Tag] = g
+	}
+	swapGlobal(t, &groupsDataMutex, &groupsData, index)
+}
+
+func withTestDailyClaims(t *testing.T) {
+	withTempPath(t, &DAILY_CLAIMS_FILE_PATH, "rotur_daily.json")
+	swapGlobal(t, &dailyClaimMutex, &dailyClaims, make(map[Username]DailyClaimState))
+	swapGlobal(t, &dailyClaimMutex, &dailyClaimsJournal, 0)
+}
+
+func withTestEconomyPolicy(t *testing.T, policy EconomyPolicy) {
+	withTempPath(t, &ECONOMY_POLICY_FILE_PATH, "economy_policy.json")
+	withTempPath(t, &ECONOMY_POLICY_LEDGER_PATH, "economy_policy_ledger.jsonl")
+	swapGlobal(t, &economyPolicyMutex, &economyPolicy, policy)
+	swapGlobal(t, &economyPolicyMutex, &lastDecayRun, 0)
+}
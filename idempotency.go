@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyHeader    = "Idempotency-Key"
+	IdempotencyKeyMaxLen = 255
+	IdempotencyTTL       = 24 * time.Hour
+	// responses are only kept in memory, a user with this many live keys has to
+	// wait for some to expire before using new ones
+	IdempotencyMaxKeysPerUser = 1000
+	// bodies are read into memory to fingerprint them
+	IdempotencyMaxBodyBytes = 1 << 20
+)
+
+type idempotentResponse struct {
+	Fingerprint string
+	InFlight    bool
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+var (
+	idempotencyStore     = make(map[UserId]map[string]*idempotentResponse) // user id -> key -> first response
+	idempotencyMutex     sync.Mutex
+	idempotencyLastSweep time.Time
+)
+
+// idempotencyRecorder keeps a copy of what the handler writes
+type idempotencyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// pruneIdempotencyLocked drops the user's expired responses, and every user's
+// once a minute so users who stopped sending requests don't keep theirs
+func pruneIdempotencyLocked(userId UserId, now time.Time) {
+	prune := func(id UserId) {
+		for k, r := range idempotencyStore[id] {
+			if !r.InFlight && now.Sub(r.CreatedAt) > IdempotencyTTL {
+				delete(idempotencyStore[id], k)
+			}
+		}
+		if len(idempotencyStore[id]) == 0 {
+			delete(idempotencyStore, id)
+		}
+	}
+
+	if now.Sub(idempotencyLastSweep) < time.Minute {
+		prune(userId)
+		return
+	}
+	idempotencyLastSweep = now
+	for id := range idempotencyStore {
+		prune(id)
+	}
+}
+
+// idempotent replays the first response for a repeated Idempotency-Key. It goes
+// before requireTwoFactor, a one-time code can't be sent twice so a retry has to
+// be answered before the step-up check. Responses are kept in memory only and
+// are forgotten on restart. Requests without the header are not affected.
+func idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > IdempotencyKeyMaxLen {
+			c.JSON(400, gin.H{"error": "Idempotency-Key must be 255 characters or less"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, IdempotencyMaxBodyBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(413, gin.H{"error": "Request body too large"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256(append([]byte(c.Request.Method+" "+c.Request.URL.RequestURI()+"\n"), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		user := c.MustGet("user").(*User)
+		userId := user.GetId()
+		now := time.Now()
+
+		idempotencyMutex.Lock()
+		pruneIdempotencyLocked(userId, now)
+		if prev, ok := idempotencyStore[userId][key]; ok {
+			idempotencyMutex.Unlock()
+			switch {
+			case prev.Fingerprint != fingerprint:
+				c.JSON(422, gin.H{"error": "Idempotency-Key was already used for a different request"})
+			case prev.InFlight:
+				c.JSON(409, gin.H{"error": "A request with this Idempotency-Key is still in progress"})
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(prev.Status, prev.ContentType, prev.Body)
+			}
+			c.Abort()
+			return
+		}
+		if len(idempotencyStore[userId]) >= IdempotencyMaxKeysPerUser {
+			idempotencyMutex.Unlock()
+			c.JSON(429, gin.H{"error": "Too many Idempotency-Keys in use, retry once older ones expire"})
+			c.Abort()
+			return
+		}
+		if idempotencyStore[userId] == nil {
+			idempotencyStore[userId] = make(map[string]*idempotentResponse)
+		}
+		entry := &idempotentResponse{Fingerprint: fingerprint, InFlight: true, CreatedAt: now}
+		idempotencyStore[userId][key] = entry
+		idempotencyMutex.Unlock()
+
+		recorder := &idempotencyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		completed := false
+		defer func() {
+			idempotencyMutex.Lock()
+			defer idempotencyMutex.Unlock()
+			// server errors, panics and failed 2fa or limit checks aren't stored so
+			// the client can retry them
+			if !completed || recorder.Status() >= 500 || recorder.Status() == 401 || recorder.Status() == 403 {
+				delete(idempotencyStore[userId], key)
+				return
+			}
+			entry.InFlight = false
+			entry.Status = recorder.Status()
+			entry.ContentType = recorder.Header().Get("Content-Type")
+			entry.Body = recorder.body.Bytes()
+		}()
+
+		c.Next()
+		completed = true
+	}
+}
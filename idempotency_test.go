@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newIdempotencyTestRouter(handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	user := User{"username": "idemuser", "sys.id": "idem-id-1"}
+	r := gin.New()
+	r.POST("/pay", func(c *gin.Context) { c.Set("user", &user) }, idempotent(), handler)
+	return r
+}
+
+func sendIdempotent(r *gin.Engine, key string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/pay", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(IdempotencyHeader, key)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	var calls atomic.Int32
+	r := newIdempotencyTestRouter(func(c *gin.Context) {
+		n := calls.Add(1)
+		c.JSON(200, gin.H{"call": n})
+	})
+
+	first := sendIdempotent(r, "replay-key", `{"amount":5}`)
+	second := sendIdempotent(r, "replay-key", `{"amount":5}`)
+	if calls.Load() != 1 {
+		t.Fatalf("Handler should run once, ran %d times", calls.Load())
+	}
+	if second.Code != 200 || second.Body.String() != first.Body.String() || second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected the first response to be replayed, got %d %s", second.Code, second.Body.String())
+	}
+
+	if w := sendIdempotent(r, "replay-key", `{"amount":6}`); w.Code != 422 {
+		t.Errorf("Reusing a key for another request should fail, got %d", w.Code)
+	}
+
+	sendIdempotent(r, "", `{"amount":5}`)
+	sendIdempotent(r, "", `{"amount":5}`)
+	if calls.Load() != 3 {
+		t.Errorf("Requests without a key should always run, ran %d times", calls.Load())
+	}
+}
+
+func TestIdempotentInFlightAndErrors(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var calls atomic.Int32
+	r := newIdempotencyTestRouter(func(c *gin.Context) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-release
+			c.JSON(500, gin.H{"error": "boom"})
+			return
+		}
+		c.JSON(200, gin.H{"ok": true})
+	})
+
+	done := make(chan int)
+	go func() { done <- sendIdempotent(r, "slow-key", `{}`).Code }()
+	<-started
+
+	if w := sendIdempotent(r, "slow-key", `{}`); w.Code != 409 {
+		t.Errorf("Duplicate of an in-flight request should be rejected, got %d", w.Code)
+	}
+	close(release)
+	if code := <-done; code != 500 {
+		t.Fatalf("Expected the first request to fail, got %d", code)
+	}
+
+	if w := sendIdempotent(r, "slow-key", `{}`); w.Code != 200 {
+		t.Errorf("A failed request should be retryable with the same key, got %d", w.Code)
+	}
+}
+
+func TestIdempotentStepUpRetry(t *testing.T) {
+	var calls atomic.Int32
+	r := newIdempotencyTestRouter(func(c *gin.Context) {
+		if calls.Add(1) == 1 {
+			c.JSON(401, gin.H{"error": "Two-factor code required", "totp_required": true})
+			return
+		}
+		c.JSON(200, gin.H{"ok": true})
+	})
+
+	if w := sendIdempotent(r, "stepup-key", `{}`); w.Code != 401 {
+		t.Fatalf("Expected the step-up challenge, got %d", w.Code)
+	}
+	if w := sendIdempotent(r, "stepup-key", `{}`); w.Code != 200 || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("Retry with the code should run, got %d", w.Code)
+	}
+	if w := sendIdempotent(r, "stepup-key", `{}`); w.Header().Get("Idempotent-Replayed") != "true" || calls.Load() != 2 {
+		t.Errorf("Completed request should be replayed without another step-up, ran %d times", calls.Load())
+	}
+}
+
+func TestIdempotencyStoreBoundedPerUser(t *testing.T) {
+	now := time.Now()
+	full := make(map[string]*idempotentResponse, IdempotencyMaxKeysPerUser)
+	for i := 0; i < IdempotencyMaxKeysPerUser; i++ {
+		full[fmt.Sprint("key-", i)] = &idempotentResponse{CreatedAt: now}
+	}
+	other := map[string]*idempotentResponse{"theirs": {CreatedAt: now}}
+	swapGlobal(t, &idempotencyMutex, &idempotencyStore, map[UserId]map[string]*idempotentResponse{"idem-id-1": full, "idem-other": other})
+
+	r := newIdempotencyTestRouter(func(c *gin.Context) { c.JSON(200, gin.H{"ok": true}) })
+	if w := sendIdempotent(r, "one-more", `{}`); w.Code != 429 {
+		t.Fatalf("A user at the key limit should be refused, got %d", w.Code)
+	}
+	idempotencyMutex.Lock()
+	_, kept := idempotencyStore["idem-other"]["theirs"]
+	userKeys := len(idempotencyStore["idem-id-1"])
+	idempotencyMutex.Unlock()
+	if !kept || userKeys != IdempotencyMaxKeysPerUser {
+		t.Error("Live keys should never be evicted to make room")
+	}
+
+	idempotencyMutex.Lock()
+	for _, entry := range full {
+		entry.CreatedAt = now.Add(-IdempotencyTTL - time.Minute)
+	}
+	idempotencyMutex.Unlock()
+	if w := sendIdempotent(r, "one-more", `{}`); w.Code != 200 {
+		t.Errorf("Expired keys should free up room, got %d", w.Code)
+	}
+}
+
+func TestIdempotentBodyLimit(t *testing.T) {
+	swapGlobal(t, &idempotencyMutex, &idempotencyStore, make(map[UserId]map[string]*idempotentResponse))
+	r := newIdempotencyTestRouter(func(c *gin.Context) { c.JSON(200, gin.H{"ok": true}) })
+	if w := sendIdempotent(r, "big", strings.Repeat("x", IdempotencyMaxBodyBytes+1)); w.Code != 413 {
+		t.Errorf("Oversized body should be refused, got %d", w.Code)
+	}
+}
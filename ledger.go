@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every credit movement is a balanced entry in an append-only journal. Amounts are
+// integer minor units (1 credit = 100), so nothing is rounded along the way.
+// sys.currency is still what the rest of the code reads, the ledger is what it is
+// checked against.
+
+const CreditMinorUnits = 100
+
+type LedgerAccount string
+
+const (
+	LedgerMint          LedgerAccount = "system:mint"       // credits entering the economy (daily claims, purchases)
+	LedgerSink          LedgerAccount = "system:sink"       // credits spent on the platform
+	LedgerTax           LedgerAccount = "system:tax"        // fees and taxes kept by the platform
+	LedgerOpening       LedgerAccount = "system:opening"    // balances that existed before the ledger
+	LedgerAdjustment    LedgerAccount = "system:adjustment" // admin corrections
+	LedgerGiftEscrow    LedgerAccount = "escrow:gifts"      // unclaimed gifts
+	LedgerDevfund       LedgerAccount = "escrow:devfund"    // petition escrow
+	LedgerTradeEscrow   LedgerAccount = "escrow:trades"     // credits held by escrow contracts
+	LedgerHeldTransfers LedgerAccount = "escrow:held"       // transfers waiting for fraud review
+)
+
+func userLedgerAccount(id UserId) LedgerAccount {
+	return LedgerAccount("user:" + string(id))
+}
+
+func groupLedgerAccount(tag string) LedgerAccount {
+	return LedgerAccount("group:" + strings.ToLower(tag))
+}
+
+func (a LedgerAccount) isUser() bool {
+	return strings.HasPrefix(string(a), "user:")
+}
+
+func toMinor(credits float64) int64 {
+	return int64(math.Round(credits * CreditMinorUnits))
+}
+
+func fromMinor(units int64) float64 {
+	return float64(units) / CreditMinorUnits
+}
+
+// Posting moves Amount minor units into an account, negative amounts move them out
+type Posting struct {
+	Account LedgerAccount `json:"account"`
+	Amount  int64         `json:"amount"`
+
+	user User // set for user accounts so the entry also updates sys.currency
+}
+
+type LedgerEntry struct {
+	Id        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Memo      string    `json:"memo,omitempty"`
+	Timestamp int64     `json:"ts"`
+	Postings  []Posting `json:"postings"`
+}
+
+func (e LedgerEntry) sum() int64 {
+	var total int64
+	for _, p := range e.Postings {
+		total += p.Amount
+	}
+	return total
+}
+
+func userPosting(u User, credits float64) Posting {
+	return Posting{Account: userLedgerAccount(u.GetId()), Amount: toMinor(credits), user: u}
+}
+
+// keyPaymentPostings splits a key payment, the creator gets 90% and the rest is kept as tax
+func keyPaymentPostings(payer User, creator User, price float64) []Posting {
+	total := toMinor(price)
+	postings := []Posting{{Account: userLedgerAccount(payer.GetId()), Amount: -total, user: payer}}
+	if len(creator) == 0 || creator.GetId() == payer.GetId() {
+		return append(postings, Posting{Account: LedgerSink, Amount: total})
+	}
+	share := total * 9 / 10
+	return append(postings,
+		Posting{Account: userLedgerAccount(creator.GetId()), Amount: share, user: creator},
+		Posting{Account: LedgerTax, Amount: total - share},
+	)
+}
+
+func accountPosting(account LedgerAccount, credits float64) Posting {
+	return Posting{Account: account, Amount: toMinor(credits)}
+}
+
+var (
+	errInsufficientCredits = errors.New("insufficient funds")
+	errUnbalancedEntry     = errors.New("ledger entry does not balance")
+)
+
+var (
+	ledgerMutex    sync.Mutex
+	ledgerBalances = make(map[LedgerAccount]int64)
+	ledgerEntries  int
+)
+
+// postLedger records a balanced entry and applies its user postings to sys.currency.
+// Nothing is applied if a user would go below zero or the entry can't be written.
+func postLedger(kind string, memo string, postings ...Posting) (*LedgerEntry, error) {
+	merged := make([]Posting, 0, len(postings))
+	for _, p := range postings {
+		if p.Amount == 0 {
+			continue
+		}
+		if p.user != nil && p.user.GetId() == "" {
+			return nil, fmt.Errorf("user account has no id")
+		}
+		merged = append(merged, p)
+	}
+	entry := &LedgerEntry{
+		Id:        newLedgerId(),
+		Kind:      kind,
+		Memo:      memo,
+		Timestamp: time.Now().UnixMilli(),
+		Postings:  merged,
+	}
+	if len(merged) == 0 {
+		return entry, nil
+	}
+	if entry.sum() != 0 {
+		return nil, errUnbalancedEntry
+	}
+
+	ledgerMutex.Lock()
+	defer ledgerMutex.Unlock()
+
+	// check every debit first so an entry is applied completely or not at all
+	newBalances := make(map[LedgerAccount]int64, len(merged))
+	for _, p := range merged {
+		if p.user == nil {
+			continue
+		}
+		bal, ok := newBalances[p.Account]
+		if !ok {
+			bal = toMinor(p.user.GetCredits())
+		}
+		bal += p.Amount
+		if p.Amount < 0 && bal < 0 {
+			return nil, errInsufficientCredits
+		}
+		newBalances[p.Account] = bal
+	}
+
+	if err := appendLedgerEntryLocked(entry); err != nil {
+		log.Printf("Failed to write ledger entry %s: %v", kind, err)
+		return nil, fmt.Errorf("failed to record transaction")
+	}
+
+	for _, p := range merged {
+		ledgerBalances[p.Account] += p.Amount
+		if p.user != nil {
+			p.user.Set("sys.currency", fromMinor(newBalances[p.Account]))
+		}
+	}
+	ledgerEntries++
+	return entry, nil
+}
+
+func newLedgerId() string {
+	b := make([]byte, 10)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func appendLedgerEntryLocked(entry *LedgerEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(LEDGER_FILE_PATH), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(LEDGER_FILE_PATH, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// replayLedger rebuilds balances from the journal, counting entries that don't balance
+func replayLedger() (map[LedgerAccount]int64, int, int, error) {
+	balances := make(map[LedgerAccount]int64)
+	f, err := os.Open(LEDGER_FILE_PATH)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return balances, 0, 0, nil
+		}
+		return nil, 0, 0, err
+	}
+	defer f.Close()
+
+	entries, unbalanced := 0, 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var e LedgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			unbalanced++
+			continue
+		}
+		entries++
+		if e.sum() != 0 {
+			unbalanced++
+		}
+		for _, p := range e.Postings {
+			balances[p.Account] += p.Amount
+		}
+	}
+	return balances, entries, unbalanced, scanner.Err()
+}
+
+// loadLedger replays the journal, on first start it opens with the balances users
+// and gifts already hold
+func loadLedger() {
+	ledgerMutex.Lock()
+	balances, entries, unbalanced, err := replayLedger()
+	if err != nil {
+		ledgerMutex.Unlock()
+		log.Printf("Error reading ledger: %v", err)
+		return
+	}
+	ledgerBalances, ledgerEntries = balances, entries
+	ledgerMutex.Unlock()
+
+	if unbalanced > 0 {
+		log.Printf("[ledger] WARNING: %d unreadable or unbalanced entries", unbalanced)
+	}
+	if entries > 0 {
+		log.Printf("Loaded ledger with %d entries", entries)
+		return
+	}
+
+	postings := make([]Posting, 0)
+	var total int64
+	usersMutex.RLock()
+	for _, u := range users {
+		id := u.GetId()
+		if id == "" {
+			continue
+		}
+		if units := toMinor(u.GetCredits()); units != 0 {
+			postings = append(postings, Posting{Account: userLedgerAccount(id), Amount: units})
+			total += units
+		}
+	}
+	usersMutex.RUnlock()
+
+	for account, units := range storedAccountBalances() {
+		if units != 0 {
+			postings = append(postings, Posting{Account: account, Amount: units})
+			total += units
+		}
+	}
+	if len(postings) == 0 {
+		return
+	}
+	postings = append(postings, Posting{Account: LedgerOpening, Amount: -total})
+	if _, err := postLedger("opening_balance", "", postings...); err != nil {
+		log.Printf("Failed to open ledger: %v", err)
+		return
+	}
+	log.Printf("Opened ledger with %d balances", len(postings)-1)
+}
+
+// storedAccountBalances is what the escrow and group accounts should hold
+// according to the open gifts, contracts and held transfers and the group balances
+func storedAccountBalances() map[LedgerAccount]int64 {
+	balances := map[LedgerAccount]int64{
+		LedgerGiftEscrow:    outstandingGiftUnits(),
+		LedgerTradeEscrow:   outstandingContractUnits(),
+		LedgerHeldTransfers: outstandingHeldUnits(),
+	}
+	groupsDataMutex.RLock()
+	for tag, data := range groupsData {
+		balances[groupLedgerAccount(tag)] += toMinor(data.Group.CreditsBalance)
+	}
+	groupsDataMutex.RUnlock()
+	return balances
+}
+
+func outstandingGiftUnits() int64 {
+	giftsMutex.RLock()
+	defer giftsMutex.RUnlock()
+	var total int64
+	for _, g := range gifts {
+		total += toMinor(g.Outstanding())
+	}
+	return total
+}
+
+func getLedgerBalance(account LedgerAccount) int64 {
+	ledgerMutex.Lock()
+	defer ledgerMutex.Unlock()
+	return ledgerBalances[account]
+}
+
+type LedgerDrift struct {
+	Username   Username      `json:"username,omitempty"`
+	Account    LedgerAccount `json:"account"`
+	Ledger     float64       `json:"ledger"`
+	Stored     float64       `json:"stored"`
+	Difference float64       `json:"difference"`
+}
+
+type LedgerReport struct {
+	Entries           int                       `json:"entries"`
+	UnbalancedEntries int                       `json:"unbalanced_entries"`
+	ReplayMismatches  []LedgerAccount           `json:"replay_mismatches"`
+	TrialBalance      float64                   `json:"trial_balance"`
+	SystemAccounts    map[LedgerAccount]float64 `json:"system_accounts"`
+	UsersChecked      int                       `json:"users_checked"`
+	Drift             []LedgerDrift             `json:"drift"`
+	Orphaned          []LedgerDrift             `json:"orphaned"`
+	TotalDrift        float64                   `json:"total_drift"`
+	GeneratedAt       int64                     `json:"generated_at"`
+}
+
+// reconcileLedger compares stored balances with the ledger. The journal is replayed
+// as well so damage to the file or the in-memory totals shows up too.
+func reconcileLedger() (*LedgerReport, error) {
+	replayed, entries, unbalanced, err := replayLedger()
+	if err != nil {
+		return nil, err
+	}
+
+	ledgerMutex.Lock()
+	balances := make(map[LedgerAccount]int64, len(ledgerBalances))
+	for k, v := range ledgerBalances {
+		balances[k] = v
+	}
+	ledgerMutex.Unlock()
+
+	report := &LedgerReport{
+		Entries:           entries,
+		UnbalancedEntries: unbalanced,
+		ReplayMismatches:  []LedgerAccount{},
+		SystemAccounts:    make(map[LedgerAccount]float64),
+		Drift:             []LedgerDrift{},
+		Orphaned:          []LedgerDrift{},
+		GeneratedAt:       time.Now().UnixMilli(),
+	}
+
+	var trial int64
+	for account, units := range balances {
+		trial += units
+		if replayed[account] != units {
+			report.ReplayMismatches = append(report.ReplayMismatches, account)
+		}
+		if !account.isUser() {
+			report.SystemAccounts[account] = fromMinor(units)
+		}
+	}
+	for account, units := range replayed {
+		if _, ok := balances[account]; !ok && units != 0 {
+			report.ReplayMismatches = append(report.ReplayMismatches, account)
+		}
+	}
+	report.TrialBalance = fromMinor(trial)
+
+	var totalDrift int64
+	seen := make(map[LedgerAccount]bool)
+	usersMutex.RLock()
+	for _, u := range users {
+		id := u.GetId()
+		if id == "" {
+			continue
+		}
+		account := userLedgerAccount(id)
+		seen[account] = true
+		report.UsersChecked++
+
+		stored := toMinor(u.GetCredits())
+		if diff := stored - balances[account]; diff != 0 {
+			totalDrift += diff
+			report.Drift = append(report.Drift, LedgerDrift{
+				Username:   u.GetUsername(),
+				Account:    account,
+				Ledger:     fromMinor(balances[account]),
+				Stored:     fromMinor(stored),
+				Difference: fromMinor(diff),
+			})
+		}
+	}
+	usersMutex.RUnlock()
+
+	for account, units := range balances {
+		if account.isUser() && !seen[account] && units != 0 {
+			report.Orphaned = append(report.Orphaned, LedgerDrift{Account: account, Ledger: fromMinor(units)})
+		}
+	}
+
+	for account, held := range storedAccountBalances() {
+		if held != balances[account] {
+			report.Drift = append(report.Drift, LedgerDrift{
+				Account:    account,
+				Ledger:     fromMinor(balances[account]),
+				Stored:     fromMinor(held),
+				Difference: fromMinor(held - balances[account]),
+			})
+		}
+	}
+
+	sort.Slice(report.Drift, func(i, j int) bool {
+		return math.Abs(report.Drift[i].Difference) > math.Abs(report.Drift[j].Difference)
+	})
+	report.TotalDrift = fromMinor(totalDrift)
+	return report, nil
+}
+
+// adjustLedgerDrift books the differences in a report so the ledger matches the
+// stored balances again. Stored balances are left alone.
+func adjustLedgerDrift(report *LedgerReport) (*LedgerEntry, error) {
+	postings := make([]Posting, 0, len(report.Drift)+1)
+	var total int64
+	for _, d := range report.Drift {
+		units := toMinor(d.Difference)
+		postings = append(postings, Posting{Account: d.Account, Amount: units})
+		total += units
+	}
+	if len(postings) == 0 {
+		return nil, nil
+	}
+	postings = append(postings, Posting{Account: LedgerAdjustment, Amount: -total})
+	return postLedger("reconcile_adjustment", "", postings...)
+}
+
+// setBalanceByAdjustment books the difference to a new balance against the adjustment account
+func setBalanceByAdjustment(u User, balance float64, memo string) error {
+	delta := toMinor(balance) - toMinor(u.GetCredits())
+	if delta == 0 {
+		return nil
+	}
+	_, err := postLedger("admin_adjustment", memo,
+		Posting{Account: userLedgerAccount(u.GetId()), Amount: delta, user: u},
+		Posting{Account: LedgerAdjustment, Amount: -delta},
+	)
+	return err
+}
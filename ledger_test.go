@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPostLedger(t *testing.T) {
+	withTestLedger(t)
+
+	alice := User{"username": "alice", "sys.id": "ledger-alice", "sys.currency": 10.0}
+	bob := User{"username": "bob", "sys.id": "ledger-bob", "sys.currency": 0.0}
+	withTestUsers(t, alice, bob)
+	loadLedger()
+
+	if _, err := postLedger("transfer", "", userPosting(alice, -0.1), userPosting(bob, 0.2)); err != errUnbalancedEntry {
+		t.Errorf("Expected unbalanced entry to be rejected, got %v", err)
+	}
+	if _, err := postLedger("transfer", "", userPosting(alice, -10.01), userPosting(bob, 10.01)); err != errInsufficientCredits {
+		t.Errorf("Expected overdraft to be rejected, got %v", err)
+	}
+
+	// 0.1 + 0.2 style amounts must not drift
+	for range 3 {
+		if _, err := postLedger("transfer", "", userPosting(alice, -0.1), userPosting(bob, 0.1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if alice.GetCredits() != 9.7 || bob.GetCredits() != 0.3 {
+		t.Errorf("Expected 9.7 and 0.3, got %v and %v", alice.GetCredits(), bob.GetCredits())
+	}
+	if got := getLedgerBalance(userLedgerAccount("ledger-alice")); got != 970 {
+		t.Errorf("Expected ledger balance 970, got %d", got)
+	}
+
+	report, err := reconcileLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Drift) != 0 || len(report.ReplayMismatches) != 0 || report.TrialBalance != 0 || report.Entries != 4 {
+		t.Errorf("Expected a clean report, got %+v", report)
+	}
+}
+
+func TestReconcileLedgerFlagsDrift(t *testing.T) {
+	withTestLedger(t)
+
+	carol := User{"username": "carol", "sys.id": "ledger-carol", "sys.currency": 5.0}
+	withTestUsers(t, carol)
+	loadLedger()
+
+	// a write that bypassed the ledger
+	carol.Set("sys.currency", 7.5)
+
+	report, err := reconcileLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Drift) != 1 || report.Drift[0].Difference != 2.5 || report.TotalDrift != 2.5 {
+		t.Fatalf("Expected 2.5 of drift on carol, got %+v", report.Drift)
+	}
+
+	if _, err := adjustLedgerDrift(report); err != nil {
+		t.Fatal(err)
+	}
+	report, _ = reconcileLedger()
+	if len(report.Drift) != 0 || report.SystemAccounts[LedgerAdjustment] != -2.5 {
+		t.Errorf("Adjustment should clear the drift, got %+v", report)
+	}
+	if carol.GetCredits() != 7.5 {
+		t.Errorf("Adjustment should not change the stored balance, got %v", carol.GetCredits())
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends account emails, SMTP in production and in memory in tests
+type Mailer interface {
+	Send(msg MailMessage) error
+}
+
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg MailMessage) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid mail header")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	return smtp.SendMail(addr, auth, m.From, []string{msg.To}, []byte(b.String()))
+}
+
+// LogMailer drops messages and only logs who they were for, used when SMTP is not
+// configured. Bodies carry tokens so they are never logged.
+type LogMailer struct{}
+
+func (LogMailer) Send(msg MailMessage) error {
+	log.Printf("[mailer] SMTP not configured, dropped %q to %s", msg.Subject, msg.To)
+	return nil
+}
+
+var mailer Mailer = LogMailer{}
+
+func initMailer() {
+	if SMTP_HOST == "" {
+		log.Printf("[config] WARNING: SMTP_HOST not set, account emails will not be delivered")
+		mailer = LogMailer{}
+		return
+	}
+	mailer = &SMTPMailer{
+		Host:     SMTP_HOST,
+		Port:     SMTP_PORT,
+		Username: SMTP_USERNAME,
+		Password: SMTP_PASSWORD,
+		From:     MAIL_FROM,
+	}
+}
@@ -24,23 +24,42 @@ func main() {
 	loadSystems()
 	loadEventsHistory()
 	loadGifts()
+	loadEscrowContracts()
+	loadHeldTransfers()
+	loadDailyClaims()
+	loadEconomyPolicy()
+	loadLedger()
+	loadStandingOrders()
+	loadPaymentRequests()
+	loadEconomySnapshots()
 	loadCosmeticsCatalog()
+	loadOAuthApps()
+	loadValidatorKeys()
+	initMailer()
 	buildSubTokenIndex()
+	buildPasskeyIndex()
+	buildSessionIndex()
 	// doAfter(reconnectFriends, nil, time.Second*20)
 
 	if err := loadJSONBadges(); err != nil {
 		log.Printf("Warning: Failed to load badges.json: %v", err)
 	}
 
+	registerDataFileWatchers()
+
 	fmt.Println("Completed loading data")
 
 	go cleanRateLimitStorage()
 	go checkSubscriptions()
-	go watchUsersFile()
-	go watchBadgesFile()
+	go runStandingOrders()
+	go runGroupBenefitBilling()
+	go startFileWatcher()
 	go cleanExpiredGifts()
+	go cleanExpiredEscrows()
+	go runEconomySnapshots()
 	go cleanExpiredSubTokens()
-	// go enactInactivityTax()
+	go purgePendingDeletions()
+	go runEconomyPolicy()
 	go startStandingRecoveryChecker()
 
 	gin.SetMode(gin.ReleaseMode)
@@ -73,6 +92,7 @@ func main() {
 	stats := r.Group("/stats")
 	{
 		stats.GET("/economy", rateLimit("default"), getEconomyStats)
+		stats.GET("/economy/history", rateLimit("default"), getEconomyHistory)
 		stats.GET("/users", rateLimit("default"), getUserStats)
 		// stats.GET("/rich", rateLimit("default"), getRichList)
 		stats.GET("/most_gained", rateLimit("default"), getMostGained)
@@ -89,6 +109,7 @@ func main() {
 	// Validator endpoints
 	r.GET("/generate_validator", requiresAuth, requirePermission(PermGenerateValidator), generateValidator)
 	r.GET("/validate", validateToken)
+	r.GET("/.well-known/jwks.json", getValidatorJWKS)
 
 	// Items endpoints
 	items := r.Group("/items")
@@ -98,7 +119,7 @@ func main() {
 		items.GET("/list/:username", listItems)
 		items.GET("/selling", getSellingItems)
 
-		items.GET("/buy/:name", requiresAuth, requirePermission(PermBuyItems), requireStanding(StandingWarning), buyItem)
+		items.GET("/buy/:name", requiresAuth, requirePermission(PermBuyItems), requireStanding(StandingWarning), idempotent(), buyItem)
 		items.GET("/transfer/:name", requiresAuth, requirePermission(PermManageItems), requireStanding(StandingGood), transferItem)
 		items.GET("/sell/:name", requiresAuth, requirePermission(PermSellItems), requireStanding(StandingGood), sellItem)
 		items.GET("/stop_selling/:name", requiresAuth, requirePermission(PermSellItems), stopSellingItem)
@@ -122,7 +143,7 @@ func main() {
 		keys.GET("/delete/:id", requiresAuth, requirePermission(PermManageKeys), deleteKey)
 		keys.GET("/admin_add/:id", requiresAuth, requirePermission(PermManageKeys), adminAddUserToKey)
 		keys.GET("/admin_remove/:id", requiresAuth, requirePermission(PermManageKeys), adminRemoveUserFromKey)
-		keys.GET("/buy/:id", requiresAuth, requirePermission(PermManageKeys), buyKey)
+		keys.GET("/buy/:id", requiresAuth, requirePermission(PermManageKeys), idempotent(), buyKey)
 		keys.GET("/cancel/:id", requiresAuth, requirePermission(PermManageKeys), cancelKey)
 		keys.GET("/debug_subscriptions", requiresAuth, requirePermission(PermViewKeys), debugSubscriptionsEndpoint)
 	}
@@ -141,6 +162,16 @@ func main() {
 		admin.POST("/set_standing", setStandingAdmin)
 		admin.POST("/get_standing_history", getStandingHistoryAdmin)
 		admin.POST("/recover_standing", recoverStandingAdmin)
+		admin.POST("/get_security_log", getSecurityLogAdmin)
+		admin.POST("/ledger_reconcile", reconcileLedgerAdmin)
+		admin.POST("/escrow_disputes", getEscrowDisputesAdmin)
+		admin.POST("/escrow_resolve", resolveEscrowAdmin)
+		admin.POST("/held_transfers", getHeldTransfersAdmin)
+		admin.POST("/held_transfer_resolve", resolveHeldTransferAdmin)
+		admin.POST("/economy_policy", getEconomyPolicyAdmin)
+		admin.POST("/economy_policy_simulate", simulateEconomyPolicyAdmin)
+		admin.POST("/economy_policy_run", runEconomyPolicyAdmin)
+		admin.POST("/economy_policy_movements", getPolicyMovementsAdmin)
 	}
 
 	// Standing endpoints
@@ -158,15 +189,64 @@ func main() {
 	{
 		auth.POST("/rotur", rateLimit("register"), registerUser)
 		auth.POST("/google", rateLimit("profile"), handleUserGoogle)
+		auth.POST("/passkey/begin", rateLimit("profile"), beginPasskeyLogin)
+		auth.POST("/passkey/finish", rateLimit("register"), finishPasskeyLogin)
+		auth.POST("/email/verify", rateLimit("profile"), confirmEmailVerification)
+		auth.POST("/password/forgot", rateLimit("email"), forgotPassword)
+		auth.POST("/password/reset", rateLimit("register"), resetPassword)
 	}
 
 	me := r.Group("/me")
 	{
 		me.POST("/update", updateUser)
-		me.POST("/refresh_token", requiresAuth, requireMainToken(), refreshToken)
-		me.POST("/transfer", requiresAuth, requirePermission(PermTransferCredits), transferCredits)
+		me.POST("/refresh_token", requiresAuth, requireMainToken(), requireTwoFactor(nil), refreshToken)
+		me.POST("/transfer", requiresAuth, requirePermission(PermTransferCredits), idempotent(), requireTwoFactor(transferAboveStepUpThreshold), limitTokenTransfer(), transferCredits)
 		me.POST("/gamble", requiresAuth, requirePermission(PermManageCredits), gambleCredits)
-		me.DELETE("/delete", requiresAuth, requirePermission(PermDeleteAccount), deleteUserKey)
+		me.DELETE("/delete", requiresAuth, requirePermission(PermDeleteAccount), requireTwoFactor(nil), deleteMe)
+
+		// two-factor authentication
+		me.GET("/2fa", requiresAuth, requireMainToken(), getTwoFactorStatus)
+		me.POST("/2fa/setup", requiresAuth, requireMainToken(), setupTwoFactor)
+		me.POST("/2fa/enable", requiresAuth, requireMainToken(), enableTwoFactor)
+		me.POST("/2fa/disable", requiresAuth, requireMainToken(), disableTwoFactor)
+		me.POST("/2fa/backup_codes", requiresAuth, requireMainToken(), regenerateBackupCodes)
+
+		// device sessions
+		me.GET("/sessions", requiresAuth, requireMainToken(), getSessions)
+		me.DELETE("/sessions", requiresAuth, requireMainToken(), revokeOtherSessions)
+		me.DELETE("/sessions/:id", requiresAuth, requireMainToken(), revokeSession)
+		me.POST("/logout", requiresAuth, requireMainToken(), logoutSession)
+
+		// email verification
+		me.GET("/email", requiresAuth, requirePermission(PermViewProfile), getEmailStatus)
+		me.POST("/email/verify", requiresAuth, requireMainToken(), requestEmailVerification)
+
+		// transaction history
+		me.GET("/transactions", requiresAuth, requirePermission(PermViewCredits), getMyTransactions)
+
+		// standing orders
+		me.GET("/standing_orders", requiresAuth, requirePermission(PermViewCredits), listStandingOrders)
+		me.POST("/standing_orders", requiresAuth, requirePermission(PermTransferCredits), requireStanding(StandingGood), requireTwoFactor(nil), createStandingOrder)
+		me.POST("/standing_orders/:id/pause", requiresAuth, requirePermission(PermTransferCredits), pauseStandingOrder)
+		me.POST("/standing_orders/:id/resume", requiresAuth, requirePermission(PermTransferCredits), resumeStandingOrder)
+		me.DELETE("/standing_orders/:id", requiresAuth, requirePermission(PermTransferCredits), cancelStandingOrder)
+
+		// payment requests
+		me.GET("/payment_requests", requiresAuth, requirePermission(PermViewCredits), getPaymentRequests)
+		me.POST("/payment_requests", rateLimit("default"), requiresAuth, requirePermission(PermTransferCredits), requireStanding(StandingGood), createPaymentRequest)
+		me.POST("/payment_requests/:id/accept", requiresAuth, requirePermission(PermTransferCredits), idempotent(), requireTwoFactor(paymentRequestAboveStepUpThreshold), acceptPaymentRequest)
+		me.POST("/payment_requests/:id/decline", requiresAuth, requirePermission(PermTransferCredits), declinePaymentRequest)
+		me.DELETE("/payment_requests/:id", requiresAuth, requirePermission(PermTransferCredits), cancelPaymentRequest)
+
+		// security log
+		me.GET("/security_log", requiresAuth, requireMainToken(), getSecurityLog)
+
+		// passkeys
+		me.GET("/passkeys", requiresAuth, requireMainToken(), listPasskeys)
+		me.POST("/passkeys/register/begin", requiresAuth, requireMainToken(), requireTwoFactor(nil), beginPasskeyRegistration)
+		me.POST("/passkeys/register/finish", requiresAuth, requireMainToken(), finishPasskeyRegistration)
+		me.PATCH("/passkeys/:id", requiresAuth, requireMainToken(), renamePasskeyHandler)
+		me.DELETE("/passkeys/:id", requiresAuth, requireMainToken(), requireTwoFactor(nil), deletePasskeyHandler)
 
 		me.GET("/blocked", requiresAuth, requirePermission(PermViewBlocked), getBlocking)
 		me.POST("/block/:username", requiresAuth, requirePermission(PermManageBlocked), blockUser)
@@ -203,6 +283,21 @@ func main() {
 		groups.GET("/:grouptag/tips", requiresAuth, requirePermission(PermViewGroups), getTips)
 		groups.POST("/:grouptag/tips", requiresAuth, requirePermission(PermManageCredits), sendTip)
 
+		groups.GET("/:grouptag/treasury", requiresAuth, requirePermission(PermViewGroups), getTreasury)
+		groups.PATCH("/:grouptag/treasury/policy", requiresAuth, requirePermission(PermManageGroups), setTreasuryPolicy)
+		groups.POST("/:grouptag/treasury/proposals", requiresAuth, requirePermission(PermManageCredits), requireStanding(StandingGood), createTreasuryProposal)
+		groups.POST("/:grouptag/treasury/proposals/:proposalid/approve", requiresAuth, requirePermission(PermManageCredits), approveTreasuryProposal)
+		groups.POST("/:grouptag/treasury/proposals/:proposalid/execute", requiresAuth, requirePermission(PermManageCredits), requireStanding(StandingGood), executeTreasuryProposal)
+		groups.POST("/:grouptag/treasury/proposals/:proposalid/cancel", requiresAuth, requirePermission(PermManageCredits), cancelTreasuryProposal)
+
+		groups.GET("/:grouptag/products", requiresAuth, requirePermission(PermViewGroups), getGroupProducts)
+		groups.POST("/:grouptag/products", requiresAuth, requirePermission(PermManageGroups), saveGroupProduct)
+		groups.PATCH("/:grouptag/products/:productid", requiresAuth, requirePermission(PermManageGroups), saveGroupProduct)
+		groups.DELETE("/:grouptag/products/:productid", requiresAuth, requirePermission(PermManageGroups), deleteGroupProduct)
+		groups.POST("/:grouptag/products/:productid/purchase", requiresAuth, requirePermission(PermBuyItems), requireStanding(StandingGood), idempotent(), requireTwoFactor(groupProductAboveStepUpThreshold), purchaseGroupProduct)
+		groups.GET("/:grouptag/purchases", requiresAuth, requirePermission(PermViewGroups), getMyGroupPurchases)
+		groups.POST("/:grouptag/purchases/:purchaseid/cancel", requiresAuth, requirePermission(PermBuyItems), cancelGroupPurchase)
+
 		groups.GET("/:grouptag/roles", requiresAuth, requirePermission(PermViewGroups), getRoles)
 		groups.POST("/:grouptag/roles", requiresAuth, requirePermission(PermManageGroups), createRole)
 		groups.PATCH("/:grouptag/roles/:roleid", requiresAuth, requirePermission(PermManageGroups), updateRole)
@@ -218,7 +313,7 @@ func main() {
 
 	r.PATCH("/users", updateUser)
 	r.DELETE("/users", deleteUserKey)
-	r.DELETE("/users/:username", requiresAuth, requirePermission(PermDeleteAccount), deleteUser)
+	r.DELETE("/users/:username", requiresAuth, requirePermission(PermDeleteAccount), requireTwoFactor(nil), deleteUser)
 
 	files := r.Group("/files")
 	{
@@ -255,11 +350,11 @@ func main() {
 	// Linking endpoints
 	link := r.Group("/link")
 	{
-		link.GET("/code", getLinkCode)
-		link.GET("/status", getLinkStatus)
-		link.GET("/user", getLinkedUser)
-
-		link.POST("/code", requiresAuth, requirePermission(PermManageSettings), linkCodeToAccount)
+		// device authorization grant (RFC 8628)
+		link.POST("/device", rateLimit("default"), requestDeviceCode)
+		link.POST("/token", pollDeviceToken)
+		link.GET("/verify", rateLimit("default"), requiresAuth, requireMainToken(), getDeviceVerification)
+		link.POST("/verify", rateLimit("default"), requiresAuth, requireMainToken(), requireTwoFactor(deviceApprovalRequested), approveDeviceVerification)
 	}
 
 	// Services endpoints
@@ -279,7 +374,7 @@ func main() {
 	// DevFund endpoints
 	devfund := r.Group("/devfund")
 	{
-		devfund.POST("/escrow_transfer", requiresAuth, requirePermission(PermTransferCredits), escrowTransfer)
+		devfund.POST("/escrow_transfer", requiresAuth, requirePermission(PermTransferCredits), idempotent(), limitTokenTransfer(), escrowTransfer)
 		devfund.POST("/escrow_release", requiresAuth, requirePermission(PermManageCredits), escrowRelease)
 	}
 
@@ -288,7 +383,7 @@ func main() {
 		tokens.GET("/permissions", listPermissions)
 		tokens.GET("", requiresAuth, requirePermission(PermManageTokens), listSubTokens)
 		tokens.GET("/active", requiresAuth, requirePermission(PermManageTokens), listActiveSubTokens)
-		tokens.POST("/create", requiresAuth, requireMainToken(), createSubToken)
+		tokens.POST("/create", requiresAuth, requireMainToken(), requireTwoFactor(nil), createSubToken)
 		tokens.GET("/:id", requiresAuth, requirePermission(PermManageTokens), getSubToken)
 		tokens.GET("/:id/activity", requiresAuth, requirePermission(PermManageTokens), getSubTokenActivity)
 		tokens.PATCH("/:id", requiresAuth, requireMainToken(), updateSubToken)
@@ -297,10 +392,36 @@ func main() {
 		tokens.DELETE("/:id", requiresAuth, requireMainToken(), deleteSubToken)
 	}
 
+	// OAuth endpoints
+	oauth := r.Group("/oauth")
+	{
+		oauth.GET("/apps", requiresAuth, requireMainToken(), listOAuthApps)
+		oauth.POST("/apps", rateLimit("default"), requiresAuth, requireMainToken(), createOAuthApp)
+		oauth.GET("/apps/:client_id", getOAuthAppInfo)
+		oauth.PATCH("/apps/:client_id", requiresAuth, requireMainToken(), updateOAuthApp)
+		oauth.POST("/apps/:client_id/secret", requiresAuth, requireMainToken(), rotateOAuthAppSecret)
+		oauth.DELETE("/apps/:client_id", requiresAuth, requireMainToken(), deleteOAuthApp)
+		oauth.GET("/authorize", requiresAuth, requireMainToken(), getOAuthConsent)
+		oauth.POST("/authorize", requiresAuth, requireMainToken(), approveOAuthConsent)
+		oauth.POST("/token", rateLimit("default"), oauthToken)
+		oauth.POST("/revoke", rateLimit("default"), oauthRevoke)
+	}
+
+	// Escrow endpoints
+	escrow := r.Group("/escrow")
+	{
+		escrow.POST("/create", rateLimit("default"), requiresAuth, requirePermission(PermTransferCredits), requireStanding(StandingGood), idempotent(), requireTwoFactor(transferAboveStepUpThreshold), limitTokenTransfer(), createEscrow)
+		escrow.GET("/mine", requiresAuth, requirePermission(PermViewCredits), getMyEscrows)
+		escrow.GET("/:id", requiresAuth, requirePermission(PermViewCredits), getEscrow)
+		escrow.POST("/:id/confirm", requiresAuth, requirePermission(PermTransferCredits), confirmEscrow)
+		escrow.POST("/:id/refund", requiresAuth, requirePermission(PermTransferCredits), refundEscrow)
+		escrow.POST("/:id/dispute", requiresAuth, requirePermission(PermTransferCredits), disputeEscrow)
+	}
+
 	// Gifts endpoints
 	gifts := r.Group("/gifts")
 	{
-		gifts.POST("/create", rateLimit("default"), requiresAuth, requirePermission(PermCreateGift), requireStanding(StandingGood), createGift)
+		gifts.POST("/create", rateLimit("default"), requiresAuth, requirePermission(PermCreateGift), requireStanding(StandingGood), idempotent(), createGift)
 		gifts.GET("/:code", getGift)
 		gifts.POST("/claim/:code", rateLimit("default"), requiresAuth, requirePermission(PermClaimGift), requireStanding(StandingWarning), claimGift)
 		gifts.POST("/cancel/:id", requiresAuth, requirePermission(PermCancelGift), cancelGift)
@@ -329,7 +450,7 @@ func main() {
 		cosmetics.GET("/shop", rateLimit("default"), getShop)
 		cosmetics.GET("/items/:id", rateLimit("default"), getCosmeticDetail)
 		cosmetics.GET("/mine", requiresAuth, requirePermission(PermViewProfile), getMyCosmetics)
-		cosmetics.POST("/purchase/:id", rateLimit("default"), requiresAuth, requirePermission(PermBuyItems), requireStanding(StandingWarning), purchaseCosmetic)
+		cosmetics.POST("/purchase/:id", rateLimit("default"), requiresAuth, requirePermission(PermBuyItems), requireStanding(StandingWarning), idempotent(), purchaseCosmetic)
 		cosmetics.POST("/equip/:id", requiresAuth, requirePermission(PermManageProfile), equipCosmetic)
 		cosmetics.POST("/unequip", requiresAuth, requirePermission(PermManageProfile), unequipCosmetic)
 		cosmetics.GET("/overlays/*filepath", rateLimit("default"), serveOverlayAsset)
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OAuthCodeLifetime         = 10 * time.Minute
+	OAuthAccessTokenLifetime  = 1 * time.Hour
+	OAuthRefreshTokenLifetime = 90 * 24 * time.Hour
+	OAuthMaxAppsPerUser       = 10
+	OAuthMaxRedirectURIs      = 10
+)
+
+type OAuthApp struct {
+	ClientId     string   `json:"client_id"`
+	SecretHash   string   `json:"secret_hash,omitempty"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description,omitempty"`
+	Website      string   `json:"website,omitempty"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Owner        UserId   `json:"owner"`
+	CreatedAt    int64    `json:"created_at"`
+}
+
+type OAuthAppPublic struct {
+	ClientId     string   `json:"client_id"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description,omitempty"`
+	Website      string   `json:"website,omitempty"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Owner        Username `json:"owner"`
+	Confidential bool     `json:"confidential"`
+	CreatedAt    int64    `json:"created_at"`
+}
+
+func (a *OAuthApp) ToPublic() OAuthAppPublic {
+	return OAuthAppPublic{
+		ClientId:     a.ClientId,
+		Name:         a.Name,
+		Description:  a.Description,
+		Website:      a.Website,
+		RedirectURIs: a.RedirectURIs,
+		Owner:        a.Owner.User().GetUsername(),
+		Confidential: a.SecretHash != "",
+		CreatedAt:    a.CreatedAt,
+	}
+}
+
+// public clients have no secret and must rely on PKCE alone
+func (a *OAuthApp) checkSecret(secret string) bool {
+	if a.SecretHash == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(hashOAuthSecret(secret)), []byte(a.SecretHash)) == 1
+}
+
+func (a *OAuthApp) hasRedirectURI(uri string) bool {
+	for _, u := range a.RedirectURIs {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+type oauthAuthCode struct {
+	ClientId      string
+	Username      string
+	RedirectURI   string
+	Permissions   []TokenPermission
+	CodeChallenge string
+	ExpiresAt     int64
+}
+
+var (
+	oauthApps      = make([]OAuthApp, 0)
+	oauthAppsMutex sync.RWMutex
+
+	oauthCodes      = make(map[string]*oauthAuthCode)
+	oauthCodesMutex sync.Mutex
+
+	oauthRefreshIndex      = make(map[string]*subTokenEntry)
+	oauthRefreshIndexMutex sync.RWMutex
+
+	// one lock per user, a refresh token must only be rotated once
+	oauthGrantLocks sync.Map
+)
+
+func oauthGrantLock(username string) *sync.Mutex {
+	mu, _ := oauthGrantLocks.LoadOrStore(strings.ToLower(username), &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+func loadOAuthApps() {
+	oauthAppsMutex.Lock()
+	defer oauthAppsMutex.Unlock()
+
+	data, err := os.ReadFile(OAUTH_APPS_FILE_PATH)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading oauth apps file: %v", err)
+		}
+		oauthApps = make([]OAuthApp, 0)
+		return
+	}
+
+	if err := json.Unmarshal(data, &oauthApps); err != nil {
+		log.Printf("Error unmarshaling oauth apps: %v", err)
+		oauthApps = make([]OAuthApp, 0)
+		return
+	}
+
+	log.Printf("Loaded %d oauth apps", len(oauthApps))
+}
+
+func saveOAuthApps() {
+	oauthAppsMutex.RLock()
+	defer oauthAppsMutex.RUnlock()
+	saveJsonFile(OAUTH_APPS_FILE_PATH, oauthApps)
+}
+
+func getOAuthApp(clientId string) (OAuthApp, bool) {
+	oauthAppsMutex.RLock()
+	defer oauthAppsMutex.RUnlock()
+	for _, app := range oauthApps {
+		if app.ClientId == clientId {
+			return app, true
+		}
+	}
+	return OAuthApp{}, false
+}
+
+func randomOAuthString(prefix string, size int) string {
+	b := make([]byte, size)
+	rand.Read(b)
+	return prefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+func generateOAuthClientId() string {
+	return randomOAuthString("app_", 12)
+}
+
+func generateOAuthClientSecret() string {
+	return randomOAuthString("rotur_cs_", 32)
+}
+
+func generateOAuthCode() string {
+	return randomOAuthString("", 32)
+}
+
+func generateOAuthRefreshToken() string {
+	return randomOAuthString("rotur_rt_", 32)
+}
+
+func hashOAuthSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// validateRedirectURI only allows https, or http on loopback for local development
+func validateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid redirect uri: %s", raw)
+	}
+	if u.Fragment != "" {
+		return fmt.Errorf("redirect uri must not contain a fragment: %s", raw)
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := u.Hostname()
+		if host == "localhost" || host == "127.0.0.1" || host == "::1" {
+			return nil
+		}
+	}
+	return fmt.Errorf("redirect uri must use https: %s", raw)
+}
+
+// parseOAuthScope expands a space separated scope string into permissions.
+// Each entry may be a single permission or the name of a permission group.
+func parseOAuthScope(scope string) ([]TokenPermission, error) {
+	validPerms := make(map[TokenPermission]bool)
+	for _, p := range AllPermissions() {
+		validPerms[p] = true
+	}
+	groups := make(map[string]PermissionGroup)
+	for _, g := range PermissionGroups() {
+		groups[g.Name] = g
+	}
+
+	seen := make(map[TokenPermission]bool)
+	perms := make([]TokenPermission, 0)
+	add := func(p TokenPermission) {
+		if !seen[p] {
+			seen[p] = true
+			perms = append(perms, p)
+		}
+	}
+
+	for _, s := range strings.Fields(scope) {
+		if g, ok := groups[s]; ok {
+			for _, p := range g.Permissions {
+				add(p)
+			}
+			continue
+		}
+		tp := TokenPermission(s)
+		if !validPerms[tp] {
+			return nil, fmt.Errorf("invalid scope: %s", s)
+		}
+		if tp == PermManageTokens {
+			return nil, fmt.Errorf("scope %s cannot be granted to apps", s)
+		}
+		add(tp)
+	}
+
+	if len(perms) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	return perms, nil
+}
+
+func formatOAuthScope(perms []TokenPermission) string {
+	parts := make([]string, len(perms))
+	for i, p := range perms {
+		parts[i] = string(p)
+	}
+	return strings.Join(parts, " ")
+}
+
+// verifyPKCE checks an S256 code challenge against the verifier from RFC 7636
+func verifyPKCE(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func issueOAuthCode(code *oauthAuthCode) string {
+	oauthCodesMutex.Lock()
+	defer oauthCodesMutex.Unlock()
+
+	now := time.Now().UnixMilli()
+	for k, v := range oauthCodes {
+		if v.ExpiresAt < now {
+			delete(oauthCodes, k)
+		}
+	}
+
+	value := generateOAuthCode()
+	oauthCodes[value] = code
+	return value
+}
+
+// consumeOAuthCode removes the code so it can only ever be exchanged once
+func consumeOAuthCode(value string) (*oauthAuthCode, bool) {
+	oauthCodesMutex.Lock()
+	defer oauthCodesMutex.Unlock()
+
+	code, ok := oauthCodes[value]
+	if !ok {
+		return nil, false
+	}
+	delete(oauthCodes, value)
+	if code.ExpiresAt < time.Now().UnixMilli() {
+		return nil, false
+	}
+	return code, true
+}
+
+// a token that can still be refreshed stays alive after its access token expires
+func (t *SubToken) refreshable(now int64) bool {
+	return t.RefreshToken != "" && t.RefreshExpiresAt != nil && *t.RefreshExpiresAt > now
+}
+
+func addToOAuthRefreshIndex(refreshToken string, username string, tokenID string) {
+	oauthRefreshIndexMutex.Lock()
+	defer oauthRefreshIndexMutex.Unlock()
+	oauthRefreshIndex[refreshToken] = &subTokenEntry{
+		Username: username,
+		TokenID:  tokenID,
+	}
+}
+
+func removeFromOAuthRefreshIndex(refreshToken string) {
+	if refreshToken == "" {
+		return
+	}
+	oauthRefreshIndexMutex.Lock()
+	defer oauthRefreshIndexMutex.Unlock()
+	delete(oauthRefreshIndex, refreshToken)
+}
+
+// issueOAuthSubToken mints a sub-token for the app, replacing any earlier grant to the same app
+func issueOAuthSubToken(username string, app OAuthApp, perms []TokenPermission) (*SubToken, error) {
+	store, err := loadTokenStore(username)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	activeCount := 0
+	for i := range store.Tokens {
+		t := &store.Tokens[i]
+		if t.Revoked {
+			continue
+		}
+		if t.AppId == app.ClientId {
+			markSubTokenRevoked(t, now)
+			continue
+		}
+		if t.ExpiresAt == nil || *t.ExpiresAt > now || t.refreshable(now) {
+			activeCount++
+		}
+	}
+	if activeCount >= 25 {
+		return nil, fmt.Errorf("maximum of 25 active sub-tokens reached")
+	}
+
+	expiresAt := now + OAuthAccessTokenLifetime.Milliseconds()
+	refreshExpiresAt := now + OAuthRefreshTokenLifetime.Milliseconds()
+	subToken := SubToken{
+		ID:               generateSubTokenID(),
+		Name:             app.Name,
+		Token:            generateSubTokenValue(),
+		Permissions:      perms,
+		CreatedAt:        now,
+		ExpiresAt:        &expiresAt,
+		Origin:           app.Website,
+		Description:      "Authorized OAuth app",
+		Websites:         []string{},
+		AppId:            app.ClientId,
+		RefreshToken:     generateOAuthRefreshToken(),
+		RefreshExpiresAt: &refreshExpiresAt,
+	}
+	store.Tokens = append(store.Tokens, subToken)
+
+	if err := saveTokenStore(username, store); err != nil {
+		return nil, err
+	}
+
+	addToSubTokenIndex(subToken.Token, username, subToken.ID)
+	addToOAuthRefreshIndex(subToken.RefreshToken, username, subToken.ID)
+	return &subToken, nil
+}
+
+// refreshOAuthSubToken rotates both the access and refresh token of an app grant
+func refreshOAuthSubToken(refreshToken string, clientId string) (*SubToken, error) {
+	oauthRefreshIndexMutex.RLock()
+	entry, ok := oauthRefreshIndex[refreshToken]
+	oauthRefreshIndexMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("refresh token not found")
+	}
+
+	// the refresh token is checked again under the lock, a concurrent refresh may have rotated it
+	mu := oauthGrantLock(entry.Username)
+	mu.Lock()
+	defer mu.Unlock()
+
+	store, err := loadTokenStore(entry.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	for i := range store.Tokens {
+		t := &store.Tokens[i]
+		if t.ID != entry.TokenID || t.RefreshToken != refreshToken {
+			continue
+		}
+		if t.AppId != clientId {
+			return nil, fmt.Errorf("refresh token was not issued to this client")
+		}
+		if t.Revoked || !t.refreshable(now) {
+			removeFromOAuthRefreshIndex(refreshToken)
+			return nil, fmt.Errorf("refresh token has expired")
+		}
+
+		removeFromSubTokenIndex(t.Token)
+		removeFromOAuthRefreshIndex(t.RefreshToken)
+
+		expiresAt := now + OAuthAccessTokenLifetime.Milliseconds()
+		refreshExpiresAt := now + OAuthRefreshTokenLifetime.Milliseconds()
+		t.Token = generateSubTokenValue()
+		t.ExpiresAt = &expiresAt
+		t.RefreshToken = generateOAuthRefreshToken()
+		t.RefreshExpiresAt = &refreshExpiresAt
+
+		if err := saveTokenStore(entry.Username, store); err != nil {
+			return nil, err
+		}
+
+		addToSubTokenIndex(t.Token, entry.Username, t.ID)
+		addToOAuthRefreshIndex(t.RefreshToken, entry.Username, t.ID)
+		issued := *t
+		return &issued, nil
+	}
+
+	removeFromOAuthRefreshIndex(refreshToken)
+	return nil, fmt.Errorf("refresh token not found")
+}
+
+// revokeOAuthToken revokes the grant behind an access or refresh token issued to clientId
+func revokeOAuthToken(value string, clientId string) bool {
+	subTokenIndexMutex.RLock()
+	entry, ok := subTokenIndex[value]
+	subTokenIndexMutex.RUnlock()
+	if !ok {
+		oauthRefreshIndexMutex.RLock()
+		entry, ok = oauthRefreshIndex[value]
+		oauthRefreshIndexMutex.RUnlock()
+	}
+	if !ok {
+		return false
+	}
+
+	mu := oauthGrantLock(entry.Username)
+	mu.Lock()
+	defer mu.Unlock()
+
+	store, err := loadTokenStore(entry.Username)
+	if err != nil {
+		return false
+	}
+
+	for i := range store.Tokens {
+		t := &store.Tokens[i]
+		if t.ID != entry.TokenID || t.AppId != clientId {
+			continue
+		}
+		if t.Token != value && t.RefreshToken != value {
+			continue
+		}
+		markSubTokenRevoked(t, time.Now().UnixMilli())
+		if err := saveTokenStore(entry.Username, store); err != nil {
+			log.Printf("Failed to save token store for %s: %v", entry.Username, err)
+		}
+		return true
+	}
+	return false
+}
+
+// revokeOAuthAppTokens revokes every grant issued to an app, used when the app is deleted
+func revokeOAuthAppTokens(clientId string) int {
+	usersMutex.RLock()
+	usernames := make([]string, 0, len(users))
+	for i := range users {
+		usernames = append(usernames, strings.ToLower(string(users[i].GetUsername())))
+	}
+	usersMutex.RUnlock()
+
+	now := time.Now().UnixMilli()
+	revoked := 0
+	for _, username := range usernames {
+		store, err := loadTokenStore(username)
+		if err != nil {
+			continue
+		}
+
+		changed := false
+		for i := range store.Tokens {
+			t := &store.Tokens[i]
+			if !t.Revoked && t.AppId == clientId {
+				markSubTokenRevoked(t, now)
+				changed = true
+				revoked++
+			}
+		}
+		if changed {
+			go saveTokenStore(username, store)
+		}
+	}
+	return revoked
+}
+
+// revokeAllSubTokens revokes every sub-token of the user, app grants included
+func revokeAllSubTokens(username string) (int, error) {
+	store, err := loadTokenStore(username)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UnixMilli()
+	revoked := 0
+	for i := range store.Tokens {
+		if t := &store.Tokens[i]; !t.Revoked {
+			markSubTokenRevoked(t, now)
+			revoked++
+		}
+	}
+	if revoked == 0 {
+		return 0, nil
+	}
+	return revoked, saveTokenStore(username, store)
+}
+
+// markSubTokenRevoked marks t revoked and drops it from the lookup indexes, the caller saves the store
+func markSubTokenRevoked(t *SubToken, now int64) {
+	t.Revoked = true
+	t.RevokedAt = &now
+	removeFromSubTokenIndex(t.Token)
+	removeFromOAuthRefreshIndex(t.RefreshToken)
+}
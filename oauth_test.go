@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	verifier := strings.Repeat("a", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	if !verifyPKCE(challenge, verifier) {
+		t.Error("Matching verifier should pass")
+	}
+	if verifyPKCE(challenge, strings.Repeat("b", 43)) {
+		t.Error("Wrong verifier should fail")
+	}
+	if verifyPKCE(challenge, "short") {
+		t.Error("Verifier shorter than 43 characters should fail")
+	}
+}
+
+func TestParseOAuthScope(t *testing.T) {
+	perms, err := parseOAuthScope("account:view posts:create account:view")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(perms) != 2 {
+		t.Errorf("Duplicate scopes should be collapsed, got %v", perms)
+	}
+
+	perms, err = parseOAuthScope("storage")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	token := SubToken{Permissions: perms}
+	if !token.hasAllPermissions([]TokenPermission{PermViewFiles, PermManageFiles, PermDeleteFiles}) {
+		t.Error("Group scope should expand to the group's permissions")
+	}
+
+	if _, err := parseOAuthScope("tokens:manage"); err == nil {
+		t.Error("tokens:manage should never be grantable to apps")
+	}
+	if _, err := parseOAuthScope("not:real"); err == nil {
+		t.Error("Unknown scope should be rejected")
+	}
+	if _, err := parseOAuthScope(""); err == nil {
+		t.Error("Empty scope should be rejected")
+	}
+}
+
+func TestOAuthCodeSingleUse(t *testing.T) {
+	code := issueOAuthCode(&oauthAuthCode{
+		ClientId:  "app_test",
+		ExpiresAt: time.Now().Add(time.Minute).UnixMilli(),
+	})
+
+	if _, ok := consumeOAuthCode(code); !ok {
+		t.Fatal("Fresh code should be accepted")
+	}
+	if _, ok := consumeOAuthCode(code); ok {
+		t.Error("Code should not be accepted twice")
+	}
+
+	expired := issueOAuthCode(&oauthAuthCode{
+		ClientId:  "app_test",
+		ExpiresAt: time.Now().Add(-time.Minute).UnixMilli(),
+	})
+	if _, ok := consumeOAuthCode(expired); ok {
+		t.Error("Expired code should be rejected")
+	}
+}
+
+func TestOAuthRefreshAndRevoke(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "oauth_test_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origPath := USERDATA_PATH
+	USERDATA_PATH = tmpDir
+	defer func() { USERDATA_PATH = origPath }()
+
+	app := OAuthApp{ClientId: "app_test", Name: "Test App"}
+	username := "oauthuser"
+
+	issued, err := issueOAuthSubToken(username, app, []TokenPermission{PermViewProfile})
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	if issued.AppId != app.ClientId {
+		t.Errorf("Issued token should be linked to the app, got %q", issued.AppId)
+	}
+
+	if _, err := refreshOAuthSubToken(issued.RefreshToken, "app_other"); err == nil {
+		t.Error("Refresh from another client should fail")
+	}
+
+	refreshed, err := refreshOAuthSubToken(issued.RefreshToken, app.ClientId)
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+	if refreshed.ID != issued.ID || refreshed.Token == issued.Token || refreshed.RefreshToken == issued.RefreshToken {
+		t.Error("Refresh should rotate both tokens on the same grant")
+	}
+	if _, err := refreshOAuthSubToken(issued.RefreshToken, app.ClientId); err == nil {
+		t.Error("Old refresh token should not be reusable")
+	}
+
+	if !revokeOAuthToken(refreshed.Token, app.ClientId) {
+		t.Fatal("Revoking the access token should succeed")
+	}
+	if _, _, err := authenticateWithSubTokenFast(refreshed.Token, ""); err == nil {
+		t.Error("Revoked access token should not authenticate")
+	}
+	if _, err := refreshOAuthSubToken(refreshed.RefreshToken, app.ClientId); err == nil {
+		t.Error("Refresh token of a revoked grant should fail")
+	}
+}
+
+func TestOAuthConcurrentRefresh(t *testing.T) {
+	withTempPath(t, &USERDATA_PATH, "userdata")
+	app := OAuthApp{ClientId: "app_test", Name: "Test App"}
+
+	issued, err := issueOAuthSubToken("racer", app, []TokenPermission{PermViewProfile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const attempts = 8
+	results := make(chan *SubToken, attempts)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if refreshed, err := refreshOAuthSubToken(issued.RefreshToken, app.ClientId); err == nil {
+				results <- refreshed
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(results)
+
+	var winner *SubToken
+	for r := range results {
+		if winner != nil {
+			t.Fatal("A refresh token should only be rotated once")
+		}
+		winner = r
+	}
+	if winner == nil {
+		t.Fatal("One of the refreshes should succeed")
+	}
+
+	oauthRefreshIndexMutex.RLock()
+	_, stale := oauthRefreshIndex[issued.RefreshToken]
+	_, current := oauthRefreshIndex[winner.RefreshToken]
+	oauthRefreshIndexMutex.RUnlock()
+	if stale || !current {
+		t.Errorf("Only the rotated refresh token should be indexed, stale %v current %v", stale, current)
+	}
+}
+
+func TestRevokeAllSubTokens(t *testing.T) {
+	withTempPath(t, &USERDATA_PATH, "userdata")
+
+	app := OAuthApp{ClientId: "app_reset", Name: "Reset App"}
+	issued, err := issueOAuthSubToken("resetgrant", app, []TokenPermission{PermViewProfile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if revoked, err := revokeAllSubTokens("resetgrant"); err != nil || revoked != 1 {
+		t.Fatalf("Expected the grant to be revoked, got %d %v", revoked, err)
+	}
+	if _, err := refreshOAuthSubToken(issued.RefreshToken, app.ClientId); err == nil {
+		t.Error("Refresh token of a revoked grant should fail")
+	}
+	if revoked, _ := revokeAllSubTokens("resetgrant"); revoked != 0 {
+		t.Errorf("Nothing should be left to revoke, got %d", revoked)
+	}
+}
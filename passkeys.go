@@ -0,0 +1,673 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PasskeyChallengeLifetime = 5 * time.Minute
+	MaxPasskeysPerUser       = 10
+
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+
+	authDataFlagUserPresent  = 0x01
+	authDataFlagUserVerified = 0x04
+	authDataFlagAttested     = 0x40
+)
+
+type Passkey struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	PublicKey  string   `json:"public_key"`
+	Algorithm  int64    `json:"alg"`
+	SignCount  uint32   `json:"sign_count"`
+	AAGUID     string   `json:"aaguid,omitempty"`
+	Transports []string `json:"transports,omitempty"`
+	CreatedAt  int64    `json:"created_at"`
+	LastUsedAt *int64   `json:"last_used_at,omitempty"`
+}
+
+type PasskeyPublic struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	AAGUID     string   `json:"aaguid,omitempty"`
+	Transports []string `json:"transports,omitempty"`
+	CreatedAt  int64    `json:"created_at"`
+	LastUsedAt *int64   `json:"last_used_at,omitempty"`
+}
+
+func (p *Passkey) ToPublic() PasskeyPublic {
+	return PasskeyPublic{
+		ID:         p.ID,
+		Name:       p.Name,
+		AAGUID:     p.AAGUID,
+		Transports: p.Transports,
+		CreatedAt:  p.CreatedAt,
+		LastUsedAt: p.LastUsedAt,
+	}
+}
+
+type PasskeyStore struct {
+	Passkeys  []Passkey `json:"passkeys"`
+	UpdatedAt int64     `json:"updated_at"`
+}
+
+// PasskeyCredential is the JSON form of a PublicKeyCredential sent by the browser
+type PasskeyCredential struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject,omitempty"`
+		AuthenticatorData string   `json:"authenticatorData,omitempty"`
+		Signature         string   `json:"signature,omitempty"`
+		UserHandle        string   `json:"userHandle,omitempty"`
+		Transports        []string `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+type webauthnChallenge struct {
+	Purpose   string
+	Username  string
+	ExpiresAt int64
+}
+
+var (
+	passkeyStoreCache = make(map[string]*PasskeyStore)
+	passkeyStoreMutex sync.RWMutex
+
+	// credential id -> lowercase username, for usernameless sign in
+	passkeyIndex      = make(map[string]string)
+	passkeyIndexMutex sync.RWMutex
+
+	webauthnChallenges      = make(map[string]*webauthnChallenge)
+	webauthnChallengesMutex sync.Mutex
+)
+
+func getPasskeyStorePath(username string) string {
+	return filepath.Join(
+		USERDATA_PATH,
+		strings.ToLower(username),
+		"passkeys.json",
+	)
+}
+
+func loadPasskeyStore(username string) (*PasskeyStore, error) {
+	username = strings.ToLower(username)
+
+	passkeyStoreMutex.RLock()
+	if cached, ok := passkeyStoreCache[username]; ok {
+		passkeyStoreMutex.RUnlock()
+		return cached, nil
+	}
+	passkeyStoreMutex.RUnlock()
+
+	store := &PasskeyStore{Passkeys: []Passkey{}}
+	data, err := os.ReadFile(getPasskeyStorePath(username))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read passkey store: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, store); err != nil {
+			return nil, fmt.Errorf("failed to parse passkey store: %w", err)
+		}
+	}
+
+	passkeyStoreMutex.Lock()
+	passkeyStoreCache[username] = store
+	passkeyStoreMutex.Unlock()
+	return store, nil
+}
+
+func savePasskeyStore(username string, store *PasskeyStore) error {
+	username = strings.ToLower(username)
+	store.UpdatedAt = time.Now().UnixMilli()
+
+	path := getPasskeyStorePath(username)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create passkey directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(store, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal passkey store: %w", err)
+	}
+	if err := atomicWrite(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write passkey store: %w", err)
+	}
+
+	passkeyStoreMutex.Lock()
+	passkeyStoreCache[username] = store
+	passkeyStoreMutex.Unlock()
+	return nil
+}
+
+func buildPasskeyIndex() {
+	usersMutex.RLock()
+	usernames := make([]string, 0, len(users))
+	for i := range users {
+		usernames = append(usernames, strings.ToLower(string(users[i].GetUsername())))
+	}
+	usersMutex.RUnlock()
+
+	index := make(map[string]string)
+	for _, username := range usernames {
+		if _, err := os.Stat(getPasskeyStorePath(username)); err != nil {
+			continue
+		}
+		store, err := loadPasskeyStore(username)
+		if err != nil {
+			continue
+		}
+		for _, p := range store.Passkeys {
+			index[p.ID] = username
+		}
+	}
+
+	passkeyIndexMutex.Lock()
+	passkeyIndex = index
+	passkeyIndexMutex.Unlock()
+
+	log.Printf("Built passkey index with %d credentials", len(index))
+}
+
+func b64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeB64url accepts base64url with or without padding, as browsers differ
+func decodeB64url(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func newWebauthnChallenge(purpose string, username string) string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	challenge := b64url(b)
+
+	webauthnChallengesMutex.Lock()
+	defer webauthnChallengesMutex.Unlock()
+
+	now := time.Now().UnixMilli()
+	for k, v := range webauthnChallenges {
+		if v.ExpiresAt < now {
+			delete(webauthnChallenges, k)
+		}
+	}
+	webauthnChallenges[challenge] = &webauthnChallenge{
+		Purpose:   purpose,
+		Username:  strings.ToLower(username),
+		ExpiresAt: time.Now().Add(PasskeyChallengeLifetime).UnixMilli(),
+	}
+	return challenge
+}
+
+// consumeWebauthnChallenge removes the challenge so a response can't be replayed
+func consumeWebauthnChallenge(challenge string, purpose string) (*webauthnChallenge, error) {
+	webauthnChallengesMutex.Lock()
+	defer webauthnChallengesMutex.Unlock()
+
+	ch, ok := webauthnChallenges[challenge]
+	if !ok {
+		return nil, fmt.Errorf("unknown or expired challenge")
+	}
+	delete(webauthnChallenges, challenge)
+	if ch.ExpiresAt < time.Now().UnixMilli() || ch.Purpose != purpose {
+		return nil, fmt.Errorf("unknown or expired challenge")
+	}
+	return ch, nil
+}
+
+// verifyClientData checks clientDataJSON and returns the challenge it was signed for
+func verifyClientData(raw []byte, expectedType string) (string, error) {
+	var cd struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return "", fmt.Errorf("invalid client data")
+	}
+	if cd.Type != expectedType {
+		return "", fmt.Errorf("unexpected client data type %q", cd.Type)
+	}
+	if !slices.Contains(WEBAUTHN_ORIGINS, cd.Origin) {
+		return "", fmt.Errorf("origin %q is not allowed", cd.Origin)
+	}
+	return strings.TrimRight(cd.Challenge, "="), nil
+}
+
+type parsedAuthData struct {
+	Flags        byte
+	SignCount    uint32
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+func parseAuthenticatorData(data []byte) (*parsedAuthData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("authenticator data too short")
+	}
+
+	rpIdHash := sha256.Sum256([]byte(WEBAUTHN_RP_ID))
+	if !bytes.Equal(data[:32], rpIdHash[:]) {
+		return nil, fmt.Errorf("relying party id mismatch")
+	}
+
+	ad := &parsedAuthData{
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if ad.Flags&authDataFlagUserPresent == 0 {
+		return nil, fmt.Errorf("user presence flag not set")
+	}
+	// a passkey login skips totp, so a touch without a pin or biometric is not enough
+	if ad.Flags&authDataFlagUserVerified == 0 {
+		return nil, fmt.Errorf("user verification flag not set")
+	}
+
+	if ad.Flags&authDataFlagAttested == 0 {
+		return ad, nil
+	}
+
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, fmt.Errorf("attested credential data too short")
+	}
+	ad.AAGUID = rest[:16]
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLen == 0 || len(rest) < idLen {
+		return nil, fmt.Errorf("invalid credential id")
+	}
+	ad.CredentialID = rest[:idLen]
+	rest = rest[idLen:]
+
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential public key: %w", err)
+	}
+	ad.PublicKey = rest[:len(rest)-len(after)]
+	return ad, nil
+}
+
+// parseCOSEKey turns a COSE_Key into a Go public key and its algorithm
+func parseCOSEKey(raw []byte) (crypto.PublicKey, int64, error) {
+	v, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, 0, fmt.Errorf("public key is not a map")
+	}
+
+	alg, _ := m[int64(3)].(int64)
+	bytesOf := func(k int64) []byte {
+		b, _ := m[k].([]byte)
+		return b
+	}
+
+	switch alg {
+	case coseAlgES256:
+		if crv, _ := m[int64(-1)].(int64); crv != 1 {
+			return nil, 0, fmt.Errorf("unsupported curve")
+		}
+		x, y := bytesOf(-2), bytesOf(-3)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, fmt.Errorf("invalid EC2 key")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, 0, fmt.Errorf("invalid EC2 key")
+		}
+		return key, alg, nil
+	case coseAlgEdDSA:
+		if crv, _ := m[int64(-1)].(int64); crv != 6 {
+			return nil, 0, fmt.Errorf("unsupported curve")
+		}
+		x := bytesOf(-2)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, 0, fmt.Errorf("invalid OKP key")
+		}
+		return ed25519.PublicKey(x), alg, nil
+	case coseAlgRS256:
+		n, e := bytesOf(-1), bytesOf(-2)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, fmt.Errorf("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, alg, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported algorithm %d", alg)
+}
+
+func verifyPasskeySignature(key crypto.PublicKey, alg int64, data []byte, sig []byte) bool {
+	switch alg {
+	case coseAlgES256:
+		k, ok := key.(*ecdsa.PublicKey)
+		digest := sha256.Sum256(data)
+		return ok && ecdsa.VerifyASN1(k, digest[:], sig)
+	case coseAlgEdDSA:
+		k, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(k, data, sig)
+	case coseAlgRS256:
+		k, ok := key.(*rsa.PublicKey)
+		digest := sha256.Sum256(data)
+		return ok && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	}
+	return false
+}
+
+// passkeyCreationOptions builds PublicKeyCredentialCreationOptions for navigator.credentials.create
+func passkeyCreationOptions(user User) (map[string]any, error) {
+	username := strings.ToLower(string(user.GetUsername()))
+	store, err := loadPasskeyStore(username)
+	if err != nil {
+		return nil, err
+	}
+	if len(store.Passkeys) >= MaxPasskeysPerUser {
+		return nil, fmt.Errorf("maximum of %d passkeys reached", MaxPasskeysPerUser)
+	}
+
+	exclude := make([]map[string]any, 0, len(store.Passkeys))
+	for _, p := range store.Passkeys {
+		exclude = append(exclude, map[string]any{"type": "public-key", "id": p.ID})
+	}
+
+	return map[string]any{
+		"challenge": newWebauthnChallenge("register", username),
+		"rp": map[string]any{
+			"id":   WEBAUTHN_RP_ID,
+			"name": WEBAUTHN_RP_NAME,
+		},
+		"user": map[string]any{
+			"id":          b64url([]byte(user.GetId())),
+			"name":        string(user.GetUsername()),
+			"displayName": string(user.GetUsername()),
+		},
+		"pubKeyCredParams": []map[string]any{
+			{"type": "public-key", "alg": coseAlgES256},
+			{"type": "public-key", "alg": coseAlgEdDSA},
+			{"type": "public-key", "alg": coseAlgRS256},
+		},
+		"timeout":            PasskeyChallengeLifetime.Milliseconds(),
+		"attestation":        "none",
+		"excludeCredentials": exclude,
+		"authenticatorSelection": map[string]any{
+			"residentKey":      "preferred",
+			"userVerification": "required",
+		},
+	}, nil
+}
+
+// registerPasskey verifies an attestation response and stores the new credential
+func registerPasskey(user User, cred PasskeyCredential, name string) (*Passkey, error) {
+	username := strings.ToLower(string(user.GetUsername()))
+
+	clientData, err := decodeB64url(cred.Response.ClientDataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client data")
+	}
+	challenge, err := verifyClientData(clientData, "webauthn.create")
+	if err != nil {
+		return nil, err
+	}
+	ch, err := consumeWebauthnChallenge(challenge, "register")
+	if err != nil {
+		return nil, err
+	}
+	if ch.Username != username {
+		return nil, fmt.Errorf("challenge was issued to another user")
+	}
+
+	rawAttestation, err := decodeB64url(cred.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation object")
+	}
+	v, _, err := decodeCBOR(rawAttestation)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation object: %w", err)
+	}
+	attestation, ok := v.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid attestation object")
+	}
+	// we ask for attestation "none", so anything else is not something we can verify
+	if format, _ := attestation["fmt"].(string); format != "none" {
+		return nil, fmt.Errorf("unsupported attestation format %q", format)
+	}
+	rawAuthData, _ := attestation["authData"].([]byte)
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.CredentialID == nil {
+		return nil, fmt.Errorf("no credential in attestation")
+	}
+	_, alg, err := parseCOSEKey(authData.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	credID := b64url(authData.CredentialID)
+	passkeyIndexMutex.RLock()
+	_, taken := passkeyIndex[credID]
+	passkeyIndexMutex.RUnlock()
+	if taken {
+		return nil, fmt.Errorf("passkey is already registered")
+	}
+
+	if name == "" {
+		name = "Passkey"
+	}
+	passkey := Passkey{
+		ID:         credID,
+		Name:       name,
+		PublicKey:  b64url(authData.PublicKey),
+		Algorithm:  alg,
+		SignCount:  authData.SignCount,
+		AAGUID:     hex.EncodeToString(authData.AAGUID),
+		Transports: cred.Response.Transports,
+		CreatedAt:  time.Now().UnixMilli(),
+	}
+
+	store, err := loadPasskeyStore(username)
+	if err != nil {
+		return nil, err
+	}
+	if len(store.Passkeys) >= MaxPasskeysPerUser {
+		return nil, fmt.Errorf("maximum of %d passkeys reached", MaxPasskeysPerUser)
+	}
+	store.Passkeys = append(store.Passkeys, passkey)
+	if err := savePasskeyStore(username, store); err != nil {
+		return nil, err
+	}
+
+	passkeyIndexMutex.Lock()
+	passkeyIndex[credID] = username
+	passkeyIndexMutex.Unlock()
+
+	return &passkey, nil
+}
+
+// passkeyRequestOptions builds PublicKeyCredentialRequestOptions for navigator.credentials.get.
+// Without a username the browser offers any discoverable passkey for this site.
+func passkeyRequestOptions(username string) (map[string]any, error) {
+	username = strings.ToLower(username)
+	allow := make([]map[string]any, 0)
+	if username != "" {
+		store, err := loadPasskeyStore(username)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range store.Passkeys {
+			entry := map[string]any{"type": "public-key", "id": p.ID}
+			if len(p.Transports) > 0 {
+				entry["transports"] = p.Transports
+			}
+			allow = append(allow, entry)
+		}
+	}
+
+	return map[string]any{
+		"challenge":        newWebauthnChallenge("login", username),
+		"rpId":             WEBAUTHN_RP_ID,
+		"timeout":          PasskeyChallengeLifetime.Milliseconds(),
+		"allowCredentials": allow,
+		"userVerification": "required",
+	}, nil
+}
+
+// verifyPasskeyLogin checks an assertion and returns the username it belongs to
+func verifyPasskeyLogin(cred PasskeyCredential) (string, error) {
+	credID := strings.TrimRight(cred.RawID, "=")
+	if credID == "" {
+		credID = strings.TrimRight(cred.ID, "=")
+	}
+
+	passkeyIndexMutex.RLock()
+	username, ok := passkeyIndex[credID]
+	passkeyIndexMutex.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown passkey")
+	}
+
+	clientData, err := decodeB64url(cred.Response.ClientDataJSON)
+	if err != nil {
+		return "", fmt.Errorf("invalid client data")
+	}
+	challenge, err := verifyClientData(clientData, "webauthn.get")
+	if err != nil {
+		return "", err
+	}
+	ch, err := consumeWebauthnChallenge(challenge, "login")
+	if err != nil {
+		return "", err
+	}
+	if ch.Username != "" && ch.Username != username {
+		return "", fmt.Errorf("passkey does not belong to this user")
+	}
+
+	rawAuthData, err := decodeB64url(cred.Response.AuthenticatorData)
+	if err != nil {
+		return "", fmt.Errorf("invalid authenticator data")
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return "", err
+	}
+	sig, err := decodeB64url(cred.Response.Signature)
+	if err != nil {
+		return "", fmt.Errorf("invalid signature")
+	}
+
+	store, err := loadPasskeyStore(username)
+	if err != nil {
+		return "", err
+	}
+	for i := range store.Passkeys {
+		p := &store.Passkeys[i]
+		if p.ID != credID {
+			continue
+		}
+
+		rawKey, err := decodeB64url(p.PublicKey)
+		if err != nil {
+			return "", fmt.Errorf("stored passkey is corrupt")
+		}
+		key, alg, err := parseCOSEKey(rawKey)
+		if err != nil {
+			return "", fmt.Errorf("stored passkey is corrupt")
+		}
+
+		clientDataHash := sha256.Sum256(clientData)
+		signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+		if !verifyPasskeySignature(key, alg, signed, sig) {
+			return "", fmt.Errorf("invalid signature")
+		}
+
+		// a counter that doesn't move forward means the credential may have been cloned
+		if (authData.SignCount != 0 || p.SignCount != 0) && authData.SignCount <= p.SignCount {
+			return "", fmt.Errorf("passkey signature counter did not increase")
+		}
+
+		now := time.Now().UnixMilli()
+		p.SignCount = authData.SignCount
+		p.LastUsedAt = &now
+		if err := savePasskeyStore(username, store); err != nil {
+			return "", err
+		}
+		return username, nil
+	}
+
+	return "", fmt.Errorf("unknown passkey")
+}
+
+func renamePasskey(username string, id string, name string) (*Passkey, error) {
+	store, err := loadPasskeyStore(username)
+	if err != nil {
+		return nil, err
+	}
+	for i := range store.Passkeys {
+		if store.Passkeys[i].ID == id {
+			store.Passkeys[i].Name = name
+			if err := savePasskeyStore(username, store); err != nil {
+				return nil, err
+			}
+			return &store.Passkeys[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func removePasskey(username string, id string) (bool, error) {
+	store, err := loadPasskeyStore(username)
+	if err != nil {
+		return false, err
+	}
+
+	kept := make([]Passkey, 0, len(store.Passkeys))
+	for _, p := range store.Passkeys {
+		if p.ID != id {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == len(store.Passkeys) {
+		return false, nil
+	}
+
+	store.Passkeys = kept
+	if err := savePasskeyStore(username, store); err != nil {
+		return false, err
+	}
+
+	passkeyIndexMutex.Lock()
+	delete(passkeyIndex, id)
+	passkeyIndexMutex.Unlock()
+	return true, nil
+}
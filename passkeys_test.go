@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+// tiny CBOR encoder for the software authenticator, only what attestation needs
+func cborHead(major byte, n int) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n < 256:
+		return []byte{major<<5 | 24, byte(n)}
+	default:
+		return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+	}
+}
+
+func cborInt(n int) []byte {
+	if n < 0 {
+		return cborHead(1, -1-n)
+	}
+	return cborHead(0, n)
+}
+
+func cborBytes(b []byte) []byte { return append(cborHead(2, len(b)), b...) }
+func cborText(s string) []byte  { return append(cborHead(3, len(s)), s...) }
+
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credID       []byte
+	signCount    uint32
+	presenceOnly bool // no pin or biometric, the user verified flag is left out
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, credID: id}
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIdHash := sha256.Sum256([]byte(WEBAUTHN_RP_ID))
+	a.signCount++
+
+	out := append([]byte{}, rpIdHash[:]...)
+	flags := byte(authDataFlagUserPresent | authDataFlagUserVerified)
+	if a.presenceOnly {
+		flags &^= authDataFlagUserVerified
+	}
+	if attested {
+		flags |= authDataFlagAttested
+	}
+	out = append(out, flags)
+	out = binary.BigEndian.AppendUint32(out, a.signCount)
+	if !attested {
+		return out
+	}
+
+	out = append(out, make([]byte, 16)...)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(a.credID)))
+	out = append(out, a.credID...)
+
+	x := a.key.PublicKey.X.FillBytes(make([]byte, 32))
+	y := a.key.PublicKey.Y.FillBytes(make([]byte, 32))
+	out = append(out, cborHead(5, 5)...)
+	out = append(out, cborInt(1)...)
+	out = append(out, cborInt(2)...)
+	out = append(out, cborInt(3)...)
+	out = append(out, cborInt(coseAlgES256)...)
+	out = append(out, cborInt(-1)...)
+	out = append(out, cborInt(1)...)
+	out = append(out, cborInt(-2)...)
+	out = append(out, cborBytes(x)...)
+	out = append(out, cborInt(-3)...)
+	out = append(out, cborBytes(y)...)
+	return out
+}
+
+func clientDataFor(typ string, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": challenge,
+		"origin":    WEBAUTHN_ORIGINS[0],
+	})
+	return data
+}
+
+func (a *softAuthenticator) create(challenge string) PasskeyCredential {
+	att := append([]byte{}, cborHead(5, 3)...)
+	att = append(att, cborText("fmt")...)
+	att = append(att, cborText("none")...)
+	att = append(att, cborText("attStmt")...)
+	att = append(att, cborHead(5, 0)...)
+	att = append(att, cborText("authData")...)
+	att = append(att, cborBytes(a.authData(true))...)
+
+	var cred PasskeyCredential
+	cred.ID = b64url(a.credID)
+	cred.RawID = cred.ID
+	cred.Type = "public-key"
+	cred.Response.ClientDataJSON = b64url(clientDataFor("webauthn.create", challenge))
+	cred.Response.AttestationObject = b64url(att)
+	return cred
+}
+
+func (a *softAuthenticator) get(t *testing.T, challenge string) PasskeyCredential {
+	authData := a.authData(false)
+	clientData := clientDataFor("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cred PasskeyCredential
+	cred.ID = b64url(a.credID)
+	cred.RawID = cred.ID
+	cred.Type = "public-key"
+	cred.Response.ClientDataJSON = b64url(clientData)
+	cred.Response.AuthenticatorData = b64url(authData)
+	cred.Response.Signature = b64url(sig)
+	return cred
+}
+
+func TestPasskeyRegisterAndLogin(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "passkey_test_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origPath := USERDATA_PATH
+	USERDATA_PATH = tmpDir
+	defer func() { USERDATA_PATH = origPath }()
+
+	origRP, origOrigins := WEBAUTHN_RP_ID, WEBAUTHN_ORIGINS
+	WEBAUTHN_RP_ID = "rotur.test"
+	WEBAUTHN_ORIGINS = []string{"https://rotur.test"}
+	defer func() { WEBAUTHN_RP_ID, WEBAUTHN_ORIGINS = origRP, origOrigins }()
+
+	user := User{"username": "passkeyuser", "id": "user-id-1"}
+	auth := newSoftAuthenticator(t)
+
+	options, err := passkeyCreationOptions(user)
+	if err != nil {
+		t.Fatalf("Failed to get creation options: %v", err)
+	}
+	passkey, err := registerPasskey(user, auth.create(options["challenge"].(string)), "Laptop")
+	if err != nil {
+		t.Fatalf("Failed to register passkey: %v", err)
+	}
+	if passkey.Algorithm != coseAlgES256 || passkey.Name != "Laptop" {
+		t.Errorf("Unexpected passkey %+v", passkey)
+	}
+
+	// usernameless login
+	options, err = passkeyRequestOptions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := options["challenge"].(string)
+	assertion := auth.get(t, challenge)
+	username, err := verifyPasskeyLogin(assertion)
+	if err != nil {
+		t.Fatalf("Passkey login failed: %v", err)
+	}
+	if username != "passkeyuser" {
+		t.Errorf("Expected passkeyuser, got %s", username)
+	}
+
+	if _, err := verifyPasskeyLogin(assertion); err == nil {
+		t.Error("Replayed assertion should be rejected")
+	}
+
+	options, _ = passkeyRequestOptions("passkeyuser")
+	bad := auth.get(t, options["challenge"].(string))
+	bad.Response.Signature = auth.get(t, challenge).Response.Signature
+	if _, err := verifyPasskeyLogin(bad); err == nil {
+		t.Error("Assertion with a bad signature should be rejected")
+	}
+
+	options, _ = passkeyRequestOptions("passkeyuser")
+	if _, err := verifyPasskeyLogin(auth.get(t, options["challenge"].(string))); err != nil {
+		t.Errorf("Second login failed: %v", err)
+	}
+
+	auth.presenceOnly = true
+	options, _ = passkeyRequestOptions("passkeyuser")
+	if _, err := verifyPasskeyLogin(auth.get(t, options["challenge"].(string))); err == nil || !strings.Contains(err.Error(), "user verification") {
+		t.Errorf("Assertion without user verification should be rejected, got %v", err)
+	}
+	auth.presenceOnly = false
+
+	removed, err := removePasskey("passkeyuser", passkey.ID)
+	if err != nil || !removed {
+		t.Fatalf("Failed to remove passkey: %v", err)
+	}
+	options, _ = passkeyRequestOptions("")
+	if _, err := verifyPasskeyLogin(auth.get(t, options["challenge"].(string))); err == nil {
+		t.Error("Removed passkey should not log in")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	PaymentRequestPending   = "pending"
+	PaymentRequestHeld      = "held" // paid, waiting for the fraud review to deliver it
+	PaymentRequestPaid      = "paid"
+	PaymentRequestDeclined  = "declined"
+	PaymentRequestCancelled = "cancelled"
+	PaymentRequestExpired   = "expired"
+
+	PaymentRequestDefaultLifetime = 7 * 24 * time.Hour
+	PaymentRequestMaxLifetime     = 30 * 24 * time.Hour
+	MaxPendingPaymentRequests     = 50
+)
+
+// PaymentRequest asks the payer to send credits to the requester
+type PaymentRequest struct {
+	Id             string  `json:"id"`
+	RequesterId    UserId  `json:"requester_id"`
+	PayerId        UserId  `json:"payer_id"`
+	Amount         float64 `json:"amount"`
+	Note           string  `json:"note,omitempty"`
+	Status         string  `json:"status"`
+	CreatedAt      int64   `json:"created_at"`
+	ExpiresAt      int64   `json:"expires_at"`
+	RespondedAt    *int64  `json:"responded_at,omitempty"`
+	HeldTransferId string  `json:"held_transfer_id,omitempty"`
+}
+
+type PaymentRequestNet struct {
+	PaymentRequest
+	Requester Username `json:"requester"`
+	Payer     Username `json:"payer"`
+}
+
+func (r PaymentRequest) ToNet() PaymentRequestNet {
+	return PaymentRequestNet{
+		PaymentRequest: r,
+		Requester:      r.RequesterId.User().GetUsername(),
+		Payer:          r.PayerId.User().GetUsername(),
+	}
+}
+
+var (
+	paymentRequests      = make([]PaymentRequest, 0)
+	paymentRequestsMutex sync.Mutex
+
+	errPaymentRequestNotFound = errors.New("payment request not found")
+	errPaymentRequestClosed   = errors.New("payment request is no longer pending")
+	errRequesterGone          = errors.New("requester no longer exists")
+)
+
+func loadPaymentRequests() {
+	paymentRequestsMutex.Lock()
+	defer paymentRequestsMutex.Unlock()
+
+	data, err := os.ReadFile(PAYMENT_REQUESTS_FILE_PATH)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading payment requests file: %v", err)
+		}
+		paymentRequests = make([]PaymentRequest, 0)
+		return
+	}
+
+	if err := json.Unmarshal(data, &paymentRequests); err != nil {
+		log.Printf("Error unmarshaling payment requests: %v", err)
+		paymentRequests = make([]PaymentRequest, 0)
+		return
+	}
+
+	log.Printf("Loaded %d payment requests", len(paymentRequests))
+}
+
+// savePaymentRequestsLocked expects paymentRequestsMutex to be held
+func savePaymentRequestsLocked() {
+	saveJsonFile(PAYMENT_REQUESTS_FILE_PATH, paymentRequests)
+}
+
+// expirePaymentRequestsLocked marks pending requests past their expiry and
+// reports whether anything changed
+func expirePaymentRequestsLocked(now int64) bool {
+	changed := false
+	for i := range paymentRequests {
+		r := &paymentRequests[i]
+		if r.Status == PaymentRequestPending && now >= r.ExpiresAt {
+			r.Status = PaymentRequestExpired
+			changed = true
+		}
+	}
+	return changed
+}
+
+func findPaymentRequestLocked(id string) *PaymentRequest {
+	for i := range paymentRequests {
+		if paymentRequests[i].Id == id {
+			return &paymentRequests[i]
+		}
+	}
+	return nil
+}
+
+func countPendingPaymentRequests(requesterId UserId) int {
+	count := 0
+	for _, r := range paymentRequests {
+		if r.RequesterId == requesterId && r.Status == PaymentRequestPending {
+			count++
+		}
+	}
+	return count
+}
+
+// payPaymentRequest transfers the requested credits and marks the request paid.
+// the lock is held for the transfer so a request can only be paid once.
+func payPaymentRequest(id string, payer User) (PaymentRequest, error) {
+	paymentRequestsMutex.Lock()
+	defer paymentRequestsMutex.Unlock()
+
+	now := time.Now().UnixMilli()
+	if expirePaymentRequestsLocked(now) {
+		savePaymentRequestsLocked()
+	}
+
+	r := findPaymentRequestLocked(id)
+	if r == nil || r.PayerId != payer.GetId() {
+		return PaymentRequest{}, errPaymentRequestNotFound
+	}
+	if r.Status != PaymentRequestPending {
+		return *r, errPaymentRequestClosed
+	}
+
+	requester := getUserById(r.RequesterId)
+	if len(requester) == 0 {
+		return *r, errRequesterGone
+	}
+
+	note := r.Note
+	if note == "" {
+		note = "payment request"
+	}
+	err := PerformCreditTransfer(payer.GetUsername(), requester.GetUsername(), r.Amount, note)
+	if heldId, held := heldTransferId(err); held {
+		// the credits have left the payer, review either pays the request or reopens it
+		r.Status = PaymentRequestHeld
+		r.HeldTransferId = heldId
+	} else if err != nil {
+		return *r, err
+	} else {
+		r.Status = PaymentRequestPaid
+	}
+	r.RespondedAt = &now
+	savePaymentRequestsLocked()
+	return *r, nil
+}
+
+// settleHeldPaymentRequest marks the request behind an approved hold paid, or
+// reopens it when the payment was rejected and refunded
+func settleHeldPaymentRequest(h HeldTransfer) {
+	paymentRequestsMutex.Lock()
+	defer paymentRequestsMutex.Unlock()
+
+	for i := range paymentRequests {
+		r := &paymentRequests[i]
+		if r.Status != PaymentRequestHeld || r.HeldTransferId != h.Id {
+			continue
+		}
+		if h.Status == HeldTransferApproved {
+			r.Status = PaymentRequestPaid
+			addUserEvent(r.RequesterId, "payment_request_paid", map[string]any{
+				"id":     r.Id,
+				"from":   r.PayerId.User().GetUsername(),
+				"amount": r.Amount,
+			})
+		} else {
+			r.Status = PaymentRequestPending
+			r.HeldTransferId = ""
+			r.RespondedAt = nil
+		}
+		savePaymentRequestsLocked()
+		return
+	}
+}
+
+// paymentRequestAmount is what paying the request would cost, 0 if there is no such request
+func paymentRequestAmount(id string) float64 {
+	paymentRequestsMutex.Lock()
+	defer paymentRequestsMutex.Unlock()
+	if r := findPaymentRequestLocked(id); r != nil {
+		return r.Amount
+	}
+	return 0
+}
+
+// paymentRequestAboveStepUpThreshold asks for 2fa when accepting a large request
+func paymentRequestAboveStepUpThreshold(c *gin.Context) bool {
+	return paymentRequestAmount(c.Param("id")) > StepUpTransferThreshold
+}
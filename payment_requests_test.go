@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPayPaymentRequest(t *testing.T) {
+	requester := User{"username": "requester", "sys.id": "pr-requester", "sys.currency": 0.0}
+	payer := User{"username": "payer", "sys.id": "pr-payer", "sys.currency": 10.0}
+	withTestUsers(t, requester, payer)
+	withTestLedger(t)
+
+	now := time.Now().UnixMilli()
+	withTestPaymentRequests(t,
+		PaymentRequest{Id: "big", RequesterId: "pr-requester", PayerId: "pr-payer", Amount: 50, Status: PaymentRequestPending, CreatedAt: now, ExpiresAt: now + 60000},
+		PaymentRequest{Id: "old", RequesterId: "pr-requester", PayerId: "pr-payer", Amount: 1, Status: PaymentRequestPending, CreatedAt: now - 120000, ExpiresAt: now - 60000},
+		PaymentRequest{Id: "rent", RequesterId: "pr-requester", PayerId: "pr-payer", Amount: 4, Status: PaymentRequestPending, CreatedAt: now, ExpiresAt: now + 60000},
+	)
+
+	if _, err := payPaymentRequest("big", requester); err != errPaymentRequestNotFound {
+		t.Errorf("Only the payer should be able to pay a request, got %v", err)
+	}
+
+	if r, err := payPaymentRequest("big", payer); err == nil || r.Status != PaymentRequestPending {
+		t.Errorf("Request should stay pending when the payer can't afford it, got %v %s", err, r.Status)
+	}
+	if payer.GetCredits() != 10 || requester.GetCredits() != 0 {
+		t.Error("A failed payment should not move credits")
+	}
+
+	if r, err := payPaymentRequest("old", payer); err != errPaymentRequestClosed || r.Status != PaymentRequestExpired {
+		t.Errorf("Expired request should not be payable, got %v %s", err, r.Status)
+	}
+
+	r, err := payPaymentRequest("rent", payer)
+	if err != nil || r.Status != PaymentRequestPaid || r.RespondedAt == nil {
+		t.Fatalf("Accepting should pay the request, got %v %+v", err, r)
+	}
+	if payer.GetCredits() != 6 || requester.GetCredits() != 4 {
+		t.Errorf("Accepting should move the credits, payer %v requester %v", payer.GetCredits(), requester.GetCredits())
+	}
+	if r, err := payPaymentRequest("rent", payer); err != errPaymentRequestClosed || r.Status != PaymentRequestPaid {
+		t.Errorf("A paid request should not be paid twice, got %v %s", err, r.Status)
+	}
+	if payer.GetCredits() != 6 {
+		t.Error("A second accept should not move credits")
+	}
+}
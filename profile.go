@@ -210,6 +210,11 @@ func getProfile(c *gin.Context) {
 
 	userId := foundUser.GetId()
 
+	if foundUser.IsPendingDeletion() {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+
 	if foundUser.IsBanned() {
 		c.JSON(200, profileResp{
 			Username:  foundUser.GetUsername(),
@@ -410,6 +415,9 @@ func getSupporters(c *gin.Context) {
 	usersMutex.RLock()
 	defer usersMutex.RUnlock()
 	for _, user := range users {
+		if user.IsPendingDeletion() {
+			continue
+		}
 		subscriptionName := user.GetSubscription().Tier
 		if subscriptionName == "Free" {
 			continue
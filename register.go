@@ -52,6 +52,7 @@ func handleUserGoogle(c *gin.Context) {
 	var req struct {
 		IDToken string `json:"id_token"`
 		System  string `json:"system"`
+		TOTP    string `json:"totp"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -84,36 +85,44 @@ func handleUserGoogle(c *gin.Context) {
 		return
 	}
 
-	usersMutex.Lock()
+	var linked User
+	usersMutex.RLock()
 	for i := range users {
 		if v, ok := users[i]["sys.google"]; ok {
 			if m, ok := v.(map[string]any); ok {
 				if sub, ok := m["sub"]; ok {
 					if strings.EqualFold(strings.TrimSpace(fmt.Sprintf("%v", sub)), googleSub) {
-						now := time.Now().UnixMilli()
-						users[i].Set("sys.last_login", now)
-						users[i].Set("sys.total_logins", users[i].GetInt("sys.total_logins")+1)
-						users[i].Set("sys.badges", calculateUserBadges(users[i]))
-						users[i].SetSubscription(users[i].GetSubscription())
-						go saveUsers()
-
-						userCopy := copyUser(users[i])
-						delete(userCopy, "password")
-						usersMutex.Unlock()
-						c.JSON(200, userCopy)
-						return
+						linked = users[i]
+						break
 					}
 				}
 			}
 		}
 
 		if strings.EqualFold(users[i].GetEmail(), email) {
-			usersMutex.Unlock()
+			usersMutex.RUnlock()
 			c.JSON(403, gin.H{"error": "Account not linked to Google"})
 			return
 		}
 	}
-	usersMutex.Unlock()
+	usersMutex.RUnlock()
+
+	if linked != nil {
+		if linked.HasTOTP() {
+			if req.TOTP == "" {
+				c.JSON(401, gin.H{"error": "Two-factor code required", "totp_required": true})
+				return
+			}
+			if !checkSecondFactor(linked, req.TOTP) {
+				logSecurityEvent(c, linked, SecLoginFailed, map[string]any{"reason": "totp", "method": "google"})
+				c.JSON(403, gin.H{"error": "Invalid two-factor code", "totp_required": true})
+				return
+			}
+		}
+		// same checks as a password login, and restores an account pending deletion
+		completeLogin(c, linked, true, "Successful Google login", "")
+		return
+	}
 
 	isValid, errorMessage, matchedSystem := validateSystem(req.System)
 	if !isValid {
@@ -147,6 +156,9 @@ func handleUserGoogle(c *gin.Context) {
 		RequestIP:     c.ClientIP(),
 		RequestOrigin: fromURL,
 		ExtraSys: map[string]any{
+			// google only hands out verified addresses
+			"sys.email_verified":    true,
+			"sys.email_verified_at": time.Now().UnixMilli(),
 			"sys.google": map[string]any{
 				"sub":     fmt.Sprintf("%v", payload.Subject),
 				"email":   email,
@@ -180,6 +192,8 @@ func handleUserGoogle(c *gin.Context) {
 		}
 	}
 
+	logSecurityEvent(c, newUser, SecGoogleLinked, map[string]any{"email": email})
+
 	userCopy := copyUser(newUser)
 	delete(userCopy, "password")
 	c.JSON(201, userCopy)
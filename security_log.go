@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// the log is compacted down to this many events once the file grows past the limit
+	MaxSecurityEvents       = 1000
+	securityLogCompactBytes = 1 << 20
+
+	// transfers above this many credits are written to the security log
+	SecurityLogTransferThreshold = StepUpTransferThreshold
+)
+
+const (
+	SecLogin             = "login"
+	SecLoginFailed       = "login_failed"
+	SecLoginBlocked      = "login_blocked"
+	SecSessionRevoked    = "session_revoked"
+	SecTokenCreated      = "token_created"
+	SecTokenRevoked      = "token_revoked"
+	SecOAuthAuthorized   = "oauth_authorized"
+	SecDeviceLinked      = "device_linked"
+	SecPasswordChanged   = "password_changed"
+	SecEmailChanged      = "email_changed"
+	SecGoogleLinked      = "google_linked"
+	SecTwoFactorEnabled  = "2fa_enabled"
+	SecTwoFactorDisabled = "2fa_disabled"
+	SecPasskeyAdded      = "passkey_added"
+	SecPasskeyRemoved    = "passkey_removed"
+	SecTransfer          = "transfer"
+	SecStandingChanged   = "standing_changed"
+)
+
+// SecurityEvent is one line of a user's security log
+type SecurityEvent struct {
+	Id        string         `json:"id"`
+	Type      string         `json:"type"`
+	Timestamp int64          `json:"timestamp"`
+	IP_hmac   string         `json:"ip_hmac,omitempty"`
+	UserAgent string         `json:"user_agent,omitempty"`
+	Country   string         `json:"country,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// one lock per user file, appends and compaction must not interleave
+var securityLogLocks sync.Map
+
+func securityLogLock(username string) *sync.Mutex {
+	mu, _ := securityLogLocks.LoadOrStore(username, &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+func getSecurityLogPath(username string) string {
+	return filepath.Join(
+		USERDATA_PATH,
+		strings.ToLower(username),
+		"security_log.jsonl",
+	)
+}
+
+// logSecurityEvent records an event for user. c is the request that caused it, nil
+// for events without one (automatic standing recovery etc.).
+func logSecurityEvent(c *gin.Context, user User, eventType string, details map[string]any) {
+	if user == nil {
+		return
+	}
+
+	idBytes := make([]byte, 8)
+	rand.Read(idBytes)
+	event := SecurityEvent{
+		Id:        hex.EncodeToString(idBytes),
+		Type:      eventType,
+		Timestamp: time.Now().UnixMilli(),
+		Details:   details,
+	}
+	if c != nil {
+		event.IP_hmac = hmacIp(c.ClientIP())
+		event.UserAgent = c.Request.UserAgent()
+		event.Country = c.GetHeader("CF-IPCountry")
+	}
+
+	if err := appendSecurityEvent(string(user.GetUsername()), event); err != nil {
+		log.Printf("Failed to write security log for %s: %v", user.GetUsername(), err)
+	}
+}
+
+func appendSecurityEvent(username string, event SecurityEvent) error {
+	username = strings.ToLower(username)
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	mu := securityLogLock(username)
+	mu.Lock()
+	defer mu.Unlock()
+
+	path := getSecurityLogPath(username)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	info, statErr := f.Stat()
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	if statErr == nil && info.Size() > securityLogCompactBytes {
+		events, err := readSecurityEventsLocked(path)
+		if err != nil {
+			return err
+		}
+		return writeSecurityEventsLocked(path, events)
+	}
+	return nil
+}
+
+// readSecurityEventsLocked returns events oldest first, skipping corrupt lines
+func readSecurityEventsLocked(path string) ([]SecurityEvent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []SecurityEvent{}, nil
+		}
+		return nil, err
+	}
+
+	events := make([]SecurityEvent, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e SecurityEvent
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			events = append(events, e)
+		}
+	}
+	return events, scanner.Err()
+}
+
+func writeSecurityEventsLocked(path string, events []SecurityEvent) error {
+	if n := len(events); n > MaxSecurityEvents {
+		events = events[n-MaxSecurityEvents:]
+	}
+	var buf bytes.Buffer
+	for _, e := range events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return atomicWrite(path, buf.Bytes(), 0644)
+}
+
+// querySecurityLog returns up to limit events newest first, optionally only of
+// the given types and older than before (ms)
+func querySecurityLog(username string, types []string, before int64, limit int) ([]SecurityEvent, bool, error) {
+	username = strings.ToLower(username)
+	mu := securityLogLock(username)
+	mu.Lock()
+	events, err := readSecurityEventsLocked(getSecurityLogPath(username))
+	mu.Unlock()
+	if err != nil {
+		return nil, false, err
+	}
+
+	wanted := make(map[string]bool, len(types))
+	for _, t := range types {
+		wanted[t] = true
+	}
+
+	out := make([]SecurityEvent, 0, limit)
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		if before > 0 && e.Timestamp >= before {
+			continue
+		}
+		if len(wanted) > 0 && !wanted[e.Type] {
+			continue
+		}
+		if len(out) == limit {
+			return out, true, nil
+		}
+		out = append(out, e)
+	}
+	return out, false, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestSecurityLogQuery(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "security_log_test_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origPath := USERDATA_PATH
+	USERDATA_PATH = tmpDir
+	defer func() { USERDATA_PATH = origPath }()
+
+	for i := 1; i <= 5; i++ {
+		eventType := SecLogin
+		if i%2 == 0 {
+			eventType = SecLoginFailed
+		}
+		if err := appendSecurityEvent("LogUser", SecurityEvent{Id: strconv.Itoa(i), Type: eventType, Timestamp: int64(i)}); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+
+	events, more, err := querySecurityLog("loguser", nil, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || !more || events[0].Timestamp != 5 || events[1].Timestamp != 4 {
+		t.Fatalf("Expected newest two events with more, got %+v more=%v", events, more)
+	}
+
+	events, more, _ = querySecurityLog("loguser", nil, events[1].Timestamp, 10)
+	if len(events) != 3 || more || events[0].Timestamp != 3 {
+		t.Errorf("Expected the three older events, got %+v more=%v", events, more)
+	}
+
+	events, _, _ = querySecurityLog("loguser", []string{SecLoginFailed}, 0, 10)
+	if len(events) != 2 {
+		t.Errorf("Expected 2 failed logins, got %d", len(events))
+	}
+}
+
+func TestSecurityLogCompaction(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "security_log_test_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origPath := USERDATA_PATH
+	USERDATA_PATH = tmpDir
+	defer func() { USERDATA_PATH = origPath }()
+
+	events := make([]SecurityEvent, MaxSecurityEvents+10)
+	for i := range events {
+		events[i] = SecurityEvent{Type: SecTransfer, Timestamp: int64(i + 1)}
+	}
+	path := getSecurityLogPath("bulkuser")
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := writeSecurityEventsLocked(path, events); err != nil {
+		t.Fatal(err)
+	}
+
+	kept, _ := readSecurityEventsLocked(path)
+	if len(kept) != MaxSecurityEvents || kept[0].Timestamp != 11 {
+		t.Errorf("Expected the newest %d events, got %d starting at %d", MaxSecurityEvents, len(kept), kept[0].Timestamp)
+	}
+}
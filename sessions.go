@@ -0,0 +1,392 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionTokenPrefix = "rotur_ses_"
+
+	// sessions expire after this long without being used
+	SessionIdleTimeout = 30 * 24 * time.Hour
+	MaxSessionsPerUser = 50
+
+	// last_used_at is only written back once per interval to avoid a save on every request
+	sessionTouchInterval = time.Minute
+)
+
+// Session is one signed-in device. Only a hash of the token is stored.
+type Session struct {
+	ID         string `json:"id"`
+	TokenHash  string `json:"token_hash"`
+	Login      Login  `json:"login"`
+	CreatedAt  int64  `json:"created_at"`
+	LastUsedAt int64  `json:"last_used_at"`
+}
+
+type SessionPublic struct {
+	ID         string `json:"id"`
+	Origin     string `json:"origin"`
+	UserAgent  string `json:"user_agent"`
+	Country    string `json:"country"`
+	DeviceType string `json:"device_type"`
+	CreatedAt  int64  `json:"created_at"`
+	LastUsedAt int64  `json:"last_used_at"`
+	ExpiresAt  int64  `json:"expires_at"`
+	Current    bool   `json:"current"`
+}
+
+func (s *Session) ExpiresAt() int64 {
+	return s.LastUsedAt + SessionIdleTimeout.Milliseconds()
+}
+
+func (s *Session) ToPublic(currentID string) SessionPublic {
+	return SessionPublic{
+		ID:         s.ID,
+		Origin:     s.Login.Origin,
+		UserAgent:  s.Login.UserAgent,
+		Country:    s.Login.Country,
+		DeviceType: s.Login.Device_type,
+		CreatedAt:  s.CreatedAt,
+		LastUsedAt: s.LastUsedAt,
+		ExpiresAt:  s.ExpiresAt(),
+		Current:    s.ID == currentID,
+	}
+}
+
+type SessionStore struct {
+	Sessions  []Session `json:"sessions"`
+	UpdatedAt int64     `json:"updated_at"`
+}
+
+type sessionEntry struct {
+	Username  string
+	SessionID string
+}
+
+var (
+	sessionStoreCache = make(map[string]*SessionStore)
+	sessionStoreMutex sync.RWMutex
+
+	// token hash -> owner, so a session token can be resolved without scanning users
+	sessionIndex      = make(map[string]*sessionEntry)
+	sessionIndexMutex sync.RWMutex
+)
+
+func isSessionToken(key string) bool {
+	return strings.HasPrefix(key, sessionTokenPrefix)
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateSessionID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "ses_" + base64.RawURLEncoding.EncodeToString(b)
+}
+
+func generateSessionToken() string {
+	b := make([]byte, 48)
+	rand.Read(b)
+	return sessionTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+func getSessionStorePath(username string) string {
+	return filepath.Join(
+		USERDATA_PATH,
+		strings.ToLower(username),
+		"sessions.json",
+	)
+}
+
+func loadSessionStore(username string) (*SessionStore, error) {
+	username = strings.ToLower(username)
+
+	sessionStoreMutex.RLock()
+	if cached, ok := sessionStoreCache[username]; ok {
+		sessionStoreMutex.RUnlock()
+		return cached, nil
+	}
+	sessionStoreMutex.RUnlock()
+
+	store := &SessionStore{Sessions: []Session{}}
+	data, err := os.ReadFile(getSessionStorePath(username))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read session store: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, store); err != nil {
+			return nil, fmt.Errorf("failed to parse session store: %w", err)
+		}
+	}
+
+	sessionStoreMutex.Lock()
+	sessionStoreCache[username] = store
+	sessionStoreMutex.Unlock()
+	return store, nil
+}
+
+func saveSessionStore(username string, store *SessionStore) error {
+	username = strings.ToLower(username)
+
+	sessionStoreMutex.Lock()
+	store.UpdatedAt = time.Now().UnixMilli()
+	data, err := json.MarshalIndent(store, "", " ")
+	sessionStoreCache[username] = store
+	sessionStoreMutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal session store: %w", err)
+	}
+
+	path := getSessionStorePath(username)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create session directory: %w", err)
+	}
+	if err := atomicWrite(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write session store: %w", err)
+	}
+	return nil
+}
+
+func buildSessionIndex() {
+	usersMutex.RLock()
+	usernames := make([]string, 0, len(users))
+	for i := range users {
+		usernames = append(usernames, strings.ToLower(string(users[i].GetUsername())))
+	}
+	usersMutex.RUnlock()
+
+	now := time.Now().UnixMilli()
+	index := make(map[string]*sessionEntry)
+	for _, username := range usernames {
+		if _, err := os.Stat(getSessionStorePath(username)); err != nil {
+			continue
+		}
+		store, err := loadSessionStore(username)
+		if err != nil {
+			continue
+		}
+		for _, s := range store.Sessions {
+			if s.ExpiresAt() > now {
+				index[s.TokenHash] = &sessionEntry{Username: username, SessionID: s.ID}
+			}
+		}
+	}
+
+	sessionIndexMutex.Lock()
+	sessionIndex = index
+	sessionIndexMutex.Unlock()
+
+	log.Printf("Built session index with %d active sessions", len(index))
+}
+
+// createSession starts a new session for a login and returns the token, which is only shown once
+func createSession(user User, login Login) (*Session, string, error) {
+	username := strings.ToLower(string(user.GetUsername()))
+	store, err := loadSessionStore(username)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := generateSessionToken()
+	now := time.Now().UnixMilli()
+	session := Session{
+		ID:         generateSessionID(),
+		TokenHash:  hashSessionToken(token),
+		Login:      login,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	session.Login.SessionId = session.ID
+
+	sessionStoreMutex.Lock()
+	kept := make([]Session, 0, len(store.Sessions)+1)
+	for _, s := range store.Sessions {
+		if s.ExpiresAt() > now {
+			kept = append(kept, s)
+		}
+	}
+	// over the cap the least recently used sessions are signed out
+	var dropped []Session
+	if len(kept) >= MaxSessionsPerUser {
+		sort.Slice(kept, func(i, j int) bool { return kept[i].LastUsedAt > kept[j].LastUsedAt })
+		dropped = kept[MaxSessionsPerUser-1:]
+		kept = kept[:MaxSessionsPerUser-1]
+	}
+	store.Sessions = append(kept, session)
+	sessionStoreMutex.Unlock()
+
+	if err := saveSessionStore(username, store); err != nil {
+		return nil, "", err
+	}
+
+	sessionIndexMutex.Lock()
+	for _, s := range dropped {
+		delete(sessionIndex, s.TokenHash)
+	}
+	sessionIndex[session.TokenHash] = &sessionEntry{Username: username, SessionID: session.ID}
+	sessionIndexMutex.Unlock()
+
+	return &session, token, nil
+}
+
+// lookupSession resolves a session token to its owner and session, touching last_used_at
+func lookupSession(token string) (string, *Session, error) {
+	hash := hashSessionToken(token)
+
+	sessionIndexMutex.RLock()
+	entry, ok := sessionIndex[hash]
+	sessionIndexMutex.RUnlock()
+	if !ok {
+		return "", nil, fmt.Errorf("session not found")
+	}
+
+	store, err := loadSessionStore(entry.Username)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	sessionStoreMutex.Lock()
+	for i := range store.Sessions {
+		s := &store.Sessions[i]
+		if s.ID != entry.SessionID || s.TokenHash != hash {
+			continue
+		}
+		if s.ExpiresAt() <= now {
+			sessionStoreMutex.Unlock()
+			sessionIndexMutex.Lock()
+			delete(sessionIndex, hash)
+			sessionIndexMutex.Unlock()
+			return "", nil, fmt.Errorf("session has expired")
+		}
+		touched := now-s.LastUsedAt > sessionTouchInterval.Milliseconds()
+		if touched {
+			s.LastUsedAt = now
+		}
+		found := *s
+		sessionStoreMutex.Unlock()
+		if touched {
+			go saveSessionStore(entry.Username, store)
+		}
+		return entry.Username, &found, nil
+	}
+	sessionStoreMutex.Unlock()
+
+	sessionIndexMutex.Lock()
+	delete(sessionIndex, hash)
+	sessionIndexMutex.Unlock()
+	return "", nil, fmt.Errorf("session not found")
+}
+
+func getSessionID(token string) string {
+	sessionIndexMutex.RLock()
+	defer sessionIndexMutex.RUnlock()
+	if entry, ok := sessionIndex[hashSessionToken(token)]; ok {
+		return entry.SessionID
+	}
+	return ""
+}
+
+func listSessions(username string) ([]Session, error) {
+	store, err := loadSessionStore(username)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	sessionStoreMutex.RLock()
+	defer sessionStoreMutex.RUnlock()
+	active := make([]Session, 0, len(store.Sessions))
+	for _, s := range store.Sessions {
+		if s.ExpiresAt() > now {
+			active = append(active, s)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].LastUsedAt > active[j].LastUsedAt })
+	return active, nil
+}
+
+// revokeSessions signs out every session that match returns true for, returning how many went
+func revokeSessions(username string, match func(s *Session) bool) (int, error) {
+	store, err := loadSessionStore(username)
+	if err != nil {
+		return 0, err
+	}
+
+	sessionStoreMutex.Lock()
+	kept := make([]Session, 0, len(store.Sessions))
+	var revoked []Session
+	for i := range store.Sessions {
+		if match(&store.Sessions[i]) {
+			revoked = append(revoked, store.Sessions[i])
+		} else {
+			kept = append(kept, store.Sessions[i])
+		}
+	}
+	store.Sessions = kept
+	sessionStoreMutex.Unlock()
+
+	if len(revoked) == 0 {
+		return 0, nil
+	}
+
+	sessionIndexMutex.Lock()
+	for _, s := range revoked {
+		delete(sessionIndex, s.TokenHash)
+	}
+	sessionIndexMutex.Unlock()
+
+	return len(revoked), saveSessionStore(username, store)
+}
+
+// rotateSession swaps the token of a session for a new one, keeping its id and login
+func rotateSession(username string, sessionID string) (string, error) {
+	store, err := loadSessionStore(username)
+	if err != nil {
+		return "", err
+	}
+
+	token := generateSessionToken()
+	hash := hashSessionToken(token)
+
+	sessionStoreMutex.Lock()
+	var oldHash string
+	for i := range store.Sessions {
+		if store.Sessions[i].ID == sessionID {
+			oldHash = store.Sessions[i].TokenHash
+			store.Sessions[i].TokenHash = hash
+			store.Sessions[i].LastUsedAt = time.Now().UnixMilli()
+			break
+		}
+	}
+	sessionStoreMutex.Unlock()
+	if oldHash == "" {
+		return "", fmt.Errorf("session not found")
+	}
+
+	if err := saveSessionStore(username, store); err != nil {
+		return "", err
+	}
+
+	sessionIndexMutex.Lock()
+	delete(sessionIndex, oldHash)
+	sessionIndex[hash] = &sessionEntry{Username: strings.ToLower(username), SessionID: sessionID}
+	sessionIndexMutex.Unlock()
+	return token, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSessionLifecycle(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "session_test_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origPath := USERDATA_PATH
+	USERDATA_PATH = tmpDir
+	defer func() { USERDATA_PATH = origPath }()
+
+	user := User{"username": "SessionUser"}
+
+	laptop, laptopToken, err := createSession(user, Login{UserAgent: "laptop"})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	phone, phoneToken, err := createSession(user, Login{UserAgent: "phone"})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if laptop.Login.SessionId != laptop.ID {
+		t.Error("Login record should point at its session")
+	}
+
+	username, session, err := lookupSession(laptopToken)
+	if err != nil || username != "sessionuser" || session.ID != laptop.ID {
+		t.Fatalf("Lookup failed: %v %s %+v", err, username, session)
+	}
+
+	newToken, err := rotateSession("sessionuser", laptop.ID)
+	if err != nil {
+		t.Fatalf("Failed to rotate session: %v", err)
+	}
+	if _, _, err := lookupSession(laptopToken); err == nil {
+		t.Error("Old token should stop working after rotation")
+	}
+	if _, _, err := lookupSession(newToken); err != nil {
+		t.Errorf("Rotated token should work: %v", err)
+	}
+
+	n, err := revokeSessions("sessionuser", func(s *Session) bool { return s.ID == phone.ID })
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 revoked session, got %d (%v)", n, err)
+	}
+	if _, _, err := lookupSession(phoneToken); err == nil {
+		t.Error("Revoked session should not authenticate")
+	}
+	if _, _, err := lookupSession(newToken); err != nil {
+		t.Errorf("Other sessions should survive a single revoke: %v", err)
+	}
+
+	store, _ := loadSessionStore("sessionuser")
+	store.Sessions[0].LastUsedAt = time.Now().Add(-SessionIdleTimeout - time.Hour).UnixMilli()
+	if _, _, err := lookupSession(newToken); err == nil {
+		t.Error("Idle session should expire")
+	}
+}
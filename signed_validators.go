@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signed validators are compact EdDSA JWTs, so rotur apps can check who a user is
+// against the published JWKS without calling /validate.
+
+type validatorSigningKey struct {
+	KeyId     string `json:"kid"`
+	Seed      string `json:"seed"` // base64url ed25519 seed
+	CreatedAt int64  `json:"created_at"`
+
+	private ed25519.PrivateKey
+}
+
+// ValidatorClaims is the payload of a signed validator. aud is the key the
+// validator was generated for, the same key the legacy validator hashes.
+type ValidatorClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   UserId `json:"sub"`
+	Username  string `json:"username"`
+	Audience  string `json:"aud"`
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf"`
+	ExpiresAt int64  `json:"exp"`
+	Id        string `json:"jti"`
+}
+
+var (
+	// the last key signs, earlier ones stay published until their validators expire
+	validatorKeys      []*validatorSigningKey
+	validatorKeysMutex sync.RWMutex
+)
+
+func newValidatorSigningKey() *validatorSigningKey {
+	seed := make([]byte, ed25519.SeedSize)
+	rand.Read(seed)
+	pub := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	sum := sha256.Sum256(pub)
+	return &validatorSigningKey{
+		KeyId:     b64url(sum[:8]),
+		Seed:      b64url(seed),
+		CreatedAt: time.Now().UnixMilli(),
+		private:   ed25519.NewKeyFromSeed(seed),
+	}
+}
+
+// loadValidatorKeys reads the signing keys, creating one on first start
+func loadValidatorKeys() {
+	validatorKeysMutex.Lock()
+	defer validatorKeysMutex.Unlock()
+
+	var stored []*validatorSigningKey
+	data, err := os.ReadFile(VALIDATOR_KEYS_FILE_PATH)
+	if err == nil {
+		if err := json.Unmarshal(data, &stored); err != nil {
+			log.Printf("Error unmarshaling validator keys: %v", err)
+			stored = nil
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("Error reading validator keys file: %v", err)
+	}
+
+	keys := make([]*validatorSigningKey, 0, len(stored))
+	for _, k := range stored {
+		seed, err := decodeB64url(k.Seed)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			log.Printf("Skipping invalid validator key %s", k.KeyId)
+			continue
+		}
+		k.private = ed25519.NewKeyFromSeed(seed)
+		keys = append(keys, k)
+	}
+
+	if len(keys) == 0 {
+		keys = append(keys, newValidatorSigningKey())
+		if data, err := json.MarshalIndent(keys, "", "  "); err == nil {
+			if err := atomicWrite(VALIDATOR_KEYS_FILE_PATH, data, 0600); err != nil {
+				log.Printf("Error saving validator keys: %v", err)
+			}
+		}
+	}
+
+	validatorKeys = keys
+	log.Printf("Loaded %d validator signing keys", len(keys))
+}
+
+func activeValidatorKey() *validatorSigningKey {
+	validatorKeysMutex.RLock()
+	defer validatorKeysMutex.RUnlock()
+	if len(validatorKeys) == 0 {
+		return nil
+	}
+	return validatorKeys[len(validatorKeys)-1]
+}
+
+func findValidatorKey(kid string) *validatorSigningKey {
+	validatorKeysMutex.RLock()
+	defer validatorKeysMutex.RUnlock()
+	for _, k := range validatorKeys {
+		if k.KeyId == kid {
+			return k
+		}
+	}
+	return nil
+}
+
+// validatorJWKS is the public key set third parties verify signed validators with
+func validatorJWKS() map[string]any {
+	validatorKeysMutex.RLock()
+	defer validatorKeysMutex.RUnlock()
+
+	keys := make([]map[string]string, 0, len(validatorKeys))
+	for _, k := range validatorKeys {
+		keys = append(keys, map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   b64url(k.private.Public().(ed25519.PublicKey)),
+			"kid": k.KeyId,
+			"alg": "EdDSA",
+			"use": "sig",
+		})
+	}
+	return map[string]any{"keys": keys}
+}
+
+func signValidator(user User, audience string, now time.Time) (string, *ValidatorClaims, error) {
+	key := activeValidatorKey()
+	if key == nil {
+		return "", nil, fmt.Errorf("no validator signing key loaded")
+	}
+
+	jti := make([]byte, 12)
+	rand.Read(jti)
+	claims := &ValidatorClaims{
+		Issuer:    VALIDATOR_ISSUER,
+		Subject:   user.GetId(),
+		Username:  string(user.GetUsername()),
+		Audience:  audience,
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Unix() + validatorWindowSeconds,
+		Id:        b64url(jti),
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "EdDSA", "typ": "JWT", "kid": key.KeyId})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	signingInput := b64url(header) + "." + b64url(payload)
+	sig := ed25519.Sign(key.private, []byte(signingInput))
+	return signingInput + "." + b64url(sig), claims, nil
+}
+
+func isSignedValidator(v string) bool {
+	return strings.Count(v, ".") == 2
+}
+
+// verifySignedValidator checks the signature, issuer, audience and time window
+func verifySignedValidator(token string, audience string, now time.Time) (*ValidatorClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed validator")
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed validator")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil || header.Alg != "EdDSA" {
+		return nil, fmt.Errorf("unsupported validator algorithm")
+	}
+
+	key := findValidatorKey(header.Kid)
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !ed25519.Verify(key.private.Public().(ed25519.PublicKey), []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	rawPayload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed validator")
+	}
+	var claims ValidatorClaims
+	if err := json.Unmarshal(rawPayload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed validator")
+	}
+
+	if claims.Issuer != VALIDATOR_ISSUER {
+		return nil, fmt.Errorf("unexpected issuer")
+	}
+	if claims.Audience != audience {
+		return nil, fmt.Errorf("validator was issued for another key")
+	}
+	ts := now.Unix()
+	if ts < claims.NotBefore || ts >= claims.ExpiresAt {
+		return nil, fmt.Errorf("validator expired")
+	}
+	return &claims, nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StandingOrderDaily   = "daily"
+	StandingOrderWeekly  = "weekly"
+	StandingOrderMonthly = "monthly"
+
+	StandingOrderActive    = "active"
+	StandingOrderPaused    = "paused"
+	StandingOrderHeld      = "held" // last payment is waiting for the fraud review
+	StandingOrderCompleted = "completed"
+	StandingOrderCancelled = "cancelled"
+
+	MaxStandingOrdersPerUser = 25
+	// consecutive failed payments before an order is paused
+	StandingOrderMaxFailures = 3
+)
+
+// StandingOrder is a repeating transfer to a user or a group
+type StandingOrder struct {
+	Id             string  `json:"id"`
+	FromUserId     UserId  `json:"from_user_id"`
+	ToUserId       UserId  `json:"to_user_id,omitempty"`
+	ToGroup        string  `json:"to_group,omitempty"`
+	Amount         float64 `json:"amount"`
+	Note           string  `json:"note,omitempty"`
+	Frequency      string  `json:"frequency"`
+	Status         string  `json:"status"`
+	NextRunAt      int64   `json:"next_run_at"`
+	EndAt          *int64  `json:"end_at,omitempty"`
+	MaxRuns        int     `json:"max_runs,omitempty"`
+	Runs           int     `json:"runs"`
+	Failures       int     `json:"failures"`
+	LastRunAt      *int64  `json:"last_run_at,omitempty"`
+	LastError      string  `json:"last_error,omitempty"`
+	CreatedAt      int64   `json:"created_at"`
+	TokenId        string  `json:"token_id,omitempty"` // sub-token the order was created with, its limits apply to every payment
+	HeldTransferId string  `json:"held_transfer_id,omitempty"`
+}
+
+var (
+	standingOrders      = make([]StandingOrder, 0)
+	standingOrdersMutex sync.Mutex
+
+	errStandingOrderSender    = errors.New("sender no longer exists")
+	errStandingOrderRecipient = errors.New("recipient no longer exists")
+	errStandingOrderToken     = errors.New("token that created the order is no longer valid")
+)
+
+func isValidFrequency(f string) bool {
+	return f == StandingOrderDaily || f == StandingOrderWeekly || f == StandingOrderMonthly
+}
+
+// nextStandingRun returns the run after at for the given frequency
+func nextStandingRun(at int64, frequency string) int64 {
+	t := time.UnixMilli(at)
+	switch frequency {
+	case StandingOrderWeekly:
+		t = t.AddDate(0, 0, 7)
+	case StandingOrderMonthly:
+		t = t.AddDate(0, 1, 0)
+	default:
+		t = t.AddDate(0, 0, 1)
+	}
+	return t.UnixMilli()
+}
+
+// advance moves the order to its next run after now, skipping missed periods,
+// and completes it once the end date or run count is reached
+func (o *StandingOrder) advance(now int64) {
+	for o.NextRunAt <= now {
+		o.NextRunAt = nextStandingRun(o.NextRunAt, o.Frequency)
+	}
+	if o.Status != StandingOrderActive {
+		return
+	}
+	if (o.MaxRuns > 0 && o.Runs >= o.MaxRuns) || (o.EndAt != nil && o.NextRunAt > *o.EndAt) {
+		o.Status = StandingOrderCompleted
+	}
+}
+
+func (o *StandingOrder) recipient() string {
+	if o.ToGroup != "" {
+		return o.ToGroup
+	}
+	return string(o.ToUserId.User().GetUsername())
+}
+
+func loadStandingOrders() {
+	standingOrdersMutex.Lock()
+	defer standingOrdersMutex.Unlock()
+
+	data, err := os.ReadFile(STANDING_ORDERS_FILE_PATH)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading standing orders file: %v", err)
+		}
+		standingOrders = make([]StandingOrder, 0)
+		return
+	}
+
+	if err := json.Unmarshal(data, &standingOrders); err != nil {
+		log.Printf("Error unmarshaling standing orders: %v", err)
+		standingOrders = make([]StandingOrder, 0)
+		return
+	}
+
+	log.Printf("Loaded %d standing orders", len(standingOrders))
+}
+
+// saveStandingOrdersLocked expects standingOrdersMutex to be held
+func saveStandingOrdersLocked() {
+	saveJsonFile(STANDING_ORDERS_FILE_PATH, standingOrders)
+}
+
+func countActiveStandingOrders(userId UserId) int {
+	count := 0
+	for _, o := range standingOrders {
+		if o.FromUserId == userId && (o.Status == StandingOrderActive || o.Status == StandingOrderPaused || o.Status == StandingOrderHeld) {
+			count++
+		}
+	}
+	return count
+}
+
+// executeStandingOrder makes a single payment for the order
+func executeStandingOrder(o *StandingOrder) error {
+	from := getUserById(o.FromUserId)
+	if len(from) == 0 {
+		return errStandingOrderSender
+	}
+	if roundVal(from.GetCredits()) < o.Amount {
+		return errInsufficientCredits
+	}
+
+	release, err := o.reserveTokenTransfer(from)
+	if err != nil {
+		return err
+	}
+	err = payStandingOrder(o, from)
+	release(err == nil || errors.Is(err, errTransferHeld))
+	return err
+}
+
+// reserveTokenTransfer charges the payment against the daily limit of the sub-token
+// that created the order
+func (o *StandingOrder) reserveTokenTransfer(from User) (func(success bool), error) {
+	if o.TokenId == "" {
+		return func(bool) {}, nil
+	}
+	username := strings.ToLower(string(from.GetUsername()))
+	token := findSubToken(username, o.TokenId)
+	if token == nil || token.Revoked || (token.ExpiresAt != nil && *token.ExpiresAt < time.Now().UnixMilli()) {
+		return nil, errStandingOrderToken
+	}
+	return token.reserveTransfer(username, o.Amount)
+}
+
+func payStandingOrder(o *StandingOrder, from User) error {
+	note := o.Note
+	if note == "" {
+		note = "standing order"
+	}
+
+	if o.ToGroup != "" {
+		group, ok := getGroupByTag(o.ToGroup)
+		if !ok || !canTipGroup(from, o.ToGroup, group) {
+			return errStandingOrderRecipient
+		}
+		_, err := PerformGroupTip(from, o.ToGroup, o.Amount)
+		return err
+	}
+
+	to := getUserById(o.ToUserId)
+	if len(to) == 0 {
+		return errStandingOrderRecipient
+	}
+	return PerformCreditTransfer(from.GetUsername(), to.GetUsername(), o.Amount, note)
+}
+
+// processStandingOrders pays every order that is due and returns how many were paid
+func processStandingOrders(now time.Time) int {
+	standingOrdersMutex.Lock()
+	defer standingOrdersMutex.Unlock()
+
+	nowMs := now.UnixMilli()
+	paid := 0
+	changed := false
+	for i := range standingOrders {
+		o := &standingOrders[i]
+		if o.Status != StandingOrderActive || o.NextRunAt > nowMs {
+			continue
+		}
+		changed = true
+		runAt := nowMs
+		o.LastRunAt = &runAt
+
+		err := executeStandingOrder(o)
+		if heldId, held := heldTransferId(err); held {
+			// the order waits for review to deliver or refund the payment
+			o.Status = StandingOrderHeld
+			o.HeldTransferId = heldId
+			o.advance(nowMs)
+			continue
+		}
+		if err == nil {
+			paid++
+			o.Runs++
+			o.Failures = 0
+			o.LastError = ""
+			o.advance(nowMs)
+			continue
+		}
+
+		o.LastError = err.Error()
+		o.Failures++
+		switch {
+		case errors.Is(err, errStandingOrderSender):
+			o.Status = StandingOrderCancelled
+			continue
+		case errors.Is(err, errStandingOrderRecipient), errors.Is(err, errStandingOrderToken):
+			o.Status = StandingOrderCancelled
+		case o.Failures >= StandingOrderMaxFailures:
+			o.Status = StandingOrderPaused
+		}
+		o.advance(nowMs)
+
+		addUserEvent(o.FromUserId, "standing_order_failed", map[string]any{
+			"id":     o.Id,
+			"to":     o.recipient(),
+			"amount": o.Amount,
+			"error":  o.LastError,
+			"status": o.Status,
+		})
+	}
+
+	if changed {
+		saveStandingOrdersLocked()
+	}
+	if paid > 0 {
+		go saveUsers()
+	}
+	return paid
+}
+
+// settleHeldStandingOrder counts the payment behind an approved hold as a run,
+// or as a failure when it was rejected and refunded, and reactivates the order
+func settleHeldStandingOrder(h HeldTransfer) {
+	standingOrdersMutex.Lock()
+	defer standingOrdersMutex.Unlock()
+
+	for i := range standingOrders {
+		o := &standingOrders[i]
+		if o.HeldTransferId != h.Id {
+			continue
+		}
+		o.HeldTransferId = ""
+		if o.Status == StandingOrderHeld {
+			o.Status = StandingOrderActive
+		}
+
+		if h.Status == HeldTransferApproved {
+			o.Runs++
+			o.Failures = 0
+			o.LastError = ""
+		} else {
+			o.Failures++
+			o.LastError = "payment rejected by review"
+			if o.Status == StandingOrderActive && o.Failures >= StandingOrderMaxFailures {
+				o.Status = StandingOrderPaused
+			}
+			addUserEvent(o.FromUserId, "standing_order_failed", map[string]any{
+				"id":     o.Id,
+				"to":     o.recipient(),
+				"amount": o.Amount,
+				"error":  o.LastError,
+				"status": o.Status,
+			})
+		}
+		o.advance(time.Now().UnixMilli())
+		saveStandingOrdersLocked()
+		return
+	}
+}
+
+func runStandingOrders() {
+	ticker := time.NewTicker(time.Duration(STANDING_ORDER_CHECK_INTERVAL) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if paid := processStandingOrders(time.Now()); paid > 0 {
+			log.Printf("Paid %d standing orders", paid)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStandingOrderSchedule(t *testing.T) {
+	start := time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC).UnixMilli()
+	if next := nextStandingRun(start, StandingOrderWeekly); next != time.Date(2026, 2, 7, 12, 0, 0, 0, time.UTC).UnixMilli() {
+		t.Errorf("Unexpected weekly run %v", time.UnixMilli(next).UTC())
+	}
+
+	o := StandingOrder{Frequency: StandingOrderDaily, Status: StandingOrderActive, NextRunAt: start, MaxRuns: 2, Runs: 1}
+	o.advance(start + 3*24*int64(time.Hour/time.Millisecond))
+	if o.NextRunAt != start+4*24*int64(time.Hour/time.Millisecond) {
+		t.Errorf("Missed runs should be skipped, next run is %v", time.UnixMilli(o.NextRunAt).UTC())
+	}
+	if o.Status != StandingOrderActive {
+		t.Errorf("Order should still be active, got %s", o.Status)
+	}
+	o.Runs = 2
+	o.advance(o.NextRunAt)
+	if o.Status != StandingOrderCompleted {
+		t.Errorf("Order should complete after max runs, got %s", o.Status)
+	}
+
+	end := start + 12*int64(time.Hour/time.Millisecond)
+	o = StandingOrder{Frequency: StandingOrderDaily, Status: StandingOrderActive, NextRunAt: start, EndAt: &end}
+	o.advance(start)
+	if o.Status != StandingOrderCompleted {
+		t.Errorf("Order should complete after its end date, got %s", o.Status)
+	}
+}
+
+func TestStandingOrderFailures(t *testing.T) {
+	payer := User{"username": "payer", "sys.id": "so-payer", "sys.currency": 1.0}
+	payee := User{"username": "payee", "sys.id": "so-payee", "sys.currency": 0.0}
+	withTestUsers(t, payer, payee)
+	withTestLedger(t)
+
+	now := time.Now()
+	withTestStandingOrders(t,
+		StandingOrder{Id: "broke", FromUserId: "so-payer", ToUserId: "so-payee", Amount: 5, Frequency: StandingOrderDaily, Status: StandingOrderActive, NextRunAt: now.UnixMilli()},
+		StandingOrder{Id: "gone", FromUserId: "so-payer", ToUserId: "so-deleted", Amount: 0.5, Frequency: StandingOrderDaily, Status: StandingOrderActive, NextRunAt: now.UnixMilli()},
+	)
+
+	for i := 0; i < StandingOrderMaxFailures; i++ {
+		if paid := processStandingOrders(now.AddDate(0, 0, i)); paid != 0 {
+			t.Fatalf("Expected no payments, got %d", paid)
+		}
+	}
+
+	standingOrdersMutex.Lock()
+	broke, gone := standingOrders[0], standingOrders[1]
+	standingOrdersMutex.Unlock()
+
+	if broke.Status != StandingOrderPaused || broke.Failures != StandingOrderMaxFailures || broke.LastError != errInsufficientCredits.Error() {
+		t.Errorf("Order should pause after repeated failures, got %+v", broke)
+	}
+	if gone.Status != StandingOrderCancelled {
+		t.Errorf("Order to a deleted user should be cancelled, got %s", gone.Status)
+	}
+	if payer.GetCredits() != 1 || payee.GetCredits() != 0 {
+		t.Errorf("Failed orders should not move credits")
+	}
+
+	eventsHistoryMutex.RLock()
+	failed := 0
+	for _, e := range eventsHistory["so-payer"] {
+		if e.Type == "standing_order_failed" {
+			failed++
+		}
+	}
+	eventsHistoryMutex.RUnlock()
+	if failed != StandingOrderMaxFailures+1 {
+		t.Errorf("Expected %d failure notifications, got %d", StandingOrderMaxFailures+1, failed)
+	}
+}
+
+func TestStandingOrderTokenLimit(t *testing.T) {
+	withTempPath(t, &USERDATA_PATH, "userdata")
+	payer := User{"username": "tokenpayer", "sys.id": "so-tokenpayer", "sys.currency": 10.0}
+	payee := User{"username": "tokenpayee", "sys.id": "so-tokenpayee", "sys.currency": 0.0}
+	withTestUsers(t, payer, payee)
+	withTestLedger(t)
+
+	store := &TokenStore{Tokens: []SubToken{{ID: "limited", Constraints: &TokenConstraints{MaxTransferDaily: 1.5}}}}
+	if err := saveTokenStore("tokenpayer", store); err != nil {
+		t.Fatal(err)
+	}
+
+	order := &StandingOrder{FromUserId: "so-tokenpayer", ToUserId: "so-tokenpayee", Amount: 1, TokenId: "limited"}
+	if err := executeStandingOrder(order); err != nil || store.Tokens[0].TransferredToday != 1 {
+		t.Fatalf("First payment should go through and count against the token: %v", err)
+	}
+	if err := executeStandingOrder(order); err == nil || payee.GetCredits() != 1 {
+		t.Errorf("Payment over the token's daily limit should fail")
+	}
+
+	store.Tokens[0].Revoked = true
+	now := time.Now()
+	withTestStandingOrders(t, StandingOrder{Id: "revoked", FromUserId: "so-tokenpayer", ToUserId: "so-tokenpayee", Amount: 1, Frequency: StandingOrderDaily, Status: StandingOrderActive, NextRunAt: now.UnixMilli(), TokenId: "limited"})
+	processStandingOrders(now)
+	standingOrdersMutex.Lock()
+	revoked := standingOrders[0]
+	standingOrdersMutex.Unlock()
+	if revoked.Status != StandingOrderCancelled || revoked.LastError != errStandingOrderToken.Error() {
+		t.Errorf("Revoking the token should cancel the order, got %+v", revoked)
+	}
+}
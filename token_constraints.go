@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TokenConstraints narrows what a sub-token can touch within the permissions it holds.
+// Empty fields mean no restriction.
+type TokenConstraints struct {
+	FilePaths        []string `json:"file_paths,omitempty"`
+	MaxTransfer      float64  `json:"max_transfer,omitempty"`
+	MaxTransferDaily float64  `json:"max_transfer_daily,omitempty"`
+	GroupTags        []string `json:"group_tags,omitempty"`
+	NotifySources    []string `json:"notify_sources,omitempty"`
+}
+
+// daily transfer usage is tracked on the token itself, guarded by this mutex
+var tokenTransferMutex sync.Mutex
+
+func normalizeFileConstraintPath(path string) string {
+	return strings.Trim(strings.ToLower(path), "/")
+}
+
+// validateTokenConstraints checks and normalises constraints supplied when creating or updating a token
+func validateTokenConstraints(tc *TokenConstraints) (*TokenConstraints, error) {
+	if tc == nil {
+		return nil, nil
+	}
+
+	out := &TokenConstraints{}
+	for _, p := range tc.FilePaths {
+		p = normalizeFileConstraintPath(p)
+		if p == "" {
+			return nil, fmt.Errorf("file path constraints cannot be empty")
+		}
+		out.FilePaths = append(out.FilePaths, p)
+	}
+
+	if tc.MaxTransfer < 0 || tc.MaxTransferDaily < 0 {
+		return nil, fmt.Errorf("transfer limits cannot be negative")
+	}
+	out.MaxTransfer = math.Round(tc.MaxTransfer*100) / 100
+	out.MaxTransferDaily = math.Round(tc.MaxTransferDaily*100) / 100
+
+	for _, tag := range tc.GroupTags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			return nil, fmt.Errorf("group tag constraints cannot be empty")
+		}
+		out.GroupTags = append(out.GroupTags, tag)
+	}
+
+	for _, source := range tc.NotifySources {
+		source = strings.TrimSpace(source)
+		if source == "" {
+			return nil, fmt.Errorf("notification source constraints cannot be empty")
+		}
+		out.NotifySources = append(out.NotifySources, source)
+	}
+
+	if len(out.FilePaths) == 0 && out.MaxTransfer == 0 && out.MaxTransferDaily == 0 &&
+		len(out.GroupTags) == 0 && len(out.NotifySources) == 0 {
+		return nil, nil
+	}
+	return out, nil
+}
+
+func (tc *TokenConstraints) allowsFilePath(path string) bool {
+	path = normalizeFileConstraintPath(path)
+	for _, prefix := range tc.FilePaths {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (tc *TokenConstraints) allowsGroup(tag string) bool {
+	for _, t := range tc.GroupTags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (tc *TokenConstraints) allowsNotifySource(source string) bool {
+	for _, s := range tc.NotifySources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// peekJSONBody decodes the request body without consuming it for the handler
+func peekJSONBody(c *gin.Context, v any) error {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+	return json.Unmarshal(body, v)
+}
+
+// enforceConstraints checks the request against the token's constraints for perm.
+// Transfer limits are charged by limitTokenTransfer and reserveTokenTransfer.
+func (t *SubToken) enforceConstraints(c *gin.Context, perm TokenPermission) error {
+	tc := t.Constraints
+	if tc == nil {
+		return nil
+	}
+
+	switch {
+	case strings.HasPrefix(string(perm), "files:") && len(tc.FilePaths) > 0:
+		return tc.checkFileRequest(c)
+	case strings.HasPrefix(string(perm), "groups:") && len(tc.GroupTags) > 0:
+		tag := c.Param("grouptag")
+		if tag == "" {
+			if perm == PermViewGroups {
+				return nil
+			}
+			return fmt.Errorf("Token is restricted to specific groups")
+		}
+		if !tc.allowsGroup(tag) {
+			return fmt.Errorf("Token is not allowed to access group %s", tag)
+		}
+	case perm == PermSendNotifications && len(tc.NotifySources) > 0:
+		var req struct {
+			Source string `json:"source"`
+		}
+		if err := peekJSONBody(c, &req); err != nil {
+			return fmt.Errorf("Invalid request body")
+		}
+		if !tc.allowsNotifySource(req.Source) {
+			return fmt.Errorf("Token is not allowed to send notifications from %s", req.Source)
+		}
+	}
+	return nil
+}
+
+// limitTokenTransfer charges the amount in the body against a sub-token's transfer
+// limits, for routes that move that amount out of the account. Routes paying an
+// amount stored elsewhere call reserveTokenTransfer from the handler instead.
+func limitTokenTransfer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if v, ok := c.Get("sub_token"); !ok || v.(*SubToken).Constraints == nil {
+			c.Next()
+			return
+		}
+
+		var req struct {
+			Amount any `json:"amount"`
+		}
+		if err := peekJSONBody(c, &req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request body"})
+			c.Abort()
+			return
+		}
+		amount, err := parseTransferAmount(req.Amount)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		release, err := reserveTokenTransfer(c, amount)
+		if err != nil {
+			c.JSON(403, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		c.Next()
+		release(c.Writer.Status() < 300)
+	}
+}
+
+// reserveTokenTransfer charges amount against the limits of the sub-token the request
+// came in with. The returned func must be called with whether the transfer went
+// through, it does nothing for main tokens and tokens without limits.
+func reserveTokenTransfer(c *gin.Context, amount float64) (func(success bool), error) {
+	v, ok := c.Get("sub_token")
+	if !ok {
+		return func(bool) {}, nil
+	}
+	user := c.MustGet("user").(*User)
+	return v.(*SubToken).reserveTransfer(strings.ToLower(string(user.GetUsername())), amount)
+}
+
+// reserveTransfer holds the amount against the daily limit until the transfer finishes,
+// so concurrent transfers can't both squeeze under it
+func (t *SubToken) reserveTransfer(username string, amount float64) (func(success bool), error) {
+	tc := t.Constraints
+	if tc == nil {
+		return func(bool) {}, nil
+	}
+	if tc.MaxTransfer > 0 && amount > tc.MaxTransfer {
+		return nil, fmt.Errorf("Token is limited to %.2f credits per transfer", tc.MaxTransfer)
+	}
+	if tc.MaxTransferDaily <= 0 {
+		return func(bool) {}, nil
+	}
+
+	tokenTransferMutex.Lock()
+	defer tokenTransferMutex.Unlock()
+
+	today := time.Now().UTC().Format("2006-01-02")
+	if t.TransferDay != today {
+		t.TransferDay = today
+		t.TransferredToday = 0
+	}
+	if t.TransferredToday+amount > tc.MaxTransferDaily {
+		remaining := math.Max(0, tc.MaxTransferDaily-t.TransferredToday)
+		return nil, fmt.Errorf("Token daily transfer limit reached, %.2f credits remaining today", remaining)
+	}
+	t.TransferredToday = math.Round((t.TransferredToday+amount)*100) / 100
+
+	return func(success bool) {
+		tokenTransferMutex.Lock()
+		if !success && t.TransferDay == today {
+			t.TransferredToday = math.Max(0, math.Round((t.TransferredToday-amount)*100)/100)
+		}
+		tokenTransferMutex.Unlock()
+
+		if store, err := loadTokenStore(username); err == nil {
+			if err := saveTokenStore(username, store); err != nil {
+				log.Printf("Failed to save token store for %s: %v", username, err)
+			}
+		}
+	}, nil
+}
+
+// findSubToken looks up one of the user's sub-tokens by id
+func findSubToken(username string, id string) *SubToken {
+	store, err := loadTokenStore(username)
+	if err != nil {
+		return nil
+	}
+	for i := range store.Tokens {
+		if store.Tokens[i].ID == id {
+			return &store.Tokens[i]
+		}
+	}
+	return nil
+}
+
+// checkFileRequest resolves every path the request touches, requests that can't be
+// narrowed to individual files are rejected outright
+func (tc *TokenConstraints) checkFileRequest(c *gin.Context) error {
+	user := c.MustGet("user").(*User)
+	username := user.GetUsername()
+
+	pathOfUUID := func(uuid string) (string, bool) {
+		entry, err := fs.GetFileByUUID(username, uuid)
+		if err != nil || len(entry) < 3 {
+			return "", false
+		}
+		return entryToPath(entry, username), true
+	}
+	checkUUIDs := func(uuids []string) error {
+		for _, uuid := range uuids {
+			path, ok := pathOfUUID(uuid)
+			if !ok || !tc.allowsFilePath(path) {
+				return fmt.Errorf("Token is not allowed to access file %s", uuid)
+			}
+		}
+		return nil
+	}
+
+	route := c.FullPath()
+	switch {
+	case strings.HasSuffix(route, "/by-path/*path"):
+		if !tc.allowsFilePath(c.Param("path")) {
+			return fmt.Errorf("Token is not allowed to access %s", c.Param("path"))
+		}
+		return nil
+	case route == "/files/usage":
+		return nil
+	case c.Request.Method == "GET" && c.Query("uuid") != "":
+		return checkUUIDs([]string{c.Query("uuid")})
+	case route == "/files/by-uuid" || route == "/files/stats":
+		var req struct {
+			UUIDs []string `json:"uuids"`
+		}
+		if err := peekJSONBody(c, &req); err != nil {
+			return fmt.Errorf("Invalid request body")
+		}
+		return checkUUIDs(req.UUIDs)
+	case route == "/files" && c.Request.Method == "POST":
+		var req UpdateFileRequest
+		if err := peekJSONBody(c, &req); err != nil {
+			return fmt.Errorf("Invalid request body")
+		}
+		for _, change := range req.Updates {
+			switch change.Command {
+			case "UUIDa":
+				dta, ok := change.Dta.([]any)
+				if !ok || len(dta) < 3 || !tc.allowsFilePath(entryToPath(dta, username)) {
+					return fmt.Errorf("Token is not allowed to create file %s", change.UUID)
+				}
+			case "UUIDr":
+				entry, err := fs.GetFileByUUID(username, change.UUID)
+				if err != nil || len(entry) < 3 || !tc.allowsFilePath(entryToPath(entry, username)) {
+					return fmt.Errorf("Token is not allowed to access file %s", change.UUID)
+				}
+				// a rename or move must stay inside the allowed paths too
+				moved := append(FileEntry{}, entry...)
+				if idx := extractIndex(change.Idx); idx >= 0 && idx < len(moved) {
+					moved[idx] = change.Dta
+				}
+				if !tc.allowsFilePath(entryToPath(moved, username)) {
+					return fmt.Errorf("Token is not allowed to move file %s there", change.UUID)
+				}
+			default:
+				if err := checkUUIDs([]string{change.UUID}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Token is restricted to specific file paths")
+}
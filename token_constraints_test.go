@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidateTokenConstraints(t *testing.T) {
+	tc, err := validateTokenConstraints(&TokenConstraints{FilePaths: []string{"/Origin/(C) Users/Test/Docs/"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if tc.FilePaths[0] != "origin/(c) users/test/docs" {
+		t.Errorf("File path should be normalised, got %q", tc.FilePaths[0])
+	}
+
+	if tc, err := validateTokenConstraints(&TokenConstraints{}); err != nil || tc != nil {
+		t.Error("Empty constraints should clear to nil")
+	}
+	if _, err := validateTokenConstraints(&TokenConstraints{MaxTransfer: -1}); err == nil {
+		t.Error("Negative transfer limit should be rejected")
+	}
+}
+
+func TestTokenConstraintsFilePaths(t *testing.T) {
+	tc := &TokenConstraints{FilePaths: []string{"origin/(c) users/test/docs"}}
+
+	if !tc.allowsFilePath("/origin/(c) users/test/docs/notes.txt") {
+		t.Error("File inside the prefix should be allowed")
+	}
+	if !tc.allowsFilePath("origin/(c) users/test/docs") {
+		t.Error("The prefix itself should be allowed")
+	}
+	if tc.allowsFilePath("origin/(c) users/test/docs2/secret.txt") {
+		t.Error("Sibling folder sharing the prefix text should not be allowed")
+	}
+}
+
+func TestTokenConstraintsDailyTransfer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	withTempPath(t, &USERDATA_PATH, "userdata")
+
+	token := &SubToken{
+		Permissions: []TokenPermission{PermTransferCredits},
+		Constraints: &TokenConstraints{MaxTransfer: 10, MaxTransferDaily: 15},
+	}
+	user := User{"username": "constraintuser"}
+
+	// the handler answers with status, a failed transfer gives its reservation back
+	request := func(amount string, status int) int {
+		r := gin.New()
+		r.POST("/me/transfer", func(c *gin.Context) {
+			c.Set("user", &user)
+			c.Set("sub_token", token)
+		}, limitTokenTransfer(), func(c *gin.Context) {
+			c.Status(status)
+		})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/me/transfer", strings.NewReader(`{"to":"someone","amount":`+amount+`}`)))
+		return w.Code
+	}
+
+	if code := request("11", 200); code != 403 {
+		t.Errorf("Transfer above the per-transfer limit should be rejected, got %d", code)
+	}
+	if code := request("10", 200); code != 200 {
+		t.Fatalf("Transfer within limits should pass, got %d", code)
+	}
+	if code := request("6", 200); code != 403 {
+		t.Errorf("Transfer over the daily limit should be rejected, got %d", code)
+	}
+	if code := request("5", 400); code != 400 {
+		t.Fatalf("Transfer up to the daily limit should reach the handler, got %d", code)
+	}
+	if token.TransferredToday != 10 {
+		t.Errorf("Failed transfer should be released, got %.2f used", token.TransferredToday)
+	}
+
+	// pausing an order or declining a request moves nothing
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/me/standing_orders/x/pause", nil)
+	c.Set("user", &user)
+	if err := token.enforceConstraints(c, PermTransferCredits); err != nil {
+		t.Errorf("Requests without an amount should not be limited: %v", err)
+	}
+}
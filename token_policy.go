@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MaxTokenAllowedIPs   = 20
+	MaxTokenViolations   = 50
+	MaxTokenIdleHrs      = 8760
+	MaxTokenBudgetCount  = 10000
+	MaxTokenBudgetPeriod = 86400
+
+	// repeats of the same violation within this window are counted on one entry
+	tokenViolationCoalesce = time.Minute
+
+	ViolationIPNotAllowed = "ip_not_allowed"
+	ViolationIdleExpired  = "idle_expired"
+	ViolationRateLimited  = "rate_limited"
+)
+
+// TokenRateLimit is a request budget of Count requests every PeriodSecs, per token
+type TokenRateLimit struct {
+	Count      int `json:"count"`
+	PeriodSecs int `json:"period_secs"`
+}
+
+type TokenViolation struct {
+	Type    string `json:"type"`
+	IP      string `json:"ip,omitempty"`
+	Count   int    `json:"count"`
+	FirstAt int64  `json:"first_at"`
+	LastAt  int64  `json:"last_at"`
+}
+
+var tokenPolicyMutex sync.Mutex
+
+// validateAllowedIPs normalises plain addresses to single-host CIDRs
+func validateAllowedIPs(list []string) ([]string, error) {
+	if len(list) > MaxTokenAllowedIPs {
+		return nil, fmt.Errorf("Maximum of %d allowed IP ranges", MaxTokenAllowedIPs)
+	}
+
+	out := make([]string, 0, len(list))
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if _, ipnet, err := net.ParseCIDR(entry); err == nil {
+			out = append(out, ipnet.String())
+			continue
+		}
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid IP or CIDR range: %s", entry)
+		}
+		if ip.To4() != nil {
+			out = append(out, ip.String()+"/32")
+		} else {
+			out = append(out, ip.String()+"/128")
+		}
+	}
+	return out, nil
+}
+
+func validateTokenRateLimit(rl *TokenRateLimit) (*TokenRateLimit, error) {
+	if rl == nil || (rl.Count == 0 && rl.PeriodSecs == 0) {
+		return nil, nil
+	}
+	if rl.Count < 1 || rl.Count > MaxTokenBudgetCount {
+		return nil, fmt.Errorf("rate_limit.count must be between 1 and %d", MaxTokenBudgetCount)
+	}
+	if rl.PeriodSecs < 1 || rl.PeriodSecs > MaxTokenBudgetPeriod {
+		return nil, fmt.Errorf("rate_limit.period_secs must be between 1 and %d", MaxTokenBudgetPeriod)
+	}
+	return &TokenRateLimit{Count: rl.Count, PeriodSecs: rl.PeriodSecs}, nil
+}
+
+func (t *SubToken) allowsIP(ip string) bool {
+	if len(t.AllowedIPs) == 0 {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, cidr := range t.AllowedIPs {
+		if _, ipnet, err := net.ParseCIDR(cidr); err == nil && ipnet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// idleExpired reports whether the token went unused for longer than its idle timeout
+func (t *SubToken) idleExpired(now int64) bool {
+	if t.IdleTimeoutHrs <= 0 {
+		return false
+	}
+	last := t.CreatedAt
+	if t.LastUsedAt != nil {
+		last = *t.LastUsedAt
+	}
+	return now-last > int64(t.IdleTimeoutHrs)*60*60*1000
+}
+
+// checkPolicy enforces the allowlist and idle timeout, ip is skipped when empty
+// (lookups that aren't made on behalf of the caller's own request)
+func (t *SubToken) checkPolicy(ip string, now int64) (string, error) {
+	if t.idleExpired(now) {
+		return ViolationIdleExpired, fmt.Errorf("token has expired due to inactivity")
+	}
+	if ip != "" && !t.allowsIP(ip) {
+		return ViolationIPNotAllowed, fmt.Errorf("token cannot be used from this IP address")
+	}
+	return "", nil
+}
+
+// recordViolation appends to the token's violation log, the caller saves the store
+func (t *SubToken) recordViolation(kind string, ip string, now int64) {
+	tokenPolicyMutex.Lock()
+	defer tokenPolicyMutex.Unlock()
+
+	if n := len(t.Violations); n > 0 {
+		last := &t.Violations[n-1]
+		if last.Type == kind && last.IP == ip && now-last.LastAt < tokenViolationCoalesce.Milliseconds() {
+			last.Count++
+			last.LastAt = now
+			return
+		}
+	}
+
+	t.Violations = append(t.Violations, TokenViolation{
+		Type:    kind,
+		IP:      ip,
+		Count:   1,
+		FirstAt: now,
+		LastAt:  now,
+	})
+	if n := len(t.Violations); n > MaxTokenViolations {
+		t.Violations = t.Violations[n-MaxTokenViolations:]
+	}
+}
+
+// applyTokenBudget counts a request against the token's own budget, tokens
+// without one are always allowed. budgets share rateLimitStorage so they get cleaned up with it.
+func applyTokenBudget(t *SubToken) (bool, int, int64) {
+	if t.RateLimit == nil {
+		return true, 0, 0
+	}
+
+	rateLimitMutex.Lock()
+	defer rateLimitMutex.Unlock()
+
+	now := time.Now().Unix()
+	key := "token:" + t.ID
+	budget, ok := rateLimitStorage[key]
+	if !ok || now > budget.ResetAt {
+		budget = &RateLimit{ResetAt: now + int64(t.RateLimit.PeriodSecs)}
+		rateLimitStorage[key] = budget
+	}
+	budget.Count++
+
+	return budget.Count <= t.RateLimit.Count, max(t.RateLimit.Count-budget.Count, 0), budget.ResetAt
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidateAllowedIPs(t *testing.T) {
+	got, err := validateAllowedIPs([]string{"10.0.0.0/8", " 192.168.1.5 ", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.5/32", "2001:db8::1/128"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Entry %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	if _, err := validateAllowedIPs([]string{"not-an-ip"}); err == nil {
+		t.Error("Invalid entry should be rejected")
+	}
+}
+
+func TestSubTokenPolicy(t *testing.T) {
+	now := time.Now().UnixMilli()
+	token := &SubToken{
+		CreatedAt:      now,
+		AllowedIPs:     []string{"10.0.0.0/8"},
+		IdleTimeoutHrs: 1,
+	}
+
+	if _, err := token.checkPolicy("10.1.2.3", now); err != nil {
+		t.Errorf("Address inside the allowlist should pass: %v", err)
+	}
+	if kind, err := token.checkPolicy("8.8.8.8", now); err == nil || kind != ViolationIPNotAllowed {
+		t.Errorf("Address outside the allowlist should be rejected, got %q", kind)
+	}
+	if _, err := token.checkPolicy("", now); err != nil {
+		t.Error("Lookups without a caller address skip the allowlist")
+	}
+
+	later := now + 2*60*60*1000
+	if kind, _ := token.checkPolicy("10.1.2.3", later); kind != ViolationIdleExpired {
+		t.Errorf("Token unused for longer than its idle timeout should expire, got %q", kind)
+	}
+	token.LastUsedAt = &later
+	if _, err := token.checkPolicy("10.1.2.3", later); err != nil {
+		t.Errorf("Use should slide the idle window: %v", err)
+	}
+}
+
+func TestRecordViolationCoalesces(t *testing.T) {
+	token := &SubToken{}
+	now := time.Now().UnixMilli()
+
+	token.recordViolation(ViolationRateLimited, "1.2.3.4", now)
+	token.recordViolation(ViolationRateLimited, "1.2.3.4", now+1000)
+	token.recordViolation(ViolationIPNotAllowed, "1.2.3.4", now+2000)
+
+	if len(token.Violations) != 2 {
+		t.Fatalf("Expected 2 violation entries, got %d", len(token.Violations))
+	}
+	if token.Violations[0].Count != 2 {
+		t.Errorf("Repeated violation should be counted, got %d", token.Violations[0].Count)
+	}
+}
+
+func TestApplyTokenBudget(t *testing.T) {
+	token := &SubToken{ID: "st_budget_test", RateLimit: &TokenRateLimit{Count: 2, PeriodSecs: 60}}
+	defer func() {
+		rateLimitMutex.Lock()
+		delete(rateLimitStorage, "token:"+token.ID)
+		rateLimitMutex.Unlock()
+	}()
+
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := applyTokenBudget(token); !ok {
+			t.Fatalf("Request %d should be within budget", i+1)
+		}
+	}
+	if ok, remaining, _ := applyTokenBudget(token); ok || remaining != 0 {
+		t.Error("Third request should exceed the budget")
+	}
+
+	if ok, _, _ := applyTokenBudget(&SubToken{ID: "st_unlimited"}); !ok {
+		t.Error("Tokens without a budget are not limited")
+	}
+}
+
+func TestTokenBudgetRejectDoesNotWrite(t *testing.T) {
+	withTempPath(t, &USERDATA_PATH, "userdata")
+	user := User{"username": "budgeted", "sys.id": "tp-budgeted"}
+	withTestUsers(t, user)
+
+	token := SubToken{ID: "st_reject_test", Token: "rotur_sub_reject_test", Permissions: []TokenPermission{PermViewProfile}, RateLimit: &TokenRateLimit{Count: 1, PeriodSecs: 60}}
+	if err := saveTokenStore("budgeted", &TokenStore{Tokens: []SubToken{token}}); err != nil {
+		t.Fatal(err)
+	}
+	addToSubTokenIndex(token.Token, "budgeted", token.ID)
+	t.Cleanup(func() {
+		removeFromSubTokenIndex(token.Token)
+		rateLimitMutex.Lock()
+		delete(rateLimitStorage, "token:"+token.ID)
+		rateLimitMutex.Unlock()
+	})
+
+	r := gin.New()
+	r.GET("/", requiresAuth, func(c *gin.Context) { c.Status(200) })
+	codes := make([]int, 0, 2)
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/?auth="+token.Token, nil))
+		codes = append(codes, w.Code)
+	}
+	if codes[0] != 200 || codes[1] != 429 {
+		t.Fatalf("Expected 200 then 429, got %v", codes)
+	}
+
+	data, err := os.ReadFile(getTokenStorePath("budgeted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var onDisk TokenStore
+	if err := json.Unmarshal(data, &onDisk); err != nil {
+		t.Fatal(err)
+	}
+	if len(onDisk.Tokens[0].Violations) != 0 {
+		t.Error("Rejected requests should not write the token store")
+	}
+
+	cached, _ := loadTokenStore("budgeted")
+	queuedTokenStoreSavesMutex.Lock()
+	queued := queuedTokenStoreSaves["budgeted"]
+	queuedTokenStoreSavesMutex.Unlock()
+	if len(cached.Tokens[0].Violations) != 1 || !queued {
+		t.Error("The violation should be kept in memory and queued for the batched save")
+	}
+}
@@ -191,6 +191,17 @@ type SubToken struct {
 	Origin      string            `json:"origin,omitempty"`
 	Description string            `json:"description,omitempty"`
 	Websites    []string          `json:"websites,omitempty"`
+	// set on tokens issued to an oauth app
+	AppId            string            `json:"app_id,omitempty"`
+	RefreshToken     string            `json:"refresh_token,omitempty"`
+	RefreshExpiresAt *int64            `json:"refresh_expires_at,omitempty"`
+	Constraints      *TokenConstraints `json:"constraints,omitempty"`
+	TransferDay      string            `json:"transfer_day,omitempty"`
+	TransferredToday float64           `json:"transferred_today,omitempty"`
+	AllowedIPs       []string          `json:"allowed_ips,omitempty"`
+	IdleTimeoutHrs   int               `json:"idle_timeout_hrs,omitempty"`
+	RateLimit        *TokenRateLimit   `json:"rate_limit,omitempty"`
+	Violations       []TokenViolation  `json:"violations,omitempty"`
 }
 
 type TokenStore struct {
@@ -201,8 +212,18 @@ type TokenStore struct {
 var (
 	tokenStoreCache = make(map[string]*TokenStore)
 	tokenStoreMutex sync.RWMutex
+
+	// one lock per user file, writes share the same temp file
+	tokenStoreSaveLocks sync.Map
+
+	queuedTokenStoreSaves      = make(map[string]bool)
+	queuedTokenStoreSavesMutex sync.Mutex
 )
 
+// TokenStoreSaveDelay batches the writes made by using a token, a busy or
+// rate limited token would otherwise rewrite its store on every request
+const TokenStoreSaveDelay = 5 * time.Second
+
 func getTokenStorePath(username string) string {
 	return filepath.Join(
 		USERDATA_PATH,
@@ -248,6 +269,10 @@ func loadTokenStore(username string) (*TokenStore, error) {
 }
 
 func saveTokenStore(username string, store *TokenStore) error {
+	mu, _ := tokenStoreSaveLocks.LoadOrStore(strings.ToLower(username), &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
 	store.UpdatedAt = time.Now().UnixMilli()
 
 	path := getTokenStorePath(username)
@@ -271,6 +296,32 @@ func saveTokenStore(username string, store *TokenStore) error {
 	return nil
 }
 
+// queueTokenStoreSave saves the user's cached token store once, TokenStoreSaveDelay
+// from the first call, however often it is called in between
+func queueTokenStoreSave(username string) {
+	username = strings.ToLower(username)
+	queuedTokenStoreSavesMutex.Lock()
+	defer queuedTokenStoreSavesMutex.Unlock()
+	if queuedTokenStoreSaves[username] {
+		return
+	}
+	queuedTokenStoreSaves[username] = true
+
+	time.AfterFunc(TokenStoreSaveDelay, func() {
+		queuedTokenStoreSavesMutex.Lock()
+		delete(queuedTokenStoreSaves, username)
+		queuedTokenStoreSavesMutex.Unlock()
+
+		store, err := loadTokenStore(username)
+		if err != nil {
+			return
+		}
+		if err := saveTokenStore(username, store); err != nil {
+			log.Printf("Failed to save token store for %s: %v", username, err)
+		}
+	})
+}
+
 func generateSubTokenID() string {
 	b := make([]byte, 16)
 	rand.Read(b)
@@ -297,6 +348,9 @@ func authenticateWithSubToken(tokenValue string) (*User, *SubToken, error) {
 		for j := range store.Tokens {
 			t := &store.Tokens[j]
 			if t.Token == tokenValue {
+				if users[i].IsPendingDeletion() {
+					return nil, nil, errAccountPendingDeletion
+				}
 				if t.Revoked {
 					return nil, nil, fmt.Errorf("token has been revoked")
 				}
@@ -305,7 +359,7 @@ func authenticateWithSubToken(tokenValue string) (*User, *SubToken, error) {
 				}
 				now := time.Now().UnixMilli()
 				t.LastUsedAt = &now
-				go saveTokenStore(username, store)
+				queueTokenStoreSave(username)
 				return &users[i], t, nil
 			}
 		}
@@ -334,44 +388,58 @@ func (t *SubToken) hasAllPermissions(perms []TokenPermission) bool {
 
 func (t *SubToken) ToPublic() SubTokenPublic {
 	return SubTokenPublic{
-		ID:          t.ID,
-		Name:        t.Name,
-		Permissions: t.Permissions,
-		CreatedAt:   t.CreatedAt,
-		LastUsedAt:  t.LastUsedAt,
-		ExpiresAt:   t.ExpiresAt,
-		Revoked:     t.Revoked,
-		RevokedAt:   t.RevokedAt,
-		Origin:      t.Origin,
-		Description: t.Description,
-		Websites:    t.Websites,
+		ID:             t.ID,
+		Name:           t.Name,
+		Permissions:    t.Permissions,
+		CreatedAt:      t.CreatedAt,
+		LastUsedAt:     t.LastUsedAt,
+		ExpiresAt:      t.ExpiresAt,
+		Revoked:        t.Revoked,
+		RevokedAt:      t.RevokedAt,
+		Origin:         t.Origin,
+		Description:    t.Description,
+		Websites:       t.Websites,
+		AppId:          t.AppId,
+		Constraints:    t.Constraints,
+		AllowedIPs:     t.AllowedIPs,
+		IdleTimeoutHrs: t.IdleTimeoutHrs,
+		RateLimit:      t.RateLimit,
 	}
 }
 
 type SubTokenPublic struct {
-	ID          string            `json:"id"`
-	Name        string            `json:"name"`
-	Permissions []TokenPermission `json:"permissions"`
-	CreatedAt   int64             `json:"created_at"`
-	LastUsedAt  *int64            `json:"last_used_at,omitempty"`
-	ExpiresAt   *int64            `json:"expires_at,omitempty"`
-	Revoked     bool              `json:"revoked"`
-	RevokedAt   *int64            `json:"revoked_at,omitempty"`
-	Origin      string            `json:"origin,omitempty"`
-	Description string            `json:"description,omitempty"`
-	Websites    []string          `json:"websites,omitempty"`
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	Permissions    []TokenPermission `json:"permissions"`
+	CreatedAt      int64             `json:"created_at"`
+	LastUsedAt     *int64            `json:"last_used_at,omitempty"`
+	ExpiresAt      *int64            `json:"expires_at,omitempty"`
+	Revoked        bool              `json:"revoked"`
+	RevokedAt      *int64            `json:"revoked_at,omitempty"`
+	Origin         string            `json:"origin,omitempty"`
+	Description    string            `json:"description,omitempty"`
+	Websites       []string          `json:"websites,omitempty"`
+	AppId          string            `json:"app_id,omitempty"`
+	Constraints    *TokenConstraints `json:"constraints,omitempty"`
+	AllowedIPs     []string          `json:"allowed_ips,omitempty"`
+	IdleTimeoutHrs int               `json:"idle_timeout_hrs,omitempty"`
+	RateLimit      *TokenRateLimit   `json:"rate_limit,omitempty"`
 }
 
 type SubTokenCreate struct {
-	ID          string            `json:"id"`
-	Name        string            `json:"name"`
-	Token       string            `json:"token"`
-	Permissions []TokenPermission `json:"permissions"`
-	CreatedAt   int64             `json:"created_at"`
-	ExpiresAt   *int64            `json:"expires_at,omitempty"`
-	Origin      string            `json:"origin,omitempty"`
-	Description string            `json:"description,omitempty"`
-	Websites    []string          `json:"websites,omitempty"`
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	Token          string            `json:"token"`
+	Permissions    []TokenPermission `json:"permissions"`
+	CreatedAt      int64             `json:"created_at"`
+	ExpiresAt      *int64            `json:"expires_at,omitempty"`
+	Origin         string            `json:"origin,omitempty"`
+	Description    string            `json:"description,omitempty"`
+	Websites       []string          `json:"websites,omitempty"`
+	Constraints    *TokenConstraints `json:"constraints,omitempty"`
+	AllowedIPs     []string          `json:"allowed_ips,omitempty"`
+	IdleTimeoutHrs int               `json:"idle_timeout_hrs,omitempty"`
+	RateLimit      *TokenRateLimit   `json:"rate_limit,omitempty"`
 }
 
 var (
@@ -390,6 +458,10 @@ func buildSubTokenIndex() {
 
 	subTokenIndex = make(map[string]*subTokenEntry)
 
+	oauthRefreshIndexMutex.Lock()
+	defer oauthRefreshIndexMutex.Unlock()
+	oauthRefreshIndex = make(map[string]*subTokenEntry)
+
 	usersMutex.RLock()
 	defer usersMutex.RUnlock()
 
@@ -400,20 +472,29 @@ func buildSubTokenIndex() {
 			continue
 		}
 
+		now := time.Now().UnixMilli()
 		for _, t := range store.Tokens {
-			if !t.Revoked && (t.ExpiresAt == nil || *t.ExpiresAt > time.Now().UnixMilli()) {
+			if !t.Revoked && (t.ExpiresAt == nil || *t.ExpiresAt > now) {
 				subTokenIndex[t.Token] = &subTokenEntry{
 					Username: username,
 					TokenID:  t.ID,
 				}
 			}
+			if !t.Revoked && t.refreshable(now) {
+				oauthRefreshIndex[t.RefreshToken] = &subTokenEntry{
+					Username: username,
+					TokenID:  t.ID,
+				}
+			}
 		}
 	}
 
 	log.Printf("Built sub-token index with %d active tokens", len(subTokenIndex))
 }
 
-func authenticateWithSubTokenFast(tokenValue string) (*User, *SubToken, error) {
+// authenticateWithSubTokenFast resolves a sub-token and enforces its policy. ip is the
+// caller's address for the allowlist, empty when checking a token on someone else's behalf.
+func authenticateWithSubTokenFast(tokenValue string, ip string) (*User, *SubToken, error) {
 	subTokenIndexMutex.RLock()
 	entry, ok := subTokenIndex[tokenValue]
 	subTokenIndexMutex.RUnlock()
@@ -435,6 +516,9 @@ func authenticateWithSubTokenFast(tokenValue string) (*User, *SubToken, error) {
 	if foundUser == nil {
 		return nil, nil, fmt.Errorf("user not found for sub-token")
 	}
+	if foundUser.IsPendingDeletion() {
+		return nil, nil, errAccountPendingDeletion
+	}
 
 	store, err := loadTokenStore(entry.Username)
 	if err != nil {
@@ -457,8 +541,16 @@ func authenticateWithSubTokenFast(tokenValue string) (*User, *SubToken, error) {
 				return nil, nil, fmt.Errorf("token has expired")
 			}
 			now := time.Now().UnixMilli()
+			if kind, err := t.checkPolicy(ip, now); err != nil {
+				t.recordViolation(kind, ip, now)
+				if kind == ViolationIdleExpired {
+					removeFromSubTokenIndex(tokenValue)
+				}
+				queueTokenStoreSave(entry.Username)
+				return nil, nil, err
+			}
 			t.LastUsedAt = &now
-			go saveTokenStore(entry.Username, store)
+			queueTokenStoreSave(entry.Username)
 			return foundUser, t, nil
 		}
 	}
@@ -506,11 +598,8 @@ func cleanExpiredSubTokens() {
 			changed := false
 			for j := range store.Tokens {
 				t := &store.Tokens[j]
-				if !t.Revoked && t.ExpiresAt != nil && *t.ExpiresAt < now {
-					t.Revoked = true
-					revokedAt := now
-					t.RevokedAt = &revokedAt
-					removeFromSubTokenIndex(t.Token)
+				if !t.Revoked && t.ExpiresAt != nil && *t.ExpiresAt < now && !t.refreshable(now) {
+					markSubTokenRevoked(t, now)
 					changed = true
 				}
 			}
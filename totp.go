@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	totpPeriod          = 30
+	totpDigits          = 6
+	totpSkew            = 1 // steps either side of now that are still accepted
+	totpIssuer          = "rotur"
+	totpBackupCodeCount = 10
+
+	// transfers above this many credits need a second factor when 2fa is enabled
+	StepUpTransferThreshold = 100.0
+)
+
+// keys that are never sent back to clients, not even to the account owner
+var privateUserKeys = []string{
+	"password",
+	"sys.totp_secret",
+	"sys.totp_pending_secret",
+	"sys.totp_backup_codes",
+	"sys.totp_last_step",
+}
+
+func isPrivateUserKey(key string) bool {
+	for _, k := range privateUserKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// serialises code checks so a single code can't be replayed by concurrent requests
+var totpMutex sync.Mutex
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func generateTOTPSecret() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return totpEncoding.EncodeToString(b)
+}
+
+func totpURI(username Username, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + string(username))
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprintf("%d", totpDigits))
+	q.Set("period", fmt.Sprintf("%d", totpPeriod))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// totpCode computes the RFC 6238 code for a time step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range totpDigits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// verifyTOTP returns the matched time step, steps at or before lastStep are rejected as replays
+func verifyTOTP(secret string, code string, lastStep int64, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func hashBackupCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateBackupCodes returns the codes to show the user once and the hashes to store
+func generateBackupCodes() ([]string, []string) {
+	codes := make([]string, totpBackupCodeCount)
+	hashes := make([]string, totpBackupCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		rand.Read(b)
+		raw := strings.ToLower(hex.EncodeToString(b))
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashBackupCode(codes[i])
+	}
+	return codes, hashes
+}
+
+func (u User) HasTOTP() bool {
+	return u.Get("sys.totp_enabled") == true && u.GetString("sys.totp_secret") != ""
+}
+
+func (u User) GetBackupCodeCount() int {
+	return len(getStringSlice(u, "sys.totp_backup_codes"))
+}
+
+// checkSecondFactor accepts a current TOTP code or consumes one backup code
+func checkSecondFactor(user User, code string) bool {
+	if !useSecondFactor(user, code) {
+		return false
+	}
+	go saveUsers()
+	return true
+}
+
+// useSecondFactor does the work for checkSecondFactor without persisting the user
+func useSecondFactor(user User, code string) bool {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false
+	}
+
+	totpMutex.Lock()
+	defer totpMutex.Unlock()
+
+	lastStep := int64(getIntOrDefault(user.Get("sys.totp_last_step"), 0))
+	if step, ok := verifyTOTP(user.GetString("sys.totp_secret"), code, lastStep, time.Now()); ok {
+		user.Set("sys.totp_last_step", step)
+		return true
+	}
+
+	hash := hashBackupCode(code)
+	remaining := getStringSlice(user, "sys.totp_backup_codes")
+	for i, h := range remaining {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			left := make([]string, 0, len(remaining)-1)
+			left = append(left, remaining[:i]...)
+			left = append(left, remaining[i+1:]...)
+			user.Set("sys.totp_backup_codes", left)
+			return true
+		}
+	}
+	return false
+}
+
+// getSecondFactorCode reads a 2fa code from the X-TOTP-Code header or the totp query param
+func getSecondFactorCode(c *gin.Context) string {
+	if code := c.GetHeader("X-TOTP-Code"); code != "" {
+		return code
+	}
+	return c.Query("totp")
+}
+
+// requireTwoFactor asks for a fresh second factor on sensitive actions when the
+// account has 2fa enabled. when is optional and limits the check to some requests.
+func requireTwoFactor(when func(c *gin.Context) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(*User)
+		if !user.HasTOTP() || (when != nil && !when(c)) {
+			c.Next()
+			return
+		}
+
+		code := getSecondFactorCode(c)
+		if code == "" {
+			c.JSON(401, gin.H{"error": "Two-factor code required", "totp_required": true})
+			c.Abort()
+			return
+		}
+		if !checkSecondFactor(*user, code) {
+			c.JSON(403, gin.H{"error": "Invalid two-factor code", "totp_required": true})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func transferAboveStepUpThreshold(c *gin.Context) bool {
+	var req struct {
+		Amount any `json:"amount"`
+	}
+	if err := peekJSONBody(c, &req); err != nil {
+		return false
+	}
+	amount, err := parseTransferAmount(req.Amount)
+	return err == nil && amount > StepUpTransferThreshold
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// RFC 6238 appendix B SHA1 vectors, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for ts, want := range cases {
+		if got := totpCode(secret, ts/totpPeriod); got != want {
+			t.Errorf("totpCode at %d = %s, want %s", ts, got, want)
+		}
+	}
+}
+
+func TestVerifyTOTPRejectsReplay(t *testing.T) {
+	secret := generateTOTPSecret()
+	key, _ := totpEncoding.DecodeString(secret)
+	now := time.Now()
+	code := totpCode(key, now.Unix()/totpPeriod)
+
+	step, ok := verifyTOTP(secret, code, 0, now)
+	if !ok {
+		t.Fatal("Current code should verify")
+	}
+	if _, ok := verifyTOTP(secret, code, step, now); ok {
+		t.Error("Code should not verify twice")
+	}
+	if _, ok := verifyTOTP(secret, "000000x", 0, now); ok {
+		t.Error("Malformed code should not verify")
+	}
+}
+
+func TestBackupCodesAreSingleUse(t *testing.T) {
+	codes, hashes := generateBackupCodes()
+	if len(codes) != totpBackupCodeCount {
+		t.Fatalf("Expected %d backup codes, got %d", totpBackupCodeCount, len(codes))
+	}
+
+	user := User{
+		"username":              "totpuser",
+		"sys.totp_enabled":      true,
+		"sys.totp_secret":       generateTOTPSecret(),
+		"sys.totp_backup_codes": hashes,
+	}
+
+	if !useSecondFactor(user, codes[0]) {
+		t.Fatal("Backup code should be accepted")
+	}
+	if useSecondFactor(user, codes[0]) {
+		t.Error("Backup code should only work once")
+	}
+	if user.GetBackupCodeCount() != totpBackupCodeCount-1 {
+		t.Errorf("Expected %d backup codes left, got %d", totpBackupCodeCount-1, user.GetBackupCodeCount())
+	}
+}
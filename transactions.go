@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	TransactionPageDefault = 50
+	TransactionPageMax     = 500
+)
+
+var transactionArchiveMutex sync.Mutex
+
+func getTransactionArchivePath(username string) string {
+	return filepath.Join(USERDATA_PATH, strings.ToLower(username), "transactions.jsonl")
+}
+
+// archiveTransactions appends transactions that no longer fit in the user's
+// history to their cold archive instead of dropping them
+func archiveTransactions(username Username, txs []Transaction) {
+	if username == "" || len(txs) == 0 {
+		return
+	}
+
+	transactionArchiveMutex.Lock()
+	defer transactionArchiveMutex.Unlock()
+
+	path := getTransactionArchivePath(string(username))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("Error creating transaction archive dir: %v", err)
+		return
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Error opening transaction archive: %v", err)
+		return
+	}
+	defer f.Close()
+
+	// txs are newest first, the archive is kept oldest first
+	w := bufio.NewWriter(f)
+	for i := len(txs) - 1; i >= 0; i-- {
+		line, err := json.Marshal(txs[i])
+		if err != nil {
+			continue
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		log.Printf("Error writing transaction archive: %v", err)
+	}
+}
+
+// loadTransactionArchive returns the archived transactions, newest first
+func loadTransactionArchive(username Username) []Transaction {
+	transactionArchiveMutex.Lock()
+	defer transactionArchiveMutex.Unlock()
+
+	f, err := os.Open(getTransactionArchivePath(string(username)))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading transaction archive: %v", err)
+		}
+		return nil
+	}
+	defer f.Close()
+
+	archived := make([]Transaction, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var tx Transaction
+		if err := json.Unmarshal(scanner.Bytes(), &tx); err == nil {
+			archived = append(archived, tx)
+		}
+	}
+	slices.Reverse(archived)
+	return archived
+}
+
+// getTransactionHistory is the user's live history followed by the archive
+func (u User) getTransactionHistory() []Transaction {
+	return append(u.GetTransactions(), loadTransactionArchive(u.GetUsername())...)
+}
+
+// TransactionFilter narrows a transaction history, zero values match everything
+type TransactionFilter struct {
+	Types        []string
+	Counterparty UserId
+	MinAmount    *float64
+	MaxAmount    *float64
+	Since        int64
+	Until        int64
+	KeyId        string
+	GiftId       string
+	EscrowId     string
+}
+
+func (f TransactionFilter) Match(tx Transaction) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if tx.Type == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	switch {
+	case f.Counterparty != "" && tx.User != f.Counterparty:
+		return false
+	case f.MinAmount != nil && tx.Amount < *f.MinAmount:
+		return false
+	case f.MaxAmount != nil && tx.Amount > *f.MaxAmount:
+		return false
+	case f.Since > 0 && tx.Timestamp < f.Since:
+		return false
+	case f.Until > 0 && tx.Timestamp > f.Until:
+		return false
+	case f.KeyId != "" && tx.KeyId != f.KeyId:
+		return false
+	case f.GiftId != "" && tx.GiftId != f.GiftId:
+		return false
+	case f.EscrowId != "" && tx.EscrowId != f.EscrowId:
+		return false
+	}
+	return true
+}
+
+// transactionCursor points just past the last transaction of a page. History
+// is newest first and timestamps can repeat, so it keeps how many transactions
+// at that timestamp were already returned.
+type transactionCursor struct {
+	Timestamp int64
+	Seen      int
+}
+
+func (c transactionCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", c.Timestamp, c.Seen)))
+}
+
+func parseTransactionCursor(s string) (transactionCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return transactionCursor{}, fmt.Errorf("invalid cursor")
+	}
+	tsStr, seenStr, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return transactionCursor{}, fmt.Errorf("invalid cursor")
+	}
+	ts, err1 := strconv.ParseInt(tsStr, 10, 64)
+	seen, err2 := strconv.Atoi(seenStr)
+	if err1 != nil || err2 != nil || seen < 0 {
+		return transactionCursor{}, fmt.Errorf("invalid cursor")
+	}
+	return transactionCursor{Timestamp: ts, Seen: seen}, nil
+}
+
+// pageTransactions returns up to limit transactions after the cursor and the
+// cursor for the next page, which is nil on the last page
+func pageTransactions(txs []Transaction, after *transactionCursor, limit int) ([]Transaction, *transactionCursor) {
+	start := 0
+	if after != nil {
+		seen := 0
+		for start < len(txs) {
+			ts := txs[start].Timestamp
+			if ts > after.Timestamp || (ts == after.Timestamp && seen < after.Seen) {
+				if ts == after.Timestamp {
+					seen++
+				}
+				start++
+				continue
+			}
+			break
+		}
+	}
+
+	end := min(start+limit, len(txs))
+	page := txs[start:end]
+	if end >= len(txs) || len(page) == 0 {
+		return page, nil
+	}
+
+	last := page[len(page)-1].Timestamp
+	next := &transactionCursor{Timestamp: last}
+	for i := end - 1; i >= 0 && txs[i].Timestamp == last; i-- {
+		next.Seen++
+	}
+	return page, next
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTransactionPaging(t *testing.T) {
+	// newest first, with a run of equal timestamps across the page boundary
+	txs := []Transaction{
+		{Type: "in", Timestamp: 50},
+		{Type: "out", Timestamp: 40},
+		{Type: "in", Timestamp: 40},
+		{Type: "out", Timestamp: 40},
+		{Type: "in", Timestamp: 30},
+	}
+
+	var seen []Transaction
+	var cursor *transactionCursor
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("Paging did not finish")
+		}
+		if cursor != nil {
+			parsed, err := parseTransactionCursor(cursor.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			cursor = &parsed
+		}
+		page, next := pageTransactions(txs, cursor, 2)
+		seen = append(seen, page...)
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != len(txs) {
+		t.Fatalf("Expected %d transactions across pages, got %d", len(txs), len(seen))
+	}
+	for i := range txs {
+		if seen[i] != txs[i] {
+			t.Errorf("Transaction %d out of order: %+v", i, seen[i])
+		}
+	}
+
+	if _, err := parseTransactionCursor("not a cursor"); err == nil {
+		t.Error("Garbage cursor should be rejected")
+	}
+}
+
+func TestTransactionFilter(t *testing.T) {
+	lo, hi := 5.0, 10.0
+	f := TransactionFilter{Types: []string{"in", "gift_claim"}, Counterparty: "friend", MinAmount: &lo, MaxAmount: &hi, Since: 100}
+
+	if !f.Match(Transaction{Type: "gift_claim", User: "friend", Amount: 5, Timestamp: 100}) {
+		t.Error("Expected transaction on the boundaries to match")
+	}
+	for _, tx := range []Transaction{
+		{Type: "out", User: "friend", Amount: 6, Timestamp: 200},
+		{Type: "in", User: "stranger", Amount: 6, Timestamp: 200},
+		{Type: "in", User: "friend", Amount: 10.5, Timestamp: 200},
+		{Type: "in", User: "friend", Amount: 6, Timestamp: 99},
+	} {
+		if f.Match(tx) {
+			t.Errorf("Did not expect %+v to match", tx)
+		}
+	}
+}
+
+func TestTransactionArchive(t *testing.T) {
+	origPath := USERDATA_PATH
+	USERDATA_PATH = t.TempDir()
+	defer func() { USERDATA_PATH = origPath }()
+
+	user := User{"username": "Archiver", "sys.id": "archive-id"}
+	limit := user.GetSubscriptionBenefits().Max_Transaction_History
+	for i := 0; i < limit+5; i++ {
+		user.addTransaction(Transaction{Type: "in", Amount: float64(i + 1)})
+	}
+
+	if live := user.GetTransactions(); len(live) != limit {
+		t.Fatalf("Expected %d live transactions, got %d", limit, len(live))
+	}
+	if archived := loadTransactionArchive(user.GetUsername()); len(archived) != 5 || archived[len(archived)-1].Amount != 1 {
+		t.Fatalf("Expected the 5 oldest transactions in the archive, got %+v", archived)
+	}
+
+	history := user.getTransactionHistory()
+	if len(history) != limit+5 || history[0].Amount != float64(limit+5) {
+		t.Errorf("History should include the archive, newest first")
+	}
+}
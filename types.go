@@ -243,6 +243,14 @@ func addGroupTip(groupTag string, tip GroupTip) {
 	data.Tips = append(data.Tips, tip)
 
 	data.Group.CreditsBalance += tip.AmountCredits
+	data.TreasuryHistory = append(data.TreasuryHistory, GroupTreasuryEntry{
+		Id:            tip.Id,
+		Type:          "tip",
+		Counterparty:  string(tip.FromUserId),
+		AmountCredits: tip.AmountCredits,
+		BalanceAfter:  data.Group.CreditsBalance,
+		CreatedAt:     tip.CreatedAt,
+	})
 
 	go saveGroupData(groupTag)
 }
@@ -396,23 +404,87 @@ type GroupTip struct {
 }
 
 type GroupBenefitProduct struct {
-	Id             string  `json:"id"`
-	GroupTag       string  `json:"group_tag"`
-	Name           string  `json:"name"`
-	Description    string  `json:"description"`
-	PriceCredits   float64 `json:"price_credits"`
-	RoleGrantedId  string  `json:"role_granted_id,omitempty"`
-	BenefitGranted string  `json:"benefit_granted,omitempty"`
+	Id                string  `json:"id"`
+	GroupTag          string  `json:"group_tag"`
+	Name              string  `json:"name"`
+	Description       string  `json:"description"`
+	PriceCredits      float64 `json:"price_credits"`
+	RoleGrantedId     string  `json:"role_granted_id,omitempty"`
+	BenefitGranted    string  `json:"benefit_granted,omitempty"`
+	BillingPeriodDays int     `json:"billing_period_days,omitempty"` // 0 for a one-off purchase
+	CreatedAt         int64   `json:"created_at"`
+}
+
+// GroupBenefitPurchase is a member's purchase of a product. Recurring purchases
+// are charged again every billing period until cancelled or unpaid.
+type GroupBenefitPurchase struct {
+	Id                string  `json:"id"`
+	ProductId         string  `json:"product_id"`
+	UserId            UserId  `json:"user_id"`
+	PriceCredits      float64 `json:"price_credits"`
+	RoleGrantedId     string  `json:"role_granted_id,omitempty"`
+	RoleAdded         bool    `json:"role_added,omitempty"` // the role was given by this purchase and goes with it
+	BenefitGranted    string  `json:"benefit_granted,omitempty"`
+	Status            string  `json:"status"`
+	PurchasedAt       int64   `json:"purchased_at"`
+	NextBillingAt     *int64  `json:"next_billing_at,omitempty"`
+	CancelAtPeriodEnd bool    `json:"cancel_at_period_end,omitempty"`
+	EndedAt           *int64  `json:"ended_at,omitempty"`
+}
+
+// GroupTreasuryPolicy requires payouts to be approved by members holding
+// Threshold different roles out of ApproverRoleIds
+type GroupTreasuryPolicy struct {
+	ApproverRoleIds []string `json:"approver_role_ids"`
+	Threshold       int      `json:"threshold"`
+}
+
+type GroupTreasuryApproval struct {
+	UserId    UserId `json:"user_id"`
+	RoleId    string `json:"role_id"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// GroupTreasuryProposal is a payout from the group balance to a user or another group
+type GroupTreasuryProposal struct {
+	Id            string                  `json:"id"`
+	GroupTag      string                  `json:"group_tag"`
+	ProposerId    UserId                  `json:"proposer_id"`
+	ToUserId      UserId                  `json:"to_user_id,omitempty"`
+	ToGroupTag    string                  `json:"to_group_tag,omitempty"`
+	AmountCredits float64                 `json:"amount_credits"`
+	Note          string                  `json:"note,omitempty"`
+	Status        string                  `json:"status"`
+	Threshold     int                     `json:"threshold"`
+	Approvals     []GroupTreasuryApproval `json:"approvals"`
+	CreatedAt     int64                   `json:"created_at"`
+	ResolvedAt    *int64                  `json:"resolved_at,omitempty"`
+	ResolvedBy    UserId                  `json:"resolved_by,omitempty"`
+}
+
+// GroupTreasuryEntry is one movement of the group balance
+type GroupTreasuryEntry struct {
+	Id            string  `json:"id"`
+	Type          string  `json:"type"`
+	Counterparty  string  `json:"counterparty"`
+	AmountCredits float64 `json:"amount_credits"`
+	BalanceAfter  float64 `json:"balance_after"`
+	ProposalId    string  `json:"proposal_id,omitempty"`
+	CreatedAt     int64   `json:"created_at"`
 }
 
 type GroupData struct {
-	Group           Group                          `json:"group"`
-	Members         []GroupMember                  `json:"members"`
-	Roles           []GroupRole                    `json:"roles"`
-	Announcements   []GroupAnnouncement            `json:"announcements"`
-	Events          map[string]GroupEvent          `json:"events"`
-	Tips            []GroupTip                     `json:"tips"`
-	BenefitProducts map[string]GroupBenefitProduct `json:"benefit_products"`
+	Group             Group                          `json:"group"`
+	Members           []GroupMember                  `json:"members"`
+	Roles             []GroupRole                    `json:"roles"`
+	Announcements     []GroupAnnouncement            `json:"announcements"`
+	Events            map[string]GroupEvent          `json:"events"`
+	Tips              []GroupTip                     `json:"tips"`
+	BenefitProducts   map[string]GroupBenefitProduct `json:"benefit_products"`
+	BenefitPurchases  []GroupBenefitPurchase         `json:"benefit_purchases,omitempty"`
+	TreasuryPolicy    *GroupTreasuryPolicy           `json:"treasury_policy,omitempty"`
+	TreasuryProposals []GroupTreasuryProposal        `json:"treasury_proposals,omitempty"`
+	TreasuryHistory   []GroupTreasuryEntry           `json:"treasury_history,omitempty"`
 }
 
 var userMutexesLock sync.Mutex
@@ -641,6 +713,15 @@ func (u User) IsPrivate() bool {
 	return private == true
 }
 
+// unix ms at which a pending deletion will be purged, 0 if none is scheduled
+func (u User) GetDeleteAt() int64 {
+	return int64(getIntOrDefault(u.Get("sys.delete_at"), 0))
+}
+
+func (u User) IsPendingDeletion() bool {
+	return u.GetDeleteAt() > 0
+}
+
 func (u User) SetFriends(friends []UserId) {
 	u.Set("sys.friends", friends)
 }
@@ -1052,6 +1133,7 @@ func (u User) addTransaction(tx Transaction) {
 
 	txs = append([]Transaction{tx}, txs...)
 	if len(txs) > benefits.Max_Transaction_History {
+		archiveTransactions(u.GetUsername(), txs[benefits.Max_Transaction_History:])
 		txs = txs[:benefits.Max_Transaction_History]
 	}
 	u.Set("sys.transactions", txs)
@@ -1059,6 +1141,7 @@ func (u User) addTransaction(tx Transaction) {
 
 func (u User) SetLogins(logins []Login) {
 	u.Set("sys.logins", logins)
+	indexLoginIps(u, logins)
 }
 
 func (u User) Has(key string) bool {
@@ -1163,7 +1246,7 @@ func (u User) Set(key string, value any) {
 	}
 	mu.Unlock()
 
-	if key != "key" && key != "password" {
+	if key != "key" && !isPrivateUserKey(key) {
 		go broadcastUserUpdate(username, key, valueCopy)
 		if uid != "" {
 			go OnUserUpdate(uid, key, value)
@@ -1326,6 +1409,7 @@ type Transaction struct {
 	KeyId      string  `json:"key_id,omitempty"`
 	GiftId     string  `json:"gift_id,omitempty"`
 	GiftCode   string  `json:"gift_code,omitempty"`
+	EscrowId   string  `json:"escrow_id,omitempty"`
 }
 
 type Gift struct {
@@ -1339,6 +1423,23 @@ type Gift struct {
 	ClaimedAt   *int64  `json:"claimed_at,omitempty"`
 	ClaimedBy   *UserId `json:"claimed_by,omitempty"`
 	CancelledAt *int64  `json:"cancelled_at,omitempty"`
+	// campaigns pay Amount to each of up to MaxClaims users, ClaimedAt is set
+	// once the last claim is taken
+	MaxClaims    int               `json:"max_claims,omitempty"`
+	Claims       []GiftClaim       `json:"claims,omitempty"`
+	Restrictions *GiftRestrictions `json:"restrictions,omitempty"`
+}
+
+type GiftClaim struct {
+	UserId    UserId `json:"user_id"`
+	ClaimedAt int64  `json:"claimed_at"`
+}
+
+// GiftRestrictions limits who can claim a campaign gift
+type GiftRestrictions struct {
+	MinAccountAgeDays int           `json:"min_account_age_days,omitempty"`
+	MinStanding       StandingLevel `json:"min_standing,omitempty"`
+	GroupTag          string        `json:"group_tag,omitempty"`
 }
 
 func (g Gift) ToNet() GiftNet {
@@ -1351,27 +1452,69 @@ func (g Gift) ToNet() GiftNet {
 		username := g.ClaimedBy.User().GetUsername()
 		claimedBy = &username
 	}
+	var claimers []Username
+	for _, claim := range g.Claims {
+		claimers = append(claimers, claim.UserId.User().GetUsername())
+	}
 	return GiftNet{
-		Id:        g.Id,
-		Code:      g.Code,
-		Amount:    g.Amount,
-		Note:      g.Note,
-		CreatorId: g.CreatorId.User().GetUsername(),
-		CreatedAt: g.CreatedAt,
-		ExpiresAt: g.ExpiresAt,
-		ClaimedAt: claimedAt,
-		ClaimedBy: claimedBy,
+		Id:           g.Id,
+		Code:         g.Code,
+		Amount:       g.Amount,
+		Note:         g.Note,
+		CreatorId:    g.CreatorId.User().GetUsername(),
+		CreatedAt:    g.CreatedAt,
+		ExpiresAt:    g.ExpiresAt,
+		ClaimedAt:    claimedAt,
+		ClaimedBy:    claimedBy,
+		MaxClaims:    g.MaxClaims,
+		Claimers:     claimers,
+		Restrictions: g.Restrictions,
 	}
 }
 
 func (g Gift) ToPublic() GiftPublic {
-	return GiftPublic{
+	public := GiftPublic{
 		Code:      g.Code,
 		Amount:    g.Amount,
 		Note:      g.Note,
 		CreatorId: g.CreatorId.User().GetUsername(),
 		ExpiresAt: g.ExpiresAt,
 	}
+	if g.IsCampaign() {
+		public.MaxClaims = g.MaxClaims
+		public.ClaimsRemaining = g.RemainingClaims()
+		public.Restrictions = g.Restrictions
+	}
+	return public
+}
+
+func (g Gift) IsCampaign() bool {
+	return g.MaxClaims > 1
+}
+
+// RemainingClaims is how many more users can claim the gift
+func (g Gift) RemainingClaims() int {
+	if !g.IsActive() {
+		return 0
+	}
+	if !g.IsCampaign() {
+		return 1
+	}
+	return g.MaxClaims - len(g.Claims)
+}
+
+// Outstanding is what the gift still holds in escrow
+func (g Gift) Outstanding() float64 {
+	return roundVal(g.Amount * float64(g.RemainingClaims()))
+}
+
+func (g Gift) HasClaimed(userId UserId) bool {
+	for _, claim := range g.Claims {
+		if claim.UserId == userId {
+			return true
+		}
+	}
+	return g.ClaimedBy != nil && *g.ClaimedBy == userId
 }
 
 func (g Gift) IsActive() bool {
@@ -1394,23 +1537,29 @@ func (g Gift) CanBeCancelled() bool {
 }
 
 type GiftNet struct {
-	Id        string    `json:"id"`
-	Code      string    `json:"code"`
-	Amount    float64   `json:"amount"`
-	Note      string    `json:"note"`
-	CreatorId Username  `json:"creator_id"`
-	CreatedAt int64     `json:"created_at"`
-	ExpiresAt int64     `json:"expires_at"`
-	ClaimedAt *int64    `json:"claimed_at,omitempty"`
-	ClaimedBy *Username `json:"claimed_by,omitempty"`
+	Id           string            `json:"id"`
+	Code         string            `json:"code"`
+	Amount       float64           `json:"amount"`
+	Note         string            `json:"note"`
+	CreatorId    Username          `json:"creator_id"`
+	CreatedAt    int64             `json:"created_at"`
+	ExpiresAt    int64             `json:"expires_at"`
+	ClaimedAt    *int64            `json:"claimed_at,omitempty"`
+	ClaimedBy    *Username         `json:"claimed_by,omitempty"`
+	MaxClaims    int               `json:"max_claims,omitempty"`
+	Claimers     []Username        `json:"claimers,omitempty"`
+	Restrictions *GiftRestrictions `json:"restrictions,omitempty"`
 }
 
 type GiftPublic struct {
-	Code      string   `json:"code"`
-	Amount    float64  `json:"amount"`
-	Note      string   `json:"note"`
-	CreatorId Username `json:"creator_id"`
-	ExpiresAt int64    `json:"expires_at"`
+	Code            string            `json:"code"`
+	Amount          float64           `json:"amount"`
+	Note            string            `json:"note"`
+	CreatorId       Username          `json:"creator_id"`
+	ExpiresAt       int64             `json:"expires_at"`
+	MaxClaims       int               `json:"max_claims,omitempty"`
+	ClaimsRemaining int               `json:"claims_remaining,omitempty"`
+	Restrictions    *GiftRestrictions `json:"restrictions,omitempty"`
 }
 
 func (t Transaction) ToNet() TransactionNet {
@@ -1428,6 +1577,9 @@ func (t Transaction) ToNet() TransactionNet {
 		PetitionId: t.PetitionId,
 		KeyName:    t.KeyName,
 		KeyId:      t.KeyId,
+		GiftId:     t.GiftId,
+		GiftCode:   t.GiftCode,
+		EscrowId:   t.EscrowId,
 	}
 }
 
@@ -1441,6 +1593,9 @@ type TransactionNet struct {
 	PetitionId string   `json:"petition_id,omitempty"`
 	KeyName    string   `json:"key_name,omitempty"`
 	KeyId      string   `json:"key_id,omitempty"`
+	GiftId     string   `json:"gift_id,omitempty"`
+	GiftCode   string   `json:"gift_code,omitempty"`
+	EscrowId   string   `json:"escrow_id,omitempty"`
 }
 
 // UnmarshalJSON custom unmarshaler to handle timestamp as string or number
@@ -1543,6 +1698,7 @@ type Login struct {
 	Timestamp   int64  `json:"timestamp"`
 	Device_type string `json:"device_type"`
 	Message     string `json:"message"`
+	SessionId   string `json:"session_id,omitempty"`
 }
 
 // TransferHistory represents item transfer history
@@ -1760,6 +1916,13 @@ func (u User) SetStanding(level StandingLevel, reason string, adminId UserId) {
 		u.Set("sys.standing_recover_at", nil)
 		u.Set("sys.banned", true)
 	}
+
+	logSecurityEvent(nil, u, SecStandingChanged, map[string]any{
+		"from":     current,
+		"to":       level,
+		"reason":   reason,
+		"admin_id": adminId,
+	})
 }
 
 func (u User) GetStandingHistory() []StandingHistoryEntry {
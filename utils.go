@@ -7,6 +7,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -293,6 +294,12 @@ func getRateLimitKey(c *gin.Context) string {
 	if authKey != "" {
 		return authKey
 	}
+	if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+		if strings.HasPrefix(strings.ToLower(authHeader), "bearer ") {
+			return authHeader[7:]
+		}
+		return authHeader
+	}
 
 	clientIP := c.ClientIP()
 	if clientIP != "" {
@@ -368,26 +375,50 @@ func requiresAuth(c *gin.Context) {
 		return
 	}
 
-	user := authenticateWithKey(authKey)
+	user := findUserByKey(authKey)
 	if user != nil {
 		if user.IsBanned() {
 			c.JSON(403, gin.H{"error": "User is banned"})
 			c.Abort()
 			return
 		}
+		if user.IsPendingDeletion() {
+			c.JSON(403, gin.H{"error": "Account is pending deletion, log in to restore it"})
+			c.Abort()
+			return
+		}
 		user.GetSubscription()
 		c.Set("user", user)
 		c.Set("token_type", "main")
+		if isSessionToken(authKey) {
+			c.Set("session_id", getSessionID(authKey))
+		}
 		c.Next()
 		return
 	}
 
-	subUser, subToken, err := authenticateWithSubTokenFast(authKey)
+	subUser, subToken, err := authenticateWithSubTokenFast(authKey, c.ClientIP())
+	if errors.Is(err, errAccountPendingDeletion) {
+		c.JSON(403, gin.H{"error": "Account is pending deletion, log in to restore it"})
+		c.Abort()
+		return
+	}
 	if err != nil || subUser == nil {
 		c.JSON(403, gin.H{"error": "Invalid authentication key"})
 		c.Abort()
 		return
 	}
+	if allowed, remaining, resetAt := applyTokenBudget(subToken); !allowed {
+		// the violation stays in memory until the next batched save, this path sees floods
+		subToken.recordViolation(ViolationRateLimited, c.ClientIP(), time.Now().UnixMilli())
+		queueTokenStoreSave(string(subUser.GetUsername()))
+		c.Header("X-RateLimit-Limit", strconv.Itoa(subToken.RateLimit.Count))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(resetAt, 10))
+		c.JSON(429, gin.H{"error": "Token rate limit exceeded", "reset_time": resetAt, "remaining": remaining})
+		c.Abort()
+		return
+	}
 	if subUser.IsBanned() {
 		c.JSON(403, gin.H{"error": "User is banned"})
 		c.Abort()
@@ -434,6 +465,12 @@ func requirePermission(perm TokenPermission) gin.HandlerFunc {
 			return
 		}
 
+		if err := subToken.enforceConstraints(c, perm); err != nil {
+			c.JSON(403, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
@@ -594,21 +631,6 @@ func clamp(num int, low int, high int) int {
 	return num
 }
 
-func deleteAccountAtIndexFast(idx int) error {
-	usersMutex.Lock()
-	defer usersMutex.Unlock()
-
-	if idx < 0 || idx >= len(users) {
-		return fmt.Errorf("index out of range")
-	}
-
-	users[idx] = users[len(users)-1]
-	users = users[:len(users)-1]
-
-	go saveUsers()
-	return nil
-}
-
 func loadGifts() {
 	file, err := os.Open("gifts.json")
 	if err != nil {
@@ -655,6 +677,37 @@ func saveGifts() {
 	}
 }
 
+// refundGiftLocked returns whatever the gift still holds to its creator and
+// cancels it. A deleted creator's refund leaves the economy. giftsMutex must be held.
+func refundGiftLocked(gift *Gift, note string, now int64) (float64, error) {
+	amount := gift.Outstanding()
+	creator := getUserById(gift.CreatorId)
+	refund := accountPosting(LedgerSink, amount)
+	if len(creator) > 0 {
+		refund = userPosting(creator, amount)
+	}
+	if _, err := postLedger("gift_refund", gift.Id, accountPosting(LedgerGiftEscrow, -amount), refund); err != nil {
+		return 0, err
+	}
+
+	if len(creator) > 0 {
+		creator.addTransaction(Transaction{
+			Note:      note,
+			User:      UserId(""),
+			Amount:    amount,
+			Type:      "gift_refund",
+			Timestamp: now,
+			NewTotal:  creator.GetCredits(),
+			GiftId:    gift.Id,
+			GiftCode:  gift.Code,
+		})
+	}
+
+	cancelledAt := now
+	gift.CancelledAt = &cancelledAt
+	return amount, nil
+}
+
 func cleanExpiredGifts() {
 	for {
 		time.Sleep(1 * time.Hour)
@@ -666,25 +719,10 @@ func cleanExpiredGifts() {
 		for i := range gifts {
 			gift := &gifts[i]
 			if gift.IsActive() && gift.IsExpired() {
-				creator := getUserById(gift.CreatorId)
-				if len(creator) > 0 {
-					newBal := roundVal(creator.GetCredits() + gift.Amount)
-					creator.SetBalance(newBal)
-					nowTs := now
-					creator.addTransaction(Transaction{
-						Note:      "Gift expired: " + gift.Code,
-						User:      UserId(""),
-						Amount:    gift.Amount,
-						Type:      "gift_refund",
-						Timestamp: nowTs,
-						NewTotal:  newBal,
-						GiftId:    gift.Id,
-						GiftCode:  gift.Code,
-					})
+				if _, err := refundGiftLocked(gift, "Gift expired: "+gift.Code, now); err != nil {
+					log.Printf("Failed to refund expired gift %s: %v", gift.Id, err)
+					continue
 				}
-
-				cancelledAt := now
-				gift.CancelledAt = &cancelledAt
 				changed = true
 			}
 		}